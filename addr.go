@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 节点地址格式
+// NodeAddr 可以是 host:port 形式的 TCP 地址，IPv6 地址需加方括号，如 [::1]:7000，
+// 也可以是带协议的 URL：tcp://host:port、tcp4://host:port、tcp6://[::1]:7000、unix:///path/to/raft.sock
+// raft 内部不解析地址，只原样交给 Transport；ParseNodeAddr、DialNodeAddr、ListenNodeAddr 供 Transport 实现建立连接和监听使用
+
+// 地址协议前缀与 net 包的网络类型
+var addrSchemes = map[string]string{
+	"tcp":  "tcp",
+	"tcp4": "tcp4",
+	"tcp6": "tcp6",
+	"unix": "unix",
+}
+
+// 解析节点地址，返回 net.Dial、net.Listen 使用的网络类型和地址
+func ParseNodeAddr(addr NodeAddr) (network, address string, err error) {
+	s := string(addr)
+	network, address = "tcp", s
+	if i := strings.Index(s, "://"); i >= 0 {
+		var ok bool
+		if network, ok = addrSchemes[s[:i]]; !ok {
+			return "", "", fmt.Errorf("节点地址 %s 的协议不支持，可用的协议：tcp、tcp4、tcp6、unix", addr)
+		}
+		address = s[i+len("://"):]
+	} else if strings.HasPrefix(s, "unix:") {
+		// unix:relative/path.sock 形式的相对路径
+		network, address = "unix", strings.TrimPrefix(s, "unix:")
+	}
+	if network == "unix" {
+		if address == "" {
+			return "", "", fmt.Errorf("节点地址 %s 缺少 socket 文件路径", addr)
+		}
+		return network, address, nil
+	}
+	if _, _, splitErr := net.SplitHostPort(address); splitErr != nil {
+		return "", "", fmt.Errorf("节点地址 %s 不是 host:port 格式，IPv6 地址需加方括号：%w", addr, splitErr)
+	}
+	return network, address, nil
+}
+
+// 建立到节点地址的连接
+func DialNodeAddr(ctx context.Context, addr NodeAddr) (net.Conn, error) {
+	network, address, err := ParseNodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+// 在节点地址上监听
+func ListenNodeAddr(addr NodeAddr) (net.Listener, error) {
+	network, address, err := ParseNodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
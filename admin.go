@@ -0,0 +1,234 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 运维管理 HTTP 接口，请求和应答均为 JSON
+//
+//	GET    /status          节点状态，Leader 还返回各节点的复制进度
+//	GET    /membership      集群成员
+//	POST   /voters          添加投票节点，请求体 {"id": "...", "addr": "..."}
+//	DELETE /servers/{id}    移除节点
+//	POST   /transfer        领导权转移，请求体 {"id": "...", "addr": "..."}，为空时由 Leader 选择目标节点
+//	POST   /snapshot        立即生成快照
+//	GET    /metrics         指标，需要 MetricsSink 实现 http.Handler，如 PrometheusSink
+//
+// 当前节点不是 Leader 时返回 503，应答中包含已知的 Leader；领导权转移的目标节点不能接收领导权时返回 400；操作超时返回 504
+type AdminServer struct {
+	node    *Node
+	metrics http.Handler
+	mux     *http.ServeMux
+}
+
+// 创建管理接口，metrics 为 nil 时 /metrics 返回 404
+func NewAdminServer(node *Node, metrics http.Handler) *AdminServer {
+	s := &AdminServer{
+		node:    node,
+		metrics: metrics,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/membership", s.handleMembership)
+	s.mux.HandleFunc("/voters", s.handleAddVoter)
+	s.mux.HandleFunc("/servers/", s.handleRemoveServer)
+	s.mux.HandleFunc("/transfer", s.handleTransfer)
+	s.mux.HandleFunc("/snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	return s
+}
+
+func (s *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// 管理接口请求体中的节点
+type adminPeer struct {
+	Id   NodeId   `json:"id"`
+	Addr NodeAddr `json:"addr"`
+}
+
+// 管理接口的错误应答
+type adminError struct {
+	Error  string `json:"error"`
+	Leader Server `json:"leader"`
+}
+
+func (s *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	status, err := s.node.Status()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, status)
+}
+
+func (s *AdminServer) handleMembership(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, s.node.ClusterMembership())
+}
+
+func (s *AdminServer) handleAddVoter(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var peer adminPeer
+	if err := json.NewDecoder(r.Body).Decode(&peer); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("请求体格式错误：%s", err)})
+		return
+	}
+	if peer.Id == None || peer.Addr == "" {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{Error: "缺少节点 id 或 addr"})
+		return
+	}
+	var res AddVoterReply
+	err := s.node.AddVoter(AddVoter{Id: peer.Id, Addr: peer.Addr}, &res)
+	if err == nil && res.Status != OK {
+		err = NotLeaderError{Leader: res.Leader}
+	}
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, res)
+}
+
+func (s *AdminServer) handleRemoveServer(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	id := NodeId(strings.TrimPrefix(r.URL.Path, "/servers/"))
+	if id == None || strings.Contains(string(id), "/") {
+		writeAdminJSON(w, http.StatusNotFound, adminError{Error: "缺少节点 id"})
+		return
+	}
+	var res RemoveServerReply
+	err := s.node.RemoveServer(RemoveServer{Id: id}, &res)
+	if err == nil && res.Status != OK {
+		err = NotLeaderError{Leader: res.Leader}
+	}
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, res)
+}
+
+func (s *AdminServer) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	// 请求体为空时由 Leader 选择日志最新的投票节点
+	var peer adminPeer
+	if err := json.NewDecoder(r.Body).Decode(&peer); err != nil && !errors.Is(err, io.EOF) {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("请求体格式错误：%s", err)})
+		return
+	}
+	var res TransferLeadershipReply
+	err := s.node.TransferLeadership(TransferLeadership{Transferee: Server{Id: peer.Id, Addr: peer.Addr}}, &res)
+	if err == nil && res.Status != OK {
+		err = NotLeaderError{Leader: s.node.raft.peerState.getLeader()}
+	}
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, res)
+}
+
+func (s *AdminServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	meta, err := s.node.Snapshot()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, meta)
+}
+
+func (s *AdminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		writeAdminJSON(w, http.StatusNotFound, adminError{Error: "未配置可导出的指标"})
+		return
+	}
+	s.metrics.ServeHTTP(w, r)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAdminJSON(w, http.StatusMethodNotAllowed, adminError{Error: fmt.Sprintf("只支持 %s 请求", method)})
+	return false
+}
+
+// 按错误类型选择状态码
+func writeAdminError(w http.ResponseWriter, err error) {
+	var notLeader NotLeaderError
+	var draining DrainingError
+	switch {
+	case errors.As(err, &notLeader):
+		writeAdminJSON(w, http.StatusServiceUnavailable, adminError{Error: err.Error(), Leader: notLeader.Leader})
+	case errors.As(err, &draining):
+		writeAdminJSON(w, http.StatusServiceUnavailable, adminError{Error: err.Error(), Leader: draining.Leader})
+	case errors.Is(err, ErrInvalidTransferee):
+		writeAdminJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+	case errors.Is(err, ErrTimeout):
+		writeAdminJSON(w, http.StatusGatewayTimeout, adminError{Error: err.Error()})
+	case errors.Is(err, ErrShutdown):
+		writeAdminJSON(w, http.StatusServiceUnavailable, adminError{Error: err.Error()})
+	default:
+		writeAdminJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 启动内嵌的管理接口服务，Config.AdminAddr 为空时不启动
+func (nd *Node) serveAdmin() {
+	if nd.config.AdminAddr == "" {
+		return
+	}
+	metrics, _ := nd.config.Metrics.(http.Handler)
+	nd.admin = &http.Server{
+		Addr:    nd.config.AdminAddr,
+		Handler: NewAdminServer(nd, metrics),
+	}
+	// 监听地址与节点地址格式相同，可以是 unix socket 或 IPv6 地址
+	listener, listenErr := ListenNodeAddr(NodeAddr(nd.config.AdminAddr))
+	if listenErr != nil {
+		nd.raft.logger.Error(fmt.Errorf("管理接口服务监听失败：%w", listenErr).Error())
+		return
+	}
+	go func() {
+		if err := nd.admin.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			nd.raft.logger.Error(fmt.Errorf("管理接口服务退出：%w", err).Error())
+		}
+	}()
+}
+
+// 关闭内嵌的管理接口服务
+func (nd *Node) closeAdmin(ctx context.Context) error {
+	if nd.admin == nil {
+		return nil
+	}
+	return nd.admin.Shutdown(ctx)
+}
@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+)
+
+// 节点间 rpc 认证
+// 设置 Config.AuthToken 后，节点发送的 AppendEntries、RequestVote、PreVote、InstallSnapshot 请求都附带此令牌，
+// 接收方在请求进入主循环之前检查令牌，令牌不匹配的请求直接返回 ErrUnauthenticated，不会触发选举或写入日志
+// 未设置 Config.PeerTokens 时所有节点使用相同的 AuthToken 作为共享密钥，
+// 设置后按请求发送方的 id 检查各节点自己的令牌，新节点加入集群前需先更新其它节点的 PeerTokens
+// 令牌以明文传输，需配合 TLS 使用
+
+type peerAuth struct {
+	token  string            // 当前节点的令牌
+	tokens map[NodeId]string // 各节点的令牌，为 nil 时使用 token 作为共享密钥
+}
+
+// 未设置 AuthToken 时返回 nil，不进行认证
+func newPeerAuth(config Config) *peerAuth {
+	if config.AuthToken == "" {
+		return nil
+	}
+	return &peerAuth{token: config.AuthToken, tokens: config.PeerTokens}
+}
+
+// 检查发送方的令牌
+func (a *peerAuth) check(sender NodeId, token string) error {
+	if a == nil {
+		return nil
+	}
+	expected := a.token
+	if a.tokens != nil {
+		var ok bool
+		if expected, ok = a.tokens[sender]; !ok {
+			return fmt.Errorf("节点 %s 没有配置令牌：%w", sender, ErrUnauthenticated)
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		return fmt.Errorf("节点 %s 的令牌不匹配：%w", sender, ErrUnauthenticated)
+	}
+	return nil
+}
+
+// 发送请求时附带当前节点的令牌
+type authTransport struct {
+	transport ContextTransport
+	token     string
+}
+
+func (tp *authTransport) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	args.AuthToken = tp.token
+	return tp.transport.AppendEntriesContext(ctx, addr, args, res)
+}
+
+func (tp *authTransport) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	args.AuthToken = tp.token
+	return tp.transport.RequestVoteContext(ctx, addr, args, res)
+}
+
+func (tp *authTransport) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	args.AuthToken = tp.token
+	return preVoteContext(tp.transport, ctx, addr, args, res)
+}
+
+func (tp *authTransport) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	args.AuthToken = tp.token
+	return tp.transport.InstallSnapshotContext(ctx, addr, args, res)
+}
+
+// 检查其它节点发来的请求，认证失败时记录日志
+func (rf *raft) authenticate(sender NodeId, token string) error {
+	err := rf.auth.check(sender, token)
+	if err != nil {
+		rf.logger.Warn(fmt.Sprintf("拒绝未认证的请求：%s", err))
+	}
+	return err
+}
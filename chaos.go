@@ -0,0 +1,185 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosTransport 丢弃的请求返回此错误
+var ErrChaosDropped = errors.New("请求被 ChaosTransport 丢弃")
+
+// 故障注入规则，匹配的请求按规则丢弃、重复或延迟
+type ChaosRule struct {
+	Rpc       string        // 匹配的 rpc：AppendEntries、RequestVote、PreVote、InstallSnapshot，为空时匹配全部
+	To        NodeAddr      // 匹配的目标节点地址，为空时匹配全部
+	Drop      float64       // 丢弃请求的概率，丢弃时目标节点收不到请求，调用方收到 ErrChaosDropped
+	DropReply float64       // 丢弃应答的概率，目标节点已处理请求，调用方收到 ErrChaosDropped
+	Duplicate float64       // 重复发送请求的概率，重复请求的应答被丢弃
+	Delay     time.Duration // 发送请求前的固定延迟
+	Jitter    time.Duration // 在 Delay 之外随机增加 [0, Jitter) 的延迟，并发的请求因此乱序到达
+}
+
+func (r ChaosRule) match(rpc string, to NodeAddr) bool {
+	return (r.Rpc == "" || r.Rpc == rpc) && (r.To == "" || r.To == to)
+}
+
+// 包装 Transport，按运行时设置的规则对发出的请求注入故障，用于重现网络分区、丢包、乱序等场景
+// 每个节点使用各自的 ChaosTransport，即可控制特定节点之间特定 rpc 的网络状况
+type ChaosTransport struct {
+	transport Transport
+	rules     []ChaosRule
+	rand      *rand.Rand
+	mu        sync.Mutex
+}
+
+// 创建 ChaosTransport，seed 相同时随机决策的序列相同，便于重现问题
+func NewChaosTransport(transport Transport, seed int64) *ChaosTransport {
+	return &ChaosTransport{
+		transport: transport,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+// 添加规则，一个请求匹配多条规则时使用最先添加的规则
+func (ct *ChaosTransport) AddRule(rule ChaosRule) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.rules = append(ct.rules, rule)
+}
+
+// 替换全部规则
+func (ct *ChaosTransport) SetRules(rules ...ChaosRule) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.rules = append([]ChaosRule(nil), rules...)
+}
+
+// 清除全部规则，恢复正常通信
+func (ct *ChaosTransport) Clear() {
+	ct.SetRules()
+}
+
+// 隔离到 addrs 的全部请求，模拟网络分区
+func (ct *ChaosTransport) Partition(addrs ...NodeAddr) {
+	for _, addr := range addrs {
+		ct.AddRule(ChaosRule{To: addr, Drop: 1})
+	}
+}
+
+// 本次请求的故障决策
+type chaosAction struct {
+	drop      bool
+	dropReply bool
+	duplicate bool
+	delay     time.Duration
+}
+
+func (ct *ChaosTransport) decide(rpc string, to NodeAddr) chaosAction {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	for _, rule := range ct.rules {
+		if !rule.match(rpc, to) {
+			continue
+		}
+		action := chaosAction{
+			drop:      ct.rand.Float64() < rule.Drop,
+			dropReply: ct.rand.Float64() < rule.DropReply,
+			duplicate: ct.rand.Float64() < rule.Duplicate,
+			delay:     rule.Delay,
+		}
+		if rule.Jitter > 0 {
+			action.delay += time.Duration(ct.rand.Int63n(int64(rule.Jitter)))
+		}
+		return action
+	}
+	return chaosAction{}
+}
+
+// 按规则发送请求，send 每次调用发送一次请求并写入应答
+// 重复的请求在后台发送，应答写入副本后丢弃，延迟期间 ctx 取消时直接返回
+func (ct *ChaosTransport) call(ctx context.Context, rpc string, to NodeAddr, send func(dup bool) error) error {
+	action := ct.decide(rpc, to)
+	if action.delay > 0 {
+		timer := time.NewTimer(action.delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if action.drop {
+		return ErrChaosDropped
+	}
+	if action.duplicate {
+		go func() {
+			_ = send(true)
+		}()
+	}
+	if err := send(false); err != nil {
+		return err
+	}
+	if action.dropReply {
+		return ErrChaosDropped
+	}
+	return nil
+}
+
+func (ct *ChaosTransport) AppendEntries(addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	return ct.AppendEntriesContext(context.Background(), addr, args, res)
+}
+
+func (ct *ChaosTransport) RequestVote(addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	return ct.RequestVoteContext(context.Background(), addr, args, res)
+}
+
+func (ct *ChaosTransport) PreVote(addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	return ct.PreVoteContext(context.Background(), addr, args, res)
+}
+
+func (ct *ChaosTransport) InstallSnapshot(addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	return ct.InstallSnapshotContext(context.Background(), addr, args, res)
+}
+
+func (ct *ChaosTransport) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	transport := withContext(ct.transport)
+	return ct.call(ctx, "AppendEntries", addr, func(dup bool) error {
+		if dup {
+			return transport.AppendEntriesContext(context.Background(), addr, args, &AppendEntryReply{})
+		}
+		return transport.AppendEntriesContext(ctx, addr, args, res)
+	})
+}
+
+func (ct *ChaosTransport) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	transport := withContext(ct.transport)
+	return ct.call(ctx, "RequestVote", addr, func(dup bool) error {
+		if dup {
+			return transport.RequestVoteContext(context.Background(), addr, args, &RequestVoteReply{})
+		}
+		return transport.RequestVoteContext(ctx, addr, args, res)
+	})
+}
+
+func (ct *ChaosTransport) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	transport := withContext(ct.transport)
+	return ct.call(ctx, "PreVote", addr, func(dup bool) error {
+		if dup {
+			return preVoteContext(transport, context.Background(), addr, args, &PreVoteReply{})
+		}
+		return preVoteContext(transport, ctx, addr, args, res)
+	})
+}
+
+func (ct *ChaosTransport) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	transport := withContext(ct.transport)
+	return ct.call(ctx, "InstallSnapshot", addr, func(dup bool) error {
+		if dup {
+			return transport.InstallSnapshotContext(context.Background(), addr, args, &InstallSnapshotReply{})
+		}
+		return transport.InstallSnapshotContext(ctx, addr, args, res)
+	})
+}
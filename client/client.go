@@ -0,0 +1,182 @@
+// raft 集群的客户端，自动发现 Leader，请求发送到非 Leader 节点时按应答中的 Leader 地址重定向，
+// 超时或网络错误时换一个节点退避重试，可选使用会话为请求编号，由状态机据此去重
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitcapybara/raft"
+)
+
+const (
+	defaultMaxRetries = 10
+	defaultMinBackoff = 50 * time.Millisecond
+	defaultMaxBackoff = time.Second
+)
+
+// 客户端发送请求的接口，由用户实现，通常调用节点的 Node.ApplyCommand 或 RpcServer.ApplyCommand
+type Transport interface {
+	ApplyCommand(ctx context.Context, addr raft.NodeAddr, args raft.ApplyCommand, res *raft.ApplyCommandReply) error
+}
+
+// 客户端配置
+type Config struct {
+	Peers          []raft.NodeAddr // 集群节点地址，客户端从第一个节点开始寻找 Leader
+	Transport      Transport       // 发送请求的接口
+	ClientId       string          // 客户端标识，随请求发送，用于授权检查和会话
+	Session        bool            // 是否使用会话，使用时命令按 EncodeCommand 的格式编码，需设置 ClientId
+	MaxRetries     int             // 单个请求的最大重试次数，重定向不计入，为 0 时为 10
+	MinBackoff     time.Duration   // 重试前的最短等待时间，每次重试翻倍，为 0 时为 50ms
+	MaxBackoff     time.Duration   // 重试前的最长等待时间，为 0 时为 1s
+	RequestTimeout time.Duration   // 单次请求的超时时间，超时后换一个节点重试，为 0 时不限制
+}
+
+type Client struct {
+	config Config
+	leader int    // 当前认为的 Leader 在 peers 中的下标
+	seq    uint64 // 会话中最后一个请求的序号
+	mu     sync.Mutex
+}
+
+func New(config Config) (*Client, error) {
+	if len(config.Peers) <= 0 {
+		return nil, errors.New("集群节点地址不能为空")
+	}
+	if config.Transport == nil {
+		return nil, errors.New("Transport 不能为空")
+	}
+	if config.Session && config.ClientId == "" {
+		return nil, errors.New("使用会话时 ClientId 不能为空")
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultMaxRetries
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = defaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	config.Peers = append([]raft.NodeAddr(nil), config.Peers...)
+	return &Client{config: config}, nil
+}
+
+// 当前认为的 Leader 地址
+func (c *Client) Leader() raft.NodeAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config.Peers[c.leader]
+}
+
+// 提交一条命令，返回命令应用到状态机后的结果
+// 使用会话时，同一命令的各次重试序号相同，状态机可据此去重
+func (c *Client) Apply(ctx context.Context, data []byte) ([]byte, error) {
+	args := raft.ApplyCommand{Data: data, ClientId: c.config.ClientId}
+	if c.config.Session {
+		args.Data = EncodeCommand(Command{ClientId: c.config.ClientId, Seq: c.nextSeq(), Data: data})
+	}
+
+	backoff := c.config.MinBackoff
+	var lastErr error
+	for retries := 0; retries <= c.config.MaxRetries; {
+		addr := c.Leader()
+		res, err := c.send(ctx, addr, args)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if res.Status == raft.OK {
+			// 请求已被 Leader 处理，错误来自授权检查或状态机，不再重试
+			return res.Result, err
+		}
+		if res.Status == raft.Rejected {
+			// 命令过大、节点只读或未通过授权检查，重试不会成功
+			return nil, err
+		}
+
+		// 请求的不是 Leader，按应答中的 Leader 地址重定向，不计入重试次数
+		leader := res.Leader.Addr
+		var notLeader raft.NotLeaderError
+		var draining raft.DrainingError
+		if errors.As(err, &notLeader) {
+			leader = notLeader.Leader.Addr
+		} else if errors.As(err, &draining) {
+			leader = draining.Leader.Addr
+		}
+		if leader != "" && leader != addr {
+			c.redirect(addr, leader)
+			continue
+		}
+
+		// Leader 未知、正在转移领导权、超时或网络错误时，换一个节点退避重试
+		if err == nil {
+			err = fmt.Errorf("节点 %s 不是 Leader，且不知道当前的 Leader", addr)
+		}
+		lastErr = err
+		retries++
+		c.next(addr)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+	return nil, fmt.Errorf("重试 %d 次后请求仍失败：%w", c.config.MaxRetries, lastErr)
+}
+
+// 向 addr 发送一次请求，设置了 RequestTimeout 时限制本次请求的时间
+func (c *Client) send(ctx context.Context, addr raft.NodeAddr, args raft.ApplyCommand) (raft.ApplyCommandReply, error) {
+	if c.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.RequestTimeout)
+		defer cancel()
+	}
+	var res raft.ApplyCommandReply
+	err := c.config.Transport.ApplyCommand(ctx, addr, args, &res)
+	return res, err
+}
+
+// 将 Leader 切换为 leader，不在节点列表中时添加到列表末尾
+// from 不是当前认为的 Leader 时，说明其它请求已经切换过，不做任何事
+func (c *Client) redirect(from, leader raft.NodeAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.Peers[c.leader] != from {
+		return
+	}
+	for i, addr := range c.config.Peers {
+		if addr == leader {
+			c.leader = i
+			return
+		}
+	}
+	c.config.Peers = append(c.config.Peers, leader)
+	c.leader = len(c.config.Peers) - 1
+}
+
+// 换下一个节点作为 Leader 尝试
+func (c *Client) next(from raft.NodeAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.Peers[c.leader] == from {
+		c.leader = (c.leader + 1) % len(c.config.Peers)
+	}
+}
+
+func (c *Client) nextSeq() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return c.seq
+}
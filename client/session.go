@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// 使用会话时写入日志的命令
+type Command struct {
+	ClientId string // 客户端标识
+	Seq      uint64 // 请求序号，同一客户端的请求序号递增，重试时不变
+	Data     []byte // 客户端提交的命令数据
+}
+
+// 会话命令格式错误
+var ErrInvalidCommand = errors.New("会话命令格式错误")
+
+// 编码会话命令，格式为 ClientId 长度、ClientId、Seq（均为 uvarint）及命令数据
+func EncodeCommand(cmd Command) []byte {
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(cmd.ClientId)+len(cmd.Data))
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(cmd.ClientId)))]...)
+	buf = append(buf, cmd.ClientId...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], cmd.Seq)]...)
+	return append(buf, cmd.Data...)
+}
+
+// 解码会话命令，状态机在 Apply 中调用
+func DecodeCommand(data []byte) (Command, error) {
+	idLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < idLen {
+		return Command{}, ErrInvalidCommand
+	}
+	data = data[n:]
+	clientId := string(data[:idLen])
+	data = data[idLen:]
+	seq, n := binary.Uvarint(data)
+	if n <= 0 {
+		return Command{}, ErrInvalidCommand
+	}
+	return Command{ClientId: clientId, Seq: seq, Data: data[n:]}, nil
+}
+
+// 客户端会话，记录最后一个已应用请求的序号和结果
+type Session struct {
+	Seq    uint64
+	Result []byte
+}
+
+// 状态机使用的会话表，用于丢弃重复的请求
+// 会话表是状态机的一部分，需要随快照一起保存和恢复
+type Sessions struct {
+	sessions map[string]Session
+	mu       sync.Mutex
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{sessions: make(map[string]Session)}
+}
+
+// 请求已应用过时返回 true 及上次的结果
+func (s *Sessions) Lookup(cmd Command) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[cmd.ClientId]
+	if !ok || cmd.Seq > session.Seq {
+		return nil, false
+	}
+	return session.Result, true
+}
+
+// 记录请求的结果
+func (s *Sessions) Record(cmd Command, result []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[cmd.ClientId]; ok && cmd.Seq <= session.Seq {
+		return
+	}
+	s.sessions[cmd.ClientId] = Session{Seq: cmd.Seq, Result: result}
+}
+
+// 全部会话的副本，生成快照时调用
+func (s *Sessions) Export() map[string]Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make(map[string]Session, len(s.sessions))
+	for id, session := range s.sessions {
+		sessions[id] = session
+	}
+	return sessions
+}
+
+// 使用快照中的会话替换全部会话，安装快照时调用
+func (s *Sessions) Import(sessions map[string]Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]Session, len(sessions))
+	for id, session := range sessions {
+		s.sessions[id] = session
+	}
+}
@@ -0,0 +1,262 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 时钟接口，raft 内部的选举超时、心跳、批处理等计时均通过此接口
+// 默认使用系统时钟，测试时可使用 MockClock 手动推进时间，使选举和心跳的行为可重现
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+	// 经过 d 后向返回的通道发送当前时间
+	After(d time.Duration) <-chan time.Time
+	// 创建计时器，经过 d 后到期
+	NewTimer(d time.Duration) Timer
+	// 创建周期计时器，每经过 d 触发一次
+	NewTicker(d time.Duration) Ticker
+}
+
+// 计时器，对应 time.Timer
+type Timer interface {
+	// 计时器到期时接收当前时间的通道
+	C() <-chan time.Time
+	// 重新设置到期时间，返回计时器在调用前是否处于计时状态
+	Reset(d time.Duration) bool
+	// 停止计时器，返回计时器在调用前是否处于计时状态
+	Stop() bool
+}
+
+// 周期计时器，对应 time.Ticker
+type Ticker interface {
+	// 每次触发时接收当前时间的通道
+	C() <-chan time.Time
+	// 停止周期计时器
+	Stop()
+}
+
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return realClock{}
+	}
+	return clock
+}
+
+// ==================== realClock ====================
+
+// 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTimer) Reset(d time.Duration) bool {
+	return rt.t.Reset(d)
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (rt realTicker) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTicker) Stop() {
+	rt.t.Stop()
+}
+
+// ==================== MockClock ====================
+
+// 手动推进的时钟，用于测试
+// 时间只在调用 Advance 或 Set 时前进，到期的计时器按到期时间先后依次触发
+type MockClock struct {
+	now     time.Time
+	waiters []*mockWaiter
+	mu      sync.Mutex
+}
+
+// 等待到期的计时器
+type mockWaiter struct {
+	when   time.Time
+	period time.Duration // 周期计时器的周期，为 0 时表示一次性计时器
+	ch     chan time.Time
+}
+
+// 创建从 start 开始计时的时钟，start 为零值时从当前系统时间开始
+func NewMockClock(start time.Time) *MockClock {
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &MockClock{now: start}
+}
+
+func (mc *MockClock) Now() time.Time {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.now
+}
+
+func (mc *MockClock) After(d time.Duration) <-chan time.Time {
+	return mc.NewTimer(d).C()
+}
+
+func (mc *MockClock) NewTimer(d time.Duration) Timer {
+	mt := &mockTimer{clock: mc, ch: make(chan time.Time, 1)}
+	mt.Reset(d)
+	return mt
+}
+
+func (mc *MockClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("MockClock.NewTicker 的周期必须为正数")
+	}
+	w := &mockWaiter{period: d, ch: make(chan time.Time, 1)}
+	mc.mu.Lock()
+	w.when = mc.now.Add(d)
+	mc.waiters = append(mc.waiters, w)
+	mc.mu.Unlock()
+	return &mockTicker{clock: mc, waiter: w}
+}
+
+// 时间前进 d，期间到期的计时器依次触发
+func (mc *MockClock) Advance(d time.Duration) {
+	mc.Set(mc.Now().Add(d))
+}
+
+// 时间前进到 t，t 早于当前时间时不做任何事
+func (mc *MockClock) Set(t time.Time) {
+	for {
+		mc.mu.Lock()
+		if t.Before(mc.now) {
+			mc.mu.Unlock()
+			return
+		}
+		w := mc.nextWaiter(t)
+		if w == nil {
+			mc.now = t
+			mc.mu.Unlock()
+			return
+		}
+		mc.now = w.when
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			mc.removeWaiter(w)
+		}
+		now := mc.now
+		mc.mu.Unlock()
+		// 与 time.Timer 相同，接收方未及时取走时丢弃本次触发
+		select {
+		case w.ch <- now:
+		default:
+		}
+	}
+}
+
+// 等待中的计时器数量，可用于测试中确认 raft 已开始计时后再推进时间
+func (mc *MockClock) Waiters() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return len(mc.waiters)
+}
+
+// 在 t 之前（含）最早到期的计时器，调用方需持有锁
+func (mc *MockClock) nextWaiter(t time.Time) *mockWaiter {
+	sort.SliceStable(mc.waiters, func(i, j int) bool {
+		return mc.waiters[i].when.Before(mc.waiters[j].when)
+	})
+	if len(mc.waiters) == 0 || mc.waiters[0].when.After(t) {
+		return nil
+	}
+	return mc.waiters[0]
+}
+
+// 移除计时器，返回计时器是否处于等待状态，调用方需持有锁
+func (mc *MockClock) removeWaiter(w *mockWaiter) bool {
+	for i, waiter := range mc.waiters {
+		if waiter == w {
+			mc.waiters = append(mc.waiters[:i], mc.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type mockTimer struct {
+	clock  *MockClock
+	ch     chan time.Time
+	waiter *mockWaiter
+}
+
+func (mt *mockTimer) C() <-chan time.Time {
+	return mt.ch
+}
+
+func (mt *mockTimer) Reset(d time.Duration) bool {
+	mc := mt.clock
+	mc.mu.Lock()
+	active := mt.waiter != nil && mc.removeWaiter(mt.waiter)
+	mt.waiter = &mockWaiter{when: mc.now.Add(d), ch: mt.ch}
+	mc.waiters = append(mc.waiters, mt.waiter)
+	mc.mu.Unlock()
+	// 到期时间不晚于当前时间时立即触发
+	if d <= 0 {
+		mc.Set(mc.Now())
+	}
+	return active
+}
+
+func (mt *mockTimer) Stop() bool {
+	mc := mt.clock
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mt.waiter == nil {
+		return false
+	}
+	active := mc.removeWaiter(mt.waiter)
+	mt.waiter = nil
+	return active
+}
+
+type mockTicker struct {
+	clock  *MockClock
+	waiter *mockWaiter
+}
+
+func (mt *mockTicker) C() <-chan time.Time {
+	return mt.waiter.ch
+}
+
+func (mt *mockTicker) Stop() {
+	mt.clock.mu.Lock()
+	defer mt.clock.mu.Unlock()
+	mt.clock.removeWaiter(mt.waiter)
+}
@@ -0,0 +1,161 @@
+// raft 集群运维工具，通过节点的管理接口（Config.AdminAddr）查看集群状态、生成快照、变更成员和转移领导权
+//
+//	raftadmin status -addr 127.0.0.1:8001
+//	raftadmin members -addr 127.0.0.1:8001
+//	raftadmin add-voter -addr 127.0.0.1:8001 n4 127.0.0.1:9004
+//	raftadmin remove -addr 127.0.0.1:8001 n4
+//	raftadmin transfer -addr 127.0.0.1:8001 [n2 127.0.0.1:9002]
+//	raftadmin snapshot -addr 127.0.0.1:8001
+//	raftadmin metrics -addr 127.0.0.1:8001
+//
+// 未指定 -addr 时使用环境变量 RAFTADMIN_ADDR
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const usage = `用法：
+  raftadmin status -addr <addr>
+  raftadmin members -addr <addr>
+  raftadmin add-voter -addr <addr> <id> <raftAddr>
+  raftadmin remove -addr <addr> <id>
+  raftadmin transfer -addr <addr> [<id> <raftAddr>]
+  raftadmin snapshot -addr <addr>
+  raftadmin metrics -addr <addr>`
+
+// 请求体中的节点，与管理接口的格式一致
+type peer struct {
+	Id   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// 管理接口的错误应答
+type adminError struct {
+	Error  string `json:"error"`
+	Leader struct {
+		Id   string
+		Addr string
+	} `json:"leader"`
+}
+
+type adminClient struct {
+	base   string
+	client *http.Client
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	addr := flags.String("addr", os.Getenv("RAFTADMIN_ADDR"), "节点管理接口地址")
+	timeout := flags.Duration("timeout", 10*time.Second, "请求超时时间")
+	_ = flags.Parse(os.Args[2:])
+	if *addr == "" {
+		fmt.Fprintln(os.Stderr, "缺少 -addr 参数")
+		os.Exit(2)
+	}
+	c := &adminClient{base: "http://" + *addr, client: &http.Client{Timeout: *timeout}}
+
+	var err error
+	switch args := flags.Args(); cmd {
+	case "status":
+		err = c.do(http.MethodGet, "/status", nil)
+	case "members":
+		err = c.do(http.MethodGet, "/membership", nil)
+	case "add-voter":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = c.do(http.MethodPost, "/voters", peer{Id: args[0], Addr: args[1]})
+	case "remove":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = c.do(http.MethodDelete, "/servers/"+url.PathEscape(args[0]), nil)
+	case "transfer":
+		// 不指定目标节点时由 Leader 选择日志最新的投票节点
+		var target peer
+		switch len(args) {
+		case 0:
+		case 2:
+			target = peer{Id: args[0], Addr: args[1]}
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = c.do(http.MethodPost, "/transfer", target)
+	case "snapshot":
+		err = c.do(http.MethodPost, "/snapshot", nil)
+	case "metrics":
+		err = c.do(http.MethodGet, "/metrics", nil)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// 发送请求并将应答输出到标准输出，JSON 应答缩进后输出
+func (c *adminClient) do(method, path string, body interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求管理接口失败：%w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取应答失败：%w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e adminError
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			return fmt.Errorf("请求失败：%s", resp.Status)
+		}
+		if e.Leader.Id != "" {
+			return fmt.Errorf("%s（Leader=%s，地址=%s，请对 Leader 节点的管理接口重试）", e.Error, e.Leader.Id, e.Leader.Addr)
+		}
+		return fmt.Errorf("%s", e.Error)
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out, data, "", "  ") != nil {
+		// 非 JSON 应答（如 Prometheus 指标）原样输出
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
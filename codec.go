@@ -0,0 +1,596 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// 消息和日志数据的编解码接口
+// raft 使用 Config.Codec 编码配置日志中的集群成员，集群中所有节点必须使用相同的编解码器
+// 方法签名与 gRPC 的 encoding.Codec 相同，Transport 的实现也可以直接使用，如注册到 gRPC 中
+type Codec interface {
+	// 编码数据
+	Marshal(v interface{}) ([]byte, error)
+	// 解码数据，v 必须是指针
+	Unmarshal(data []byte, v interface{}) error
+	// 编解码器名称
+	Name() string
+}
+
+// 未设置 Config.Codec 时使用 gob 编码，与之前版本保存的配置日志兼容
+func codecOrDefault(codec Codec) Codec {
+	if codec == nil {
+		return GobCodec{}
+	}
+	return codec
+}
+
+// 根据名称获取内置的编解码器，名称为空时使用 gob
+// ProtoCodec 只支持 rpc 消息，不能用于配置日志
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "", "gob":
+		return GobCodec{}, nil
+	case "json":
+		return JSONCodec{}, nil
+	case "msgpack":
+		return MsgpackCodec{}, nil
+	}
+	return nil, fmt.Errorf("不支持的编解码器：%s", name)
+}
+
+// ==================== gob ====================
+
+// 基于 encoding/gob 的编解码器，只能在 Go 程序之间使用
+type GobCodec struct{}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(v); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ==================== json ====================
+
+// 基于 encoding/json 的编解码器，可读性好，便于调试和跨语言使用
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ==================== msgpack ====================
+
+// MessagePack 编解码器，比 json 更紧凑，多数语言都有现成的实现
+// 结构体按导出字段名编码为 map，与常见 msgpack 库的默认行为一致
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack 解码目标必须是非空指针：%T", v)
+	}
+	d := &msgpackDecoder{data: data}
+	return d.decode(rv.Elem())
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) write(b ...byte) {
+	e.buf = append(e.buf, b...)
+}
+
+// 写入类型标记和 size 字节的大端整数
+func (e *msgpackEncoder) writeSized(code byte, size int, n uint64) {
+	e.write(code)
+	for i := size - 1; i >= 0; i-- {
+		e.write(byte(n >> (8 * uint(i))))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.write(byte(n))
+	case n <= math.MaxUint8:
+		e.writeSized(0xcc, 1, n)
+	case n <= math.MaxUint16:
+		e.writeSized(0xcd, 2, n)
+	case n <= math.MaxUint32:
+		e.writeSized(0xce, 4, n)
+	default:
+		e.writeSized(0xcf, 8, n)
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.write(byte(n))
+	case n >= math.MinInt8:
+		e.writeSized(0xd0, 1, uint64(n))
+	case n >= math.MinInt16:
+		e.writeSized(0xd1, 2, uint64(n))
+	case n >= math.MinInt32:
+		e.writeSized(0xd2, 4, uint64(n))
+	default:
+		e.writeSized(0xd3, 8, uint64(n))
+	}
+}
+
+// 按长度选择 fix / 8 / 16 / 32 位的类型标记，fix 为 0 表示没有 fix 格式
+func (e *msgpackEncoder) encodeLen(n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.write(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		e.writeSized(code8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		e.writeSized(code16, 2, uint64(n))
+	default:
+		e.writeSized(code32, 4, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	e.encodeLen(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.write(0xc0)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.write(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.write(0xc3)
+		} else {
+			e.write(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.writeSized(0xca, 4, uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		e.writeSized(0xcb, 8, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.write(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeLen(v.Len(), 0, 0, 0xc4, 0xc5, 0xc6)
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.write(0xc0)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("不支持 msgpack 编码的类型：%s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.encodeLen(v.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 键值对按编码后的键排序，保证相同的 map 编码结果相同
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	type pair struct {
+		key, value []byte
+	}
+	pairs := make([]pair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		k, val := &msgpackEncoder{}, &msgpackEncoder{}
+		if err := k.encode(iter.Key()); err != nil {
+			return err
+		}
+		if err := val.encode(iter.Value()); err != nil {
+			return err
+		}
+		pairs = append(pairs, pair{key: k.buf, value: val.buf})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+	e.encodeLen(len(pairs), 0x80, 15, 0, 0xde, 0xdf)
+	for _, p := range pairs {
+		e.write(p.key...)
+		e.write(p.value...)
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	t := v.Type()
+	fields := make([]int, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	e.encodeLen(len(fields), 0x80, 15, 0, 0xde, 0xdf)
+	for _, i := range fields {
+		e.encodeString(t.Field(i).Name)
+		if err := e.encode(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errMsgpackTruncated = errors.New("msgpack 数据不完整")
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMsgpackTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// 读取 size 字节的大端整数
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) int(code byte) (int64, error) {
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code >= 0xcc && code <= 0xcf:
+		n, err := d.uint(1 << (code - 0xcc))
+		return int64(n), err
+	case code >= 0xd0 && code <= 0xd3:
+		size := 1 << (code - 0xd0)
+		n, err := d.uint(size)
+		// 符号扩展
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, err
+	}
+	return 0, fmt.Errorf("msgpack 类型不匹配，期望整数：0x%x", code)
+}
+
+func (d *msgpackDecoder) float(code byte) (float64, error) {
+	switch code {
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	}
+	n, err := d.int(code)
+	return float64(n), err
+}
+
+// 读取 str 或 bin 类型的数据
+func (d *msgpackDecoder) bytes(code byte) ([]byte, error) {
+	var n uint64
+	var err error
+	switch {
+	case code >= 0xa0 && code <= 0xbf:
+		n = uint64(code & 0x1f)
+	case code == 0xd9 || code == 0xc4:
+		n, err = d.uint(1)
+	case code == 0xda || code == 0xc5:
+		n, err = d.uint(2)
+	case code == 0xdb || code == 0xc6:
+		n, err = d.uint(4)
+	default:
+		return nil, fmt.Errorf("msgpack 类型不匹配，期望字符串或二进制：0x%x", code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.read(int(n))
+}
+
+func (d *msgpackDecoder) length(code byte, fix byte, code16, code32 byte) (int, error) {
+	var n uint64
+	var err error
+	switch {
+	case code&0xf0 == fix:
+		n = uint64(code & 0x0f)
+	case code == code16:
+		n, err = d.uint(2)
+	case code == code32:
+		n, err = d.uint(4)
+	default:
+		return 0, fmt.Errorf("msgpack 类型不匹配：0x%x", code)
+	}
+	// 每个元素至少占一个字节，长度超过剩余数据时数据不完整
+	if err == nil && n > uint64(len(d.data)-d.pos) {
+		err = errMsgpackTruncated
+	}
+	return int(n), err
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	code, err := d.byte()
+	if err != nil {
+		return err
+	}
+	if code == 0xc0 {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		d.pos--
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		d.pos--
+		x, err := d.decodeAny()
+		if err != nil {
+			return err
+		}
+		if x != nil {
+			v.Set(reflect.ValueOf(x))
+		}
+	case reflect.Bool:
+		if code != 0xc2 && code != 0xc3 {
+			return fmt.Errorf("msgpack 类型不匹配，期望布尔值：0x%x", code)
+		}
+		v.SetBool(code == 0xc3)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.int(code)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.int(code)
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, err := d.float(code)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		b, err := d.bytes(code)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.bytes(code)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		n, err := d.length(code, 0x90, 0xdc, 0xdd)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		n, err := d.length(code, 0x90, 0xdc, 0xdd)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i))
+			} else {
+				_, err = d.decodeAny()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length(code, 0x80, 0xde, 0xdf)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		n, err := d.length(code, 0x80, 0xde, 0xdf)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			// 未知字段直接跳过
+			field, ok := v.Type().FieldByName(name)
+			if !ok || field.PkgPath != "" || len(field.Index) != 1 {
+				if _, err := d.decodeAny(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Field(field.Index[0])); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("不支持 msgpack 解码的类型：%s", v.Type())
+	}
+	return nil
+}
+
+// 解码为通用类型，map 解码为 map[string]interface{}，数组解码为 []interface{}
+func (d *msgpackDecoder) decodeAny() (interface{}, error) {
+	code, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code == 0xc0:
+		return nil, nil
+	case code == 0xc2 || code == 0xc3:
+		return code == 0xc3, nil
+	case code == 0xca || code == 0xcb:
+		return d.float(code)
+	case code >= 0xcc && code <= 0xcf:
+		n, err := d.uint(1 << (code - 0xcc))
+		return n, err
+	case code <= 0x7f || code >= 0xe0 || (code >= 0xd0 && code <= 0xd3):
+		return d.int(code)
+	case (code >= 0xa0 && code <= 0xbf) || (code >= 0xd9 && code <= 0xdb):
+		b, err := d.bytes(code)
+		return string(b), err
+	case code >= 0xc4 && code <= 0xc6:
+		b, err := d.bytes(code)
+		return append([]byte(nil), b...), err
+	case code&0xf0 == 0x90 || code == 0xdc || code == 0xdd:
+		n, err := d.length(code, 0x90, 0xdc, 0xdd)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = d.decodeAny(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case code&0xf0 == 0x80 || code == 0xde || code == 0xdf:
+		n, err := d.length(code, 0x80, 0xde, 0xdf)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := d.decodeAny()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decodeAny()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("不支持的 msgpack 类型：0x%x", code)
+}
+
+// ProtoCodec 也实现了 Codec，但只支持 message.go 中的 rpc 消息
+var _ Codec = ProtoCodec{}
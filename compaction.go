@@ -0,0 +1,80 @@
+package raft
+
+import "time"
+
+// 日志压缩策略，决定何时自动生成快照
+// 每次应用日志到状态机后检查，返回 true 时生成快照
+type CompactionPolicy interface {
+	ShouldCompact(stats CompactionStats) bool
+}
+
+// 检查压缩策略时的日志统计信息
+type CompactionStats struct {
+	LogLength         int           // 快照之后已提交的日志条目数
+	LogBytes          int           // 快照之后已提交的日志数据总字节数
+	SinceLastSnapshot time.Duration // 距离上次生成或安装快照的时间
+}
+
+// 已提交的日志条目数达到阈值时压缩
+type LogLengthPolicy int
+
+func (p LogLengthPolicy) ShouldCompact(stats CompactionStats) bool {
+	return stats.LogLength >= int(p)
+}
+
+// 已提交的日志数据总字节数达到阈值时压缩
+type LogBytesPolicy int
+
+func (p LogBytesPolicy) ShouldCompact(stats CompactionStats) bool {
+	return stats.LogLength > 0 && stats.LogBytes >= int(p)
+}
+
+// 距离上次快照超过指定时间，且有新的已提交日志时压缩
+type IntervalPolicy time.Duration
+
+func (p IntervalPolicy) ShouldCompact(stats CompactionStats) bool {
+	return stats.LogLength > 0 && stats.SinceLastSnapshot >= time.Duration(p)
+}
+
+// 组合策略，任意一个策略满足条件时压缩
+type AnyPolicy []CompactionPolicy
+
+func (p AnyPolicy) ShouldCompact(stats CompactionStats) bool {
+	for _, policy := range p {
+		if policy.ShouldCompact(stats) {
+			return true
+		}
+	}
+	return false
+}
+
+// 组合策略，所有策略都满足条件时压缩
+type AllPolicy []CompactionPolicy
+
+func (p AllPolicy) ShouldCompact(stats CompactionStats) bool {
+	if len(p) <= 0 {
+		return false
+	}
+	for _, policy := range p {
+		if !policy.ShouldCompact(stats) {
+			return false
+		}
+	}
+	return true
+}
+
+// 根据配置生成压缩策略
+// 未设置 CompactionPolicy 时，MaxLogLength、MaxLogBytes、SnapshotInterval 任意一个满足即压缩
+func newCompactionPolicy(config Config) CompactionPolicy {
+	if config.CompactionPolicy != nil {
+		return config.CompactionPolicy
+	}
+	policy := AnyPolicy{LogLengthPolicy(config.MaxLogLength)}
+	if config.MaxLogBytes > 0 {
+		policy = append(policy, LogBytesPolicy(config.MaxLogBytes))
+	}
+	if config.SnapshotInterval > 0 {
+		policy = append(policy, IntervalPolicy(time.Duration(config.SnapshotInterval)*time.Millisecond))
+	}
+	return policy
+}
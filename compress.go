@@ -0,0 +1,67 @@
+package raft
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// AppendEntries 日志压缩
+// 节点在 AppendEntryReply.Compression 中声明支持接收压缩的日志，Leader 只给声明过支持的节点发送压缩的请求，
+// 请求中日志数据总字节数达到 CompressThreshold 时，各日志的 Data 使用 DEFLATE 压缩，并设置 AppendEntry.Compressed
+// 压缩只发生在网络传输中，双方持久化的日志和交给状态机的数据都是原始数据
+
+// 压缩一个请求中全部日志的数据，返回新的日志切片，不修改原日志
+func compressEntries(entries []Entry) ([]Entry, error) {
+	compressed := make([]Entry, len(entries))
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		buf.Reset()
+		w.Reset(&buf)
+		if _, err := w.Write(entry.Data); err != nil {
+			return nil, fmt.Errorf("压缩 index=%d 的日志失败：%w", entry.Index, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("压缩 index=%d 的日志失败：%w", entry.Index, err)
+		}
+		entry.Data = append([]byte(nil), buf.Bytes()...)
+		compressed[i] = entry
+	}
+	return compressed, nil
+}
+
+// 解压一个请求中全部日志的数据，limit 大于 0 时解压后超过 limit 字节的日志返回 EntryTooLargeError
+func decompressEntries(entries []Entry, limit int) error {
+	for i := range entries {
+		r := flate.NewReader(bytes.NewReader(entries[i].Data))
+		var src io.Reader = r
+		if limit > 0 && entries[i].Type == EntryReplicate {
+			// 多读一个字节，用于判断是否超过上限，避免恶意数据解压后占满内存
+			src = io.LimitReader(r, int64(limit)+1)
+		}
+		data, err := io.ReadAll(src)
+		_ = r.Close()
+		if err != nil {
+			return fmt.Errorf("解压 index=%d 的日志失败：%w", entries[i].Index, err)
+		}
+		if limit > 0 && entries[i].Type == EntryReplicate && len(data) > limit {
+			return EntryTooLargeError{Size: len(data), Limit: limit}
+		}
+		entries[i].Data = data
+	}
+	return nil
+}
+
+// 日志数据总字节数
+func entriesDataSize(entries []Entry) int {
+	size := 0
+	for _, entry := range entries {
+		size += len(entry.Data)
+	}
+	return size
+}
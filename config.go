@@ -0,0 +1,466 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 环境变量前缀，如 RAFT_ME 会覆盖配置文件中的 me
+const configEnvPrefix = "RAFT_"
+
+// 配置文件内容
+// Fsm、持久化器、Transport 和 Logger 需要由用户在代码中创建，
+// Storage 和 Transport 中的配置供用户创建这些组件时使用
+type ConfigSpec struct {
+	Me                        NodeId              `json:"me"`
+	Role                      string              `json:"role"`
+	Peers                     map[NodeId]NodeAddr `json:"peers"`
+	NonVoters                 map[NodeId]NodeAddr `json:"nonVoters"`
+	ElectionMinTimeout        int                 `json:"electionMinTimeout"`
+	ElectionMaxTimeout        int                 `json:"electionMaxTimeout"`
+	HeartbeatTimeout          int                 `json:"heartbeatTimeout"`
+	MaxLogLength              int                 `json:"maxLogLength"`
+	MaxLogBytes               int                 `json:"maxLogBytes"`
+	SnapshotInterval          int                 `json:"snapshotInterval"`
+	SnapshotChunkSize         int                 `json:"snapshotChunkSize"`
+	SnapshotRateLimit         int                 `json:"snapshotRateLimit"`
+	MaxBatchWait              int                 `json:"maxBatchWait"`
+	MaxBatchBytes             int                 `json:"maxBatchBytes"`
+	PreVote                   bool                `json:"preVote"`
+	Witness                   bool                `json:"witness"`
+	ProposalLeaseCheck        bool                `json:"proposalLeaseCheck"`
+	Debug                     bool                `json:"debug"`
+	PromotionMaxLag           int                 `json:"promotionMaxLag"`
+	PromotionRounds           int                 `json:"promotionRounds"`
+	MaxApplyBacklog           int                 `json:"maxApplyBacklog"`
+	ApplyIndexInterval        int                 `json:"applyIndexInterval"`
+	SlowDiskThreshold         int                 `json:"slowDiskThreshold"`
+	SlowDiskDuration          int                 `json:"slowDiskDuration"`
+	DiskErrorPolicy           string              `json:"diskErrorPolicy"` // 磁盘错误的处理策略：ignore、stepDown、readOnly、halt，为空时为 ignore
+	StalePeerPolicy           string              `json:"stalePeerPolicy"` // 失联节点的处理策略：keep、remove、demote，为空时为 keep
+	StalePeerTimeout          int                 `json:"stalePeerTimeout"`
+	LeadershipTransferTimeout int                 `json:"leadershipTransferTimeout"`
+	MaxTransferQueue          int                 `json:"maxTransferQueue"`
+	TransferMaxLag            int                 `json:"transferMaxLag"`
+	PeerQueueSize             int                 `json:"peerQueueSize"`
+	ClientQueueSize           int                 `json:"clientQueueSize"`
+	RpcTimeout                int                 `json:"rpcTimeout"`
+	ForwardApply              bool                `json:"forwardApply"`
+	MaxReplicationLag         int                 `json:"maxReplicationLag"`
+	MaxReplicationLagBytes    int                 `json:"maxReplicationLagBytes"`
+	AdminAddr                 string              `json:"adminAddr"` // 管理接口监听地址，为空时不启动
+	MaxEntrySize              int                 `json:"maxEntrySize"`
+	CompressThreshold         int                 `json:"compressThreshold"`
+	FsmVersion                string              `json:"fsmVersion"`
+	AuthToken                 string              `json:"authToken"`
+	PeerTokens                map[NodeId]string   `json:"peerTokens"`
+	Codec                     string              `json:"codec"`        // 配置日志的编解码器：gob、json、msgpack，为空时使用 gob
+	PeerResolver              string              `json:"peerResolver"` // 节点地址解析器：dns，为空时不解析
+	Storage                   StorageSpec         `json:"storage"`
+	Transport                 TransportSpec       `json:"transport"`
+}
+
+// 存储配置
+type StorageSpec struct {
+	Dir string `json:"dir"` // 数据目录
+}
+
+// 网络通信配置
+type TransportSpec struct {
+	Type string  `json:"type"` // 通信方式，如 http、grpc
+	TLS  TLSSpec `json:"tls"`
+}
+
+// TLS 配置，CertFile 为空表示不启用
+type TLSSpec struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	CAFile   string `json:"caFile"`
+}
+
+// 从 JSON 配置文件加载配置
+// 环境变量会覆盖文件中的同名配置，如 RAFT_ME、RAFT_HEARTBEAT_TIMEOUT、RAFT_PEERS=id1=addr1,id2=addr2
+func LoadConfigSpec(path string) (ConfigSpec, error) {
+	var spec ConfigSpec
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return spec, fmt.Errorf("读取配置文件失败：%w", readErr)
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("解析配置文件失败：%w", err)
+	}
+	if err := spec.applyEnv(); err != nil {
+		return spec, err
+	}
+	if err := spec.Validate(); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// 使用环境变量覆盖配置
+func (spec *ConfigSpec) applyEnv() error {
+	strVars := map[string]*string{
+		"ROLE":              &spec.Role,
+		"CODEC":             &spec.Codec,
+		"ADMIN_ADDR":        &spec.AdminAddr,
+		"FSM_VERSION":       &spec.FsmVersion,
+		"AUTH_TOKEN":        &spec.AuthToken,
+		"DISK_ERROR_POLICY": &spec.DiskErrorPolicy,
+		"STALE_PEER_POLICY": &spec.StalePeerPolicy,
+		"PEER_RESOLVER":     &spec.PeerResolver,
+		"STORAGE_DIR":       &spec.Storage.Dir,
+		"TRANSPORT_TYPE":    &spec.Transport.Type,
+		"TLS_CERT_FILE":     &spec.Transport.TLS.CertFile,
+		"TLS_KEY_FILE":      &spec.Transport.TLS.KeyFile,
+		"TLS_CA_FILE":       &spec.Transport.TLS.CAFile,
+	}
+	for name, field := range strVars {
+		if value, ok := os.LookupEnv(configEnvPrefix + name); ok {
+			*field = value
+		}
+	}
+	if value, ok := os.LookupEnv(configEnvPrefix + "ME"); ok {
+		spec.Me = NodeId(value)
+	}
+
+	intVars := map[string]*int{
+		"ELECTION_MIN_TIMEOUT":        &spec.ElectionMinTimeout,
+		"ELECTION_MAX_TIMEOUT":        &spec.ElectionMaxTimeout,
+		"HEARTBEAT_TIMEOUT":           &spec.HeartbeatTimeout,
+		"MAX_LOG_LENGTH":              &spec.MaxLogLength,
+		"MAX_LOG_BYTES":               &spec.MaxLogBytes,
+		"SNAPSHOT_INTERVAL":           &spec.SnapshotInterval,
+		"SNAPSHOT_CHUNK_SIZE":         &spec.SnapshotChunkSize,
+		"SNAPSHOT_RATE_LIMIT":         &spec.SnapshotRateLimit,
+		"MAX_BATCH_WAIT":              &spec.MaxBatchWait,
+		"MAX_BATCH_BYTES":             &spec.MaxBatchBytes,
+		"PROMOTION_MAX_LAG":           &spec.PromotionMaxLag,
+		"PROMOTION_ROUNDS":            &spec.PromotionRounds,
+		"MAX_APPLY_BACKLOG":           &spec.MaxApplyBacklog,
+		"APPLY_INDEX_INTERVAL":        &spec.ApplyIndexInterval,
+		"SLOW_DISK_THRESHOLD":         &spec.SlowDiskThreshold,
+		"SLOW_DISK_DURATION":          &spec.SlowDiskDuration,
+		"STALE_PEER_TIMEOUT":          &spec.StalePeerTimeout,
+		"LEADERSHIP_TRANSFER_TIMEOUT": &spec.LeadershipTransferTimeout,
+		"MAX_TRANSFER_QUEUE":          &spec.MaxTransferQueue,
+		"TRANSFER_MAX_LAG":            &spec.TransferMaxLag,
+		"PEER_QUEUE_SIZE":             &spec.PeerQueueSize,
+		"CLIENT_QUEUE_SIZE":           &spec.ClientQueueSize,
+		"RPC_TIMEOUT":                 &spec.RpcTimeout,
+		"MAX_REPLICATION_LAG":         &spec.MaxReplicationLag,
+		"MAX_REPLICATION_LAG_BYTES":   &spec.MaxReplicationLagBytes,
+		"MAX_ENTRY_SIZE":              &spec.MaxEntrySize,
+		"COMPRESS_THRESHOLD":          &spec.CompressThreshold,
+	}
+	for name, field := range intVars {
+		if value, ok := os.LookupEnv(configEnvPrefix + name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s%s 不是整数：%w", configEnvPrefix, name, err)
+			}
+			*field = n
+		}
+	}
+
+	boolVars := map[string]*bool{
+		"PRE_VOTE":             &spec.PreVote,
+		"WITNESS":              &spec.Witness,
+		"FORWARD_APPLY":        &spec.ForwardApply,
+		"PROPOSAL_LEASE_CHECK": &spec.ProposalLeaseCheck,
+		"DEBUG":                &spec.Debug,
+	}
+	for name, field := range boolVars {
+		if value, ok := os.LookupEnv(configEnvPrefix + name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s%s 不是布尔值：%w", configEnvPrefix, name, err)
+			}
+			*field = b
+		}
+	}
+
+	// 节点列表格式：id1=addr1,id2=addr2
+	if value, ok := os.LookupEnv(configEnvPrefix + "PEERS"); ok {
+		pairs, err := parseEnvPairs("PEERS", value)
+		if err != nil {
+			return err
+		}
+		peers := make(map[NodeId]NodeAddr, len(pairs))
+		for id, addr := range pairs {
+			peers[NodeId(id)] = NodeAddr(addr)
+		}
+		spec.Peers = peers
+	}
+	// 节点令牌格式：id1=token1,id2=token2
+	if value, ok := os.LookupEnv(configEnvPrefix + "PEER_TOKENS"); ok {
+		pairs, err := parseEnvPairs("PEER_TOKENS", value)
+		if err != nil {
+			return err
+		}
+		tokens := make(map[NodeId]string, len(pairs))
+		for id, token := range pairs {
+			tokens[NodeId(id)] = token
+		}
+		spec.PeerTokens = tokens
+	}
+	return nil
+}
+
+// 解析 k1=v1,k2=v2 格式的环境变量
+func parseEnvPairs(name, value string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("环境变量 %s%s 格式错误：%s", configEnvPrefix, name, pair)
+		}
+		pairs[kv[0]] = kv[1]
+	}
+	return pairs, nil
+}
+
+// 检查配置是否合法
+func (spec ConfigSpec) Validate() error {
+	if spec.Me == None {
+		return fmt.Errorf("缺失 me 配置")
+	}
+	if spec.Role != "" && RoleToString(RoleFromString(spec.Role)) != spec.Role {
+		return fmt.Errorf("role 配置错误：%s", spec.Role)
+	}
+	_, isNonVoter := spec.NonVoters[spec.Me]
+	if _, ok := spec.Peers[spec.Me]; !ok && !isNonVoter && spec.roleStage() != Learner {
+		return fmt.Errorf("peers 中不包含当前节点 %s", spec.Me)
+	}
+	for id := range spec.NonVoters {
+		if _, ok := spec.Peers[id]; ok {
+			return fmt.Errorf("节点 %s 不能同时是投票节点和非投票节点", id)
+		}
+	}
+	if err := validateTimeouts(spec.ElectionMinTimeout, spec.ElectionMaxTimeout, spec.HeartbeatTimeout); err != nil {
+		return err
+	}
+	if spec.MaxLogLength < 0 || spec.MaxLogBytes < 0 || spec.SnapshotInterval < 0 || spec.SnapshotChunkSize < 0 || spec.SnapshotRateLimit < 0 || spec.MaxBatchWait < 0 || spec.MaxBatchBytes < 0 {
+		return fmt.Errorf("maxLogLength、maxLogBytes、snapshotInterval、snapshotChunkSize、snapshotRateLimit、maxBatchWait、maxBatchBytes 不能为负数")
+	}
+	if spec.PromotionMaxLag < 0 || spec.PromotionRounds < 0 || spec.MaxApplyBacklog < 0 || spec.ApplyIndexInterval < 0 || spec.LeadershipTransferTimeout < 0 || spec.MaxTransferQueue < 0 || spec.TransferMaxLag < 0 || spec.RpcTimeout < 0 {
+		return fmt.Errorf("promotionMaxLag、promotionRounds、maxApplyBacklog、applyIndexInterval、leadershipTransferTimeout、maxTransferQueue、transferMaxLag、rpcTimeout 不能为负数")
+	}
+	if spec.MaxReplicationLag < 0 || spec.MaxReplicationLagBytes < 0 || spec.MaxEntrySize < 0 || spec.CompressThreshold < 0 {
+		return fmt.Errorf("maxReplicationLag、maxReplicationLagBytes、maxEntrySize、compressThreshold 不能为负数")
+	}
+	if spec.SlowDiskThreshold < 0 || spec.SlowDiskDuration < 0 || spec.StalePeerTimeout < 0 {
+		return fmt.Errorf("slowDiskThreshold、slowDiskDuration、stalePeerTimeout 不能为负数")
+	}
+	if spec.PeerQueueSize < 0 || spec.ClientQueueSize < 0 {
+		return fmt.Errorf("peerQueueSize、clientQueueSize 不能为负数")
+	}
+	if _, err := CodecByName(spec.Codec); err != nil {
+		return err
+	}
+	if _, err := DiskErrorPolicyFromString(spec.DiskErrorPolicy); err != nil {
+		return err
+	}
+	if _, err := StalePeerPolicyFromString(spec.StalePeerPolicy); err != nil {
+		return err
+	}
+	if _, err := PeerResolverByName(spec.PeerResolver); err != nil {
+		return err
+	}
+	if spec.PeerTokens != nil && spec.AuthToken == "" {
+		return fmt.Errorf("配置 peerTokens 时必须配置 authToken")
+	}
+	tls := spec.Transport.TLS
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		return fmt.Errorf("tls 的 certFile 和 keyFile 必须同时配置")
+	}
+	return nil
+}
+
+// 默认配置，各超时时间适用于同一数据中心内的集群
+// Me、Peers、Fsm、持久化器、Transport 和 Logger 需由用户设置，其余未设置的配置项使用各自的默认行为
+func DefaultConfig() Config {
+	return Config{
+		Role:               Follower,
+		ElectionMinTimeout: 150,
+		ElectionMaxTimeout: 300,
+		HeartbeatTimeout:   50,
+		MaxLogLength:       10000,
+	}
+}
+
+// 检查节点配置是否合法，返回第一个不合法的配置项
+func (config Config) Validate() error {
+	if config.Me == None {
+		return fmt.Errorf("缺失 Me 配置")
+	}
+	if config.Role != Learner && config.Role != Follower {
+		return fmt.Errorf("节点只能以 Learner 或 Follower 角色启动，当前为 %s", RoleToString(config.Role))
+	}
+	if config.Fsm == nil && !config.Witness {
+		return fmt.Errorf("缺失 Fsm 配置")
+	}
+	if config.RaftStatePersister == nil {
+		return fmt.Errorf("缺失 RaftStatePersister 配置")
+	}
+	if config.SnapshotPersister == nil && config.StreamSnapshotPersister == nil {
+		return fmt.Errorf("缺失 SnapshotPersister 配置")
+	}
+	if config.StreamSnapshotPersister != nil {
+		if config.Witness {
+			return fmt.Errorf("见证节点不支持 StreamSnapshotPersister")
+		}
+		if _, ok := config.Fsm.(FsmSnapshotter); !ok {
+			return fmt.Errorf("使用 StreamSnapshotPersister 时 Fsm 需实现 FsmSnapshotter 接口")
+		}
+		if config.KeyProvider != nil {
+			return fmt.Errorf("StreamSnapshotPersister 不支持 KeyProvider 加密，需由存储自行加密")
+		}
+	}
+	if config.Transport == nil {
+		return fmt.Errorf("缺失 Transport 配置")
+	}
+	if config.Logger == nil {
+		return fmt.Errorf("缺失 Logger 配置")
+	}
+	// Peers 为空时由 BootstrapCluster 或持久化的集群配置决定成员
+	_, isNonVoter := config.NonVoters[config.Me]
+	if _, ok := config.Peers[config.Me]; len(config.Peers) > 0 && !ok && !isNonVoter && config.Role != Learner {
+		return fmt.Errorf("Peers 中不包含当前节点 %s", config.Me)
+	}
+	for id := range config.NonVoters {
+		if _, ok := config.Peers[id]; ok {
+			return fmt.Errorf("节点 %s 不能同时是投票节点和非投票节点", id)
+		}
+	}
+	if err := validateTimeouts(config.ElectionMinTimeout, config.ElectionMaxTimeout, config.HeartbeatTimeout); err != nil {
+		return err
+	}
+	if config.MaxLogLength < 0 || config.MaxLogBytes < 0 || config.SnapshotInterval < 0 || config.SnapshotChunkSize < 0 || config.SnapshotRateLimit < 0 || config.MaxBatchWait < 0 || config.MaxBatchBytes < 0 {
+		return fmt.Errorf("MaxLogLength、MaxLogBytes、SnapshotInterval、SnapshotChunkSize、SnapshotRateLimit、MaxBatchWait、MaxBatchBytes 不能为负数")
+	}
+	if config.PromotionMaxLag < 0 || config.PromotionRounds < 0 || config.MaxApplyBacklog < 0 || config.ApplyIndexInterval < 0 || config.LeadershipTransferTimeout < 0 || config.MaxTransferQueue < 0 || config.TransferMaxLag < 0 || config.RpcTimeout < 0 {
+		return fmt.Errorf("PromotionMaxLag、PromotionRounds、MaxApplyBacklog、ApplyIndexInterval、LeadershipTransferTimeout、MaxTransferQueue、TransferMaxLag、RpcTimeout 不能为负数")
+	}
+	if config.MaxReplicationLag < 0 || config.MaxReplicationLagBytes < 0 || config.MaxEntrySize < 0 || config.CompressThreshold < 0 {
+		return fmt.Errorf("MaxReplicationLag、MaxReplicationLagBytes、MaxEntrySize、CompressThreshold 不能为负数")
+	}
+	if config.SlowDiskThreshold < 0 || config.SlowDiskDuration < 0 || config.PeerQueueSize < 0 || config.ClientQueueSize < 0 {
+		return fmt.Errorf("SlowDiskThreshold、SlowDiskDuration、PeerQueueSize、ClientQueueSize 不能为负数")
+	}
+	if DiskErrorPolicyToString(config.DiskErrorPolicy) == "" {
+		return fmt.Errorf("不支持的磁盘错误处理策略：%d", config.DiskErrorPolicy)
+	}
+	if StalePeerPolicyToString(config.StalePeerPolicy) == "" {
+		return fmt.Errorf("不支持的失联节点处理策略：%d", config.StalePeerPolicy)
+	}
+	if config.StalePeerTimeout < 0 {
+		return fmt.Errorf("StalePeerTimeout 不能为负数")
+	}
+	if config.PeerTokens != nil && config.AuthToken == "" {
+		return fmt.Errorf("设置 PeerTokens 时必须设置 AuthToken")
+	}
+	return nil
+}
+
+// 检查选举和心跳超时时间
+func validateTimeouts(electionMin, electionMax, heartbeat int) error {
+	if electionMin <= 0 || electionMax <= 0 || heartbeat <= 0 {
+		return fmt.Errorf("超时时间必须大于 0")
+	}
+	if electionMin >= electionMax {
+		return fmt.Errorf("electionMinTimeout 必须小于 electionMaxTimeout")
+	}
+	if heartbeat >= electionMin {
+		return fmt.Errorf("heartbeatTimeout 必须小于 electionMinTimeout")
+	}
+	return nil
+}
+
+// 生成节点配置，Fsm、持久化器、Transport 和 Logger 需由用户设置
+func (spec ConfigSpec) Config() Config {
+	return Config{
+		Peers:                     spec.Peers,
+		NonVoters:                 spec.NonVoters,
+		Me:                        spec.Me,
+		Role:                      spec.roleStage(),
+		ElectionMinTimeout:        spec.ElectionMinTimeout,
+		ElectionMaxTimeout:        spec.ElectionMaxTimeout,
+		HeartbeatTimeout:          spec.HeartbeatTimeout,
+		MaxLogLength:              spec.MaxLogLength,
+		MaxLogBytes:               spec.MaxLogBytes,
+		SnapshotInterval:          spec.SnapshotInterval,
+		SnapshotChunkSize:         spec.SnapshotChunkSize,
+		SnapshotRateLimit:         spec.SnapshotRateLimit,
+		MaxBatchWait:              spec.MaxBatchWait,
+		MaxBatchBytes:             spec.MaxBatchBytes,
+		PreVote:                   spec.PreVote,
+		Witness:                   spec.Witness,
+		ProposalLeaseCheck:        spec.ProposalLeaseCheck,
+		Debug:                     spec.Debug,
+		PromotionMaxLag:           spec.PromotionMaxLag,
+		PromotionRounds:           spec.PromotionRounds,
+		MaxApplyBacklog:           spec.MaxApplyBacklog,
+		ApplyIndexInterval:        spec.ApplyIndexInterval,
+		SlowDiskThreshold:         spec.SlowDiskThreshold,
+		SlowDiskDuration:          spec.SlowDiskDuration,
+		DiskErrorPolicy:           spec.diskErrorPolicy(),
+		StalePeerPolicy:           spec.stalePeerPolicy(),
+		StalePeerTimeout:          spec.StalePeerTimeout,
+		LeadershipTransferTimeout: spec.LeadershipTransferTimeout,
+		MaxTransferQueue:          spec.MaxTransferQueue,
+		TransferMaxLag:            spec.TransferMaxLag,
+		PeerQueueSize:             spec.PeerQueueSize,
+		ClientQueueSize:           spec.ClientQueueSize,
+		RpcTimeout:                spec.RpcTimeout,
+		ForwardApply:              spec.ForwardApply,
+		MaxReplicationLag:         spec.MaxReplicationLag,
+		MaxReplicationLagBytes:    spec.MaxReplicationLagBytes,
+		AdminAddr:                 spec.AdminAddr,
+		MaxEntrySize:              spec.MaxEntrySize,
+		CompressThreshold:         spec.CompressThreshold,
+		FsmVersion:                spec.FsmVersion,
+		AuthToken:                 spec.AuthToken,
+		PeerTokens:                spec.PeerTokens,
+		Codec:                     spec.codec(),
+		PeerResolver:              spec.peerResolver(),
+	}
+}
+
+// 配置日志的编解码器，名称不合法时使用默认编解码器，需先调用 Validate 检查
+func (spec ConfigSpec) codec() Codec {
+	codec, _ := CodecByName(spec.Codec)
+	return codec
+}
+
+// 节点地址解析器，名称不合法时不解析，需先调用 Validate 检查
+func (spec ConfigSpec) peerResolver() PeerResolver {
+	resolver, _ := PeerResolverByName(spec.PeerResolver)
+	return resolver
+}
+
+// 磁盘错误的处理策略，名称不合法时使用默认策略，需先调用 Validate 检查
+func (spec ConfigSpec) diskErrorPolicy() DiskErrorPolicy {
+	policy, _ := DiskErrorPolicyFromString(spec.DiskErrorPolicy)
+	return policy
+}
+
+// 失联节点的处理策略，名称不合法时使用默认策略，需先调用 Validate 检查
+func (spec ConfigSpec) stalePeerPolicy() StalePeerPolicy {
+	policy, _ := StalePeerPolicyFromString(spec.StalePeerPolicy)
+	return policy
+}
+
+// 节点角色，未配置时为 Follower
+func (spec ConfigSpec) roleStage() RoleStage {
+	if spec.Role == "" {
+		return Follower
+	}
+	return RoleFromString(spec.Role)
+}
@@ -0,0 +1,106 @@
+//go:build raftdev
+// +build raftdev
+
+package raft
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// 开发调试用的交互式控制台，使用 raftdev 构建标签启用
+// 控制台附加到一个本地节点上，可查看节点状态、提交测试命令、强制选举及生成快照
+
+const consoleHelp = `可用命令：
+  status            查看节点状态
+  progress          查看各节点的日志复制进度，只有 Leader 可用
+  propose <data>    提交一条测试命令
+  elect             选举计时器立即到期，强制开始选举
+  snapshot          立即生成快照
+  help              显示帮助
+  quit              退出控制台
+`
+
+// 从 in 读取命令并执行，结果输出到 out，读取到 quit 命令或输入结束时返回
+func RunConsole(nd *Node, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, "raft> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch cmd {
+		case "":
+		case "status":
+			consoleStatus(nd.raft, out)
+		case "progress":
+			consoleProgress(nd, out)
+		case "propose":
+			var res ApplyCommandReply
+			if err := nd.ApplyCommand(ApplyCommand{Data: []byte(arg)}, &res); err != nil {
+				fmt.Fprintf(out, "提交失败：%s\n", err)
+			} else if res.Status == NotLeader {
+				fmt.Fprintf(out, "当前节点不是 Leader，Leader=%s(%s)\n", res.Leader.Id, res.Leader.Addr)
+			} else {
+				fmt.Fprintf(out, "提交成功，结果：%q\n", res.Result)
+			}
+		case "elect":
+			switch rf := nd.raft; rf.roleState.getRoleStage() {
+			case Leader:
+				fmt.Fprintln(out, "当前节点已是 Leader")
+			case Learner:
+				fmt.Fprintln(out, "Learner 节点不参与选举")
+			default:
+				rf.timerState.expireNow()
+				fmt.Fprintln(out, "选举计时器已到期，开始选举")
+			}
+		case "snapshot":
+			if meta, err := nd.Snapshot(); err != nil {
+				fmt.Fprintf(out, "生成快照失败：%s\n", err)
+			} else {
+				fmt.Fprintf(out, "生成快照成功，LastIndex=%d, LastTerm=%d, Size=%d\n",
+					meta.LastIndex, meta.LastTerm, meta.Size)
+			}
+		case "help":
+			fmt.Fprint(out, consoleHelp)
+		case "quit", "exit":
+			return nil
+		default:
+			fmt.Fprintf(out, "未知命令：%s\n%s", cmd, consoleHelp)
+		}
+		fmt.Fprint(out, "raft> ")
+	}
+	return scanner.Err()
+}
+
+func consoleStatus(rf *raft, out io.Writer) {
+	snapshot := rf.snapshotState.getSnapshot()
+	leader := rf.peerState.getLeader()
+	fmt.Fprintf(out, "Id:          %s\n", rf.peerState.myId())
+	fmt.Fprintf(out, "Role:        %s\n", RoleToString(rf.roleState.getRoleStage()))
+	fmt.Fprintf(out, "Term:        %d\n", rf.hardState.currentTerm())
+	fmt.Fprintf(out, "VotedFor:    %s\n", rf.hardState.voted())
+	fmt.Fprintf(out, "Leader:      %s(%s)\n", leader.Id, leader.Addr)
+	fmt.Fprintf(out, "CommitIndex: %d\n", rf.softState.getCommitIndex())
+	fmt.Fprintf(out, "LastApplied: %d\n", rf.softState.getLastApplied())
+	fmt.Fprintf(out, "LastLog:     index=%d, term=%d\n", rf.lastEntryIndex(), rf.lastEntryTerm())
+	fmt.Fprintf(out, "Snapshot:    index=%d, term=%d\n", snapshot.LastIndex, snapshot.LastTerm)
+	fmt.Fprintf(out, "Peers:       %v\n", rf.peerState.peers())
+}
+
+func consoleProgress(nd *Node, out io.Writer) {
+	progress, err := nd.Progress()
+	if err != nil {
+		fmt.Fprintf(out, "查询失败：%s\n", err)
+		return
+	}
+	for id, p := range progress {
+		fmt.Fprintf(out, "%s: state=%s, match=%d, next=%d, lag=%d, busy=%t, lastContact=%s\n",
+			id, ProgressStateToString(p.State), p.MatchIndex, p.NextIndex, p.Lag, p.RpcBusy, p.LastContact.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,275 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 磁盘写入监测
+// 设置 Config.SlowDiskThreshold 后记录每次保存 RaftState 的耗时，超过阈值的写入视为缓慢写入，
+// 连续的缓慢写入持续 Config.SlowDiskDuration 以上时判定磁盘缓慢
+// Leader 的每次写入都在客户端请求的关键路径上，磁盘缓慢的 Leader 会拖慢整个集群，
+// 因此 Leader 发现磁盘缓慢后发出 EventSlowDisk 事件，并主动将领导权转移给日志最新的投票节点
+
+//
+// 设置 Config.DiskErrorPolicy 后，持久化器返回磁盘已满、配额不足、只读或 IO 错误时，节点按策略处理并发出 EventDiskError 事件：
+// 退位策略下 Leader 退位为 Follower；只读策略下 Leader 同样退位，节点不再发起选举并驳回客户端命令，
+// 直到再次写入成功；停止策略下关闭节点。自定义的持久化器可包装 ErrDiskFault 表示磁盘故障
+
+// 判定磁盘缓慢所需的默认持续时间
+const defaultSlowDiskDuration = 10 * time.Second
+
+// 停止策略下关闭节点的超时时间
+const diskHaltTimeout = 10 * time.Second
+
+// 磁盘错误的处理策略
+type DiskErrorPolicy uint8
+
+const (
+	DiskErrorIgnore   DiskErrorPolicy = iota // 只将错误返回给调用方，默认策略
+	DiskErrorStepDown                        // Leader 退位为 Follower
+	DiskErrorReadOnly                        // Leader 退位，节点不再发起选举并驳回客户端命令，直到再次写入成功
+	DiskErrorHalt                            // 关闭节点
+)
+
+func DiskErrorPolicyFromString(policy string) (DiskErrorPolicy, error) {
+	switch policy {
+	case "", "ignore":
+		return DiskErrorIgnore, nil
+	case "stepDown":
+		return DiskErrorStepDown, nil
+	case "readOnly":
+		return DiskErrorReadOnly, nil
+	case "halt":
+		return DiskErrorHalt, nil
+	}
+	return DiskErrorIgnore, fmt.Errorf("不支持的磁盘错误处理策略：%s", policy)
+}
+
+func DiskErrorPolicyToString(policy DiskErrorPolicy) (name string) {
+	switch policy {
+	case DiskErrorIgnore:
+		name = "ignore"
+	case DiskErrorStepDown:
+		name = "stepDown"
+	case DiskErrorReadOnly:
+		name = "readOnly"
+	case DiskErrorHalt:
+		name = "halt"
+	}
+	return
+}
+
+// 是否是磁盘故障导致的错误
+func isDiskError(err error) bool {
+	return errors.Is(err, ErrDiskFault) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) ||
+		errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EROFS)
+}
+
+// 磁盘故障状态
+type diskFault struct {
+	policy DiskErrorPolicy
+	err    error // 最近一次磁盘错误，写入成功后清除
+	mu     sync.Mutex
+}
+
+// 记录磁盘错误，返回是否是新发生的故障
+func (f *diskFault) fail(err error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	first := f.err == nil
+	f.err = err
+	return first
+}
+
+// 写入成功，返回之前是否处于故障状态
+func (f *diskFault) recover() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failed := f.err != nil
+	f.err = nil
+	return failed
+}
+
+func (f *diskFault) failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err != nil
+}
+
+// 只读模式下不发起选举，驳回客户端命令
+func (f *diskFault) readOnly() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.policy == DiskErrorReadOnly && f.err != nil
+}
+
+type diskMonitor struct {
+	threshold time.Duration // 单次写入的耗时阈值
+	duration  time.Duration // 缓慢写入持续此时间后判定磁盘缓慢
+	slowSince time.Time     // 本轮连续缓慢写入中第一次写入的时间，为零值表示最近一次写入不慢
+	slowUntil time.Time     // 本轮连续缓慢写入中最后一次写入的时间
+	mu        sync.Mutex
+}
+
+// 未设置 SlowDiskThreshold 时返回 nil，不检测
+func newDiskMonitor(config Config) *diskMonitor {
+	if config.SlowDiskThreshold <= 0 {
+		return nil
+	}
+	duration := time.Millisecond * time.Duration(config.SlowDiskDuration)
+	if duration <= 0 {
+		duration = defaultSlowDiskDuration
+	}
+	return &diskMonitor{
+		threshold: time.Millisecond * time.Duration(config.SlowDiskThreshold),
+		duration:  duration,
+	}
+}
+
+// 记录一次写入，end 为写入完成的时间
+func (m *diskMonitor) observe(elapsed time.Duration, end time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elapsed < m.threshold {
+		m.slowSince = time.Time{}
+		return
+	}
+	if m.slowSince.IsZero() {
+		m.slowSince = end
+	}
+	m.slowUntil = end
+}
+
+// 磁盘是否缓慢，返回连续缓慢写入持续的时间
+func (m *diskMonitor) slow() (time.Duration, bool) {
+	if m == nil {
+		return 0, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.slowSince.IsZero() {
+		return 0, false
+	}
+	lasting := m.slowUntil.Sub(m.slowSince)
+	return lasting, lasting >= m.duration
+}
+
+// 已处理本轮缓慢写入，重新开始计时
+func (m *diskMonitor) reset() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slowSince = time.Time{}
+}
+
+// 记录写入耗时和写入结果的 RaftStatePersister
+type diskRaftStatePersister struct {
+	persister RaftStatePersister
+	metrics   MetricsSink
+	monitor   *diskMonitor
+	onSave    func(err error) // 每次写入后调用，可能持有 HardState 的锁，不能阻塞
+}
+
+func (ps *diskRaftStatePersister) SaveRaftState(state RaftState) error {
+	start := time.Now()
+	err := ps.persister.SaveRaftState(state)
+	end := time.Now()
+	ps.metrics.Observe(MetricPersistDuration, end.Sub(start).Seconds())
+	ps.monitor.observe(end.Sub(start), end)
+	if ps.onSave != nil {
+		ps.onSave(err)
+	}
+	return err
+}
+
+func (ps *diskRaftStatePersister) LoadRaftState() (RaftState, error) {
+	return ps.persister.LoadRaftState()
+}
+
+// 记录写入结果的 SnapshotPersister
+type diskSnapshotPersister struct {
+	persister SnapshotPersister
+	onSave    func(err error)
+}
+
+func (ps *diskSnapshotPersister) SaveSnapshot(snapshot Snapshot) error {
+	err := ps.persister.SaveSnapshot(snapshot)
+	if ps.onSave != nil {
+		ps.onSave(err)
+	}
+	return err
+}
+
+func (ps *diskSnapshotPersister) LoadSnapshot() (Snapshot, error) {
+	return ps.persister.LoadSnapshot()
+}
+
+// 持久化器的写入结果，可能持有 HardState 的锁，只记录状态，由其它协程处理
+func (rf *raft) onPersist(err error) {
+	if err == nil {
+		if rf.diskFault.recover() {
+			rf.logger.Warn("磁盘恢复写入")
+		}
+		return
+	}
+	if rf.diskFault.policy == DiskErrorIgnore || !isDiskError(err) {
+		return
+	}
+	if rf.diskFault.fail(err) {
+		// 停止策略下关闭节点时会等待 goFunc 开启的协程退出，此处不使用 goFunc
+		go rf.handleDiskError(err)
+	}
+}
+
+// 发生磁盘故障时发出事件，停止策略下关闭节点，Leader 在下一次心跳时退位
+func (rf *raft) handleDiskError(err error) {
+	policy := rf.diskFault.policy
+	rf.logger.Error(fmt.Sprintf("磁盘故障，按 %s 策略处理：%s", DiskErrorPolicyToString(policy), err))
+	rf.notifyObservers(Event{Type: EventDiskError, Term: rf.hardState.currentTerm(), Err: err})
+	if policy != DiskErrorHalt {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diskHaltTimeout)
+	defer cancel()
+	if shutdownErr := rf.shutdown(ctx); shutdownErr != nil {
+		rf.logger.Error(fmt.Errorf("磁盘故障，关闭节点失败：%w", shutdownErr).Error())
+	}
+}
+
+// 磁盘故障时 Leader 退位为 Follower，返回是否已退位，故障节点无法持久化新的任期和投票，不会再次当选
+func (rf *raft) checkDiskFault() bool {
+	if rf.diskFault.policy == DiskErrorIgnore || rf.diskFault.policy == DiskErrorHalt || !rf.diskFault.failed() {
+		return false
+	}
+	rf.logger.Warn("磁盘故障，Leader 退位")
+	rf.setRoleStage(Follower)
+	rf.onRoleChange(Follower)
+	return true
+}
+
+// Leader 的磁盘持续缓慢时，发出事件并主动转移领导权
+// 未能转移时（如没有可选的目标节点）等待下一轮缓慢写入持续足够时间后再尝试
+func (rf *raft) checkSlowDisk() {
+	lasting, slow := rf.disk.slow()
+	if !slow {
+		return
+	}
+	if _, busy := rf.leaderState.isTransferBusy(); busy {
+		return
+	}
+	rf.disk.reset()
+	rf.logger.Warn(fmt.Sprintf("磁盘写入持续缓慢 %s，主动转移领导权", lasting))
+	rf.notifyObservers(Event{Type: EventSlowDisk, Term: rf.hardState.currentTerm(), Duration: lasting})
+	// 没有客户端等待结果，应答通道带缓冲，转移结束时不会阻塞
+	rf.handleTransfer(rpc{rpcType: TransferLeadershipRpc, req: TransferLeadership{}, res: make(chan rpcReply, 1)})
+}
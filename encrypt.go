@@ -0,0 +1,296 @@
+package raft
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 持久化数据加密
+// 使用信封加密：每个持久化器在首次保存时生成随机的数据密钥，用 AES-GCM 加密日志数据、集群配置和快照数据，
+// 数据密钥由 KeyProvider 的主密钥加密后与密文保存在一起，主密钥本身不落盘
+// 日志的索引、任期、类型等元数据不加密，持久化器中的空数据保持为空
+// 加密需在节点首次启动时开启，已有的明文数据无法直接读取，可清空数据后作为新节点重新加入集群
+
+// 密文格式版本
+const envelopeVersion = 1
+
+// 数据密钥的字节数，使用 AES-256
+const dataKeySize = 32
+
+// 主密钥提供者，由用户实现，可对接 KMS、HSM 等密钥管理服务
+type KeyProvider interface {
+	// 使用当前主密钥加密数据密钥，返回主密钥 id 和加密后的数据密钥
+	WrapKey(dataKey []byte) (keyId string, wrapped []byte, err error)
+	// 使用 id 对应的主密钥解密数据密钥，主密钥轮换后旧的主密钥仍需可用，直到数据全部重写
+	UnwrapKey(keyId string, wrapped []byte) ([]byte, error)
+}
+
+// 使用本地主密钥的 KeyProvider，开发测试或没有密钥管理服务时使用
+type StaticKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// keys 是主密钥 id 到 16、24 或 32 字节 AES 密钥的映射，current 是加密新数据使用的主密钥 id
+func NewStaticKeyProvider(current string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("主密钥 %s 不存在", current)
+	}
+	p := &StaticKeyProvider{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("主密钥 %s 不可用：%w", id, err)
+		}
+		p.keys[id] = aead
+	}
+	return p, nil
+}
+
+func (p *StaticKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(p.keys[p.current], dataKey, []byte(p.current))
+	if err != nil {
+		return "", nil, err
+	}
+	return p.current, wrapped, nil
+}
+
+func (p *StaticKeyProvider) UnwrapKey(keyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("主密钥 %s 不存在", keyId)
+	}
+	return open(aead, wrapped, []byte(keyId))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 加密，返回 nonce 和密文拼接的结果
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("密文长度不足")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// 信封加密，持有当前使用的数据密钥和解密过的数据密钥缓存
+type envelope struct {
+	keys    KeyProvider
+	aead    cipher.AEAD            // 当前使用的数据密钥
+	header  []byte                 // 当前数据密钥对应的密文头部：版本、主密钥 id、加密后的数据密钥
+	unwraps map[string]cipher.AEAD // 密文头部到数据密钥的缓存
+	mu      sync.Mutex
+}
+
+func newEnvelope(keys KeyProvider) *envelope {
+	return &envelope{keys: keys, unwraps: make(map[string]cipher.AEAD)}
+}
+
+// 加密数据，aad 将密文与其所在的位置绑定，防止密文被替换到其它位置
+func (e *envelope) seal(plaintext, aad []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return plaintext, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.aead == nil {
+		if err := e.newDataKey(); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := seal(e.aead, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, len(e.header)+len(sealed)), e.header...), sealed...), nil
+}
+
+// 生成新的数据密钥，并使用主密钥加密
+func (e *envelope) newDataKey() error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return fmt.Errorf("生成数据密钥失败：%w", err)
+	}
+	keyId, wrapped, err := e.keys.WrapKey(dataKey)
+	if err != nil {
+		return fmt.Errorf("加密数据密钥失败：%w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	header := []byte{envelopeVersion}
+	header = appendChunk(header, []byte(keyId))
+	header = appendChunk(header, wrapped)
+	e.aead, e.header = aead, header
+	e.unwraps[string(header)] = aead
+	return nil
+}
+
+func (e *envelope) open(data, aad []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if data[0] != envelopeVersion {
+		return nil, fmt.Errorf("不支持的密文版本 %d", data[0])
+	}
+	keyId, rest, ok := readChunk(data[1:])
+	if !ok {
+		return nil, errors.New("密文头部不完整")
+	}
+	wrapped, sealed, ok := readChunk(rest)
+	if !ok {
+		return nil, errors.New("密文头部不完整")
+	}
+	header := data[:len(data)-len(sealed)]
+
+	e.mu.Lock()
+	aead, ok := e.unwraps[string(header)]
+	if !ok {
+		dataKey, err := e.keys.UnwrapKey(string(keyId), wrapped)
+		if err != nil {
+			e.mu.Unlock()
+			return nil, fmt.Errorf("使用主密钥 %s 解密数据密钥失败：%w", keyId, err)
+		}
+		if aead, err = newAEAD(dataKey); err != nil {
+			e.mu.Unlock()
+			return nil, err
+		}
+		e.unwraps[string(header)] = aead
+	}
+	e.mu.Unlock()
+	return open(aead, sealed, aad)
+}
+
+// 写入两字节长度前缀和数据
+func appendChunk(dst, chunk []byte) []byte {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(chunk)))
+	return append(append(dst, size[:]...), chunk...)
+}
+
+func readChunk(data []byte) (chunk, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	size := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+size {
+		return nil, nil, false
+	}
+	return data[2 : 2+size], data[2+size:], true
+}
+
+// 附加数据，标明密文的用途和所在位置
+func sealAAD(kind string, index, term int) []byte {
+	aad := make([]byte, len(kind)+16)
+	copy(aad, kind)
+	binary.BigEndian.PutUint64(aad[len(kind):], uint64(index))
+	binary.BigEndian.PutUint64(aad[len(kind)+8:], uint64(term))
+	return aad
+}
+
+// 加密的 RaftStatePersister，日志数据和集群配置加密后交给内部的持久化器保存
+type encryptedRaftStatePersister struct {
+	persister RaftStatePersister
+	envelope  *envelope
+}
+
+// 使用 keys 加密 persister 保存的日志数据和集群配置
+// Config.KeyProvider 不为空时节点会自动包装持久化器，离线调用 RecoverCluster 等函数时需传入包装后的持久化器
+func NewEncryptedRaftStatePersister(persister RaftStatePersister, keys KeyProvider) RaftStatePersister {
+	return &encryptedRaftStatePersister{persister: persister, envelope: newEnvelope(keys)}
+}
+
+func (ps *encryptedRaftStatePersister) SaveRaftState(state RaftState) error {
+	entries := make([]Entry, len(state.Entries))
+	for i, entry := range state.Entries {
+		data, err := ps.envelope.seal(entry.Data, sealAAD("entry", entry.Index, entry.Term))
+		if err != nil {
+			return fmt.Errorf("加密 index=%d 的日志失败：%w", entry.Index, err)
+		}
+		entry.Data = data
+		entries[i] = entry
+	}
+	config, err := ps.envelope.seal(state.Config, sealAAD("config", state.ConfigIndex, 0))
+	if err != nil {
+		return fmt.Errorf("加密集群配置失败：%w", err)
+	}
+	state.Entries, state.Config = entries, config
+	return ps.persister.SaveRaftState(state)
+}
+
+func (ps *encryptedRaftStatePersister) LoadRaftState() (RaftState, error) {
+	state, err := ps.persister.LoadRaftState()
+	if err != nil {
+		return state, err
+	}
+	entries := make([]Entry, len(state.Entries))
+	for i, entry := range state.Entries {
+		data, err := ps.envelope.open(entry.Data, sealAAD("entry", entry.Index, entry.Term))
+		if err != nil {
+			return state, fmt.Errorf("解密 index=%d 的日志失败：%w", entry.Index, err)
+		}
+		entry.Data = data
+		entries[i] = entry
+	}
+	config, err := ps.envelope.open(state.Config, sealAAD("config", state.ConfigIndex, 0))
+	if err != nil {
+		return state, fmt.Errorf("解密集群配置失败：%w", err)
+	}
+	state.Entries, state.Config = entries, config
+	return state, nil
+}
+
+// 加密的 SnapshotPersister，快照数据加密后交给内部的持久化器保存
+type encryptedSnapshotPersister struct {
+	persister SnapshotPersister
+	envelope  *envelope
+}
+
+// 使用 keys 加密 persister 保存的快照数据，StreamSnapshotPersister 不支持加密，需由存储自行加密
+func NewEncryptedSnapshotPersister(persister SnapshotPersister, keys KeyProvider) SnapshotPersister {
+	return &encryptedSnapshotPersister{persister: persister, envelope: newEnvelope(keys)}
+}
+
+func (ps *encryptedSnapshotPersister) SaveSnapshot(snapshot Snapshot) error {
+	data, err := ps.envelope.seal(snapshot.Data, sealAAD("snapshot", snapshot.LastIndex, snapshot.LastTerm))
+	if err != nil {
+		return fmt.Errorf("加密快照失败：%w", err)
+	}
+	snapshot.Data = data
+	return ps.persister.SaveSnapshot(snapshot)
+}
+
+func (ps *encryptedSnapshotPersister) LoadSnapshot() (Snapshot, error) {
+	snapshot, err := ps.persister.LoadSnapshot()
+	if err != nil {
+		return snapshot, err
+	}
+	data, err := ps.envelope.open(snapshot.Data, sealAAD("snapshot", snapshot.LastIndex, snapshot.LastTerm))
+	if err != nil {
+		return snapshot, fmt.Errorf("解密快照失败：%w", err)
+	}
+	snapshot.Data = data
+	return snapshot, nil
+}
@@ -0,0 +1,155 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 节点已关闭
+var ErrShutdown = errors.New("节点已关闭")
+
+// 上一次成员变更的配置日志尚未提交，不能开始新的成员变更
+var ErrConfigChangePending = errors.New("上一次成员变更尚未提交")
+
+// 新节点正在作为 Learner 追赶日志，满足升级条件后才能成为投票节点，客户端可稍后重试
+var ErrLearnerCatchingUp = errors.New("新节点正在追赶日志，请稍后重试")
+
+// 节点已有日志或快照，不能再初始化集群
+var ErrAlreadyBootstrapped = errors.New("集群已初始化")
+
+// 客户端等待操作完成超时
+var ErrTimeout = errors.New("操作超时")
+
+// 领导权转移未指定目标节点，且没有可以接收领导权的投票节点
+var ErrNoTransferee = errors.New("没有可以接收领导权的节点")
+
+// 指定的领导权转移目标节点不能接收领导权
+var ErrInvalidTransferee = errors.New("目标节点不能接收领导权")
+
+// 领导权转移的目标节点不是投票节点、是见证节点或已不可达，Reason 为具体原因
+type TransfereeError struct {
+	Id     NodeId // 目标节点
+	Reason string
+}
+
+func (e TransfereeError) Error() string {
+	return fmt.Sprintf("%s：Id=%s %s", ErrInvalidTransferee, e.Id, e.Reason)
+}
+
+func (e TransfereeError) Unwrap() error {
+	return ErrInvalidTransferee
+}
+
+// 领导权转移被客户端取消
+var ErrTransferAborted = errors.New("领导权转移已取消")
+
+// Leader 正在进行领导权转移，暂不处理此请求，客户端可在转移结束后重试
+var ErrLeadershipTransferInProgress = errors.New("正在进行领导权转移")
+
+// 请求的日志已被压缩到快照中
+var ErrLogCompacted = errors.New("日志已被压缩")
+
+// Transport 没有实现 PreVoteTransport 或 PreVoteContextTransport，不能发送 PreVote 请求
+var ErrPreVoteUnsupported = errors.New("Transport 不支持 PreVote 请求")
+
+// MultiNode 中不存在此 raft 组
+var ErrGroupNotFound = errors.New("raft 组不存在")
+
+// MultiNode 中已存在同名的 raft 组
+var ErrGroupExists = errors.New("raft 组已存在")
+
+// 当前节点不是 Leader，不能处理只能由 Leader 处理的请求
+type NotLeaderError struct {
+	Leader Server // 集群当前的 Leader，客户端可据此重定向请求
+}
+
+func (e NotLeaderError) Error() string {
+	return fmt.Sprintf("当前节点不是 Leader。Leader=%s", e.Leader.Id)
+}
+
+// 其它节点发来的请求没有通过认证
+var ErrUnauthenticated = errors.New("rpc 认证失败")
+
+// 持久化的数据校验失败
+var ErrCorrupt = errors.New("持久化数据已损坏")
+
+// 持久化的日志或快照校验失败，FirstIndex 到 LastIndex 是损坏的日志索引范围
+// 可调用 TruncateCorruptLog 截断损坏的日志，节点重启后从 Leader 重新复制
+type CorruptError struct {
+	Target     string // 损坏的数据：日志或快照
+	FirstIndex int
+	LastIndex  int
+}
+
+func (e CorruptError) Error() string {
+	return fmt.Sprintf("%s：%s校验失败，index=%d~%d", ErrCorrupt, e.Target, e.FirstIndex, e.LastIndex)
+}
+
+func (e CorruptError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// 接收的快照与当前节点的状态机版本不同，拒绝安装
+type SnapshotVersionError struct {
+	Version  string // 快照的状态机版本
+	Expected string // 当前节点的状态机版本
+}
+
+func (e SnapshotVersionError) Error() string {
+	return fmt.Sprintf("快照的状态机版本 %s 与当前节点的 %s 不同", e.Version, e.Expected)
+}
+
+// 客户端命令或日志的数据超过 MaxEntrySize
+type EntryTooLargeError struct {
+	Size  int // 数据的字节数
+	Limit int // 允许的最大字节数
+}
+
+func (e EntryTooLargeError) Error() string {
+	return fmt.Sprintf("日志数据过大：%d 字节，上限为 %d 字节", e.Size, e.Limit)
+}
+
+// 节点正在下线，不再接收新的客户端请求
+type DrainingError struct {
+	Leader Server // 集群当前的 Leader，客户端可据此重定向请求
+}
+
+func (e DrainingError) Error() string {
+	return fmt.Sprintf("节点正在下线，不再接收新的请求。Leader=%s", e.Leader.Id)
+}
+
+// 磁盘故障，自定义的持久化器可包装此错误，使节点按 Config.DiskErrorPolicy 处理
+var ErrDiskFault = errors.New("磁盘故障")
+
+// 节点因磁盘故障或运维设置处于只读模式，不再接收客户端命令，客户端可重试其它节点
+var ErrReadOnly = errors.New("节点处于只读模式")
+
+// Leader 最近未收到法定人数节点的应答，可能已被隔离，客户端可重试其它节点
+var ErrLeadershipLost = errors.New("Leader 租约已过期，可能已失去领导权")
+
+// 节点过载，请求队列已满，客户端可稍后重试
+var ErrBusy = errors.New("节点繁忙")
+
+// 请求所在的队列已满，Queue 为 peer（其它节点发来的请求）或 client（客户端请求）
+type BusyError struct {
+	Queue string
+	Limit int // 队列的排队上限
+}
+
+func (e BusyError) Error() string {
+	return fmt.Sprintf("%s：%s 请求队列已满，上限为 %d", ErrBusy, e.Queue, e.Limit)
+}
+
+func (e BusyError) Unwrap() error {
+	return ErrBusy
+}
+
+// 不变量检查发现的违反，设置 Config.Debug 时随 EventInvariantViolated 事件发出
+type InvariantError struct {
+	Invariant string // 违反的不变量
+	Detail    string // 违反时的状态
+}
+
+func (e InvariantError) Error() string {
+	return fmt.Sprintf("违反不变量“%s”：%s", e.Invariant, e.Detail)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitcapybara/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KV 服务客户端，请求发送到非 Leader 节点时自动重定向
+// 同一客户端的请求使用同一个会话，重试时序号不变，服务端据此去重
+type kvClient struct {
+	clientId string
+	seq      uint64
+	addr     string
+	conn     *grpc.ClientConn
+}
+
+func newKvClient(clientId, addr string) (*kvClient, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &kvClient{clientId: clientId, addr: addr, conn: conn}, nil
+}
+
+func (c *kvClient) Put(ctx context.Context, key, value string) (*KVReply, error) {
+	req := &PutRequest{Key: key, Value: value, ClientId: c.clientId, Seq: c.nextSeq()}
+	res := new(KVReply)
+	return res, c.invoke(ctx, "Put", req, res)
+}
+
+func (c *kvClient) Get(ctx context.Context, key string, stale bool) (*KVReply, error) {
+	req := &GetRequest{Key: key, ClientId: c.clientId, Seq: c.nextSeq(), Stale: stale}
+	res := new(KVReply)
+	return res, c.invoke(ctx, "Get", req, res)
+}
+
+func (c *kvClient) Delete(ctx context.Context, key string) (*KVReply, error) {
+	req := &DeleteRequest{Key: key, ClientId: c.clientId, Seq: c.nextSeq()}
+	res := new(KVReply)
+	return res, c.invoke(ctx, "Delete", req, res)
+}
+
+func (c *kvClient) Members(ctx context.Context, peers map[raft.NodeId]raft.NodeAddr) error {
+	return c.invoke(ctx, "Members", &MembersRequest{Peers: peers}, new(MembersReply))
+}
+
+func (c *kvClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *kvClient) nextSeq() uint64 {
+	return atomic.AddUint64(&c.seq, 1)
+}
+
+// 发送请求，遇到非 Leader 节点时重定向到 Leader，Leader 未知时稍后重试
+func (c *kvClient) invoke(ctx context.Context, method string, req, res interface{}) error {
+	for {
+		err := c.conn.Invoke(ctx, "/"+kvServiceName+"/"+method, req, res)
+		st, ok := status.FromError(err)
+		if err == nil || !ok || st.Code() != codes.FailedPrecondition || !strings.HasPrefix(st.Message(), notLeaderPrefix) {
+			return err
+		}
+		if leader := strings.TrimPrefix(st.Message(), notLeaderPrefix); leader != "" && leader != c.addr {
+			conn, dialErr := dial(leader)
+			if dialErr != nil {
+				return fmt.Errorf("连接 Leader 节点失败：%w", dialErr)
+			}
+			_ = c.conn.Close()
+			c.conn, c.addr = conn, leader
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 状态机命令类型
+const (
+	opPut = "put"
+	opGet = "get"
+	opDel = "del"
+)
+
+// 写入日志的状态机命令
+type command struct {
+	Op       string
+	Key      string
+	Value    string
+	ClientId string // 客户端会话标识
+	Seq      uint64 // 客户端会话内单调递增的请求序号，用于去重
+}
+
+// 命令应用到状态机后的结果
+type result struct {
+	Value string
+	Found bool
+}
+
+// 客户端会话，记录最后一次应用的请求及其结果
+// 客户端重试已应用的请求时，直接返回记录的结果，保证每个请求只应用一次
+type session struct {
+	Seq    uint64
+	Result []byte
+}
+
+// 复制的键值对状态机，实现 raft.Fsm 接口
+type kvFsm struct {
+	data     map[string]string
+	sessions map[string]session
+	mu       sync.Mutex
+}
+
+func newKvFsm() *kvFsm {
+	return &kvFsm{
+		data:     make(map[string]string),
+		sessions: make(map[string]session),
+	}
+}
+
+func (fsm *kvFsm) Apply(entry raft.AppliedEntry) ([]byte, error) {
+	// 配置日志和空日志不影响键值数据
+	if entry.Type != raft.EntryReplicate {
+		return nil, nil
+	}
+	var cmd command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return nil, fmt.Errorf("解析命令失败：%w", err)
+	}
+
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	if cmd.ClientId != "" {
+		if s, ok := fsm.sessions[cmd.ClientId]; ok && cmd.Seq <= s.Seq {
+			// 重复的请求，返回上次的结果
+			return s.Result, nil
+		}
+	}
+
+	var res result
+	switch cmd.Op {
+	case opPut:
+		fsm.data[cmd.Key] = cmd.Value
+		res = result{Value: cmd.Value, Found: true}
+	case opGet:
+		res.Value, res.Found = fsm.data[cmd.Key]
+	case opDel:
+		res.Value, res.Found = fsm.data[cmd.Key]
+		delete(fsm.data, cmd.Key)
+	default:
+		return nil, fmt.Errorf("未知的命令类型：%s", cmd.Op)
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.ClientId != "" {
+		fsm.sessions[cmd.ClientId] = session{Seq: cmd.Seq, Result: resData}
+	}
+	return resData, nil
+}
+
+// 本地读取，不经过 raft 日志，可能读到旧数据
+func (fsm *kvFsm) get(key string) (string, bool) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	value, ok := fsm.data[key]
+	return value, ok
+}
+
+// 快照数据，客户端会话也需要包含在快照中
+type fsmSnapshot struct {
+	Data     map[string]string
+	Sessions map[string]session
+}
+
+func (fsm *kvFsm) Serialize() ([]byte, error) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return json.Marshal(fsmSnapshot{Data: fsm.data, Sessions: fsm.sessions})
+}
+
+func (fsm *kvFsm) Restore(data []byte) error {
+	snapshot := fsmSnapshot{
+		Data:     make(map[string]string),
+		Sessions: make(map[string]session),
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("解析快照失败：%w", err)
+		}
+	}
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.data = snapshot.Data
+	fsm.sessions = snapshot.Sessions
+	return nil
+}
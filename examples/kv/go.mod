@@ -0,0 +1,10 @@
+module github.com/bitcapybara/raft/examples/kv
+
+go 1.16
+
+require (
+	github.com/bitcapybara/raft v0.0.0
+	google.golang.org/grpc v1.50.1
+)
+
+replace github.com/bitcapybara/raft => ../..
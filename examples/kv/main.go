@@ -0,0 +1,190 @@
+// 基于 raft 的复制键值对存储示例
+//
+// 启动节点：
+//
+//	kv serve -config node1.json
+//
+// 客户端命令：
+//
+//	kv put -addr 127.0.0.1:9001 key value
+//	kv get -addr 127.0.0.1:9001 [-stale] key
+//	kv del -addr 127.0.0.1:9001 key
+//	kv members -addr 127.0.0.1:9001 n1=127.0.0.1:9001,n2=127.0.0.1:9002,n3=127.0.0.1:9003
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitcapybara/raft"
+	"google.golang.org/grpc"
+)
+
+const usage = `用法：
+  kv serve -config <file>
+  kv put -addr <addr> <key> <value>
+  kv get -addr <addr> [-stale] <key>
+  kv del -addr <addr> <key>
+  kv members -addr <addr> <id=addr,...>`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "serve":
+		err = serve(args)
+	case "put", "get", "del", "members":
+		err = runClient(cmd, args)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// 启动节点，同一个 gRPC 服务器同时提供节点间的 raft 服务和客户端的 KV 服务
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "", "节点配置文件")
+	_ = flags.Parse(args)
+
+	spec, err := raft.LoadConfigSpec(*configPath)
+	if err != nil {
+		return err
+	}
+	persister, err := newFilePersister(spec.Storage.Dir)
+	if err != nil {
+		return err
+	}
+	fsm := newKvFsm()
+	transport := newGrpcTransport(time.Duration(spec.HeartbeatTimeout) * time.Millisecond)
+	defer transport.close()
+
+	config := spec.Config()
+	config.Fsm = fsm
+	config.RaftStatePersister = persister
+	config.SnapshotPersister = persister
+	config.Transport = transport
+	config.Logger = stdLogger{}
+	node, err := raft.NewNode(config)
+	if err != nil {
+		return err
+	}
+
+	listener, err := raft.ListenNodeAddr(spec.Peers[spec.Me])
+	if err != nil {
+		return fmt.Errorf("监听地址失败：%w", err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&raftServiceDesc, &raftServer{node: node})
+	server.RegisterService(&kvServiceDesc, &kvServer{node: node, fsm: fsm})
+	go func() {
+		if serveErr := server.Serve(listener); serveErr != nil {
+			log.Println(serveErr)
+		}
+	}()
+
+	// 收到退出信号后，等待已接收的请求处理完成再关闭节点
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if shutdownErr := node.Shutdown(ctx); shutdownErr != nil {
+			log.Println(shutdownErr)
+		}
+	}()
+
+	node.Run()
+	server.GracefulStop()
+	return nil
+}
+
+func runClient(cmd string, args []string) error {
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:9001", "任一节点地址")
+	clientId := flags.String("client", fmt.Sprintf("kv-%d", os.Getpid()), "客户端会话标识")
+	stale := flags.Bool("stale", false, "直接读取所连接节点的本地数据")
+	timeout := flags.Duration("timeout", 5*time.Second, "请求超时时间")
+	_ = flags.Parse(args)
+
+	client, err := newKvClient(*clientId, *addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var res *KVReply
+	switch {
+	case cmd == "put" && flags.NArg() == 2:
+		res, err = client.Put(ctx, flags.Arg(0), flags.Arg(1))
+	case cmd == "get" && flags.NArg() == 1:
+		res, err = client.Get(ctx, flags.Arg(0), *stale)
+	case cmd == "del" && flags.NArg() == 1:
+		res, err = client.Delete(ctx, flags.Arg(0))
+	case cmd == "members" && flags.NArg() == 1:
+		peers, parseErr := parsePeers(flags.Arg(0))
+		if parseErr != nil {
+			return parseErr
+		}
+		return client.Members(ctx, peers)
+	default:
+		return fmt.Errorf("参数错误\n%s", usage)
+	}
+	if err != nil {
+		return err
+	}
+	if !res.Found {
+		fmt.Println("(not found)")
+		return nil
+	}
+	fmt.Println(res.Value)
+	return nil
+}
+
+// 解析 id=addr,id=addr 格式的节点列表
+func parsePeers(value string) (map[raft.NodeId]raft.NodeAddr, error) {
+	peers := make(map[raft.NodeId]raft.NodeAddr)
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("节点格式错误：%s", pair)
+		}
+		peers[raft.NodeId(kv[0])] = raft.NodeAddr(kv[1])
+	}
+	return peers, nil
+}
+
+// 使用标准库打印日志，忽略 Trace 级别
+type stdLogger struct{}
+
+func (stdLogger) Trace(msg string) {}
+
+func (stdLogger) Debug(msg string) {}
+
+func (stdLogger) Info(msg string) {
+	log.Println("[INFO]", msg)
+}
+
+func (stdLogger) Warn(msg string) {
+	log.Println("[WARN]", msg)
+}
+
+func (stdLogger) Error(msg string) {
+	log.Println("[ERROR]", msg)
+}
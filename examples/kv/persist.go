@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 基于文件的持久化器，实现 raft.RaftStatePersister 和 raft.SnapshotPersister 接口
+type filePersister struct {
+	dir string
+}
+
+func newFilePersister(dir string) (*filePersister, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败：%w", err)
+	}
+	return &filePersister{dir: dir}, nil
+}
+
+func (fp *filePersister) SaveRaftState(state raft.RaftState) error {
+	return fp.save("raft_state", state)
+}
+
+func (fp *filePersister) LoadRaftState() (raft.RaftState, error) {
+	var state raft.RaftState
+	err := fp.load("raft_state", &state)
+	return state, err
+}
+
+func (fp *filePersister) SaveSnapshot(snapshot raft.Snapshot) error {
+	return fp.save("snapshot", snapshot)
+}
+
+func (fp *filePersister) LoadSnapshot() (raft.Snapshot, error) {
+	var snapshot raft.Snapshot
+	err := fp.load("snapshot", &snapshot)
+	return snapshot, err
+}
+
+// 先写入临时文件再重命名，保证文件内容完整
+func (fp *filePersister) save(name string, value interface{}) error {
+	tmp, err := ioutil.TempFile(fp.dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = gob.NewEncoder(tmp).Encode(value); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(fp.dir, name))
+}
+
+// 文件不存在时保持空对象
+func (fp *filePersister) load(name string, value interface{}) error {
+	file, err := os.Open(filepath.Join(fp.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewDecoder(file).Decode(value)
+}
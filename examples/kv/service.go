@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitcapybara/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// 不依赖 protoc 生成代码，使用 raft.JSONCodec 编解码 gRPC 消息
+// 客户端通过 grpc.CallContentSubtype("json") 选择此编解码器
+func init() {
+	encoding.RegisterCodec(raft.JSONCodec{})
+}
+
+// ==================== KV 服务消息 ====================
+
+type PutRequest struct {
+	Key      string
+	Value    string
+	ClientId string
+	Seq      uint64
+}
+
+type GetRequest struct {
+	Key      string
+	ClientId string
+	Seq      uint64
+	Stale    bool // 为 true 时直接读取本地状态机，不经过 raft 日志
+}
+
+type DeleteRequest struct {
+	Key      string
+	ClientId string
+	Seq      uint64
+}
+
+type KVReply struct {
+	Value string
+	Found bool
+}
+
+type MembersRequest struct {
+	Peers map[raft.NodeId]raft.NodeAddr // 变更后集群的全部节点
+}
+
+type MembersReply struct{}
+
+// ==================== KV 服务 ====================
+
+type kvService interface {
+	Put(ctx context.Context, req *PutRequest) (*KVReply, error)
+	Get(ctx context.Context, req *GetRequest) (*KVReply, error)
+	Delete(ctx context.Context, req *DeleteRequest) (*KVReply, error)
+	Members(ctx context.Context, req *MembersRequest) (*MembersReply, error)
+}
+
+// 客户端使用的 KV 服务，写请求和非 Stale 读请求都经过 raft 日志
+type kvServer struct {
+	node *raft.Node
+	fsm  *kvFsm
+}
+
+func (s *kvServer) Put(ctx context.Context, req *PutRequest) (*KVReply, error) {
+	return s.propose(command{Op: opPut, Key: req.Key, Value: req.Value, ClientId: req.ClientId, Seq: req.Seq})
+}
+
+func (s *kvServer) Get(ctx context.Context, req *GetRequest) (*KVReply, error) {
+	if req.Stale {
+		value, found := s.fsm.get(req.Key)
+		return &KVReply{Value: value, Found: found}, nil
+	}
+	// 读请求也写入日志，保证读到最新提交的数据
+	return s.propose(command{Op: opGet, Key: req.Key, ClientId: req.ClientId, Seq: req.Seq})
+}
+
+func (s *kvServer) Delete(ctx context.Context, req *DeleteRequest) (*KVReply, error) {
+	return s.propose(command{Op: opDel, Key: req.Key, ClientId: req.ClientId, Seq: req.Seq})
+}
+
+// 成员变更：新节点先作为 Learner 追赶日志，再提交新配置
+func (s *kvServer) Members(ctx context.Context, req *MembersRequest) (*MembersReply, error) {
+	var learnerRes raft.AddLearnerReply
+	if err := s.node.AddLearner(raft.AddLearner{Learners: req.Peers}, &learnerRes); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if learnerRes.Status != raft.OK {
+		return nil, notLeaderError(learnerRes.Leader)
+	}
+	var configRes raft.ChangeConfigReply
+	if err := s.node.ChangeConfig(raft.ChangeConfig{Peers: req.Peers}, &configRes); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if configRes.Status != raft.OK {
+		return nil, notLeaderError(configRes.Leader)
+	}
+	return &MembersReply{}, nil
+}
+
+func (s *kvServer) propose(cmd command) (*KVReply, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var res raft.ApplyCommandReply
+	if err = s.node.ApplyCommand(raft.ApplyCommand{Data: data, ClientId: cmd.ClientId}, &res); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if res.Status != raft.OK {
+		return nil, notLeaderError(res.Leader)
+	}
+	var r result
+	if err = json.Unmarshal(res.Result, &r); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &KVReply{Value: r.Value, Found: r.Found}, nil
+}
+
+// 当前节点不是 Leader 时，返回 Leader 地址，客户端重定向
+func notLeaderError(leader raft.Server) error {
+	return status.Error(codes.FailedPrecondition, fmt.Sprintf("%s%s", notLeaderPrefix, leader.Addr))
+}
+
+const notLeaderPrefix = "not leader, leader="
+
+// ==================== Raft 服务 ====================
+
+type raftService interface {
+	AppendEntries(ctx context.Context, args *raft.AppendEntry) (*raft.AppendEntryReply, error)
+	RequestVote(ctx context.Context, args *raft.RequestVote) (*raft.RequestVoteReply, error)
+	PreVote(ctx context.Context, args *raft.PreVote) (*raft.PreVoteReply, error)
+	InstallSnapshot(ctx context.Context, args *raft.InstallSnapshot) (*raft.InstallSnapshotReply, error)
+}
+
+// 节点之间的 raft rpc 服务，将请求转发给 raft.Node
+type raftServer struct {
+	node *raft.Node
+}
+
+func (s *raftServer) AppendEntries(ctx context.Context, args *raft.AppendEntry) (*raft.AppendEntryReply, error) {
+	var res raft.AppendEntryReply
+	err := s.node.AppendEntries(*args, &res)
+	return &res, err
+}
+
+func (s *raftServer) RequestVote(ctx context.Context, args *raft.RequestVote) (*raft.RequestVoteReply, error) {
+	var res raft.RequestVoteReply
+	err := s.node.RequestVote(*args, &res)
+	return &res, err
+}
+
+func (s *raftServer) PreVote(ctx context.Context, args *raft.PreVote) (*raft.PreVoteReply, error) {
+	var res raft.PreVoteReply
+	err := s.node.PreVote(*args, &res)
+	return &res, err
+}
+
+func (s *raftServer) InstallSnapshot(ctx context.Context, args *raft.InstallSnapshot) (*raft.InstallSnapshotReply, error) {
+	var res raft.InstallSnapshotReply
+	err := s.node.InstallSnapshot(*args, &res)
+	return &res, err
+}
+
+// ==================== 服务描述 ====================
+
+const (
+	kvServiceName   = "raft.example.kv.KV"
+	raftServiceName = "raft.example.kv.Raft"
+)
+
+// 生成 gRPC 一元方法的处理函数
+func unaryHandler(service, method string, newReq func() interface{},
+	call func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv, ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv, ctx, req)
+			})
+		},
+	}
+}
+
+var kvServiceDesc = grpc.ServiceDesc{
+	ServiceName: kvServiceName,
+	HandlerType: (*kvService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler(kvServiceName, "Put", func() interface{} { return new(PutRequest) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(kvService).Put(ctx, req.(*PutRequest))
+			}),
+		unaryHandler(kvServiceName, "Get", func() interface{} { return new(GetRequest) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(kvService).Get(ctx, req.(*GetRequest))
+			}),
+		unaryHandler(kvServiceName, "Delete", func() interface{} { return new(DeleteRequest) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(kvService).Delete(ctx, req.(*DeleteRequest))
+			}),
+		unaryHandler(kvServiceName, "Members", func() interface{} { return new(MembersRequest) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(kvService).Members(ctx, req.(*MembersRequest))
+			}),
+	},
+}
+
+var raftServiceDesc = grpc.ServiceDesc{
+	ServiceName: raftServiceName,
+	HandlerType: (*raftService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler(raftServiceName, "AppendEntries", func() interface{} { return new(raft.AppendEntry) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(raftService).AppendEntries(ctx, req.(*raft.AppendEntry))
+			}),
+		unaryHandler(raftServiceName, "RequestVote", func() interface{} { return new(raft.RequestVote) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(raftService).RequestVote(ctx, req.(*raft.RequestVote))
+			}),
+		unaryHandler(raftServiceName, "PreVote", func() interface{} { return new(raft.PreVote) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(raftService).PreVote(ctx, req.(*raft.PreVote))
+			}),
+		unaryHandler(raftServiceName, "InstallSnapshot", func() interface{} { return new(raft.InstallSnapshot) },
+			func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(raftService).InstallSnapshot(ctx, req.(*raft.InstallSnapshot))
+			}),
+	},
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bitcapybara/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// 基于 gRPC 的 raft.Transport 实现，缓存到各节点的连接
+type grpcTransport struct {
+	timeout time.Duration
+	conns   map[raft.NodeAddr]*grpc.ClientConn
+	mu      sync.Mutex
+}
+
+func newGrpcTransport(timeout time.Duration) *grpcTransport {
+	return &grpcTransport{
+		timeout: timeout,
+		conns:   make(map[raft.NodeAddr]*grpc.ClientConn),
+	}
+}
+
+func (tp *grpcTransport) AppendEntries(addr raft.NodeAddr, args raft.AppendEntry, res *raft.AppendEntryReply) error {
+	return tp.invoke(addr, "AppendEntries", &args, res)
+}
+
+func (tp *grpcTransport) RequestVote(addr raft.NodeAddr, args raft.RequestVote, res *raft.RequestVoteReply) error {
+	return tp.invoke(addr, "RequestVote", &args, res)
+}
+
+func (tp *grpcTransport) PreVote(addr raft.NodeAddr, args raft.PreVote, res *raft.PreVoteReply) error {
+	return tp.invoke(addr, "PreVote", &args, res)
+}
+
+func (tp *grpcTransport) InstallSnapshot(addr raft.NodeAddr, args raft.InstallSnapshot, res *raft.InstallSnapshotReply) error {
+	return tp.invoke(addr, "InstallSnapshot", &args, res)
+}
+
+func (tp *grpcTransport) invoke(addr raft.NodeAddr, method string, args, res interface{}) error {
+	conn, err := tp.conn(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tp.timeout)
+	defer cancel()
+	return conn.Invoke(ctx, "/"+raftServiceName+"/"+method, args, res)
+}
+
+func (tp *grpcTransport) conn(addr raft.NodeAddr) (*grpc.ClientConn, error) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if conn, ok := tp.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := dial(string(addr))
+	if err != nil {
+		return nil, err
+	}
+	tp.conns[addr] = conn
+	return conn, nil
+}
+
+func (tp *grpcTransport) close() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	for addr, conn := range tp.conns {
+		_ = conn.Close()
+		delete(tp.conns, addr)
+	}
+}
+
+// 建立使用 json 编解码的 gRPC 连接
+// 地址由 raft.DialNodeAddr 解析，支持 host:port、tcp://[::1]:9001、unix:///tmp/n1.sock 等格式
+func dial(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial("passthrough:///"+addr,
+		grpc.WithContextDialer(func(ctx context.Context, target string) (net.Conn, error) {
+			return raft.DialNodeAddr(ctx, raft.NodeAddr(target))
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(raft.JSONCodec{}.Name())))
+}
@@ -0,0 +1,217 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 集群节点默认配置，日志超过 maxLogLength 条时生成快照，便于演示快照和日志追赶
+const (
+	electionMinTimeout = 150
+	electionMaxTimeout = 300
+	heartbeatTimeout   = 50
+	maxLogLength       = 100
+)
+
+// 只打印警告和错误的日志
+type logger struct{}
+
+func (logger) Trace(string)     {}
+func (logger) Debug(string)     {}
+func (logger) Info(string)      {}
+func (logger) Warn(msg string)  { log.Println("[WARN]", msg) }
+func (logger) Error(msg string) { log.Println("[ERROR]", msg) }
+
+// 集群中的一个节点
+type member struct {
+	node      *raft.Node
+	store     *Store
+	persister *memPersister
+}
+
+// 在一个进程内运行的 raft 集群，节点之间通过 Network 通信
+type Cluster struct {
+	network *Network
+	members map[raft.NodeId]*member
+	mu      sync.Mutex
+}
+
+// 启动 size 个投票节点组成的集群，节点 id 和地址为 n1、n2...
+func StartCluster(size int) (*Cluster, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("节点数必须大于 0")
+	}
+	c := &Cluster{
+		network: NewNetwork(),
+		members: make(map[raft.NodeId]*member),
+	}
+	peers := make(map[raft.NodeId]raft.NodeAddr, size)
+	for i := 1; i <= size; i++ {
+		id := raft.NodeId(fmt.Sprintf("n%d", i))
+		peers[id] = raft.NodeAddr(id)
+	}
+	for id := range peers {
+		if _, err := c.startMember(id, peers, raft.Follower); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// 创建并启动节点，节点地址与 id 相同
+func (c *Cluster) startMember(id raft.NodeId, peers map[raft.NodeId]raft.NodeAddr, role raft.RoleStage) (*member, error) {
+	m := &member{store: NewStore(), persister: newMemPersister()}
+	node, err := raft.NewNode(raft.Config{
+		Fsm:                m.store,
+		RaftStatePersister: m.persister,
+		SnapshotPersister:  m.persister,
+		Transport:          c.network,
+		Logger:             logger{},
+		Peers:              peers,
+		Me:                 id,
+		Role:               role,
+		ElectionMinTimeout: electionMinTimeout,
+		ElectionMaxTimeout: electionMaxTimeout,
+		HeartbeatTimeout:   heartbeatTimeout,
+		MaxLogLength:       maxLogLength,
+		ForwardApply:       true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建节点 %s 失败：%w", id, err)
+	}
+	m.node = node
+	c.mu.Lock()
+	c.members[id] = m
+	c.mu.Unlock()
+	c.network.Register(raft.NodeAddr(id), m.node)
+	m.node.Run()
+	return m, nil
+}
+
+func (c *Cluster) member(id raft.NodeId) (*member, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.members[id]
+	return m, ok
+}
+
+// 集群中全部节点的 id，按字典序排列
+func (c *Cluster) Ids() []raft.NodeId {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]raft.NodeId, 0, len(c.members))
+	for id := range c.members {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 节点对象，节点不存在时返回 nil
+func (c *Cluster) Node(id raft.NodeId) *raft.Node {
+	if m, ok := c.member(id); ok {
+		return m.node
+	}
+	return nil
+}
+
+// 节点的状态机，节点不存在时返回 nil
+func (c *Cluster) Store(id raft.NodeId) *Store {
+	if m, ok := c.member(id); ok {
+		return m.store
+	}
+	return nil
+}
+
+// 进程内网络，可用于断开节点模拟网络分区
+func (c *Cluster) Network() *Network {
+	return c.network
+}
+
+// 等待集群选出 Leader，超时返回 raft.ErrTimeout
+func (c *Cluster) WaitLeader(timeout time.Duration) (raft.NodeId, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, id := range c.Ids() {
+			if node := c.Node(id); node != nil && node.IsLeader() {
+				return id, nil
+			}
+		}
+		time.Sleep(heartbeatTimeout * time.Millisecond)
+	}
+	return raft.None, raft.ErrTimeout
+}
+
+// 添加投票节点：以 Learner 角色启动新节点，追赶日志完成后升级为投票节点
+func (c *Cluster) AddVoter(id raft.NodeId, timeout time.Duration) error {
+	if _, ok := c.member(id); ok {
+		return fmt.Errorf("节点 %s 已存在", id)
+	}
+	if _, err := c.startMember(id, nil, raft.Learner); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		leader, err := c.WaitLeader(time.Until(deadline))
+		if err != nil {
+			return err
+		}
+		var res raft.AddVoterReply
+		err = c.Node(leader).AddVoter(raft.AddVoter{Id: id, Addr: raft.NodeAddr(id)}, &res)
+		if err == nil && res.Status == raft.OK {
+			return nil
+		}
+		if err != nil && !errors.Is(err, raft.ErrLearnerCatchingUp) {
+			var notLeader raft.NotLeaderError
+			if !errors.As(err, &notLeader) {
+				return err
+			}
+		}
+		// 日志追赶未完成或 Leader 已变更，稍后重试
+		time.Sleep(heartbeatTimeout * time.Millisecond)
+	}
+	return raft.ErrTimeout
+}
+
+// 将节点移出集群并关闭
+func (c *Cluster) RemoveServer(ctx context.Context, id raft.NodeId) error {
+	m, ok := c.member(id)
+	if !ok {
+		return fmt.Errorf("节点 %s 不存在", id)
+	}
+	leader, err := c.WaitLeader(electionMaxTimeout * time.Millisecond * 10)
+	if err != nil {
+		return err
+	}
+	var res raft.RemoveServerReply
+	if err := c.Node(leader).RemoveServer(raft.RemoveServer{Id: id}, &res); err != nil {
+		return err
+	}
+	if res.Status != raft.OK {
+		return raft.NotLeaderError{Leader: res.Leader}
+	}
+	c.network.Unregister(raft.NodeAddr(id))
+	c.mu.Lock()
+	delete(c.members, id)
+	c.mu.Unlock()
+	return m.node.Shutdown(ctx)
+}
+
+// 关闭全部节点
+func (c *Cluster) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, id := range c.Ids() {
+		if err := c.Node(id).Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("关闭节点 %s 失败：%w", id, err)
+		}
+	}
+	return firstErr
+}
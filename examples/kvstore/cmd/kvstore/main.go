@@ -0,0 +1,68 @@
+// 在一个进程内启动 3 节点的键值对存储集群，每个节点有独立的 HTTP 前端
+//
+//	go run ./examples/kvstore/cmd/kvstore -size 3 -port 8001
+//
+//	curl -X PUT -d bar 127.0.0.1:8001/keys/foo
+//	curl 127.0.0.1:8001/keys/foo
+//	curl 127.0.0.1:8002/keys/foo?stale=true
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bitcapybara/raft/examples/kvstore"
+)
+
+func main() {
+	size := flag.Int("size", 3, "节点数")
+	port := flag.Int("port", 8001, "第一个节点 HTTP 前端的端口，其它节点依次递增")
+	flag.Parse()
+
+	cluster, err := kvstore.StartCluster(*size)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	leader, err := cluster.WaitLeader(5 * time.Second)
+	if err != nil {
+		log.Fatalln(fmt.Errorf("等待选举 Leader 失败：%w", err))
+	}
+	log.Printf("集群已启动，Leader=%s\n", leader)
+
+	servers := make([]*http.Server, 0, *size)
+	for i, id := range cluster.Ids() {
+		server := &http.Server{
+			Addr:    fmt.Sprintf("127.0.0.1:%d", *port+i),
+			Handler: kvstore.NewHandler(cluster.Node(id), cluster.Store(id)),
+		}
+		servers = append(servers, server)
+		log.Printf("节点 %s 的 HTTP 前端：http://%s\n", id, server.Addr)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalln(err)
+			}
+		}()
+	}
+
+	// 等待退出信号
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		_ = server.Shutdown(ctx)
+	}
+	if err := cluster.Shutdown(ctx); err != nil {
+		log.Fatalln(err)
+	}
+}
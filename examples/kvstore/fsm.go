@@ -0,0 +1,130 @@
+// 基于 raft 的复制键值对存储示例，只依赖标准库
+//
+// Store 实现 raft.Fsm，Cluster 在一个进程内启动多个节点，节点之间通过内存网络通信，
+// NewHandler 为节点提供 HTTP 前端。此包既是使用文档，也可作为快照、成员变更等集成测试的基础
+package kvstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 状态机命令类型
+const (
+	opPut = "put"
+	opDel = "del"
+)
+
+// 写入日志的状态机命令
+type command struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// 命令应用到状态机后的结果
+type result struct {
+	Value string `json:"value"`
+	Found bool   `json:"found"`
+}
+
+// 复制的键值对状态机，实现 raft.Fsm 接口
+type Store struct {
+	data    map[string]string
+	applied int // 已应用的最大日志索引
+	mu      sync.RWMutex
+}
+
+func NewStore() *Store {
+	return &Store{data: make(map[string]string)}
+}
+
+func (s *Store) Apply(entry raft.AppliedEntry) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = entry.Index
+	// 配置日志和空日志不影响键值数据
+	if entry.Type != raft.EntryReplicate {
+		return nil, nil
+	}
+	var cmd command
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		return nil, fmt.Errorf("解析命令失败：%w", err)
+	}
+
+	var res result
+	switch cmd.Op {
+	case opPut:
+		s.data[cmd.Key] = cmd.Value
+		res = result{Value: cmd.Value, Found: true}
+	case opDel:
+		res.Value, res.Found = s.data[cmd.Key]
+		delete(s.data, cmd.Key)
+	default:
+		return nil, fmt.Errorf("未知的命令类型：%s", cmd.Op)
+	}
+	return json.Marshal(res)
+}
+
+// 读取本地数据，不经过 raft 日志
+func (s *Store) Get(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+// 本地数据的键值对数
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+// 已应用到状态机的最大日志索引
+func (s *Store) AppliedIndex() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.applied
+}
+
+// 快照数据
+type storeSnapshot struct {
+	Data    map[string]string `json:"data"`
+	Applied int               `json:"applied"`
+}
+
+func (s *Store) Serialize() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(storeSnapshot{Data: s.data, Applied: s.applied})
+}
+
+func (s *Store) Restore(data []byte) error {
+	snapshot := storeSnapshot{Data: make(map[string]string)}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("解析快照失败：%w", err)
+		}
+	}
+	if snapshot.Data == nil {
+		snapshot.Data = make(map[string]string)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = snapshot.Data
+	s.applied = snapshot.Applied
+	return nil
+}
+
+// 编码写入命令
+func encodePut(key, value string) ([]byte, error) {
+	return json.Marshal(command{Op: opPut, Key: key, Value: value})
+}
+
+func encodeDel(key string) ([]byte, error) {
+	return json.Marshal(command{Op: opDel, Key: key})
+}
@@ -0,0 +1,120 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 线性一致读等待 Barrier 的最长时间
+const readTimeout = 2 * time.Second
+
+// 节点的 HTTP 前端
+//
+//	GET    /keys/{key}              线性一致读，只能在 Leader 上执行
+//	GET    /keys/{key}?stale=true   读取当前节点的本地数据，可能读到旧数据
+//	PUT    /keys/{key}              写入，请求体为值
+//	DELETE /keys/{key}              删除
+//
+// 写请求发送到非 Leader 节点时由节点转发给 Leader；无法处理时返回 503，应答中包含已知的 Leader
+type handler struct {
+	node  *raft.Node
+	store *Store
+}
+
+func NewHandler(node *raft.Node, store *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/keys/", &handler{node: node, store: store})
+	return mux
+}
+
+// 错误应答
+type errorReply struct {
+	Error  string `json:"error"`
+	Leader string `json:"leader,omitempty"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/keys/")
+	if key == "" {
+		writeJSON(w, http.StatusNotFound, errorReply{Error: "缺少 key"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, key)
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorReply{Error: err.Error()})
+			return
+		}
+		data, err := encodePut(key, string(value))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorReply{Error: err.Error()})
+			return
+		}
+		h.apply(w, data)
+	case http.MethodDelete:
+		data, err := encodeDel(key)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorReply{Error: err.Error()})
+			return
+		}
+		h.apply(w, data)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, errorReply{Error: fmt.Sprintf("不支持 %s 请求", r.Method)})
+	}
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	// 线性一致读：等待之前提交的日志全部应用到状态机后再读取本地数据
+	if r.URL.Query().Get("stale") != "true" {
+		if err := h.node.Barrier(readTimeout); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	value, found := h.store.Get(key)
+	writeJSON(w, http.StatusOK, result{Value: value, Found: found})
+}
+
+// 提交写入命令，返回状态机的应用结果
+func (h *handler) apply(w http.ResponseWriter, data []byte) {
+	var res raft.ApplyCommandReply
+	if err := h.node.ApplyCommand(raft.ApplyCommand{Data: data}, &res); err != nil {
+		writeError(w, err)
+		return
+	}
+	if res.Status != raft.OK {
+		writeError(w, raft.NotLeaderError{Leader: res.Leader})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(res.Result)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var notLeader raft.NotLeaderError
+	switch {
+	case errors.As(err, &notLeader):
+		writeJSON(w, http.StatusServiceUnavailable, errorReply{Error: err.Error(), Leader: string(notLeader.Leader.Id)})
+	case errors.Is(err, raft.ErrTimeout):
+		writeJSON(w, http.StatusGatewayTimeout, errorReply{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, errorReply{Error: err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,97 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 进程内的网络，实现 raft.Transport 和 raft.CommandForwarder，按节点地址将请求直接交给目标节点处理
+// 可断开单个节点，模拟网络分区
+type Network struct {
+	nodes        map[raft.NodeAddr]*raft.Node
+	disconnected map[raft.NodeAddr]bool
+	mu           sync.RWMutex
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		nodes:        make(map[raft.NodeAddr]*raft.Node),
+		disconnected: make(map[raft.NodeAddr]bool),
+	}
+}
+
+// 将节点注册到网络上
+func (n *Network) Register(addr raft.NodeAddr, node *raft.Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[addr] = node
+}
+
+// 将节点从网络上移除
+func (n *Network) Unregister(addr raft.NodeAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.nodes, addr)
+}
+
+// 断开或恢复节点的网络，断开后发往此节点的请求都返回错误
+func (n *Network) SetConnected(addr raft.NodeAddr, connected bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[addr] = !connected
+}
+
+func (n *Network) node(addr raft.NodeAddr) (*raft.Node, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.disconnected[addr] {
+		return nil, fmt.Errorf("节点 %s 网络已断开", addr)
+	}
+	node, ok := n.nodes[addr]
+	if !ok {
+		return nil, fmt.Errorf("节点 %s 不存在", addr)
+	}
+	return node, nil
+}
+
+func (n *Network) AppendEntries(addr raft.NodeAddr, args raft.AppendEntry, res *raft.AppendEntryReply) error {
+	node, err := n.node(addr)
+	if err != nil {
+		return err
+	}
+	return node.AppendEntries(args, res)
+}
+
+func (n *Network) RequestVote(addr raft.NodeAddr, args raft.RequestVote, res *raft.RequestVoteReply) error {
+	node, err := n.node(addr)
+	if err != nil {
+		return err
+	}
+	return node.RequestVote(args, res)
+}
+
+func (n *Network) PreVote(addr raft.NodeAddr, args raft.PreVote, res *raft.PreVoteReply) error {
+	node, err := n.node(addr)
+	if err != nil {
+		return err
+	}
+	return node.PreVote(args, res)
+}
+
+func (n *Network) InstallSnapshot(addr raft.NodeAddr, args raft.InstallSnapshot, res *raft.InstallSnapshotReply) error {
+	node, err := n.node(addr)
+	if err != nil {
+		return err
+	}
+	return node.InstallSnapshot(args, res)
+}
+
+func (n *Network) ApplyCommand(addr raft.NodeAddr, args raft.ApplyCommand, res *raft.ApplyCommandReply) error {
+	node, err := n.node(addr)
+	if err != nil {
+		return err
+	}
+	return node.ApplyCommand(args, res)
+}
@@ -0,0 +1,45 @@
+package kvstore
+
+import (
+	"sync"
+
+	"github.com/bitcapybara/raft"
+)
+
+// 内存持久化器，同时实现 raft.RaftStatePersister 和 raft.SnapshotPersister
+// 节点重启时复用同一个持久化器即可恢复状态
+type memPersister struct {
+	state    raft.RaftState
+	snapshot raft.Snapshot
+	mu       sync.Mutex
+}
+
+func newMemPersister() *memPersister {
+	return &memPersister{state: raft.RaftState{Entries: make([]raft.Entry, 0)}}
+}
+
+func (ps *memPersister) SaveRaftState(state raft.RaftState) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.state = state
+	return nil
+}
+
+func (ps *memPersister) LoadRaftState() (raft.RaftState, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.state, nil
+}
+
+func (ps *memPersister) SaveSnapshot(snapshot raft.Snapshot) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.snapshot = snapshot
+	return nil
+}
+
+func (ps *memPersister) LoadSnapshot() (raft.Snapshot, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.snapshot, nil
+}
@@ -0,0 +1,86 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 不变量检查
+// 设置 Config.Debug 或使用 raftdev 构建标签时，raft 在运行中检查以下不变量：
+// 任期只增不减；commitIndex 不回退；Leader 在同一任期内记录的各节点 matchIndex 不回退；
+// 法定人数满足交集性质，即同意的节点满足法定人数时，其余投票节点不能同时满足法定人数，同一节点被重复计入或策略有误时不满足；
+// 成为 Leader 时当前任期的选票投给了自己
+// 违反时记录错误日志并发出 EventInvariantViolated 事件，使用 raftdev 构建标签时直接 panic，便于测试中尽早发现问题
+
+// 不变量检查器，为 nil 时不检查
+type auditor struct {
+	term   int // 已观察到的最大任期
+	report func(err InvariantError, term int)
+	mu     sync.Mutex
+}
+
+func newAuditor(config Config, report func(err InvariantError, term int)) *auditor {
+	if !config.Debug && !invariantPanic {
+		return nil
+	}
+	return &auditor{report: report}
+}
+
+func (a *auditor) violate(err InvariantError, term int) {
+	a.report(err, term)
+	if invariantPanic {
+		panic(err)
+	}
+}
+
+// 任期变更后调用，调用方可能持有 HardState 的锁
+func (a *auditor) checkTerm(term int) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	last := a.term
+	if term > a.term {
+		a.term = term
+	}
+	a.mu.Unlock()
+	if term <= last {
+		a.violate(InvariantError{Invariant: "任期只增不减", Detail: fmt.Sprintf("任期从 %d 变为 %d", last, term)}, term)
+	}
+}
+
+func (a *auditor) checkCommit(old, index int) {
+	if a == nil || index >= old {
+		return
+	}
+	a.violate(InvariantError{Invariant: "commitIndex 不回退", Detail: fmt.Sprintf("commitIndex 从 %d 回退到 %d", old, index)}, 0)
+}
+
+func (a *auditor) checkMatch(id NodeId, old, matchIndex int) {
+	if a == nil || matchIndex >= old {
+		return
+	}
+	a.violate(InvariantError{Invariant: "matchIndex 不回退", Detail: fmt.Sprintf("节点 Id=%s 的 matchIndex 从 %d 回退到 %d", id, old, matchIndex)}, 0)
+}
+
+func (a *auditor) checkQuorum(quorum QuorumPolicy, voters map[NodeId]NodeAddr, acks map[NodeId]bool, granted bool) {
+	if a == nil || !granted {
+		return
+	}
+	rest := make(map[NodeId]bool, len(voters))
+	for id := range voters {
+		if !acks[id] {
+			rest[id] = true
+		}
+	}
+	if quorum.IsQuorum(voters, rest) {
+		a.violate(InvariantError{Invariant: "法定人数交集", Detail: fmt.Sprintf("同意的节点 %v 与其余的投票节点 %v 都满足法定人数", acks, rest)}, 0)
+	}
+}
+
+func (a *auditor) checkSelfVote(me, votedFor NodeId, term int) {
+	if a == nil || votedFor == me {
+		return
+	}
+	a.violate(InvariantError{Invariant: "Leader 投票给自己", Detail: fmt.Sprintf("节点 Id=%s 在任期 %d 成为 Leader，但选票投给了 %q", me, term, votedFor)}, term)
+}
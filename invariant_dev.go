@@ -0,0 +1,7 @@
+//go:build raftdev
+// +build raftdev
+
+package raft
+
+// 使用 raftdev 构建标签时总是检查不变量，违反时 panic
+const invariantPanic = true
@@ -0,0 +1,7 @@
+//go:build !raftdev
+// +build !raftdev
+
+package raft
+
+// 未使用 raftdev 构建标签时，只在设置 Config.Debug 时检查不变量，违反时不 panic
+const invariantPanic = false
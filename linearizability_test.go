@@ -0,0 +1,410 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ==================== 线性一致性检查 ====================
+
+// 结果未知的操作的结束时间，此类操作可以在调用之后的任意时刻生效，也可以从未生效
+const unknownReturn = math.MaxInt64
+
+// 键值对操作的历史记录，Call 和 Return 是操作开始和结束的逻辑时间
+type kvOperation struct {
+	Key    string
+	Put    bool
+	Value  string // 写操作写入的值，读操作读到的值
+	Call   int64
+	Return int64
+}
+
+// 检查历史是否线性一致，键值对模型中各个键相互独立，按键分组后分别检查
+func checkLinearizable(history []kvOperation) bool {
+	byKey := make(map[string][]kvOperation)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	for _, ops := range byKey {
+		if !checkKeyLinearizable(ops) {
+			return false
+		}
+	}
+	return true
+}
+
+// 回溯搜索一个满足实时顺序的线性化顺序：每一步只能选择在所有未线性化操作结束之前调用的操作
+// 已线性化的操作集合和当前值相同的搜索状态只搜索一次
+func checkKeyLinearizable(ops []kvOperation) bool {
+	done := make([]byte, len(ops))
+	visited := make(map[string]bool)
+
+	var search func(value string) bool
+	search = func(value string) bool {
+		minReturn := int64(unknownReturn)
+		for i, op := range ops {
+			if done[i] == 0 && op.Return < minReturn {
+				minReturn = op.Return
+			}
+		}
+		// 结果已知的操作都已线性化
+		if minReturn == unknownReturn {
+			return true
+		}
+		for i, op := range ops {
+			if done[i] != 0 || op.Call > minReturn {
+				continue
+			}
+			next := value
+			if op.Put {
+				next = op.Value
+			} else if op.Value != value {
+				continue
+			}
+			done[i] = 1
+			state := string(done) + "|" + next
+			if !visited[state] {
+				visited[state] = true
+				if search(next) {
+					return true
+				}
+			}
+			done[i] = 0
+		}
+		return false
+	}
+	return search("")
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []kvOperation
+		want    bool
+	}{
+		{
+			name: "sequential",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: 2},
+				{Key: "x", Value: "1", Call: 3, Return: 4},
+			},
+			want: true,
+		},
+		{
+			name: "concurrent read sees either value",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: 2},
+				{Key: "x", Put: true, Value: "2", Call: 3, Return: 6},
+				{Key: "x", Value: "1", Call: 4, Return: 5},
+				{Key: "x", Value: "2", Call: 7, Return: 8},
+			},
+			want: true,
+		},
+		{
+			name: "stale read after write returned",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: 2},
+				{Key: "x", Put: true, Value: "2", Call: 3, Return: 4},
+				{Key: "x", Value: "1", Call: 5, Return: 6},
+			},
+			want: false,
+		},
+		{
+			name: "read flips back",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: 10},
+				{Key: "x", Value: "1", Call: 2, Return: 3},
+				{Key: "x", Value: "", Call: 4, Return: 5},
+			},
+			want: false,
+		},
+		{
+			name: "unknown write may take effect",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: unknownReturn},
+				{Key: "x", Value: "", Call: 2, Return: 3},
+				{Key: "x", Value: "1", Call: 4, Return: 5},
+			},
+			want: true,
+		},
+		{
+			name: "unknown write may never take effect",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: unknownReturn},
+				{Key: "x", Value: "", Call: 2, Return: 3},
+			},
+			want: true,
+		},
+		{
+			name: "keys are independent",
+			history: []kvOperation{
+				{Key: "x", Put: true, Value: "1", Call: 1, Return: 2},
+				{Key: "y", Value: "", Call: 3, Return: 4},
+				{Key: "x", Value: "", Call: 5, Return: 6},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkLinearizable(tt.history); got != tt.want {
+				t.Fatalf("checkLinearizable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ==================== 测试集群 ====================
+
+// 键值对状态机，命令格式为 "put key value" 或 "get key"
+type kvFsm struct {
+	data map[string]string
+	mu   sync.Mutex
+}
+
+func newKvFsm() *kvFsm {
+	return &kvFsm{data: make(map[string]string)}
+}
+
+func (fsm *kvFsm) Apply(entry AppliedEntry) ([]byte, error) {
+	if entry.Type != EntryReplicate {
+		return nil, nil
+	}
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	args := strings.Fields(string(entry.Data))
+	switch {
+	case len(args) == 3 && args[0] == "put":
+		fsm.data[args[1]] = args[2]
+		return nil, nil
+	case len(args) == 2 && args[0] == "get":
+		return []byte(fsm.data[args[1]]), nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", entry.Data)
+	}
+}
+
+func (fsm *kvFsm) Serialize() ([]byte, error) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return json.Marshal(fsm.data)
+}
+
+func (fsm *kvFsm) Restore(data []byte) error {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	restored := make(map[string]string)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &restored); err != nil {
+			return err
+		}
+	}
+	fsm.data = restored
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Trace(string) {}
+func (nopLogger) Debug(string) {}
+func (nopLogger) Info(string)  {}
+func (nopLogger) Warn(string)  {}
+func (nopLogger) Error(string) {}
+
+// 通过 inMemTransport 互相通信的集群，每个节点发出的请求经过各自的 ChaosTransport
+type testCluster struct {
+	transport *inMemTransport
+	peers     map[NodeId]NodeAddr
+	nodes     map[NodeId]*Node
+	chaos     map[NodeId]*ChaosTransport
+}
+
+// options 在创建节点前依次修改各节点的配置
+func newTestCluster(t *testing.T, size int, options ...func(*Config)) *testCluster {
+	t.Helper()
+	cluster := &testCluster{
+		transport: newInMemTransport(),
+		peers:     make(map[NodeId]NodeAddr, size),
+		nodes:     make(map[NodeId]*Node, size),
+		chaos:     make(map[NodeId]*ChaosTransport, size),
+	}
+	for i := 1; i <= size; i++ {
+		cluster.peers[NodeId(fmt.Sprintf("node%d", i))] = NodeAddr(fmt.Sprintf("127.0.0.1:%d", 9000+i))
+	}
+	for id := range cluster.peers {
+		cluster.addNode(t, id, Follower, options...)
+	}
+	t.Cleanup(cluster.shutdown)
+	return cluster
+}
+
+// 创建并启动节点，节点的初始配置为集群创建时的投票节点
+func (c *testCluster) addNode(t *testing.T, id NodeId, role RoleStage, options ...func(*Config)) *Node {
+	t.Helper()
+	addr, ok := c.peers[id]
+	if !ok {
+		addr = NodeAddr(fmt.Sprintf("127.0.0.1:%d", 9000+len(c.nodes)+1))
+	}
+	peers := make(map[NodeId]NodeAddr, len(c.peers))
+	for peer, peerAddr := range c.peers {
+		peers[peer] = peerAddr
+	}
+	chaos := NewChaosTransport(c.transport, int64(len(c.nodes)+1))
+	config := Config{
+		Fsm:                newKvFsm(),
+		RaftStatePersister: newImMemRaftStatePersister(),
+		SnapshotPersister:  newInMemSnapshotPersister(),
+		Transport:          chaos,
+		Logger:             nopLogger{},
+		Peers:              peers,
+		Me:                 id,
+		Role:               role,
+		ElectionMinTimeout: 300,
+		ElectionMaxTimeout: 600,
+		HeartbeatTimeout:   30,
+		MaxLogLength:       64,
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	node, err := NewNode(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transport.register(addr, node)
+	c.nodes[id] = node
+	c.chaos[id] = chaos
+	c.peers[id] = addr
+	go node.Run()
+	return node
+}
+
+func (c *testCluster) shutdown() {
+	var wg sync.WaitGroup
+	for _, node := range c.nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
+			_ = node.Shutdown(ctx)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (c *testCluster) addr(id NodeId) NodeAddr {
+	return c.peers[id]
+}
+
+// 等待集群选出 Leader
+func (c *testCluster) waitLeader(t *testing.T, timeout time.Duration) NodeId {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for id, node := range c.nodes {
+			if node.IsLeader() {
+				return id
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("no leader elected within %s", timeout)
+	return None
+}
+
+// 向当前的 Leader 发送命令，没有 Leader 或请求失败时返回 false
+func (c *testCluster) apply(cmd string) (string, bool) {
+	for _, node := range c.nodes {
+		if !node.IsLeader() {
+			continue
+		}
+		var res ApplyCommandReply
+		if err := node.ApplyCommand(ApplyCommand{Data: []byte(cmd)}, &res); err != nil || res.Status != OK {
+			return "", false
+		}
+		return string(res.Result), true
+	}
+	return "", false
+}
+
+// ==================== 线性一致性测试 ====================
+
+// 多个客户端并发读写，记录每个操作的调用和返回时间，结果未知的写操作按 unknownReturn 记录
+func runClients(c *testCluster, clients, opsPerClient int, keys []string) []kvOperation {
+	var (
+		now     int64
+		history []kvOperation
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(client)))
+			for j := 0; j < opsPerClient; j++ {
+				op := kvOperation{Key: keys[random.Intn(len(keys))], Put: random.Intn(2) == 0}
+				cmd := "get " + op.Key
+				if op.Put {
+					op.Value = fmt.Sprintf("c%d-%d", client, j)
+					cmd = fmt.Sprintf("put %s %s", op.Key, op.Value)
+				}
+				op.Call = atomic.AddInt64(&now, 1)
+				result, ok := c.apply(cmd)
+				op.Return = atomic.AddInt64(&now, 1)
+				switch {
+				case !ok && !op.Put:
+					// 失败的读操作不影响状态，不记录
+					time.Sleep(time.Millisecond * 10)
+					continue
+				case !ok:
+					op.Return = unknownReturn
+					time.Sleep(time.Millisecond * 10)
+				case !op.Put:
+					op.Value = result
+				}
+				mu.Lock()
+				history = append(history, op)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return history
+}
+
+func TestLinearizableClientPath(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	cluster := newTestCluster(t, 3)
+	cluster.waitLeader(t, time.Second*5)
+
+	// 日志复制请求乱序、重复、丢失，应答丢失时 Leader 会重发已被接收的日志
+	for _, chaos := range cluster.chaos {
+		chaos.AddRule(ChaosRule{
+			Rpc:       "AppendEntries",
+			Drop:      0.02,
+			DropReply: 0.05,
+			Duplicate: 0.1,
+			Jitter:    time.Millisecond * 2,
+		})
+	}
+
+	history := runClients(cluster, 5, 40, []string{"x", "y", "z"})
+	if len(history) == 0 {
+		t.Fatal("no operation completed")
+	}
+	if !checkLinearizable(history) {
+		t.Fatalf("history is not linearizable: %+v", history)
+	}
+}
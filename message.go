@@ -1,5 +1,7 @@
 package raft
 
+import "time"
+
 type rpcType uint8
 
 // 日志类型
@@ -11,6 +13,7 @@ const (
 	EntryHeartbeat
 	EntryTimeoutNow
 	EntryPromote
+	EntryNoop
 )
 
 func EntryTypeToString(entryType EntryType) (typeString string) {
@@ -25,16 +28,19 @@ func EntryTypeToString(entryType EntryType) (typeString string) {
 		typeString = "EntryTimeoutNow"
 	case EntryPromote:
 		typeString = "EntryPromote"
+	case EntryNoop:
+		typeString = "EntryNoop"
 	}
 	return
 }
 
 // 日志条目
 type Entry struct {
-	Index int       // 此条目的逻辑索引， 从 1 开始
-	Term  int       // 日志项所在term
-	Type  EntryType // 日志类型
-	Data  []byte    // 状态机命令
+	Index    int       // 此条目的逻辑索引， 从 1 开始
+	Term     int       // 日志项所在term
+	Type     EntryType // 日志类型
+	Data     []byte    // 状态机命令
+	Checksum uint32    // 校验和，添加到日志时计算，为 0 表示没有校验和
 }
 
 type Status uint8
@@ -42,6 +48,7 @@ type Status uint8
 const (
 	NotLeader Status = iota
 	OK
+	Rejected // 请求被 Leader 驳回，如命令过大、节点只读或未通过授权检查，重试不会成功
 )
 
 type Server struct {
@@ -63,8 +70,12 @@ type AppendEntry struct {
 	LeaderId     NodeId    // 领导者的地址，方便 Follower 重定向
 	PrevLogIndex int       // 要发送的日志条目的前一个条目的索引
 	PrevLogTerm  int       // PrevLogIndex 条目所处任期
-	LeaderCommit int       // Leader 提交的索引
-	Entries      []Entry   // 日志条目
+	LeaderCommit int          // Leader 提交的索引
+	Entries      []Entry      // 日志条目
+	TraceContext TraceContext // 链路追踪上下文，未设置 Tracer 时为空
+	GroupId      GroupId      // 所属的 raft 组，由 MultiNode 设置，单组部署时为空
+	Compressed   bool         // Entries 中各日志的 Data 是否经过 DEFLATE 压缩
+	AuthToken    string       // 发送方的认证令牌，设置 Config.AuthToken 时由 raft 填写
 }
 
 type AppendEntryReply struct {
@@ -72,16 +83,21 @@ type AppendEntryReply struct {
 	ConflictTerm       int  // 当前节点与 Leader 发生冲突的日志的 Term
 	ConflictStartIndex int  // 发生冲突的 Term 包含的第一条日志
 	Success            bool // 如果关注者包含与prevLogIndex和prevLogTerm匹配的条目，则为true
+	Compression        bool // 当前节点是否支持接收压缩的日志
+	Witness            bool // 当前节点是否是见证节点，Leader 据此只发送日志元数据
 }
 
 // ==================== RequestVote ====================
 
 type RequestVote struct {
-	IsPreVote    bool   // 是否是 preVote 请求
 	Term         int    // 当前时刻所属任期
 	CandidateId  NodeId // 候选人id
 	LastLogIndex int    // 发送此请求的 Candidate 最后一个日志条目的索引
 	LastLogTerm  int    // LastLogIndex 所处的任期
+	Transfer     bool         // 是否是领导权转移发起的选举，为 true 时接收方不检查是否有正常的 Leader
+	TraceContext TraceContext // 链路追踪上下文，未设置 Tracer 时为空
+	GroupId      GroupId      // 所属的 raft 组，由 MultiNode 设置，单组部署时为空
+	AuthToken    string       // 发送方的认证令牌，设置 Config.AuthToken 时由 raft 填写
 }
 
 type RequestVoteReply struct {
@@ -89,6 +105,22 @@ type RequestVoteReply struct {
 	VoteGranted bool // 为 true 表示候选人收到一个选票
 }
 
+// ==================== PreVote ====================
+
+type PreVote struct {
+	Term         int     // 发送方赢得选举后的任期，即发送方当前任期 + 1
+	CandidateId  NodeId  // 候选人id
+	LastLogIndex int     // 发送此请求的节点最后一个日志条目的索引
+	LastLogTerm  int     // LastLogIndex 所处的任期
+	GroupId      GroupId // 所属的 raft 组，由 MultiNode 设置，单组部署时为空
+	AuthToken    string  // 发送方的认证令牌，设置 Config.AuthToken 时由 raft 填写
+}
+
+type PreVoteReply struct {
+	Term        int  // 接收方的当前任期，接收方不会因 PreVote 请求修改任期
+	VoteGranted bool // 为 true 表示接收方会给发送方投票
+}
+
 // ==================== InstallSnapshot ====================
 
 type InstallSnapshot struct {
@@ -98,28 +130,42 @@ type InstallSnapshot struct {
 	LastIncludedTerm  int    // LastIncludedIndex 所在位置的条目的 Term
 	Offset            int64  // 分批发送数据时，当前块的字节偏移量
 	Data              []byte // 快照的序列化数据
-	Done              bool   // 分批发送是否完成
+	Done              bool         // 分批发送是否完成
+	TraceContext      TraceContext // 链路追踪上下文，未设置 Tracer 时为空
+	GroupId           GroupId      // 所属的 raft 组，由 MultiNode 设置，单组部署时为空
+	Config            []byte       // Leader 已提交的集群配置，只在最后一个分块中发送
+	ConfigIndex       int          // Config 所在的日志索引
+	Checksum          uint32       // 完整快照的校验和，只在最后一个分块中发送，为 0 时不校验
+	FsmVersion        string       // 生成快照的状态机版本，为空时不检查
+	AuthToken         string       // 发送方的认证令牌，设置 Config.AuthToken 时由 raft 填写
 }
 
 type InstallSnapshotReply struct {
-	Term int // 接收的 Follower 的当前 Term
+	Term   int   // 接收的 Follower 的当前 Term
+	Offset int64 // 接收方期望的下一个分块的字节偏移量
 }
 
 // ==================== ApplyCommand ====================
 
 type ApplyCommand struct {
-	Data []byte // 客户端请求应用到状态机的数据
+	Data         []byte            // 客户端请求应用到状态机的数据
+	ClientId     string            // 发起请求的客户端标识，用于授权检查
+	Metadata     map[string]string // 请求附带的元数据，用于授权检查，不会写入日志
+	TraceContext TraceContext      // 客户端的链路追踪上下文，未设置 Tracer 时忽略
+	Forwarded    bool              // 由非 Leader 节点转发的请求，接收方不再转发
 }
 
 type ApplyCommandReply struct {
-	Status Status // 客户端请求的是 Leader 节点时，返回 true
+	Status Status // 客户端请求的是 Leader 节点时，返回 OK；请求被驳回时返回 Rejected
 	Leader Server // 客户端请求的不是 Leader 节点时，返回 LeaderId
+	Result []byte // 命令应用到状态机后的返回结果
 }
 
 // ==================== ChangeConfig ====================
 
 type ChangeConfig struct {
-	Peers map[NodeId]NodeAddr // 新配置的集群各节点
+	Peers     map[NodeId]NodeAddr // 新配置的集群各节点
+	NonVoters map[NodeId]NodeAddr // 新配置的非投票节点，为 nil 时保持不变
 }
 
 type ChangeConfigReply struct {
@@ -130,11 +176,12 @@ type ChangeConfigReply struct {
 // ==================== TransferLeadership ====================
 
 type TransferLeadership struct {
-	Transferee Server
+	Transferee Server // 接收领导权的节点，Id 为空时由 Leader 选择日志最新的投票节点
 }
 
 type TransferLeadershipReply struct {
-	Status Status
+	Status     Status
+	Transferee Server // 实际接收领导权的节点
 }
 
 // ==================== AddLearner ====================
@@ -147,3 +194,103 @@ type AddLearnerReply struct {
 	Status Status
 	Leader Server // 请求的不是 Leader 节点时，返回 Leader 节点信息
 }
+
+// ==================== AddVoter ====================
+
+type AddVoter struct {
+	Id   NodeId   // 新添加的投票节点
+	Addr NodeAddr // 新节点的地址
+}
+
+type AddVoterReply struct {
+	Status Status
+	Leader Server // 请求的不是 Leader 节点时，返回 Leader 节点信息
+}
+
+// ==================== AddNonVoter ====================
+
+type AddNonVoter struct {
+	Id   NodeId   // 新添加的非投票节点
+	Addr NodeAddr // 新节点的地址
+}
+
+type AddNonVoterReply struct {
+	Status Status
+	Leader Server // 请求的不是 Leader 节点时，返回 Leader 节点信息
+}
+
+// ==================== CatchUpProgress ====================
+
+type CatchUpProgress struct{}
+
+type CatchUpProgressReply struct {
+	Status   Status
+	Leader   Server                     // 请求的不是 Leader 节点时，返回 Leader 节点信息
+	Learners map[NodeId]LearnerProgress // 各 Learner 节点的日志追赶进度
+}
+
+// Learner 节点的日志追赶进度，由 Leader 维护
+type LearnerProgress struct {
+	MatchIndex int  // 已复制到 Learner 的最大日志索引
+	Lag        int  // 落后 Leader 的日志条数
+	Rounds     int  // 连续追赶完成的复制轮数
+	Ready      bool // 是否满足升级条件
+}
+
+// ==================== Status ====================
+
+// 节点状态快照，由 Node.Status 返回
+type NodeStatus struct {
+	Id            NodeId                  // 当前节点 id
+	Role          RoleStage               // 当前角色
+	Term          int                     // 当前任期
+	Leader        Server                  // 当前节点所知的 Leader，Id 为空表示没有已知的 Leader
+	CommitIndex   int                     // 已提交的最大日志索引
+	LastApplied   int                     // 已应用到状态机的最大日志索引
+	LastLogIndex  int                     // 最后一条日志的索引
+	LastLogTerm   int                     // 最后一条日志的任期
+	SnapshotIndex int                     // 最新快照包含的最后一条日志的索引
+	SnapshotTerm  int                     // 最新快照包含的最后一条日志的任期
+	Membership    ClusterMembership       // 当前节点所知的集群成员
+	Progress      map[NodeId]PeerProgress // 各节点的日志复制进度，只有 Leader 返回
+	QuorumContact time.Time               // 多数投票节点最近一次应答 Leader 的时间，只有 Leader 返回
+	ReadOnly      bool                    // 节点是否因磁盘故障或运维设置处于只读模式
+}
+
+// Leader 向其它节点复制日志的进度
+type PeerProgress struct {
+	Role           RoleStage     // 节点角色
+	State          ProgressState // 复制状态
+	MatchIndex     int           // 已复制到节点的最大日志索引
+	NextIndex      int           // 下一次发送给节点的日志索引
+	Lag            int           // 落后 Leader 的日志条数
+	RpcBusy        bool          // 是否正在通信
+	SnapshotIndex  int           // 正在发送的快照的 LastIndex，为 0 表示没有发送快照
+	SnapshotOffset int64         // 正在发送的快照已被接收的字节数
+	LastContact    time.Time     // 最近一次收到节点应答的时间
+	Witness        bool          // 是否是见证节点
+	Unreachable    bool          // 是否因连续通信失败被判定为不可达
+}
+
+// ==================== RemoveServer ====================
+
+type RemoveServer struct {
+	Id NodeId // 要移除的节点，可以是投票节点、非投票节点或 Learner 节点
+}
+
+type RemoveServerReply struct {
+	Status Status
+	Leader Server // 请求的不是 Leader 节点时，返回 Leader 节点信息
+}
+
+// ==================== UpdatePeerAddress ====================
+
+type UpdatePeerAddress struct {
+	Id   NodeId   // 地址改变的节点，可以是投票节点、非投票节点或 Learner 节点
+	Addr NodeAddr // 节点的新地址
+}
+
+type UpdatePeerAddressReply struct {
+	Status Status
+	Leader Server // 请求的不是 Leader 节点时，返回 Leader 节点信息
+}
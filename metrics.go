@@ -0,0 +1,265 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// 指标收集接口，由客户端实现或使用 PrometheusSink
+// 各方法在 raft 主循环中调用，不能阻塞
+type MetricsSink interface {
+	// 设置瞬时值，如当前任期、commitIndex
+	SetGauge(name string, value float64, labels ...Label)
+	// 累加计数，如选举次数
+	IncrCounter(name string, delta float64, labels ...Label)
+	// 记录一次观测值，如 rpc 耗时（秒）、快照大小
+	Observe(name string, value float64, labels ...Label)
+}
+
+// raft 输出的指标名
+const (
+	MetricTerm             = "raft_term"                      // 当前任期
+	MetricRole             = "raft_role"                      // 当前角色，值为 RoleStage
+	MetricCommitIndex      = "raft_commit_index"              // 提交索引
+	MetricLastApplied      = "raft_last_applied"              // 已应用到状态机的索引
+	MetricApplyLag         = "raft_apply_lag"                 // 已提交但尚未应用到状态机的日志条数
+	MetricCommitLatency    = "raft_commit_latency_seconds"    // Leader 上客户端命令从添加到日志到提交的耗时
+	MetricApplyLatency     = "raft_apply_latency_seconds"     // Leader 上客户端命令从提交到应用到状态机的耗时
+	MetricPeerMatchLag     = "raft_peer_match_lag"            // 各节点 matchIndex 落后 Leader 的日志条数，标签 peer
+	MetricPeerNextIndex    = "raft_peer_next_index"           // 各节点的 nextIndex，标签 peer
+	MetricRpcDuration      = "raft_rpc_duration_seconds"      // rpc 耗时，标签 rpc
+	MetricRpcFailures      = "raft_rpc_failures_total"        // rpc 失败次数，标签 rpc
+	MetricElections        = "raft_elections_total"           // 发起选举的次数
+	MetricSnapshotSize     = "raft_snapshot_size_bytes"       // 最新快照的大小
+	MetricSnapshotDuration = "raft_snapshot_duration_seconds" // 生成快照的耗时
+	MetricPersistDuration  = "raft_persist_duration_seconds"  // 保存 RaftState 的耗时
+)
+
+// 未设置 MetricsSink 时使用，不做任何事
+type nopMetrics struct{}
+
+func (nopMetrics) SetGauge(string, float64, ...Label)    {}
+func (nopMetrics) IncrCounter(string, float64, ...Label) {}
+func (nopMetrics) Observe(string, float64, ...Label)     {}
+
+// 包装 Transport，记录各 rpc 的耗时和失败次数
+type metricsTransport struct {
+	transport ContextTransport
+	metrics   MetricsSink
+}
+
+func (tp *metricsTransport) record(rpc string, start time.Time, err error) error {
+	label := Label{Name: "rpc", Value: rpc}
+	tp.metrics.Observe(MetricRpcDuration, time.Since(start).Seconds(), label)
+	if err != nil {
+		tp.metrics.IncrCounter(MetricRpcFailures, 1, label)
+	}
+	return err
+}
+
+func (tp *metricsTransport) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	start := time.Now()
+	return tp.record("AppendEntries", start, tp.transport.AppendEntriesContext(ctx, addr, args, res))
+}
+
+func (tp *metricsTransport) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	start := time.Now()
+	return tp.record("RequestVote", start, tp.transport.RequestVoteContext(ctx, addr, args, res))
+}
+
+func (tp *metricsTransport) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	start := time.Now()
+	return tp.record("PreVote", start, preVoteContext(tp.transport, ctx, addr, args, res))
+}
+
+func (tp *metricsTransport) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	start := time.Now()
+	return tp.record("InstallSnapshot", start, tp.transport.InstallSnapshotContext(ctx, addr, args, res))
+}
+
+// ==================== Prometheus ====================
+
+// 直方图默认分桶，单位为秒
+var defaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	gaugeType     metricType = "gauge"
+	counterType   metricType = "counter"
+	histogramType metricType = "histogram"
+)
+
+type histogram struct {
+	counts []uint64 // 各分桶的计数，不累加
+	sum    float64
+	count  uint64
+}
+
+type metricFamily struct {
+	metricType metricType
+	values     map[string]float64    // 标签串 -> gauge / counter 值
+	histograms map[string]*histogram // 标签串 -> 直方图
+}
+
+// MetricsSink 的 Prometheus 实现，同时实现 http.Handler，以 Prometheus 文本格式输出指标
+// 使用时将其注册到 HTTP 服务的 /metrics 路径即可
+type PrometheusSink struct {
+	buckets  []float64
+	families map[string]*metricFamily
+	mu       sync.Mutex
+}
+
+// 新建 PrometheusSink，buckets 为直方图分桶上界，为空时使用默认分桶
+func NewPrometheusSink(buckets ...float64) *PrometheusSink {
+	if len(buckets) == 0 {
+		buckets = defaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &PrometheusSink{
+		buckets:  sorted,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+func (ps *PrometheusSink) family(name string, metricType metricType) *metricFamily {
+	f, ok := ps.families[name]
+	if !ok {
+		f = &metricFamily{
+			metricType: metricType,
+			values:     make(map[string]float64),
+			histograms: make(map[string]*histogram),
+		}
+		ps.families[name] = f
+	}
+	return f
+}
+
+func (ps *PrometheusSink) SetGauge(name string, value float64, labels ...Label) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.family(name, gaugeType).values[formatLabels(labels)] = value
+}
+
+func (ps *PrometheusSink) IncrCounter(name string, delta float64, labels ...Label) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.family(name, counterType).values[formatLabels(labels)] += delta
+}
+
+func (ps *PrometheusSink) Observe(name string, value float64, labels ...Label) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	f := ps.family(name, histogramType)
+	key := formatLabels(labels)
+	h, ok := f.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(ps.buckets))}
+		f.histograms[key] = h
+	}
+	for i, upper := range ps.buckets {
+		if value <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// 以 Prometheus 文本格式输出全部指标
+func (ps *PrometheusSink) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	names := make([]string, 0, len(ps.families))
+	for name := range ps.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := ps.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.metricType)
+		if f.metricType != histogramType {
+			for _, key := range sortedKeys(f.values) {
+				fmt.Fprintf(&b, "%s%s %s\n", name, wrapLabels(key), formatValue(f.values[key]))
+			}
+			continue
+		}
+		keys := make([]string, 0, len(f.histograms))
+		for key := range f.histograms {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := f.histograms[key]
+			var cumulative uint64
+			for i, upper := range ps.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, "le", formatValue(upper))), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, "le", "+Inf")), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, wrapLabels(key), formatValue(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, wrapLabels(key), h.count)
+		}
+	}
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// 标签按名称排序后格式化为 name="value",... 作为指标的键
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	pairs := make([]string, len(sorted))
+	for i, label := range sorted {
+		pairs[i] = fmt.Sprintf("%s=%s", label.Name, strconv.Quote(label.Value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(key, name, value string) string {
+	pair := fmt.Sprintf("%s=%s", name, strconv.Quote(value))
+	if key == "" {
+		return pair
+	}
+	return key + "," + pair
+}
+
+func wrapLabels(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "{" + key + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录全部观测值
+type recordingSink struct {
+	observed map[string][]float64
+	mu       sync.Mutex
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{observed: make(map[string][]float64)}
+}
+
+func (s *recordingSink) SetGauge(string, float64, ...Label)    {}
+func (s *recordingSink) IncrCounter(string, float64, ...Label) {}
+
+func (s *recordingSink) Observe(name string, value float64, _ ...Label) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed[name] = append(s.observed[name], value)
+}
+
+func (s *recordingSink) values(name string) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]float64(nil), s.observed[name]...)
+}
+
+func TestLatencyMetricsUseClock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	clock := NewMockClock(time.Time{})
+	sink := newRecordingSink()
+	cluster := newTestCluster(t, 1, func(config *Config) {
+		config.Clock = clock
+		config.Metrics = sink
+	})
+
+	// 推进时钟直到选举超时，单节点集群随即成为 Leader
+	var leader *Node
+	for i := 0; i < 500 && leader == nil; i++ {
+		clock.Advance(time.Millisecond * 10)
+		time.Sleep(time.Millisecond)
+		for _, node := range cluster.nodes {
+			if node.IsLeader() {
+				leader = node
+			}
+		}
+	}
+	if leader == nil {
+		t.Fatal("no leader elected")
+	}
+
+	// 时钟停止推进，提交和应用耗时都应为 0
+	if _, ok := cluster.apply("put x 1"); !ok {
+		t.Fatal("apply failed")
+	}
+	for _, name := range []string{MetricCommitLatency, MetricApplyLatency} {
+		values := sink.values(name)
+		if len(values) == 0 {
+			t.Fatalf("%s not observed", name)
+		}
+		for _, value := range values {
+			if value != 0 {
+				t.Fatalf("%s = %v with a stopped clock, want 0", name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// raft 组 id，同一进程内的多个 raft 组以此区分
+type GroupId string
+
+// 在一个进程内运行多个相互独立的 raft 组，用于分片系统
+// 所有组共用一个 Transport，组内节点之间的 rpc 消息携带 GroupId，
+// 接收方将请求交给 MultiNode 的同名方法，由其按 GroupId 分发给对应的组
+type MultiNode struct {
+	transport Transport
+	groups    map[GroupId]*Node
+	mu        sync.RWMutex
+}
+
+func NewMultiNode(transport Transport) *MultiNode {
+	return &MultiNode{
+		transport: transport,
+		groups:    make(map[GroupId]*Node),
+	}
+}
+
+// 添加一个 raft 组，config.Transport 会被替换为共用的 Transport
+// 返回的 Node 与单独创建的 Node 用法相同，调用方需在 BootstrapCluster（如需要）之后调用其 Run 方法
+func (mn *MultiNode) AddGroup(id GroupId, config Config) (*Node, error) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	if _, ok := mn.groups[id]; ok {
+		return nil, fmt.Errorf("添加 raft 组 %s 失败：%w", id, ErrGroupExists)
+	}
+	config.Transport = &groupTransport{group: id, transport: mn.transport}
+	node, err := NewNode(config)
+	if err != nil {
+		return nil, fmt.Errorf("添加 raft 组 %s 失败：%w", id, err)
+	}
+	mn.groups[id] = node
+	return node, nil
+}
+
+// 关闭并移除一个 raft 组
+func (mn *MultiNode) RemoveGroup(ctx context.Context, id GroupId) error {
+	mn.mu.Lock()
+	node, ok := mn.groups[id]
+	delete(mn.groups, id)
+	mn.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("移除 raft 组 %s 失败：%w", id, ErrGroupNotFound)
+	}
+	return node.Shutdown(ctx)
+}
+
+// 获取 raft 组对应的节点
+func (mn *MultiNode) Group(id GroupId) (*Node, bool) {
+	mn.mu.RLock()
+	defer mn.mu.RUnlock()
+	node, ok := mn.groups[id]
+	return node, ok
+}
+
+// 当前进程内的全部 raft 组，按 id 排序
+func (mn *MultiNode) Groups() []GroupId {
+	mn.mu.RLock()
+	defer mn.mu.RUnlock()
+	ids := make([]GroupId, 0, len(mn.groups))
+	for id := range mn.groups {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 关闭全部 raft 组，返回遇到的第一个错误
+func (mn *MultiNode) Shutdown(ctx context.Context) error {
+	mn.mu.Lock()
+	groups := mn.groups
+	mn.groups = make(map[GroupId]*Node)
+	mn.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(groups))
+	for id, node := range groups {
+		id, node := id, node
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := node.Shutdown(ctx); err != nil {
+				errCh <- fmt.Errorf("关闭 raft 组 %s 失败：%w", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
+
+func (mn *MultiNode) route(id GroupId) (*Node, error) {
+	node, ok := mn.Group(id)
+	if !ok {
+		return nil, fmt.Errorf("raft 组 %s：%w", id, ErrGroupNotFound)
+	}
+	return node, nil
+}
+
+// 接收到其它节点的 rpc 请求后，调用以下方法，按 GroupId 分发给对应的组
+
+func (mn *MultiNode) AppendEntries(args AppendEntry, res *AppendEntryReply) error {
+	node, err := mn.route(args.GroupId)
+	if err != nil {
+		return err
+	}
+	return node.AppendEntries(args, res)
+}
+
+func (mn *MultiNode) RequestVote(args RequestVote, res *RequestVoteReply) error {
+	node, err := mn.route(args.GroupId)
+	if err != nil {
+		return err
+	}
+	return node.RequestVote(args, res)
+}
+
+func (mn *MultiNode) PreVote(args PreVote, res *PreVoteReply) error {
+	node, err := mn.route(args.GroupId)
+	if err != nil {
+		return err
+	}
+	return node.PreVote(args, res)
+}
+
+func (mn *MultiNode) InstallSnapshot(args InstallSnapshot, res *InstallSnapshotReply) error {
+	node, err := mn.route(args.GroupId)
+	if err != nil {
+		return err
+	}
+	return node.InstallSnapshot(args, res)
+}
+
+// 为发出的 rpc 消息设置 GroupId，再交给共用的 Transport
+type groupTransport struct {
+	group     GroupId
+	transport Transport
+}
+
+func (tp *groupTransport) AppendEntries(addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	args.GroupId = tp.group
+	return tp.transport.AppendEntries(addr, args, res)
+}
+
+func (tp *groupTransport) RequestVote(addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	args.GroupId = tp.group
+	return tp.transport.RequestVote(addr, args, res)
+}
+
+func (tp *groupTransport) PreVote(addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	transport, ok := tp.transport.(PreVoteTransport)
+	if !ok {
+		return ErrPreVoteUnsupported
+	}
+	args.GroupId = tp.group
+	return transport.PreVote(addr, args, res)
+}
+
+func (tp *groupTransport) InstallSnapshot(addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	args.GroupId = tp.group
+	return tp.transport.InstallSnapshot(addr, args, res)
+}
+
+// 共用的 Transport 实现了 ContextTransport 时，ctx 原样传递给它
+
+func (tp *groupTransport) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	args.GroupId = tp.group
+	return withContext(tp.transport).AppendEntriesContext(ctx, addr, args, res)
+}
+
+func (tp *groupTransport) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	args.GroupId = tp.group
+	return withContext(tp.transport).RequestVoteContext(ctx, addr, args, res)
+}
+
+func (tp *groupTransport) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	args.GroupId = tp.group
+	return preVoteContext(withContext(tp.transport), ctx, addr, args, res)
+}
+
+func (tp *groupTransport) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	args.GroupId = tp.group
+	return withContext(tp.transport).InstallSnapshotContext(ctx, addr, args, res)
+}
@@ -1,10 +1,19 @@
 package raft
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
 const (
 	// 来自 Leader 的日志复制请求
 	AppendEntryRpc rpcType = iota
 	// 来自 Candidate 的投票请求
 	RequestVoteRpc
+	// 来自 Candidate 的预投票请求
+	PreVoteRpc
 	// 来自 Leader 的安装快照请求
 	InstallSnapshotRpc
 	// 来自客户端的安装命令请求
@@ -15,12 +24,39 @@ const (
 	TransferLeadershipRpc
 	// 来自客户端的添加 Learner 节点请求
 	AddLearnerRpc
+	// 来自客户端的生成快照请求
+	SnapshotRpc
+	// 来自客户端的添加投票节点请求
+	AddVoterRpc
+	// 来自客户端的移除节点请求
+	RemoveServerRpc
+	// 来自客户端的添加非投票节点请求
+	AddNonVoterRpc
+	// 来自客户端的查询 Learner 追赶进度请求
+	CatchUpProgressRpc
+	// 来自客户端的查询节点状态请求
+	StatusRpc
+	// 来自客户端的屏障请求
+	BarrierRpc
+	// 来自客户端的取消领导权转移请求
+	AbortTransferRpc
+	// 来自客户端的更新节点地址请求
+	UpdatePeerAddressRpc
 )
 
 type rpc struct {
 	rpcType rpcType
 	req     interface{}
 	res     chan rpcReply
+	ctx     context.Context // 客户端请求的 ctx，为 nil 时不限制处理时间
+}
+
+// 客户端请求的 ctx 已取消或超时时返回其错误
+func (msg rpc) ctxErr() error {
+	if msg.ctx == nil {
+		return nil
+	}
+	return msg.ctx.Err()
 }
 
 type rpcReply struct {
@@ -33,21 +69,159 @@ type Node struct {
 	raft   *raft
 	config Config // 节点配置对象
 	rpcCh  chan rpc
+	queue  *rpcQueue    // 请求队列，未设置排队上限时为 nil
+	admin  *http.Server // 内嵌的管理接口服务
 }
 
-func NewNode(config Config) *Node {
+// 创建节点，配置不合法或加载持久化的快照、日志失败时返回错误
+// 持久化数据损坏时返回的错误包装了 CorruptError，调用方可据此从其它节点恢复数据或以空白节点重新加入集群
+func NewNode(config Config) (*Node, error) {
+	rf, err := newRaft(config)
+	if err != nil {
+		return nil, err
+	}
+	rpcCh := make(chan rpc)
 	return &Node{
-		raft:   newRaft(config),
+		raft:   rf,
 		config: config,
-		rpcCh:  make(chan rpc),
-	}
+		rpcCh:  rpcCh,
+		queue:  newRpcQueue(config, rpcCh),
+	}, nil
+}
+
+// 集群首次启动时，在一个空白节点上调用，将初始配置作为第一条日志写入，需在 Run 之前调用
+// 其它节点不需要相同的静态配置，以 Learner 角色启动后通过 AddVoter 或 AddLearner 加入集群
+// 节点已有日志或快照时返回 ErrAlreadyBootstrapped
+func (nd *Node) BootstrapCluster(peers map[NodeId]NodeAddr) error {
+	return nd.raft.bootstrap(peers)
 }
 
 func (nd *Node) Run() {
+	// 设置了 AdminAddr 时开启管理接口服务
+	nd.serveAdmin()
+	// 开启请求队列的分发协程
+	if nd.queue != nil {
+		go nd.queue.run(nd.raft.shutdownState.stopCh)
+	}
 	// 开启 raft 循环
 	nd.raft.raftRun(nd.rpcCh)
 }
 
+// 客户端关闭当前节点
+// 节点先停止接收新的客户端请求，新请求返回 DrainingError，待已接收的请求提交并应用到状态机后退出主循环，
+// 再等待日志复制、rpc 调用、快照生成等后台协程退出，最后将 raft 状态完整持久化一次
+// ctx 超时后返回错误，此时部分协程可能仍在运行
+func (nd *Node) Shutdown(ctx context.Context) error {
+	if err := nd.closeAdmin(ctx); err != nil {
+		nd.raft.logger.Error(fmt.Errorf("关闭管理接口服务失败：%w", err).Error())
+	}
+	return nd.raft.shutdown(ctx)
+}
+
+// 客户端关闭当前节点，用于不中断服务的滚动重启
+// 当前节点是 Leader 时，先将领导权转移给日志最新的投票节点，转移完成、失败或 ctx 超时后再调用 Shutdown 关闭节点
+func (nd *Node) StepDownAndShutdown(ctx context.Context) error {
+	if err := nd.stepDown(ctx); err != nil {
+		nd.raft.logger.Error(fmt.Errorf("关闭前转移领导权失败：%w", err).Error())
+	}
+	return nd.Shutdown(ctx)
+}
+
+// 当前节点是 Leader 时，将领导权转移给日志最新的投票节点
+func (nd *Node) stepDown(ctx context.Context) error {
+	if !nd.IsLeader() {
+		return nil
+	}
+
+	// 领导权转移超时后 Leader 会答复请求，使用带缓冲的通道，ctx 超时返回后协程仍可退出
+	resultCh := make(chan error, 1)
+	go func() {
+		var res TransferLeadershipReply
+		// 不指定目标节点，由 Leader 选择日志最新的投票节点
+		err := nd.TransferLeadership(TransferLeadership{}, &res)
+		if err == nil && res.Status != OK {
+			err = fmt.Errorf("节点已不是 Leader")
+		}
+		resultCh <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-resultCh:
+		return err
+	}
+}
+
+// 客户端查询已应用到状态机的最大日志索引
+// 日志由单独的应用协程异步应用，此值可能落后于提交索引
+func (nd *Node) AppliedIndex() int {
+	return nd.raft.softState.getLastApplied()
+}
+
+// 运行时修改当前节点的配置，不需要重启节点，配置不合法时返回错误且不做任何修改
+// 只有 ElectionMinTimeout、ElectionMaxTimeout、HeartbeatTimeout、MaxLogLength、MaxLogBytes、SnapshotInterval、
+// CompactionPolicy、MaxBatchWait、MaxBatchBytes 生效，其余配置项忽略
+// 超时时间在计时器下一次重置时生效，集群中各节点需分别调用
+func (nd *Node) ReloadConfig(config Config) error {
+	return nd.raft.reloadConfig(config)
+}
+
+// 设置节点的只读模式，用于维护前排空节点，立即生效，不持久化，重启后恢复为可写
+// 只读模式下节点驳回客户端命令并返回 ErrReadOnly，不发起选举，但仍接收日志复制、参与投票
+// 只读模式不会使 Leader 退位，Leader 节点应先调用 TransferLeadership 转移领导权
+func (nd *Node) SetReadOnly(readOnly bool) {
+	nd.raft.setReadOnly(readOnly)
+}
+
+// 客户端查询各节点的日志复制进度，包括 nextIndex、matchIndex、落后的日志条数、复制状态及最近一次应答的时间
+// 当前节点不是 Leader 时返回 NotLeaderError
+func (nd *Node) Progress() (map[NodeId]PeerProgress, error) {
+	status, err := nd.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.Role != Leader {
+		return nil, NotLeaderError{Leader: status.Leader}
+	}
+	return status.Progress, nil
+}
+
+// 在 Leader 上检查节点能否作为领导权转移的目标，供编排工具在调用 TransferLeadership 前选择安全的目标节点
+// 目标节点需是投票节点、不是见证节点、未被判定为不可达，且落后 Leader 不超过 TransferMaxLag 条日志，
+// 不满足时返回 false 及原因，当前节点不是 Leader 时也返回 false
+func (nd *Node) CanTransferTo(id NodeId) (bool, string) {
+	status, err := nd.Status()
+	if err != nil {
+		return false, err.Error()
+	}
+	if status.Role != Leader {
+		return false, NotLeaderError{Leader: status.Leader}.Error()
+	}
+	if id == status.Id {
+		return false, "目标节点是当前 Leader"
+	}
+	if _, ok := status.Membership.Voters[id]; !ok {
+		if _, ok := status.Membership.NonVoters[id]; ok {
+			return false, fmt.Sprintf("节点 Id=%s 不是投票节点", id)
+		}
+		return false, fmt.Sprintf("节点 Id=%s 不是集群成员", id)
+	}
+	progress, ok := status.Progress[id]
+	if !ok {
+		return false, fmt.Sprintf("节点 Id=%s 没有复制进度", id)
+	}
+	if progress.Witness {
+		return false, fmt.Sprintf("节点 Id=%s 是见证节点，不能成为 Leader", id)
+	}
+	if progress.Unreachable {
+		return false, fmt.Sprintf("节点 Id=%s 不可达", id)
+	}
+	if progress.Lag > nd.raft.transferLag {
+		return false, fmt.Sprintf("节点 Id=%s 落后 Leader %d 条日志，超过 %d 条", id, progress.Lag, nd.raft.transferLag)
+	}
+	return true, ""
+}
+
 // 客户端查询当前节点是否是 Leader 节点
 func (nd *Node) IsLeader() bool {
 	return nd.raft.isLeader()
@@ -58,14 +232,42 @@ func (nd *Node) AddRoleObserver(ob chan RoleStage) {
 	nd.raft.addRoleObserver(ob)
 }
 
+// 客户端注册事件观察者，接收角色、任期、Leader、集群成员、快照变更及节点通信失败等事件
+func (nd *Node) RegisterObserver(ob *Observer) {
+	nd.raft.addObserver(ob)
+}
+
+// 客户端注销事件观察者
+func (nd *Node) DeregisterObserver(ob *Observer) {
+	nd.raft.removeObserver(ob)
+}
+
+// 客户端获取 Leader 变更通知，每次调用返回一个新的通道
+// 当前节点得知的 Leader 变化时发送最新的 Leader 信息，Id 为空表示正在选举，
+// 可据此开启或停止只在 Leader 上运行的后台任务，接收不及时时只保留最新的通知
+func (nd *Node) LeaderCh() <-chan LeaderInfo {
+	ch := make(chan LeaderInfo, 1)
+	nd.raft.addLeaderObserver(ch)
+	return ch
+}
+
 // 客户端查询集群 Leader 地址
 func (nd *Node) GetLeader() NodeAddr {
 	return nd.raft.peerState.getLeader().Addr
 }
 
+// 客户端查询当前节点所知的集群成员，投票节点和非投票节点分开返回
+// 正在追赶日志的 Learner 不属于集群成员，可通过 CatchUpProgress 查询
+func (nd *Node) ClusterMembership() ClusterMembership {
+	return nd.raft.peerState.membership()
+}
+
 // Follower 和 Candidate 开放的 rpc接口，由 Leader 调用
 // 客户端接收到请求后，调用此方法
 func (nd *Node) AppendEntries(args AppendEntry, res *AppendEntryReply) error {
+	if err := nd.raft.authenticate(args.LeaderId, args.AuthToken); err != nil {
+		return err
+	}
 	if msg := nd.sendRpc(AppendEntryRpc, args); msg.err != nil {
 		return msg.err
 	} else {
@@ -77,6 +279,9 @@ func (nd *Node) AppendEntries(args AppendEntry, res *AppendEntryReply) error {
 // Follower 和 Candidate 开放的 rpc 接口，由 Candidate 调用
 // 客户端接收到请求后，调用此方法
 func (nd *Node) RequestVote(args RequestVote, res *RequestVoteReply) error {
+	if err := nd.raft.authenticate(args.CandidateId, args.AuthToken); err != nil {
+		return err
+	}
 	if msg := nd.sendRpc(RequestVoteRpc, args); msg.err != nil {
 		return msg.err
 	} else {
@@ -85,9 +290,26 @@ func (nd *Node) RequestVote(args RequestVote, res *RequestVoteReply) error {
 	}
 }
 
+// 所有节点开放的 rpc 接口，由准备发起选举的节点调用
+// 客户端接收到请求后，调用此方法
+func (nd *Node) PreVote(args PreVote, res *PreVoteReply) error {
+	if err := nd.raft.authenticate(args.CandidateId, args.AuthToken); err != nil {
+		return err
+	}
+	if msg := nd.sendRpc(PreVoteRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(PreVoteReply)
+		return nil
+	}
+}
+
 // Follower 开放的 rpc 接口，由 Leader 调用
 // 客户端接收到请求后，调用此方法
 func (nd *Node) InstallSnapshot(args InstallSnapshot, res *InstallSnapshotReply) error {
+	if err := nd.raft.authenticate(args.LeaderId, args.AuthToken); err != nil {
+		return err
+	}
 	if msg := nd.sendRpc(InstallSnapshotRpc, args); msg.err != nil {
 		return msg.err
 	} else {
@@ -106,6 +328,17 @@ func (nd *Node) ApplyCommand(args ApplyCommand, res *ApplyCommandReply) error {
 	}
 }
 
+// 与 ApplyCommand 相同，ctx 取消或超时时立即返回其错误
+// 请求在添加到日志之前 ctx 已结束时不会被提交，添加到日志之后仍可能被提交，客户端需自行去重
+func (nd *Node) ApplyCommandContext(ctx context.Context, args ApplyCommand, res *ApplyCommandReply) error {
+	if msg := nd.sendRpcContext(ctx, ApplyCommandRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(ApplyCommandReply)
+		return nil
+	}
+}
+
 // Leader 开放的 rpc 接口，由客户端调用，添加新配置
 func (nd *Node) ChangeConfig(args ChangeConfig, res *ChangeConfigReply) error {
 	if msg := nd.sendRpc(ChangeConfigRpc, args); msg.err != nil {
@@ -136,12 +369,191 @@ func (nd *Node) AddLearner(args AddLearner, res *AddLearnerReply) error {
 	}
 }
 
+// Leader 开放的 rpc 接口，由客户端调用，单节点成员变更，添加一个投票节点
+// 新节点先作为 Learner 追赶日志，日志追赶完成前返回 ErrLearnerCatchingUp，客户端需稍后重试
+func (nd *Node) AddVoter(args AddVoter, res *AddVoterReply) error {
+	if msg := nd.sendRpc(AddVoterRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AddVoterReply)
+		return nil
+	}
+}
+
+// Leader 开放的 rpc 接口，由客户端调用，添加一个永久的非投票节点
+// 非投票节点只接收日志，不参与选举和多数派计算，也不会被自动升级为投票节点
+func (nd *Node) AddNonVoter(args AddNonVoter, res *AddNonVoterReply) error {
+	if msg := nd.sendRpc(AddNonVoterRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AddNonVoterReply)
+		return nil
+	}
+}
+
+// Leader 开放的 rpc 接口，由客户端调用，查询各 Learner 节点的日志追赶进度
+// 运维人员可据此判断何时将 Learner 升级为投票节点
+func (nd *Node) CatchUpProgress(args CatchUpProgress, res *CatchUpProgressReply) error {
+	if msg := nd.sendRpc(CatchUpProgressRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(CatchUpProgressReply)
+		return nil
+	}
+}
+
+// Leader 开放的 rpc 接口，由客户端调用，单节点成员变更，移除一个节点
+func (nd *Node) RemoveServer(args RemoveServer, res *RemoveServerReply) error {
+	if msg := nd.sendRpc(RemoveServerRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(RemoveServerReply)
+		return nil
+	}
+}
+
+// Leader 开放的 rpc 接口，由客户端调用，节点的网络地址改变而身份不变时更新其地址
+// 集群成员的新地址通过一条成员不变的配置日志同步给所有节点，不需要先移除再添加节点；Learner 只更新 Leader 上的地址
+func (nd *Node) UpdatePeerAddress(args UpdatePeerAddress, res *UpdatePeerAddressReply) error {
+	if msg := nd.sendRpc(UpdatePeerAddressRpc, args); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(UpdatePeerAddressReply)
+		return nil
+	}
+}
+
+// 客户端立即生成快照，不受日志压缩策略限制，可用于备份或维护前压缩日志
+// 返回生成的快照的元数据，没有新应用的日志时返回当前快照的元数据
+func (nd *Node) Snapshot() (SnapshotMeta, error) {
+	if msg := nd.sendRpc(SnapshotRpc, nil); msg.err != nil {
+		return SnapshotMeta{}, msg.err
+	} else {
+		return msg.res.(SnapshotMeta), nil
+	}
+}
+
+// 客户端查询当前节点的状态，包括角色、任期、Leader、提交和应用进度、日志和快照索引、集群成员，
+// 当前节点是 Leader 时还包括各节点的日志复制进度
+func (nd *Node) Status() (NodeStatus, error) {
+	if msg := nd.sendRpc(StatusRpc, nil); msg.err != nil {
+		return NodeStatus{}, msg.err
+	} else {
+		return msg.res.(NodeStatus), nil
+	}
+}
+
+// 客户端等待状态机应用完之前提交的所有日志，用于写入后直接读取状态机的场景
+// Leader 添加并复制一条空日志，空日志提交并应用到状态机后返回
+// 当前节点不是 Leader 时返回 NotLeaderError，timeout 内未完成时返回 ErrTimeout
+func (nd *Node) Barrier(timeout time.Duration) error {
+	timer := nd.raft.clock.NewTimer(timeout)
+	defer timer.Stop()
+	// 超时返回后主循环仍会发送结果，使用带缓冲的通道避免阻塞
+	rpcMsg := rpc{
+		rpcType: BarrierRpc,
+		res:     make(chan rpcReply, 1),
+	}
+	if queued, err := nd.queue.push(rpcMsg); err != nil {
+		return err
+	} else if !queued {
+		select {
+		case <-nd.raft.shutdownState.stopCh:
+			return ErrShutdown
+		case <-timer.C():
+			return ErrTimeout
+		case nd.rpcCh <- rpcMsg:
+		}
+	}
+	select {
+	case <-timer.C():
+		return ErrTimeout
+	case msg := <-rpcMsg.res:
+		return msg.err
+	}
+}
+
+// 客户端取消正在进行的领导权转移，Leader 恢复处理客户端请求
+// 发起转移的请求收到 ErrTransferAborted，没有正在进行的领导权转移时直接返回
+// 目标节点已收到 timeoutNow 消息时，仍可能发起选举成为新的 Leader
+func (nd *Node) AbortTransfer() error {
+	return nd.sendRpc(AbortTransferRpc, nil).err
+}
+
 func (nd *Node) sendRpc(rpcType rpcType, args interface{}) rpcReply {
 	rpcMsg := rpc{
 		rpcType: rpcType,
-		req: args,
-		res: make(chan rpcReply),
+		req:     args,
+		res:     make(chan rpcReply),
+	}
+	if queued, err := nd.queue.push(rpcMsg); err != nil {
+		return rpcReply{err: err}
+	} else if !queued {
+		select {
+		case <-nd.raft.shutdownState.stopCh:
+			return rpcReply{err: ErrShutdown}
+		case nd.rpcCh <- rpcMsg:
+		}
+	}
+	return <-rpcMsg.res
+}
+
+// 与 sendRpc 相同，ctx 随请求传给主循环，ctx 结束时不再等待应答，返回其错误
+func (nd *Node) sendRpcContext(ctx context.Context, rpcType rpcType, args interface{}) rpcReply {
+	// ctx 结束返回后主循环仍会发送应答，使用带缓冲的通道避免阻塞
+	rpcMsg := rpc{
+		rpcType: rpcType,
+		req:     args,
+		res:     make(chan rpcReply, 1),
+		ctx:     ctx,
+	}
+	if queued, err := nd.queue.push(rpcMsg); err != nil {
+		return rpcReply{err: err}
+	} else if !queued {
+		select {
+		case <-nd.raft.shutdownState.stopCh:
+			return rpcReply{err: ErrShutdown}
+		case <-ctx.Done():
+			return rpcReply{err: ctx.Err()}
+		case nd.rpcCh <- rpcMsg:
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return rpcReply{err: ctx.Err()}
+	case msg := <-rpcMsg.res:
+		return msg
+	}
+}
+
+// 与 sendRpc 相同，timeout 内未收到应答时返回 ErrTimeout，timeout 为 0 时不限制
+func (nd *Node) sendRpcTimeout(rpcType rpcType, args interface{}, timeout time.Duration) rpcReply {
+	if timeout <= 0 {
+		return nd.sendRpc(rpcType, args)
+	}
+	timer := nd.raft.clock.NewTimer(timeout)
+	defer timer.Stop()
+	// 超时返回后主循环仍会发送应答，使用带缓冲的通道避免阻塞
+	rpcMsg := rpc{
+		rpcType: rpcType,
+		req:     args,
+		res:     make(chan rpcReply, 1),
+	}
+	if queued, err := nd.queue.push(rpcMsg); err != nil {
+		return rpcReply{err: err}
+	} else if !queued {
+		select {
+		case <-nd.raft.shutdownState.stopCh:
+			return rpcReply{err: ErrShutdown}
+		case <-timer.C():
+			return rpcReply{err: ErrTimeout}
+		case nd.rpcCh <- rpcMsg:
+		}
+	}
+	select {
+	case <-timer.C():
+		return rpcReply{err: ErrTimeout}
+	case msg := <-rpcMsg.res:
+		return msg
 	}
-	nd.rpcCh <- rpcMsg
-	return <- rpcMsg.res
 }
@@ -0,0 +1,107 @@
+package raft
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 事件类型
+type EventType uint8
+
+const (
+	EventRoleChange        EventType = iota // 角色变更
+	EventTermChange                         // 任期变更
+	EventLeaderChange                       // Leader 变更
+	EventMembershipChange                   // 集群成员变更
+	EventSnapshotCreated                    // 生成快照
+	EventSnapshotInstalled                  // 安装 Leader 发来的快照
+	EventPeerFailure                        // 与其它节点通信失败
+	EventPeerUnreachable                    // 与其它节点连续通信失败，判定为不可达
+	EventPeerRecovered                      // 不可达的节点恢复通信
+	EventSlowDisk                           // Leader 的磁盘写入持续缓慢，主动转移领导权
+	EventDiskError                          // 持久化器返回磁盘错误，按 DiskErrorPolicy 处理
+	EventInvariantViolated                  // 设置 Config.Debug 时，不变量检查发现违反
+	EventPeerPruned                         // Leader 按 StalePeerPolicy 移除失联节点或将其降级为非投票节点
+)
+
+func EventTypeToString(eventType EventType) (name string) {
+	switch eventType {
+	case EventRoleChange:
+		name = "RoleChange"
+	case EventTermChange:
+		name = "TermChange"
+	case EventLeaderChange:
+		name = "LeaderChange"
+	case EventMembershipChange:
+		name = "MembershipChange"
+	case EventSnapshotCreated:
+		name = "SnapshotCreated"
+	case EventSnapshotInstalled:
+		name = "SnapshotInstalled"
+	case EventPeerFailure:
+		name = "PeerFailure"
+	case EventPeerUnreachable:
+		name = "PeerUnreachable"
+	case EventPeerRecovered:
+		name = "PeerRecovered"
+	case EventSlowDisk:
+		name = "SlowDisk"
+	case EventDiskError:
+		name = "DiskError"
+	case EventInvariantViolated:
+		name = "InvariantViolated"
+	case EventPeerPruned:
+		name = "PeerPruned"
+	}
+	return
+}
+
+// raft 内部事件，根据 Type 只设置相应的字段
+type Event struct {
+	Type       EventType
+	Term       int               // 事件发生时的任期
+	Role       RoleStage         // EventRoleChange
+	Leader     LeaderInfo        // EventLeaderChange
+	Membership ClusterMembership // EventMembershipChange
+	Snapshot   SnapshotMeta      // EventSnapshotCreated、EventSnapshotInstalled
+	Peer       NodeId            // EventPeerFailure、EventPeerUnreachable、EventPeerRecovered、EventPeerPruned
+	Err        error             // EventPeerFailure、EventPeerUnreachable、EventDiskError，EventInvariantViolated 时为 InvariantError
+	Duration   time.Duration     // EventSlowDisk 时为磁盘写入持续缓慢的时间，EventPeerPruned 时为节点失联的时间
+}
+
+// 事件观察者，通过带缓冲的通道接收事件
+// 缓冲区满时丢弃新事件，不阻塞 raft 主循环
+type Observer struct {
+	ch      chan Event
+	filter  func(Event) bool
+	dropped uint64
+}
+
+// 新建观察者，bufferSize 为通道缓冲大小，filter 为 nil 时接收全部事件
+func NewObserver(bufferSize int, filter func(Event) bool) *Observer {
+	return &Observer{
+		ch:     make(chan Event, bufferSize),
+		filter: filter,
+	}
+}
+
+// 接收事件的通道
+func (ob *Observer) Events() <-chan Event {
+	return ob.ch
+}
+
+// 因缓冲区已满被丢弃的事件数
+func (ob *Observer) Dropped() uint64 {
+	return atomic.LoadUint64(&ob.dropped)
+}
+
+func (ob *Observer) notify(event Event) {
+	if ob.filter != nil && !ob.filter(event) {
+		return
+	}
+	select {
+	case ob.ch <- event:
+	default:
+		atomic.AddUint64(&ob.dropped, 1)
+	}
+}
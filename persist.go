@@ -1,21 +1,34 @@
 package raft
 
-import "sync"
+import (
+	"io"
+	"sync"
+)
 
 // ========== raft 保存的数据 ==========
 
 type RaftState struct {
-	Term     int
-	VotedFor NodeId
-	Entries  []Entry
+	Term         int
+	VotedFor     NodeId
+	Entries      []Entry
+	CompactIndex int    // 日志压缩标记，压缩后第一条日志的索引
+	Config       []byte // 最后一个已提交的集群配置，为空时使用 Config.Peers 启动
+	ConfigIndex  int    // 最后一个已提交的配置所在的日志索引
+	CommitIndex  int    // 已提交的最大日志索引，设置 ApplyIndexInterval 时定期保存
+	LastApplied  int    // 已应用到状态机的最大日志索引，设置 ApplyIndexInterval 时定期保存
 }
 
 func (rs RaftState) toHardState(persister RaftStatePersister) HardState {
 	return HardState{
-		term:      rs.Term,
-		votedFor:  rs.VotedFor,
-		entries:   rs.Entries,
-		persister: persister,
+		term:         rs.Term,
+		votedFor:     rs.VotedFor,
+		entries:      rs.Entries,
+		compactIndex: rs.CompactIndex,
+		config:       rs.Config,
+		configIndex:  rs.ConfigIndex,
+		commitIndex:  rs.CommitIndex,
+		lastApplied:  rs.LastApplied,
+		persister:    persister,
 	}
 }
 
@@ -31,9 +44,13 @@ type RaftStatePersister interface {
 // ========== 保存的快照数据 ==========
 
 type Snapshot struct {
-	LastIndex int
-	LastTerm  int
-	Data      []byte
+	LastIndex   int
+	LastTerm    int
+	Data        []byte
+	Checksum    uint32 // 校验和，保存快照时计算，为 0 表示没有校验和
+	Version     string // 生成快照的状态机版本，即 Config.FsmVersion
+	Config      []byte // LastIndex 处生效的已提交集群配置，为空时快照中没有配置
+	ConfigIndex int    // Config 所在的日志索引
 }
 
 // ========== 快照持久化器接口，由用户实现 ==========
@@ -45,6 +62,30 @@ type SnapshotPersister interface {
 	LoadSnapshot() (Snapshot, error)
 }
 
+// ========== 流式快照持久化器接口，由用户实现 ==========
+
+// 快照元数据
+type SnapshotMeta struct {
+	LastIndex int
+	LastTerm  int
+	Size      int64  // 快照数据的字节数
+	Version   string // 生成快照的状态机版本，流式快照为空
+}
+
+// 快照写入器，快照数据写入完成后调用 Close 保存，写入失败时调用 Cancel 放弃
+type SnapshotSink interface {
+	io.WriteCloser
+	Cancel() error
+}
+
+// 状态机较大时使用，快照数据直接写入存储，不在内存中保存完整快照
+type StreamSnapshotPersister interface {
+	// 保存快照时调用，返回快照写入器
+	CreateSnapshot(lastIndex, lastTerm int) (SnapshotSink, error)
+	// 打开最新的快照，若没有需返回空元数据和空读取器
+	OpenSnapshot() (SnapshotMeta, io.ReadCloser, error)
+}
+
 // RaftStatePersister 接口的内存实现，开发测试用
 type inMemRaftStatePersister struct {
 	raftState RaftState
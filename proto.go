@@ -0,0 +1,726 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// protobuf 线格式的编解码器，消息定义见 proto/raft.proto
+// 只使用标准库，不依赖 protobuf 生成的代码，其它语言的客户端可根据 proto 文件生成代码与节点通信
+// 实现了 gRPC 的 encoding.Codec 接口，可直接注册到 gRPC 中使用
+type ProtoCodec struct{}
+
+// 编解码器名称，对应 gRPC 的 content-subtype
+func (ProtoCodec) Name() string {
+	return "proto"
+}
+
+// 将消息编码为 protobuf 格式，v 可以是消息结构体或其指针
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &protoWriter{}
+	switch m := v.(type) {
+	case AppendEntry:
+		w.appendEntry(m)
+	case *AppendEntry:
+		w.appendEntry(*m)
+	case AppendEntryReply:
+		w.appendEntryReply(m)
+	case *AppendEntryReply:
+		w.appendEntryReply(*m)
+	case RequestVote:
+		w.requestVote(m)
+	case *RequestVote:
+		w.requestVote(*m)
+	case RequestVoteReply:
+		w.voteReply(m.Term, m.VoteGranted)
+	case *RequestVoteReply:
+		w.voteReply(m.Term, m.VoteGranted)
+	case PreVote:
+		w.preVote(m)
+	case *PreVote:
+		w.preVote(*m)
+	case PreVoteReply:
+		w.voteReply(m.Term, m.VoteGranted)
+	case *PreVoteReply:
+		w.voteReply(m.Term, m.VoteGranted)
+	case InstallSnapshot:
+		w.installSnapshot(m)
+	case *InstallSnapshot:
+		w.installSnapshot(*m)
+	case InstallSnapshotReply:
+		w.installSnapshotReply(m)
+	case *InstallSnapshotReply:
+		w.installSnapshotReply(*m)
+	case ApplyCommand:
+		w.applyCommand(m)
+	case *ApplyCommand:
+		w.applyCommand(*m)
+	case ApplyCommandReply:
+		w.applyCommandReply(m)
+	case *ApplyCommandReply:
+		w.applyCommandReply(*m)
+	case ChangeConfig:
+		w.changeConfig(m)
+	case *ChangeConfig:
+		w.changeConfig(*m)
+	case ChangeConfigReply:
+		w.statusReply(m.Status, &m.Leader)
+	case *ChangeConfigReply:
+		w.statusReply(m.Status, &m.Leader)
+	case TransferLeadership:
+		w.message(1, func(w *protoWriter) { w.server(m.Transferee) })
+	case *TransferLeadership:
+		w.message(1, func(w *protoWriter) { w.server(m.Transferee) })
+	case TransferLeadershipReply:
+		w.statusReply(m.Status, &m.Transferee)
+	case *TransferLeadershipReply:
+		w.statusReply(m.Status, &m.Transferee)
+	default:
+		return nil, fmt.Errorf("不支持 protobuf 编码的消息类型：%T", v)
+	}
+	return w.buf, nil
+}
+
+// 将 protobuf 格式的数据解码到消息中，v 必须是消息结构体的指针
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *AppendEntry:
+		return m.unmarshalProto(data)
+	case *AppendEntryReply:
+		return m.unmarshalProto(data)
+	case *RequestVote:
+		return m.unmarshalProto(data)
+	case *RequestVoteReply:
+		*m = RequestVoteReply{}
+		return decodeVoteReply(data, &m.Term, &m.VoteGranted)
+	case *PreVote:
+		return m.unmarshalProto(data)
+	case *PreVoteReply:
+		*m = PreVoteReply{}
+		return decodeVoteReply(data, &m.Term, &m.VoteGranted)
+	case *InstallSnapshot:
+		return m.unmarshalProto(data)
+	case *InstallSnapshotReply:
+		return m.unmarshalProto(data)
+	case *ApplyCommand:
+		return m.unmarshalProto(data)
+	case *ApplyCommandReply:
+		return m.unmarshalProto(data)
+	case *ChangeConfig:
+		return m.unmarshalProto(data)
+	case *ChangeConfigReply:
+		*m = ChangeConfigReply{}
+		return decodeStatusReply(data, &m.Status, &m.Leader)
+	case *TransferLeadership:
+		*m = TransferLeadership{}
+		return decodeProto(data, func(num int, val protoValue) error {
+			if num == 1 {
+				return val.server(&m.Transferee)
+			}
+			return nil
+		})
+	case *TransferLeadershipReply:
+		*m = TransferLeadershipReply{}
+		return decodeStatusReply(data, &m.Status, &m.Transferee)
+	default:
+		return fmt.Errorf("不支持 protobuf 解码的消息类型：%T", v)
+	}
+}
+
+// ==================== 编码 ====================
+
+// protobuf 线格式的字段类型
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoWriter struct {
+	buf []byte
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func (w *protoWriter) tag(num int, wireType int) {
+	w.buf = appendUvarint(w.buf, uint64(num)<<3|uint64(wireType))
+}
+
+// 整数字段，为 0 时按 proto3 的约定不编码
+func (w *protoWriter) int(num int, v int64) {
+	if v == 0 {
+		return
+	}
+	w.tag(num, wireVarint)
+	w.buf = appendUvarint(w.buf, uint64(v))
+}
+
+func (w *protoWriter) bool(num int, v bool) {
+	if v {
+		w.int(num, 1)
+	}
+}
+
+func (w *protoWriter) bytes(num int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	w.tag(num, wireBytes)
+	w.buf = appendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *protoWriter) string(num int, v string) {
+	if v == "" {
+		return
+	}
+	w.tag(num, wireBytes)
+	w.buf = appendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// 嵌套消息字段，空消息也会编码，用于表示 repeated 字段中的元素
+func (w *protoWriter) message(num int, encode func(w *protoWriter)) {
+	sub := &protoWriter{}
+	encode(sub)
+	w.tag(num, wireBytes)
+	w.buf = appendUvarint(w.buf, uint64(len(sub.buf)))
+	w.buf = append(w.buf, sub.buf...)
+}
+
+// map<string, string> 字段，按键排序编码，保证相同的 map 编码结果相同
+func (w *protoWriter) stringMap(num int, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := m[k]
+		w.message(num, func(w *protoWriter) {
+			w.string(1, k)
+			w.string(2, value)
+		})
+	}
+}
+
+func (w *protoWriter) nodeMap(num int, m map[NodeId]NodeAddr) {
+	converted := make(map[string]string, len(m))
+	for id, addr := range m {
+		converted[string(id)] = string(addr)
+	}
+	w.stringMap(num, converted)
+}
+
+func (w *protoWriter) server(s Server) {
+	w.string(1, string(s.Id))
+	w.string(2, string(s.Addr))
+}
+
+func (w *protoWriter) entry(e Entry) {
+	w.int(1, int64(e.Index))
+	w.int(2, int64(e.Term))
+	w.int(3, int64(e.Type))
+	w.bytes(4, e.Data)
+	w.int(5, int64(e.Checksum))
+}
+
+func (w *protoWriter) appendEntry(m AppendEntry) {
+	w.int(1, int64(m.EntryType))
+	w.int(2, int64(m.Term))
+	w.string(3, string(m.LeaderId))
+	w.int(4, int64(m.PrevLogIndex))
+	w.int(5, int64(m.PrevLogTerm))
+	w.int(6, int64(m.LeaderCommit))
+	for _, e := range m.Entries {
+		e := e
+		w.message(7, func(w *protoWriter) { w.entry(e) })
+	}
+	w.stringMap(8, m.TraceContext)
+	w.string(9, string(m.GroupId))
+	w.bool(10, m.Compressed)
+	w.string(11, m.AuthToken)
+}
+
+func (w *protoWriter) appendEntryReply(m AppendEntryReply) {
+	w.int(1, int64(m.Term))
+	w.int(2, int64(m.ConflictTerm))
+	w.int(3, int64(m.ConflictStartIndex))
+	w.bool(4, m.Success)
+	w.bool(5, m.Compression)
+	w.bool(6, m.Witness)
+}
+
+func (w *protoWriter) requestVote(m RequestVote) {
+	w.int(1, int64(m.Term))
+	w.string(2, string(m.CandidateId))
+	w.int(3, int64(m.LastLogIndex))
+	w.int(4, int64(m.LastLogTerm))
+	w.bool(5, m.Transfer)
+	w.stringMap(6, m.TraceContext)
+	w.string(7, string(m.GroupId))
+	w.string(8, m.AuthToken)
+}
+
+func (w *protoWriter) preVote(m PreVote) {
+	w.int(1, int64(m.Term))
+	w.string(2, string(m.CandidateId))
+	w.int(3, int64(m.LastLogIndex))
+	w.int(4, int64(m.LastLogTerm))
+	w.string(5, string(m.GroupId))
+	w.string(6, m.AuthToken)
+}
+
+// RequestVoteReply 和 PreVoteReply 的字段相同
+func (w *protoWriter) voteReply(term int, granted bool) {
+	w.int(1, int64(term))
+	w.bool(2, granted)
+}
+
+func (w *protoWriter) installSnapshot(m InstallSnapshot) {
+	w.int(1, int64(m.Term))
+	w.string(2, string(m.LeaderId))
+	w.int(3, int64(m.LastIncludedIndex))
+	w.int(4, int64(m.LastIncludedTerm))
+	w.int(5, m.Offset)
+	w.bytes(6, m.Data)
+	w.bool(7, m.Done)
+	w.stringMap(8, m.TraceContext)
+	w.string(9, string(m.GroupId))
+	w.bytes(10, m.Config)
+	w.int(11, int64(m.ConfigIndex))
+	w.int(12, int64(m.Checksum))
+	w.string(13, m.FsmVersion)
+	w.string(14, m.AuthToken)
+}
+
+func (w *protoWriter) installSnapshotReply(m InstallSnapshotReply) {
+	w.int(1, int64(m.Term))
+	w.int(2, m.Offset)
+}
+
+func (w *protoWriter) applyCommand(m ApplyCommand) {
+	w.bytes(1, m.Data)
+	w.string(2, m.ClientId)
+	w.stringMap(3, m.Metadata)
+	w.stringMap(4, m.TraceContext)
+	w.bool(5, m.Forwarded)
+}
+
+func (w *protoWriter) applyCommandReply(m ApplyCommandReply) {
+	w.statusReply(m.Status, &m.Leader)
+	w.bytes(3, m.Result)
+}
+
+func (w *protoWriter) changeConfig(m ChangeConfig) {
+	w.nodeMap(1, m.Peers)
+	w.nodeMap(2, m.NonVoters)
+	w.bool(3, m.NonVoters != nil)
+}
+
+// 只包含 Status 和 Leader 的答复，leader 为 nil 表示消息中没有 Leader 字段
+func (w *protoWriter) statusReply(status Status, leader *Server) {
+	w.int(1, int64(status))
+	if leader != nil && *leader != (Server{}) {
+		w.message(2, func(w *protoWriter) { w.server(*leader) })
+	}
+}
+
+// ==================== 解码 ====================
+
+var errProtoTruncated = errors.New("protobuf 数据不完整")
+
+// 一个字段的值，varint 和定长类型保存在 num 中，长度分隔类型保存在 data 中
+type protoValue struct {
+	wireType int
+	num      uint64
+	data     []byte
+}
+
+func (v protoValue) int() int {
+	return int(int64(v.num))
+}
+
+func (v protoValue) bool() bool {
+	return v.num != 0
+}
+
+func (v protoValue) string() string {
+	return string(v.data)
+}
+
+// 复制一份数据，避免引用解码缓冲区
+func (v protoValue) bytes() []byte {
+	return append([]byte(nil), v.data...)
+}
+
+func (v protoValue) server(s *Server) error {
+	return decodeProto(v.data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			s.Id = NodeId(val.string())
+		case 2:
+			s.Addr = NodeAddr(val.string())
+		}
+		return nil
+	})
+}
+
+// map 的一个键值对
+func (v protoValue) mapEntry() (key, value string, err error) {
+	err = decodeProto(v.data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			key = val.string()
+		case 2:
+			value = val.string()
+		}
+		return nil
+	})
+	return
+}
+
+func (v protoValue) putString(m *map[string]string) error {
+	key, value, err := v.mapEntry()
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+	return nil
+}
+
+func (v protoValue) putTrace(m *TraceContext) error {
+	key, value, err := v.mapEntry()
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(TraceContext)
+	}
+	(*m)[key] = value
+	return nil
+}
+
+func (v protoValue) putNode(m *map[NodeId]NodeAddr) error {
+	key, value, err := v.mapEntry()
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[NodeId]NodeAddr)
+	}
+	(*m)[NodeId(key)] = NodeAddr(value)
+	return nil
+}
+
+// 依次解析 data 中的字段，field 处理各字段的值，未知字段直接忽略
+func decodeProto(data []byte, field func(num int, val protoValue) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		num, wireType := tag>>3, int(tag&7)
+		if num == 0 || num > math.MaxInt32 {
+			return fmt.Errorf("protobuf 字段编号不合法：%d", num)
+		}
+		val := protoValue{wireType: wireType}
+		switch wireType {
+		case wireVarint:
+			val.num, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			val.num, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			val.num, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errProtoTruncated
+			}
+			data = data[n:]
+			val.data, data = data[:length], data[length:]
+		default:
+			return fmt.Errorf("不支持的 protobuf 字段类型：%d", wireType)
+		}
+		if err := field(int(num), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeEntry(data []byte) (entry Entry, err error) {
+	err = decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			entry.Index = val.int()
+		case 2:
+			entry.Term = val.int()
+		case 3:
+			entry.Type = EntryType(val.num)
+		case 4:
+			entry.Data = val.bytes()
+		case 5:
+			entry.Checksum = uint32(val.num)
+		}
+		return nil
+	})
+	return
+}
+
+func (m *AppendEntry) unmarshalProto(data []byte) error {
+	*m = AppendEntry{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.EntryType = EntryType(val.num)
+		case 2:
+			m.Term = val.int()
+		case 3:
+			m.LeaderId = NodeId(val.string())
+		case 4:
+			m.PrevLogIndex = val.int()
+		case 5:
+			m.PrevLogTerm = val.int()
+		case 6:
+			m.LeaderCommit = val.int()
+		case 7:
+			entry, err := decodeEntry(val.data)
+			if err != nil {
+				return err
+			}
+			m.Entries = append(m.Entries, entry)
+		case 8:
+			return val.putTrace(&m.TraceContext)
+		case 9:
+			m.GroupId = GroupId(val.string())
+		case 10:
+			m.Compressed = val.bool()
+		case 11:
+			m.AuthToken = val.string()
+		}
+		return nil
+	})
+}
+
+func (m *AppendEntryReply) unmarshalProto(data []byte) error {
+	*m = AppendEntryReply{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Term = val.int()
+		case 2:
+			m.ConflictTerm = val.int()
+		case 3:
+			m.ConflictStartIndex = val.int()
+		case 4:
+			m.Success = val.bool()
+		case 5:
+			m.Compression = val.bool()
+		case 6:
+			m.Witness = val.bool()
+		}
+		return nil
+	})
+}
+
+func (m *RequestVote) unmarshalProto(data []byte) error {
+	*m = RequestVote{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Term = val.int()
+		case 2:
+			m.CandidateId = NodeId(val.string())
+		case 3:
+			m.LastLogIndex = val.int()
+		case 4:
+			m.LastLogTerm = val.int()
+		case 5:
+			m.Transfer = val.bool()
+		case 6:
+			return val.putTrace(&m.TraceContext)
+		case 7:
+			m.GroupId = GroupId(val.string())
+		case 8:
+			m.AuthToken = val.string()
+		}
+		return nil
+	})
+}
+
+func (m *PreVote) unmarshalProto(data []byte) error {
+	*m = PreVote{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Term = val.int()
+		case 2:
+			m.CandidateId = NodeId(val.string())
+		case 3:
+			m.LastLogIndex = val.int()
+		case 4:
+			m.LastLogTerm = val.int()
+		case 5:
+			m.GroupId = GroupId(val.string())
+		case 6:
+			m.AuthToken = val.string()
+		}
+		return nil
+	})
+}
+
+func decodeVoteReply(data []byte, term *int, granted *bool) error {
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			*term = val.int()
+		case 2:
+			*granted = val.bool()
+		}
+		return nil
+	})
+}
+
+func (m *InstallSnapshot) unmarshalProto(data []byte) error {
+	*m = InstallSnapshot{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Term = val.int()
+		case 2:
+			m.LeaderId = NodeId(val.string())
+		case 3:
+			m.LastIncludedIndex = val.int()
+		case 4:
+			m.LastIncludedTerm = val.int()
+		case 5:
+			m.Offset = int64(val.num)
+		case 6:
+			m.Data = val.bytes()
+		case 7:
+			m.Done = val.bool()
+		case 8:
+			return val.putTrace(&m.TraceContext)
+		case 9:
+			m.GroupId = GroupId(val.string())
+		case 10:
+			m.Config = val.bytes()
+		case 11:
+			m.ConfigIndex = val.int()
+		case 12:
+			m.Checksum = uint32(val.num)
+		case 13:
+			m.FsmVersion = val.string()
+		case 14:
+			m.AuthToken = val.string()
+		}
+		return nil
+	})
+}
+
+func (m *InstallSnapshotReply) unmarshalProto(data []byte) error {
+	*m = InstallSnapshotReply{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Term = val.int()
+		case 2:
+			m.Offset = int64(val.num)
+		}
+		return nil
+	})
+}
+
+func (m *ApplyCommand) unmarshalProto(data []byte) error {
+	*m = ApplyCommand{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Data = val.bytes()
+		case 2:
+			m.ClientId = val.string()
+		case 3:
+			return val.putString(&m.Metadata)
+		case 4:
+			return val.putTrace(&m.TraceContext)
+		case 5:
+			m.Forwarded = val.bool()
+		}
+		return nil
+	})
+}
+
+func (m *ApplyCommandReply) unmarshalProto(data []byte) error {
+	*m = ApplyCommandReply{}
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			m.Status = Status(val.num)
+		case 2:
+			return val.server(&m.Leader)
+		case 3:
+			m.Result = val.bytes()
+		}
+		return nil
+	})
+}
+
+func (m *ChangeConfig) unmarshalProto(data []byte) error {
+	*m = ChangeConfig{}
+	hasNonVoters := false
+	err := decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			return val.putNode(&m.Peers)
+		case 2:
+			return val.putNode(&m.NonVoters)
+		case 3:
+			hasNonVoters = val.bool()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if hasNonVoters && m.NonVoters == nil {
+		m.NonVoters = make(map[NodeId]NodeAddr)
+	}
+	return nil
+}
+
+func decodeStatusReply(data []byte, status *Status, leader *Server) error {
+	return decodeProto(data, func(num int, val protoValue) error {
+		switch num {
+		case 1:
+			*status = Status(val.num)
+		case 2:
+			if leader != nil {
+				return val.server(leader)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package raft
+
+import "sync"
+
+// rpc 请求队列
+// 设置 Config.PeerQueueSize 或 Config.ClientQueueSize 后，其它节点发来的 rpc 请求和客户端请求分别在各自的队列中排队，
+// 由分发协程依次交给主循环，两个队列都有请求时优先交付其它节点的请求，客户端请求的突发不会延误心跳、选举和日志复制
+// 队列已满时请求立即返回 BusyError（errors.Is(err, ErrBusy)），不再阻塞调用方，客户端可稍后重试或改用其它节点
+// 排队上限为 0 的一类请求不排队，调用方等待主循环接收，与未设置时相同
+
+// 请求所在的队列
+const (
+	peerQueue   = "peer"
+	clientQueue = "client"
+)
+
+type rpcQueue struct {
+	peerCh     chan rpc   // 其它节点发来的请求，为 nil 时不排队
+	clientCh   chan rpc   // 客户端请求，为 nil 时不排队
+	out        chan<- rpc // 主循环接收请求的通道
+	peerSize   int
+	clientSize int
+	closed     bool // 节点已关闭，不再接收新的请求
+	mu         sync.Mutex
+}
+
+// 未设置排队上限时返回 nil，请求直接交给主循环
+func newRpcQueue(config Config, out chan<- rpc) *rpcQueue {
+	if config.PeerQueueSize <= 0 && config.ClientQueueSize <= 0 {
+		return nil
+	}
+	q := &rpcQueue{out: out, peerSize: config.PeerQueueSize, clientSize: config.ClientQueueSize}
+	if q.peerSize > 0 {
+		q.peerCh = make(chan rpc, q.peerSize)
+	}
+	if q.clientSize > 0 {
+		q.clientCh = make(chan rpc, q.clientSize)
+	}
+	return q
+}
+
+// 其它节点发来的请求
+func isPeerRpc(rpcType rpcType) bool {
+	switch rpcType {
+	case AppendEntryRpc, RequestVoteRpc, PreVoteRpc, InstallSnapshotRpc:
+		return true
+	}
+	return false
+}
+
+// 将请求放入队列，返回是否已排队，此类请求不排队时返回 false，由调用方直接交给主循环
+// 队列已满时返回 BusyError，节点已关闭时返回 ErrShutdown
+func (q *rpcQueue) push(msg rpc) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	ch, name, size := q.clientCh, clientQueue, q.clientSize
+	if isPeerRpc(msg.rpcType) {
+		ch, name, size = q.peerCh, peerQueue, q.peerSize
+	}
+	if ch == nil {
+		return false, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true, ErrShutdown
+	}
+	select {
+	case ch <- msg:
+		return true, nil
+	default:
+		return true, BusyError{Queue: name, Limit: size}
+	}
+}
+
+// 分发协程，将排队的请求交给主循环，其它节点的请求优先
+// 节点关闭后不再接收新的请求，尚未交给主循环的请求返回 ErrShutdown
+func (q *rpcQueue) run(stopCh <-chan struct{}) {
+	defer q.close()
+	for {
+		var msg rpc
+		select {
+		case msg = <-q.peerCh:
+		default:
+			select {
+			case msg = <-q.peerCh:
+			case msg = <-q.clientCh:
+			case <-stopCh:
+				return
+			}
+		}
+		select {
+		case q.out <- msg:
+		case <-stopCh:
+			msg.res <- rpcReply{err: ErrShutdown}
+			return
+		}
+	}
+}
+
+// 驳回队列中剩余的请求
+func (q *rpcQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	for _, ch := range []chan rpc{q.peerCh, q.clientCh} {
+		for len(ch) > 0 {
+			msg := <-ch
+			msg.res <- rpcReply{err: ErrShutdown}
+		}
+	}
+}
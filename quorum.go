@@ -0,0 +1,95 @@
+package raft
+
+import "sort"
+
+// 法定人数策略，决定哪些投票节点的同意足以赢得选举、通过预投票或提交日志
+// 选举和日志提交使用同一个策略，任意两个满足策略的节点集合必须有交集，否则可能同时出现两个 Leader 或丢失已提交的日志
+// 策略需满足单调性：满足策略的节点集合加入更多节点后仍然满足
+type QuorumPolicy interface {
+	// voters 是当前配置中的全部投票节点，acks 是已同意的节点，包括当前节点，可能包含非投票节点
+	IsQuorum(voters map[NodeId]NodeAddr, acks map[NodeId]bool) bool
+}
+
+// 多数派，超过半数的投票节点同意，默认策略
+type MajorityQuorum struct{}
+
+func (MajorityQuorum) IsQuorum(voters map[NodeId]NodeAddr, acks map[NodeId]bool) bool {
+	return countAcks(voters, acks) >= len(voters)/2+1
+}
+
+// 多数派，且 Required 中仍是投票节点的节点全部同意
+// 可用于要求每次提交都必须到达特定节点（如主数据中心的节点），Required 中的节点故障时集群不可用
+type RequiredQuorum struct {
+	Required []NodeId
+}
+
+func (q RequiredQuorum) IsQuorum(voters map[NodeId]NodeAddr, acks map[NodeId]bool) bool {
+	for _, id := range q.Required {
+		if _, ok := voters[id]; ok && !acks[id] {
+			return false
+		}
+	}
+	return MajorityQuorum{}.IsQuorum(voters, acks)
+}
+
+// 分层多数派，投票节点按 Groups 分组（如按数据中心），超过半数的分组中各有超过半数的节点同意
+// 只统计仍是投票节点的组员，没有投票节点的分组不参与计算，不属于任何分组的投票节点不影响结果
+type GroupQuorum struct {
+	Groups [][]NodeId
+}
+
+func (q GroupQuorum) IsQuorum(voters map[NodeId]NodeAddr, acks map[NodeId]bool) bool {
+	groups, granted := 0, 0
+	for _, group := range q.Groups {
+		members := make(map[NodeId]NodeAddr, len(group))
+		for _, id := range group {
+			if addr, ok := voters[id]; ok {
+				members[id] = addr
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+		groups++
+		if (MajorityQuorum{}).IsQuorum(members, acks) {
+			granted++
+		}
+	}
+	return groups > 0 && granted >= groups/2+1
+}
+
+// 已同意的投票节点数
+func countAcks(voters map[NodeId]NodeAddr, acks map[NodeId]bool) int {
+	count := 0
+	for id := range voters {
+		if acks[id] {
+			count++
+		}
+	}
+	return count
+}
+
+// 满足法定人数的最大值：值不小于返回值的节点构成法定人数，没有节点构成法定人数时返回 false
+// 按值降序依次加入节点，第一次构成法定人数时加入的节点的值即为结果
+func quorumIndex(values map[NodeId]int, isQuorum func(map[NodeId]bool) bool) (int, bool) {
+	ids := make([]NodeId, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return values[ids[i]] > values[ids[j]] })
+	acks := make(map[NodeId]bool, len(ids))
+	for _, id := range ids {
+		acks[id] = true
+		if isQuorum(acks) {
+			return values[id], true
+		}
+	}
+	return 0, false
+}
+
+func quorumOrDefault(policy QuorumPolicy) QuorumPolicy {
+	if policy == nil {
+		return MajorityQuorum{}
+	}
+	return policy
+}
@@ -2,12 +2,11 @@ package raft
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"sort"
 	"sync"
 	"time"
 )
@@ -27,115 +26,542 @@ type finishMsg struct {
 	id      NodeId
 }
 
+// 单条日志应用到状态机的结果
+type applyResult struct {
+	data []byte // 状态机返回的结果
+	err  error  // 状态机返回的错误
+}
+
 // 配置参数
 type Config struct {
-	Fsm                Fsm
-	RaftStatePersister RaftStatePersister
-	SnapshotPersister  SnapshotPersister
-	Transport          Transport
-	Logger             Logger
-	Peers              map[NodeId]NodeAddr
-	Me                 NodeId
-	Role               RoleStage
-	ElectionMinTimeout int
-	ElectionMaxTimeout int
-	HeartbeatTimeout   int
-	MaxLogLength       int
+	Fsm                       Fsm
+	RaftStatePersister        RaftStatePersister
+	SnapshotPersister         SnapshotPersister
+	StreamSnapshotPersister   StreamSnapshotPersister // 流式快照持久化器，设置后 Fsm 需实现 FsmSnapshotter，且不再使用 SnapshotPersister
+	Transport                 Transport
+	Logger                    Logger
+	Authorizer                ProposalAuthorizer // 客户端请求授权检查，为 nil 时不检查
+	Metrics                   MetricsSink        // 指标收集，为 nil 时不收集
+	Tracer                    Tracer             // 链路追踪，为 nil 时不追踪
+	Codec                     Codec              // 配置日志的编解码器，集群中所有节点必须相同，为 nil 时使用 GobCodec
+	KeyProvider               KeyProvider        // 持久化数据加密的主密钥提供者，为 nil 时不加密，不支持 StreamSnapshotPersister
+	Quorum                    QuorumPolicy       // 选举和日志提交的法定人数策略，为 nil 时使用多数派，集群中所有节点必须相同
+	Clock                     Clock              // 计时使用的时钟，为 nil 时使用系统时钟，测试时可使用 MockClock
+	PeerResolver              PeerResolver       // 节点地址解析器，发送 rpc 前解析节点地址，rpc 失败后重新解析，为 nil 时直接使用配置的地址
+	Peers                     map[NodeId]NodeAddr
+	NonVoters                 map[NodeId]NodeAddr // 非投票节点，只接收日志，不参与选举和多数派计算，不会被自动升级
+	AuthToken                 string              // 节点间 rpc 的认证令牌，发送请求时附带，为空时不认证
+	PeerTokens                map[NodeId]string   // 各节点的认证令牌，按请求发送方检查，为 nil 时所有节点使用 AuthToken 作为共享密钥
+	Me                        NodeId
+	Role                      RoleStage
+	ElectionMinTimeout        int
+	ElectionMaxTimeout        int
+	HeartbeatTimeout          int
+	MaxLogLength              int
+	MaxLogBytes               int              // 已提交日志数据总字节数达到此值时生成快照，为 0 时不检查
+	SnapshotInterval          int              // 距离上次快照超过此时间（毫秒）时生成快照，为 0 时不检查
+	CompactionPolicy          CompactionPolicy // 自定义日志压缩策略，设置后忽略 MaxLogLength、MaxLogBytes、SnapshotInterval
+	SnapshotChunkSize         int              // 快照分块发送时每块的最大字节数，为 0 时不分块
+	SnapshotRateLimit         int              // 发送快照的速率限制（字节/秒），为 0 时不限制
+	MaxBatchWait              int              // 客户端请求批处理的最长等待时间（毫秒），为 0 时不进行批处理
+	MaxBatchBytes             int              // 单个批次中客户端请求数据的最大字节数，为 0 时不限制
+	PreVote                   bool             // 发起选举前是否先进行预投票
+	Witness                   bool             // 当前节点是否是见证节点，见证节点参与投票但不保存客户端命令，不会成为 Leader，不需要设置 Fsm
+	PromotionMaxLag           int              // Learner 落后 Leader 不超过此日志条数时，本轮复制视为追赶完成
+	PromotionRounds           int              // Learner 连续追赶完成的轮数达到此值后才能升级为投票节点，为 0 时为 1
+	MaxApplyBacklog           int              // 已提交但尚未应用到状态机的日志批次上限，达到上限时主循环等待状态机，为 0 时为 64
+	LeadershipTransferTimeout int              // 领导权转移的超时时间（毫秒），超时后取消转移并恢复服务，为 0 时为 ElectionMinTimeout
+	MaxTransferQueue          int              // 领导权转移期间暂存的客户端请求数上限，超出时驳回，为 0 时为 1024
+	TransferMaxLag            int              // CanTransferTo 允许目标节点落后 Leader 的最大日志条数，为 0 时为 100
+	RpcTimeout                int              // 发送给其它节点的单个 rpc 请求的超时时间（毫秒），超时后取消请求，为 0 时不限制
+	ForwardApply              bool             // 非 Leader 节点是否将客户端命令转发给 Leader，Transport 需实现 CommandForwarder
+	MaxReplicationLag         int              // Follower 落后 Leader 超过此日志条数时暂停批量复制，改为后台追赶，为 0 时不限制
+	MaxReplicationLagBytes    int              // Follower 未复制的日志数据超过此字节数时暂停批量复制，改为后台追赶，为 0 时不限制
+	AdminAddr                 string           // 内嵌管理接口服务的监听地址，如 :8080、unix:///run/raft-admin.sock，为空时不启动
+	MaxEntrySize              int              // 单条客户端命令数据的最大字节数，超过时返回 EntryTooLargeError，为 0 时不限制，集群中所有节点应相同
+	CompressThreshold         int              // AppendEntries 中日志数据总字节数达到此值时压缩后发送，只对声明支持压缩的节点生效，为 0 时不压缩
+	FsmVersion                string           // 状态机快照格式的版本，记录在快照中，接收的快照版本不同时拒绝安装，为空时不检查
+	ApplyIndexInterval        int              // 持久化 commitIndex 和 lastApplied 的最小间隔（毫秒），为 0 时不持久化，状态机数据也持久化时才需设置
+	SlowDiskThreshold         int              // 保存 RaftState 的耗时超过此值（毫秒）时视为缓慢写入，为 0 时不检测磁盘
+	SlowDiskDuration          int              // 缓慢写入持续此时间（毫秒）后 Leader 主动转移领导权，为 0 时为 10000
+	DiskErrorPolicy           DiskErrorPolicy  // 持久化器返回磁盘错误时的处理策略，默认只将错误返回给调用方
+	StalePeerPolicy           StalePeerPolicy  // 投票节点失联 StalePeerTimeout 后的处理策略，默认保留
+	StalePeerTimeout          int              // 投票节点被判定为不可达且持续此时间（毫秒）未应答时按 StalePeerPolicy 处理，为 0 时不处理
+	PeerQueueSize             int              // 其它节点发来的 rpc 请求的排队上限，队列已满时返回 BusyError，为 0 时不排队
+	ClientQueueSize           int              // 客户端请求的排队上限，队列已满时返回 BusyError，为 0 时不排队
+	ProposalLeaseCheck        bool             // Leader 最近一个最小选举超时时间内未收到法定人数节点的应答时，立即以 ErrLeadershipLost 驳回客户端命令
+	Debug                     bool             // 开启不变量检查，违反时记录错误日志并发出 EventInvariantViolated 事件，使用 raftdev 构建标签时总是开启且违反时 panic
 }
 
 // 客户端状态机接口
 type Fsm interface {
-	// 参数实际上是 Entry 的 Data 字段
-	// 返回值是应用状态机后的结果
-	Apply([]byte) error
+	// 已提交的日志按索引顺序调用，包括配置日志和空日志，状态机可据此记录已应用的索引
+	// 只有 Type 为 EntryReplicate 的日志是客户端命令，其返回值会原样返回给发起请求的客户端
+	Apply(entry AppliedEntry) ([]byte, error)
 
 	// 生成快照二进制数据
 	Serialize() ([]byte, error)
 
-	// 应用快照数据
-	Install([]byte) error
+	// 使用快照数据恢复状态机，参数是 Serialize 生成的快照数据
+	Restore([]byte) error
 }
 
-type raft struct {
-	fsm           Fsm            // 客户端状态机
-	transport     Transport      // 发送请求的接口
-	logger        Logger         // 日志打印
-	roleState     *RoleState     // 当前节点的角色
-	hardState     *HardState     // 需要持久化存储的状态
-	softState     *SoftState     // 保存在内存中的实时状态
-	peerState     *PeerState     // 对等节点状态和路由表
-	leaderState   *LeaderState   // 节点是 Leader 时，保存在内存中的状态
-	timerState    *timerState    // 计时器状态
-	snapshotState *snapshotState // 快照状态
+// 状态机批量应用接口，可选实现
+// 实现后应用协程将连续的普通日志一次交给状态机，状态机可合并加锁和磁盘写入
+type BatchingFsm interface {
+	// 按顺序应用 entries，返回值与 entries 一一对应，为 nil 表示应用成功
+	// 通过此方法应用的日志，客户端收到的 ApplyCommandReply.Result 为空
+	ApplyBatch(entries []Entry) []error
+}
+
+// 状态机自行持久化数据时可选实现，节点重启后从此索引之后开始应用日志
+type FsmAppliedIndexer interface {
+	// 状态机数据已包含的最后一条日志的索引，即最后一次 Apply 的 AppliedEntry.Index
+	LastAppliedIndex() int
+}
+
+// 应用到状态机的日志
+type AppliedEntry struct {
+	Index int       // 日志索引
+	Term  int       // 日志所在任期
+	Type  EntryType // 日志类型
+	Data  []byte    // EntryReplicate 日志为客户端命令，其它类型为 raft 内部数据
+}
+
+// 旧版本的状态机接口，Apply 的参数只有客户端命令
+type LegacyFsm interface {
+	Apply([]byte) ([]byte, error)
+	Serialize() ([]byte, error)
+	Restore([]byte) error
+}
+
+// 将旧版本的状态机适配为 Fsm，只有 EntryReplicate 日志会交给状态机
+// 适配后只实现 Fsm 接口，需要流式快照或批量应用时，状态机应直接实现 Fsm
+func NewLegacyFsm(fsm LegacyFsm) Fsm {
+	return legacyFsm{fsm: fsm}
+}
+
+type legacyFsm struct {
+	fsm LegacyFsm
+}
+
+func (lf legacyFsm) Apply(entry AppliedEntry) ([]byte, error) {
+	if entry.Type != EntryReplicate {
+		return nil, nil
+	}
+	return lf.fsm.Apply(entry.Data)
+}
+
+func (lf legacyFsm) Serialize() ([]byte, error) {
+	return lf.fsm.Serialize()
+}
+
+func (lf legacyFsm) Restore(data []byte) error {
+	return lf.fsm.Restore(data)
+}
+
+// 状态机流式快照接口，使用 StreamSnapshotPersister 时需要实现
+// 快照数据直接写入存储或从存储中读取，不在内存中保存完整快照
+type FsmSnapshotter interface {
+	// 将快照数据写入 sink
+	SerializeTo(sink io.Writer) error
+
+	// 从 source 读取快照数据恢复状态机
+	RestoreFrom(source io.Reader) error
+}
+
+// 状态机时间点快照接口，可选实现
+// 实现后生成快照时只在暂停应用日志期间获取快照句柄，快照数据在恢复应用日志后于后台写入，不阻塞客户端写入
+// 未实现时生成快照期间一直暂停应用日志
+type FsmSnapshotProvider interface {
+	// 返回状态机当前状态的快照句柄，调用期间不会应用日志，应尽快返回，
+	// 如保存写时复制数据结构的当前版本，或加锁后复制出只读副本
+	Snapshot() (FsmSnapshot, error)
+}
+
+// 状态机在某一时刻的快照句柄
+type FsmSnapshot interface {
+	// 将获取句柄时的状态机数据写入 sink，之后应用的日志不能影响写入的数据
+	// 写入的数据交给 Restore 或 RestoreFrom 恢复状态机
+	Persist(sink io.Writer) error
+
+	// 快照写入完成或失败后调用，释放句柄持有的资源
+	Release()
+}
+
+// 客户端请求的元数据
+type ProposalInfo struct {
+	ClientId string            // 发起请求的客户端标识
+	Metadata map[string]string // 请求附带的元数据
+	Size     int               // 请求数据的字节数
+	Data     []byte            // 请求数据
+}
 
-	rpcCh  chan rpc      // 主线程接收 rpc 消息
-	exitCh chan struct{} // 当前节点离开节点，退出程序
+// 客户端请求授权接口，由用户实现
+type ProposalAuthorizer interface {
+	// Leader 将请求添加到日志之前调用
+	// 返回错误时请求被驳回，错误会原样返回给客户端
+	Authorize(ProposalInfo) error
+}
+
+type raft struct {
+	fsm           Fsm                // 客户端状态机
+	transport     ContextTransport   // 发送请求的接口
+	logger        Logger             // 日志打印
+	authorizer    ProposalAuthorizer // 客户端请求授权检查
+	metrics       MetricsSink        // 指标收集
+	tracer        Tracer             // 链路追踪
+	codec         Codec              // 配置日志的编解码器
+	clock         Clock              // 时钟
+	preVote       bool               // 发起选举前是否先进行预投票
+	witness       bool               // 当前节点是否是见证节点
+	leaseCheck    bool               // 接收客户端命令前是否检查 Leader 的租约
+	stalePolicy   StalePeerPolicy    // 失联节点的处理策略
+	staleTimeout  time.Duration      // 投票节点失联此时间后按 stalePolicy 处理
+	transferLag   int                // 领导权转移的目标节点允许落后 Leader 的最大日志条数
+	audit         *auditor           // 不变量检查，为 nil 时不检查
+	roleState     *RoleState         // 当前节点的角色
+	hardState     *HardState         // 需要持久化存储的状态
+	softState     *SoftState         // 保存在内存中的实时状态
+	peerState     *PeerState         // 对等节点状态和路由表
+	leaderState   *LeaderState       // 节点是 Leader 时，保存在内存中的状态
+	timerState    *timerState        // 计时器状态
+	snapshotState *snapshotState     // 快照状态
+	applyState    *applyState        // 日志应用状态
+	rpcCtx        context.Context    // 节点关闭时取消，中止正在发送的 rpc 请求
+	rpcCancel     context.CancelFunc // 取消 rpcCtx
+	rpcTimeout    time.Duration      // 发送给其它节点的单个 rpc 请求的超时时间，为 0 时不限制
+	maxEntrySize  int                // 单条客户端命令数据的最大字节数，为 0 时不限制
+	compressAt    int                // AppendEntries 中日志数据总字节数达到此值时压缩，为 0 时不压缩
+	fsmVersion    string             // 状态机快照格式的版本，为空时不检查
+	auth          *peerAuth          // 节点间 rpc 认证，为 nil 时不认证
+	disk          *diskMonitor       // 磁盘写入监测，为 nil 时不检测
+	diskFault     *diskFault         // 磁盘故障状态
+	maintenance   *maintenanceState  // 运维设置的维护状态
+	forwarder     CommandForwarder   // 将客户端命令转发给 Leader，为 nil 时不转发
+
+	rpcCh         chan rpc       // 主线程接收 rpc 消息
+	exitCh        chan struct{}  // 当前节点离开节点，退出程序
+	shutdownState *shutdownState // 节点关闭状态
+
+	roleObserver   []chan RoleStage  // 节点角色变更观察者
+	leaderObserver []chan LeaderInfo // Leader 变更观察者
+	observers      []*Observer       // 事件观察者
+	obMu           sync.Mutex
+}
 
-	roleObserver []chan RoleStage // 节点角色变更观察者
-	obMu         sync.Mutex
+// 集群 Leader 信息，Id 为空表示当前没有已知的 Leader
+type LeaderInfo struct {
+	Id   NodeId
+	Addr NodeAddr
+	Term int
+	IsMe bool // 当前节点是否是 Leader
 }
 
-func newRaft(config Config) *raft {
-	if config.ElectionMinTimeout > config.ElectionMaxTimeout {
-		panic("ElectionMinTimeout 不能大于 ElectionMaxTimeout！")
+// 创建节点，配置不合法或加载持久化数据失败时返回错误
+func newRaft(config Config) (*raft, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("配置不合法：%w", err)
 	}
+	clock := clockOrDefault(config.Clock)
 	// 加载快照
+	// 见证节点不运行状态机
+	if config.Witness {
+		config.Fsm = witnessFsm{}
+	}
 	var snpshtState snapshotState
 	snpshtPersister := config.SnapshotPersister
-	if snpshtPersister != nil {
-		snapshot, snapshotErr := snpshtPersister.LoadSnapshot()
+	// 设置磁盘错误处理策略时，记录保存快照的结果
+	var diskSnpsht *diskSnapshotPersister
+	if snpshtPersister != nil && config.DiskErrorPolicy != DiskErrorIgnore {
+		diskSnpsht = &diskSnapshotPersister{persister: snpshtPersister}
+		snpshtPersister = diskSnpsht
+	}
+	if snpshtPersister != nil && config.KeyProvider != nil {
+		snpshtPersister = NewEncryptedSnapshotPersister(snpshtPersister, config.KeyProvider)
+	}
+	if streamPersister := config.StreamSnapshotPersister; streamPersister != nil {
+		meta, source, snapshotErr := streamPersister.OpenSnapshot()
 		if snapshotErr != nil {
-			log.Fatalln(fmt.Errorf("加载快照失败：%w", snapshotErr))
+			return nil, fmt.Errorf("加载快照失败：%w", snapshotErr)
+		}
+		if source != nil {
+			_ = source.Close()
 		}
 		snpshtState = snapshotState{
-			snapshot:     &snapshot,
-			persister:    snpshtPersister,
-			maxLogLength: config.MaxLogLength,
+			snapshot:  &Snapshot{LastIndex: meta.LastIndex, LastTerm: meta.LastTerm},
+			size:      meta.Size,
+			stream:    streamPersister,
+			policy:    newCompactionPolicy(config),
+			createdAt: clock.Now(),
+			clock:     clock,
+			chunkSize: config.SnapshotChunkSize,
+			limiter:   newRateLimiter(config.SnapshotRateLimit),
 		}
 	} else {
-		log.Fatalln("缺失 SnapshotPersister!")
+		snapshot, snapshotErr := snpshtPersister.LoadSnapshot()
+		if snapshotErr == nil {
+			snapshotErr = verifySnapshot(snapshot)
+		}
+		if snapshotErr != nil {
+			return nil, fmt.Errorf("加载快照失败：%w", snapshotErr)
+		}
+		snpshtState = snapshotState{
+			snapshot:  &snapshot,
+			size:      int64(len(snapshot.Data)),
+			persister: snpshtPersister,
+			policy:    newCompactionPolicy(config),
+			createdAt: clock.Now(),
+			clock:     clock,
+			chunkSize: config.SnapshotChunkSize,
+			limiter:   newRateLimiter(config.SnapshotRateLimit),
+		}
+	}
+
+	// 设置指标收集、磁盘检测或磁盘错误处理策略时，记录保存 RaftState 的耗时和结果
+	var metrics MetricsSink = nopMetrics{}
+	if config.Metrics != nil {
+		metrics = config.Metrics
 	}
+	disk := newDiskMonitor(config)
+	var diskPst *diskRaftStatePersister
 
 	// 加载 hardState
 	raftPst := config.RaftStatePersister
-	var raftState RaftState
-	if raftPst != nil {
-		rfState, raftStateErr := raftPst.LoadRaftState()
-		if raftStateErr != nil {
-			panic(fmt.Sprintf("持久化器加载 RaftState 失败：%s\n", raftStateErr))
-		} else {
-			raftState = rfState
-		}
-	} else {
-		log.Fatalln("缺失 RaftStatePersister!")
+	if config.Metrics != nil || disk != nil || config.DiskErrorPolicy != DiskErrorIgnore {
+		diskPst = &diskRaftStatePersister{persister: raftPst, metrics: metrics, monitor: disk}
+		raftPst = diskPst
+	}
+	if config.KeyProvider != nil {
+		raftPst = NewEncryptedRaftStatePersister(raftPst, config.KeyProvider)
+	}
+	raftState, raftStateErr := raftPst.LoadRaftState()
+	if raftStateErr == nil {
+		raftStateErr = verifyEntries(raftState.Entries)
+	}
+	if raftStateErr != nil {
+		return nil, fmt.Errorf("持久化器加载 RaftState 失败：%w", raftStateErr)
 	}
 	hardState := raftState.toHardState(raftPst)
 
+	// 持久化的日志头早于压缩标记时（旧版本分两次写入标记和日志），根据压缩标记完成压缩
+	if compactErr := hardState.recoverCompaction(snpshtState.snapshot.LastIndex, snpshtState.snapshot.LastTerm); compactErr != nil {
+		return nil, fmt.Errorf("恢复日志压缩失败：%w", compactErr)
+	}
+
 	// 如果是初次加载
 	if snpshtState.snapshot.LastIndex <= 0 && len(hardState.entries) <= 0 {
 		hardState.entries = make([]Entry, 1)
 	}
 
-	return &raft{
+	// 快照中的配置比持久化的配置新时使用快照中的配置，如只安装了快照、尚未保存 RaftState 时节点重启
+	if snapshot := snpshtState.snapshot; len(snapshot.Config) > 0 && snapshot.ConfigIndex > hardState.configIndex {
+		hardState.config, hardState.configIndex = snapshot.Config, snapshot.ConfigIndex
+	}
+
+	// 恢复集群配置，没有持久化的配置时使用 Config.Peers 启动
+	codec := codecOrDefault(config.Codec)
+	peerState := newPeerState(config.Peers, config.NonVoters, config.Me, codec)
+	peerState.quorum = quorumOrDefault(config.Quorum)
+	role := config.Role
+	if len(hardState.config) > 0 {
+		if restoreErr := peerState.restoreConfig(hardState.configIndex, hardState.config); restoreErr != nil {
+			return nil, fmt.Errorf("恢复集群配置失败：%w", restoreErr)
+		}
+		// 重启前已成为投票节点的 Learner 以 Follower 角色启动
+		if _, ok := peerState.peers()[config.Me]; ok && role == Learner {
+			role = Follower
+		}
+	}
+	// 已提交配置之后的配置日志在添加时已生效
+	for _, entry := range hardState.entries {
+		if entry.Type == EntryChangeConf && entry.Index > hardState.configIndex {
+			if setErr := peerState.setConfigWithBytes(entry.Index, entry.Data); setErr != nil {
+				return nil, fmt.Errorf("恢复集群配置失败：%w", setErr)
+			}
+		}
+	}
+
+	// 非投票节点始终以 Learner 角色运行
+	if _, ok := peerState.nonVoters()[config.Me]; ok {
+		role = Learner
+	}
+
+	// 设置认证令牌时，发送的请求附带令牌
+	transport := withContext(config.Transport)
+	// 设置地址解析器时，发送请求前解析节点地址
+	resolver := newPeerResolver(config)
+	if resolver != nil {
+		transport = &resolvingTransport{transport: transport, resolver: resolver}
+	}
+	if config.AuthToken != "" {
+		transport = &authTransport{transport: transport, token: config.AuthToken}
+	}
+
+	// 设置指标收集时，记录各 rpc 的耗时
+	if config.Metrics != nil {
+		transport = &metricsTransport{transport: transport, metrics: metrics}
+	}
+
+	var tracer Tracer = nopTracer{}
+	if config.Tracer != nil {
+		tracer = config.Tracer
+	}
+
+	// 设置 ForwardApply 且 Transport 支持时，非 Leader 节点转发客户端命令
+	var forwarder CommandForwarder
+	if f, ok := config.Transport.(CommandForwarder); ok && config.ForwardApply {
+		forwarder = f
+		if resolver != nil {
+			forwarder = &resolvingForwarder{forwarder: f, resolver: resolver}
+		}
+	}
+
+	transferLag := config.TransferMaxLag
+	if transferLag == 0 {
+		transferLag = defaultTransferMaxLag
+	}
+
+	rpcCtx, rpcCancel := context.WithCancel(context.Background())
+	rf := &raft{
 		fsm:           config.Fsm,
-		transport:     config.Transport,
+		transport:     transport,
+		metrics:       metrics,
+		tracer:        tracer,
+		codec:         codec,
+		clock:         clock,
 		logger:        config.Logger,
-		roleState:     newRoleState(config.Role),
+		authorizer:    config.Authorizer,
+		preVote:       config.PreVote && supportsPreVote(config.Transport),
+		witness:       config.Witness,
+		leaseCheck:    config.ProposalLeaseCheck,
+		stalePolicy:   config.StalePeerPolicy,
+		staleTimeout:  time.Millisecond * time.Duration(config.StalePeerTimeout),
+		transferLag:   transferLag,
+		roleState:     newRoleState(role),
 		hardState:     &hardState,
 		softState:     newSoftState(),
-		peerState:     newPeerState(config.Peers, config.Me),
-		leaderState:   newLeaderState(),
-		timerState:    newTimerState(config),
+		peerState:     peerState,
+		leaderState:   newLeaderState(config, clock),
+		timerState:    newTimerState(config, clock),
 		snapshotState: &snpshtState,
+		applyState:    newApplyState(config),
+		rpcCtx:        rpcCtx,
+		rpcCancel:     rpcCancel,
+		rpcTimeout:    time.Millisecond * time.Duration(config.RpcTimeout),
+		maxEntrySize:  config.MaxEntrySize,
+		compressAt:    config.CompressThreshold,
+		fsmVersion:    config.FsmVersion,
+		auth:          newPeerAuth(config),
+		disk:          disk,
+		diskFault:     &diskFault{policy: config.DiskErrorPolicy},
+		maintenance:   &maintenanceState{},
+		forwarder:     forwarder,
 		rpcCh:         make(chan rpc),
-		exitCh:        make(chan struct{}),
+		exitCh:        make(chan struct{}, 1),
+		shutdownState: newShutdownState(),
+	}
+	if diskPst != nil {
+		diskPst.onSave = rf.onPersist
+	}
+	if diskSnpsht != nil {
+		diskSnpsht.onSave = rf.onPersist
+	}
+	audit := newAuditor(config, rf.reportViolation)
+	rf.audit = audit
+	rf.softState.audit = audit
+	rf.peerState.audit = audit
+	rf.leaderState.audit = audit
+	hardState.onTermChange = func(term int) {
+		audit.checkTerm(term)
+		rf.metrics.SetGauge(MetricTerm, float64(term))
+		rf.notifyObservers(Event{Type: EventTermChange, Term: term})
+	}
+	if config.PreVote && !rf.preVote {
+		rf.logger.Warn("Transport 没有实现 PreVoteTransport，不进行预投票")
+	}
+	if restoreErr := rf.restoreApplyIndex(); restoreErr != nil {
+		return nil, restoreErr
+	}
+	return rf, nil
+}
+
+// 恢复重启前已应用到状态机的日志索引，避免重新应用全部日志
+// 状态机实现了 FsmAppliedIndexer 时以状态机为准，否则使用定期持久化的索引
+// 状态机未包含快照中的数据时使用快照恢复，已应用索引不低于快照的索引
+func (rf *raft) restoreApplyIndex() error {
+	commitIndex, lastApplied := rf.hardState.applyIndex()
+	if rf.applyState.persistInterval <= 0 {
+		commitIndex, lastApplied = 0, 0
+	}
+	if indexer, ok := rf.fsm.(FsmAppliedIndexer); ok {
+		lastApplied = indexer.LastAppliedIndex()
+	}
+	if lastEntryIndex := rf.lastEntryIndex(); lastApplied > lastEntryIndex {
+		rf.logger.Warn(fmt.Sprintf("已应用索引 %d 超过最后一条日志的索引 %d，使用日志索引", lastApplied, lastEntryIndex))
+		lastApplied = lastEntryIndex
+	}
+	if snapshotIndex := rf.snapshotState.lastIndex(); snapshotIndex > lastApplied {
+		if err := rf.restoreFsm(); err != nil {
+			return fmt.Errorf("使用快照恢复状态机失败：%w", err)
+		}
+		rf.logger.Trace(fmt.Sprintf("使用快照恢复状态机成功！lastApplied=%d", snapshotIndex))
+		lastApplied = snapshotIndex
+	}
+	if lastApplied <= 0 {
+		return nil
+	}
+	// 已应用的日志一定已提交
+	if commitIndex < lastApplied {
+		commitIndex = lastApplied
+	}
+	rf.softState.setCommitIndex(commitIndex)
+	rf.softState.setLastApplied(lastApplied)
+	rf.applyState.setQueued(lastApplied)
+	rf.logger.Trace(fmt.Sprintf("恢复已应用索引，commitIndex=%d，lastApplied=%d", commitIndex, lastApplied))
+	return nil
+}
+
+// 将初始集群配置作为第一条日志写入，只能在空白节点上调用一次
+func (rf *raft) bootstrap(peers map[NodeId]NodeAddr) error {
+	if snapshot := rf.snapshotState.getSnapshot(); rf.lastEntryIndex() > 0 || rf.hardState.currentTerm() > 0 ||
+		(snapshot != nil && snapshot.LastIndex > 0) {
+		return ErrAlreadyBootstrapped
+	}
+	if _, ok := peers[rf.peerState.myId()]; !ok {
+		return fmt.Errorf("初始配置中不包含当前节点 %s", rf.peerState.myId())
+	}
+	data, encodeErr := encodeMembership(rf.codec, peers, nil)
+	if encodeErr != nil {
+		return fmt.Errorf("初始配置序列化失败！%w", encodeErr)
+	}
+	if termErr := rf.hardState.setTerm(1); termErr != nil {
+		return termErr
+	}
+	if addEntryErr := rf.addEntry(Entry{Term: 1, Type: EntryChangeConf, Data: data}); addEntryErr != nil {
+		return fmt.Errorf("将初始配置添加到日志失败！%w", addEntryErr)
+	}
+	rf.peerState.setConfig(rf.lastEntryIndex(), peers, nil)
+	rf.onMembershipChange()
+	if rf.roleState.getRoleStage() == Learner {
+		rf.roleState.setRoleStage(Follower)
 	}
+	return nil
 }
 
 func (rf *raft) raftRun(rpcCh chan rpc) {
 	rf.rpcCh = rpcCh
+	rf.goFunc(rf.runApplier)
 	go func() {
+		defer close(rf.shutdownState.doneCh)
 		for {
+			select {
+			case <-rf.shutdownState.stopCh:
+				rf.logger.Trace("接收到节点关闭信号，退出 raft 循环")
+				rf.timerState.stopTimer()
+				return
+			default:
+			}
 			switch rf.roleState.getRoleStage() {
 			case Leader:
 				rf.logger.Trace("开启runLeader()循环")
@@ -154,7 +580,11 @@ func (rf *raft) raftRun(rpcCh chan rpc) {
 	}()
 
 	go func() {
-		<-rf.exitCh
+		select {
+		case <-rf.exitCh:
+		case <-rf.shutdownState.stopCh:
+			return
+		}
 		rf.logger.Trace("接收到程序退出信号")
 		rf.timerState.stopTimer()
 		os.Exit(0)
@@ -167,25 +597,44 @@ func (rf *raft) runLeader() {
 	rf.timerState.setHeartbeatTimer()
 	rf.logger.Trace("初始化心跳定时器成功")
 
-	// 开启日志复制循环
-	rf.runReplication()
-	rf.logger.Trace("已开启全部节点日志复制循环")
+	// 提交一条当前任期的空日志，确立提交进度
+	rf.appendNoop()
 
 	// 节点退出 Leader 状态，收尾工作
 	defer func() {
+		rf.rejectClientCmds()
+		rf.endTransfer(TransferLeadershipReply{}, NotLeaderError{Leader: rf.peerState.getLeader()})
+		// 先取出全部 Replication 再逐个停止，replications 由下一任期的 resetReplications 清空
+		replications := make([]*Replication, 0, len(rf.leaderState.replications))
 		for _, st := range rf.leaderState.replications {
-			close(st.stopCh)
+			replications = append(replications, st)
+		}
+		for _, st := range replications {
+			st.stop()
 		}
 		rf.logger.Trace("退出 runLeader()，关闭各个 replication 的 stopCh")
+		// 等待本任期的复制循环全部退出，下一次成为 Leader 时不会与之共用 Replication
+		rf.leaderState.workers.Wait()
+		rf.logger.Trace("各个 replication 的协程已退出")
 	}()
 
 	for rf.roleState.getRoleStage() == Leader {
 		select {
+		case <-rf.shutdownState.stopCh:
+			return
 		case msg := <-rf.rpcCh:
-			if transfereeId, busy := rf.leaderState.isTransferBusy(); busy {
-				// 如果正在进行领导权转移
-				rf.logger.Trace("节点正在进行领导权转移，请求驳回！")
-				msg.res <- rpcReply{err: fmt.Errorf("正在进行领导权转移，请求驳回！")}
+			if msg.rpcType == AbortTransferRpc {
+				// 领导权转移期间也可以取消转移
+				rf.logger.Trace("接收到 AbortTransferRpc 请求")
+				rf.handleTransferAbort(msg)
+			} else if transfereeId, busy := rf.leaderState.isTransferBusy(); busy {
+				// 如果正在进行领导权转移，客户端命令暂存到转移结束，其它请求驳回
+				if msg.rpcType == ApplyCommandRpc && rf.leaderState.queueDuringTransfer(msg) {
+					rf.logger.Trace("节点正在进行领导权转移，暂存客户端请求")
+				} else {
+					rf.logger.Trace("节点正在进行领导权转移，请求驳回！")
+					msg.res <- rpcReply{err: ErrLeadershipTransferInProgress}
+				}
 				rf.checkTransfer(transfereeId)
 			} else {
 				switch msg.rpcType {
@@ -195,6 +644,9 @@ func (rf *raft) runLeader() {
 				case RequestVoteRpc:
 					rf.logger.Trace("接收到 RequestVoteRpc 请求")
 					rf.handleVoteReq(msg)
+				case PreVoteRpc:
+					rf.logger.Trace("接收到 PreVoteRpc 请求")
+					rf.handlePreVote(msg)
 				case ApplyCommandRpc:
 					rf.logger.Trace("接收到 ApplyCommandRpc 请求")
 					rf.handleClientCmd(msg)
@@ -207,47 +659,52 @@ func (rf *raft) runLeader() {
 				case AddLearnerRpc:
 					rf.logger.Trace("接收到 AddLearnerRpc 请求")
 					rf.handleLearnerAdd(msg)
+				case AddVoterRpc:
+					rf.logger.Trace("接收到 AddVoterRpc 请求")
+					rf.handleVoterAdd(msg)
+				case RemoveServerRpc:
+					rf.logger.Trace("接收到 RemoveServerRpc 请求")
+					rf.handleServerRemove(msg)
+				case UpdatePeerAddressRpc:
+					rf.logger.Trace("接收到 UpdatePeerAddressRpc 请求")
+					rf.handlePeerAddressUpdate(msg)
+				case AddNonVoterRpc:
+					rf.logger.Trace("接收到 AddNonVoterRpc 请求")
+					rf.handleNonVoterAdd(msg)
+				case CatchUpProgressRpc:
+					rf.logger.Trace("接收到 CatchUpProgressRpc 请求")
+					rf.handleCatchUpProgress(msg)
+				case SnapshotRpc:
+					rf.logger.Trace("接收到 SnapshotRpc 请求")
+					rf.handleSnapshotCreate(msg)
+				case StatusRpc:
+					rf.logger.Trace("接收到 StatusRpc 请求")
+					rf.handleStatus(msg)
+				case BarrierRpc:
+					rf.logger.Trace("接收到 BarrierRpc 请求")
+					rf.handleBarrier(msg)
 				}
 			}
 		case <-rf.timerState.tick():
-			rf.logger.Trace("心跳计时器到期，开始发送心跳")
-			stopCh := make(chan struct{})
-			finishCh := rf.heartbeat(stopCh)
-			successCnt := 0
-			count := 0
-			end := false
-			after := time.After(rf.timerState.heartbeatDuration())
-			for !end {
-				select {
-				case <-after:
-					rf.logger.Trace("操作超时退出")
-					end = true
-				case msg := <-finishCh:
-					if msg.msgType == Degrade && rf.becomeFollower(msg.term) {
-						rf.logger.Trace("降级为 Follower")
-						end = true
-						break
-					}
-					nodeId := msg.id
-					if msg.msgType == Success {
-						rf.logger.Trace(fmt.Sprintf("获取到 id=%s 的心跳结果：Success", nodeId))
-						successCnt += 1
-					}
-					if successCnt >= rf.peerState.majority() {
-						rf.logger.Trace("心跳已成功发送给多数节点")
-						end = true
-						break
-					}
-					count += 1
-					if count >= rf.peerState.peersCnt() {
-						rf.logger.Trace("已接收所有响应，成功节点数未达到多数")
-						end = true
-						break
-					}
-				}
+			// 磁盘故障时退位，不再发送心跳
+			if rf.checkDiskFault() {
+				continue
 			}
-			close(stopCh)
+			rf.logger.Trace("心跳计时器到期，开始发送心跳")
+			rf.heartbeat()
+			// 日志追赶协程推进的 commitIndex 在此应用到状态机
+			rf.applyCommitted(nil)
+			rf.checkSlowDisk()
+			rf.checkStalePeers()
+		case <-rf.leaderState.transferTimer():
+			rf.logger.Trace("领导权转移超时")
+			rf.endTransfer(TransferLeadershipReply{}, fmt.Errorf("领导权转移未完成：%w", ErrTimeout))
+		case <-rf.leaderState.batchTimer():
+			rf.logger.Trace("批处理等待超时，开始处理客户端请求")
+			rf.flushClientCmds()
 		case id := <-rf.leaderState.done:
+			rf.updateLeaderCommit()
+			rf.logger.Trace(fmt.Sprintf("commitIndex 更新为 %d", rf.softState.getCommitIndex()))
 			if transfereeId, busy := rf.leaderState.isTransferBusy(); busy && transfereeId == id {
 				rf.logger.Trace("领导权转移的目标节点日志复制结束，开始领导权转移")
 				rf.checkTransfer(transfereeId)
@@ -274,9 +731,11 @@ func (rf *raft) runCandidate() {
 	rf.logger.Trace("开始选举")
 	finishCh := rf.election(stopCh)
 
-	successCnt := 0
+	acks := make(map[NodeId]bool)
 	for rf.roleState.getRoleStage() == Candidate {
 		select {
+		case <-rf.shutdownState.stopCh:
+			return
 		case <-rf.timerState.tick():
 			// 开启下一轮选举
 			rf.logger.Trace("选举计时器到期，开启新一轮选举")
@@ -284,18 +743,17 @@ func (rf *raft) runCandidate() {
 		case msg := <-rf.rpcCh:
 			switch msg.rpcType {
 			case ApplyCommandRpc:
-				rf.logger.Trace("当前节点不是 Leader，ApplyCommandRpc 请求驳回")
-				replyRes := ApplyCommandReply{
-					Status: NotLeader,
-					Leader: rf.peerState.getLeader(),
-				}
-				msg.res <- rpcReply{res: replyRes}
+				rf.logger.Trace("当前节点不是 Leader，接收到 ApplyCommandRpc 请求")
+				rf.forwardClientCmd(msg)
 			case AppendEntryRpc:
 				rf.logger.Trace("接收到 AppendEntryRpc 请求")
 				rf.handleCommand(msg)
 			case RequestVoteRpc:
 				rf.logger.Trace("接收到 RequestVoteRpc 请求")
 				rf.handleVoteReq(msg)
+			case PreVoteRpc:
+				rf.logger.Trace("接收到 PreVoteRpc 请求")
+				rf.handlePreVote(msg)
 			case InstallSnapshotRpc:
 				rf.logger.Trace("接收到 RequestVoteRpc 请求")
 				rf.handleSnapshot(msg)
@@ -313,6 +771,56 @@ func (rf *raft) runCandidate() {
 					Leader: rf.peerState.getLeader(),
 				}
 				msg.res <- rpcReply{res: replyRes}
+			case AddVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddVoterRpc 请求驳回")
+				replyRes := AddVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case RemoveServerRpc:
+				rf.logger.Trace("当前节点不是 Leader，RemoveServerRpc 请求驳回")
+				replyRes := RemoveServerReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case UpdatePeerAddressRpc:
+				rf.logger.Trace("当前节点不是 Leader，UpdatePeerAddressRpc 请求驳回")
+				replyRes := UpdatePeerAddressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case AddNonVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddNonVoterRpc 请求驳回")
+				replyRes := AddNonVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case CatchUpProgressRpc:
+				rf.logger.Trace("当前节点不是 Leader，CatchUpProgressRpc 请求驳回")
+				replyRes := CatchUpProgressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case SnapshotRpc:
+				rf.logger.Trace("接收到 SnapshotRpc 请求")
+				rf.handleSnapshotCreate(msg)
+			case StatusRpc:
+				rf.logger.Trace("接收到 StatusRpc 请求")
+				rf.handleStatus(msg)
+			case BarrierRpc:
+				rf.logger.Trace("当前节点不是 Leader，BarrierRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case AbortTransferRpc:
+				rf.logger.Trace("当前节点不是 Leader，AbortTransferRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case TransferLeadershipRpc:
+				rf.logger.Trace("当前节点不是 Leader，TransferLeadershipRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
 			}
 		case msg := <-finishCh:
 			// 降级
@@ -324,10 +832,10 @@ func (rf *raft) runCandidate() {
 				return
 			}
 			if msg.msgType == Success {
-				successCnt += 1
+				acks[msg.id] = true
 			}
 			// 升级
-			if successCnt >= rf.peerState.majority() {
+			if rf.peerState.isQuorum(acks) {
 				rf.logger.Trace("获取到多数节点投票")
 				if rf.becomeLeader() {
 					rf.logger.Trace("升级为 Leader")
@@ -344,25 +852,28 @@ func (rf *raft) runFollower() {
 	rf.logger.Trace("初始化选举计时器成功")
 	for rf.roleState.getRoleStage() == Follower {
 		select {
+		case <-rf.shutdownState.stopCh:
+			return
 		case <-rf.timerState.tick():
 			// 成为候选者
 			rf.logger.Trace("选举计时器到期，开启新一轮选举")
-			rf.becomeCandidate()
+			if !rf.becomeCandidate() {
+				rf.timerState.setElectionTimer()
+			}
 		case msg := <-rf.rpcCh:
 			switch msg.rpcType {
 			case ApplyCommandRpc:
-				rf.logger.Trace("当前节点不是 Leader，ApplyCommandRpc 请求驳回")
-				replyRes := ApplyCommandReply{
-					Status: NotLeader,
-					Leader: rf.peerState.getLeader(),
-				}
-				msg.res <- rpcReply{res: replyRes}
+				rf.logger.Trace("当前节点不是 Leader，接收到 ApplyCommandRpc 请求")
+				rf.forwardClientCmd(msg)
 			case AppendEntryRpc:
 				rf.logger.Trace("接收到 AppendEntryRpc 请求")
 				rf.handleCommand(msg)
 			case RequestVoteRpc:
 				rf.logger.Trace("接收到 RequestVoteRpc 请求")
 				rf.handleVoteReq(msg)
+			case PreVoteRpc:
+				rf.logger.Trace("接收到 PreVoteRpc 请求")
+				rf.handlePreVote(msg)
 			case InstallSnapshotRpc:
 				rf.logger.Trace("接收到 InstallSnapshotRpc 请求")
 				rf.handleSnapshot(msg)
@@ -380,6 +891,56 @@ func (rf *raft) runFollower() {
 					Leader: rf.peerState.getLeader(),
 				}
 				msg.res <- rpcReply{res: replyRes}
+			case AddVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddVoterRpc 请求驳回")
+				replyRes := AddVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case RemoveServerRpc:
+				rf.logger.Trace("当前节点不是 Leader，RemoveServerRpc 请求驳回")
+				replyRes := RemoveServerReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case UpdatePeerAddressRpc:
+				rf.logger.Trace("当前节点不是 Leader，UpdatePeerAddressRpc 请求驳回")
+				replyRes := UpdatePeerAddressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case AddNonVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddNonVoterRpc 请求驳回")
+				replyRes := AddNonVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case CatchUpProgressRpc:
+				rf.logger.Trace("当前节点不是 Leader，CatchUpProgressRpc 请求驳回")
+				replyRes := CatchUpProgressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case SnapshotRpc:
+				rf.logger.Trace("接收到 SnapshotRpc 请求")
+				rf.handleSnapshotCreate(msg)
+			case StatusRpc:
+				rf.logger.Trace("接收到 StatusRpc 请求")
+				rf.handleStatus(msg)
+			case BarrierRpc:
+				rf.logger.Trace("当前节点不是 Leader，BarrierRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case AbortTransferRpc:
+				rf.logger.Trace("当前节点不是 Leader，AbortTransferRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case TransferLeadershipRpc:
+				rf.logger.Trace("当前节点不是 Leader，TransferLeadershipRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
 			}
 		}
 	}
@@ -388,11 +949,90 @@ func (rf *raft) runFollower() {
 func (rf *raft) runLearner() {
 	for rf.roleState.getRoleStage() == Learner {
 		select {
+		case <-rf.shutdownState.stopCh:
+			return
 		case msg := <-rf.rpcCh:
 			switch msg.rpcType {
+			case ApplyCommandRpc:
+				rf.logger.Trace("当前节点不是 Leader，接收到 ApplyCommandRpc 请求")
+				rf.forwardClientCmd(msg)
 			case AppendEntryRpc:
 				rf.logger.Trace("接收到 AppendEntryRpc 请求")
 				rf.handleCommand(msg)
+			case RequestVoteRpc:
+				// Learner 不参与投票
+				rf.logger.Trace("当前节点是 Learner，RequestVoteRpc 请求驳回")
+				msg.res <- rpcReply{res: RequestVoteReply{Term: rf.hardState.currentTerm()}}
+			case PreVoteRpc:
+				rf.logger.Trace("接收到 PreVoteRpc 请求")
+				rf.handlePreVote(msg)
+			case InstallSnapshotRpc:
+				rf.logger.Trace("接收到 InstallSnapshotRpc 请求")
+				rf.handleSnapshot(msg)
+			case ChangeConfigRpc:
+				rf.logger.Trace("当前节点不是 Leader，ChangeConfigRpc 请求驳回")
+				replyRes := ChangeConfigReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case AddLearnerRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddLearnerRpc 请求驳回")
+				replyRes := AddLearnerReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case AddVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddVoterRpc 请求驳回")
+				replyRes := AddVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case RemoveServerRpc:
+				rf.logger.Trace("当前节点不是 Leader，RemoveServerRpc 请求驳回")
+				replyRes := RemoveServerReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case UpdatePeerAddressRpc:
+				rf.logger.Trace("当前节点不是 Leader，UpdatePeerAddressRpc 请求驳回")
+				replyRes := UpdatePeerAddressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case AddNonVoterRpc:
+				rf.logger.Trace("当前节点不是 Leader，AddNonVoterRpc 请求驳回")
+				replyRes := AddNonVoterReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case CatchUpProgressRpc:
+				rf.logger.Trace("当前节点不是 Leader，CatchUpProgressRpc 请求驳回")
+				replyRes := CatchUpProgressReply{
+					Status: NotLeader,
+					Leader: rf.peerState.getLeader(),
+				}
+				msg.res <- rpcReply{res: replyRes}
+			case SnapshotRpc:
+				rf.logger.Trace("接收到 SnapshotRpc 请求")
+				rf.handleSnapshotCreate(msg)
+			case StatusRpc:
+				rf.logger.Trace("接收到 StatusRpc 请求")
+				rf.handleStatus(msg)
+			case BarrierRpc:
+				rf.logger.Trace("当前节点不是 Leader，BarrierRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case AbortTransferRpc:
+				rf.logger.Trace("当前节点不是 Leader，AbortTransferRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
+			case TransferLeadershipRpc:
+				rf.logger.Trace("当前节点不是 Leader，TransferLeadershipRpc 请求驳回")
+				msg.res <- rpcReply{err: NotLeaderError{Leader: rf.peerState.getLeader()}}
 			}
 		}
 	}
@@ -400,46 +1040,111 @@ func (rf *raft) runLearner() {
 
 // ==================== logic process ====================
 
-func (rf *raft) heartbeat(stopCh chan struct{}) chan finishMsg {
+// 通知投票节点和非投票节点的心跳循环发送心跳，不等待结果
+// 上一次心跳还未返回的节点跳过本轮，结果由各节点的心跳循环异步汇总
+func (rf *raft) heartbeat() {
 
 	// 重置心跳计时器
 	rf.timerState.setHeartbeatTimer()
 	rf.logger.Trace("重置心跳计时器成功")
 
-	finishCh := make(chan finishMsg)
+	rf.triggerLearners()
 
-	for id, addr := range rf.peerState.peers() {
-		if rf.peerState.isMe(id) {
-			rf.logger.Trace(fmt.Sprintf("自身节点，不发送心跳。Id=%s", id))
-			go func() { finishCh <- finishMsg{msgType: Success, id: id} }()
+	nonVoters := rf.peerState.nonVoters()
+	for id, replication := range rf.leaderState.getReplications() {
+		if _, ok := nonVoters[id]; !ok && rf.leaderState.getFollowerRole(id) == Learner {
 			continue
 		}
-		if rf.leaderState.isRpcBusy(id) {
-			rf.logger.Trace(fmt.Sprintf("忙节点，不发送心跳。Id=%s", id))
-			go func() { finishCh <- finishMsg{msgType: Error} }()
-			continue
+		select {
+		case replication.heartbeatCh <- struct{}{}:
+		default:
+			rf.logger.Trace(fmt.Sprintf("上一次心跳未结束，跳过本轮。Id=%s", id))
 		}
-		rf.logger.Trace(fmt.Sprintf("给 Id=%s 的节点发送心跳", id))
-		go rf.replicationTo(id, addr, finishCh, stopCh, EntryHeartbeat)
 	}
+}
 
-	return finishCh
+// 节点的心跳循环，由 Leader 的心跳计时器统一触发
+// 每个节点同一时间最多有一个心跳请求，慢节点不会阻塞 Leader 主循环，也不会堆积心跳协程
+func (rf *raft) heartbeatLoop(r *Replication) {
+	finishCh := make(chan finishMsg, 1)
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-r.heartbeatCh:
+		}
+		if r.isRpcBusy() {
+			rf.logger.Trace(fmt.Sprintf("忙节点，不发送心跳。Id=%s", r.id))
+			continue
+		}
+		if r.inBackoff(rf.clock.Now()) {
+			rf.logger.Trace(fmt.Sprintf("节点不可达，等待下一次探测。Id=%s", r.id))
+			continue
+		}
+		rf.logger.Trace(fmt.Sprintf("给 Id=%s 的节点发送心跳", r.id))
+		rf.replicationTo(nil, r, r.peerAddr(), finishCh, r.stopCh, EntryHeartbeat)
+		select {
+		case <-r.stopCh:
+			return
+		case msg := <-finishCh:
+			if msg.msgType != Degrade {
+				continue
+			}
+			// 通知 Leader 主循环降级
+			rf.logger.Trace("心跳发现任期落后，发送降级通知")
+			select {
+			case r.stepDownCh <- msg.term:
+			case <-r.stopCh:
+				return
+			}
+		}
+	}
 }
 
 // Candidate / Follower 开启新一轮选举
 func (rf *raft) election(stopCh chan struct{}) <-chan finishMsg {
-	// pre-vote
-	preVoteFinishCh := rf.sendRequestVote(stopCh, true)
+	// 不在集群配置中的节点不能发起选举，如未初始化的空白节点
+	if _, ok := rf.peerState.peers()[rf.peerState.myId()]; !ok {
+		rf.logger.Trace("当前节点不是投票节点，退出选举")
+		finishCh := make(chan finishMsg, 1)
+		finishCh <- finishMsg{msgType: Error}
+		return finishCh
+	}
 
-	finish := false
-	count := 0
-	successCnt := 0
-	end := false
-	after := time.After(rf.timerState.heartbeatDuration())
-	for !end {
-		select {
-		case <-after:
-			rf.logger.Trace("操作超时退出")
+	// 领导权转移发起的选举已经得到 Leader 同意，不需要预投票
+	transfer := rf.roleState.takeTransfer()
+	if rf.preVote && !transfer && !rf.preVoteElection(stopCh) {
+		rf.logger.Trace("preVote 失败，退出选举")
+		finishCh := make(chan finishMsg, 1)
+		finishCh <- finishMsg{msgType: Error}
+		return finishCh
+	}
+
+	// 增加 Term 数
+	rf.metrics.IncrCounter(MetricElections, 1)
+	err := rf.hardState.termAddAndVote(1, rf.peerState.myId())
+	if err != nil {
+		rf.logger.Error(fmt.Errorf("增加term，设置votedFor失败%w", err).Error())
+	}
+	rf.logger.Trace(fmt.Sprintf("增加 Term 数，开始发送 RequestVote 请求。Term=%d", rf.hardState.currentTerm()))
+
+	return rf.sendRequestVote(stopCh, transfer)
+}
+
+// 预投票阶段，询问其它节点是否会给当前节点投票，不会修改任何节点的任期和投票
+// 获得多数节点同意时返回 true，避免网络分区中的节点不断增加任期，恢复后打断正常的集群
+func (rf *raft) preVoteElection(stopCh chan struct{}) bool {
+	preVoteFinishCh := rf.sendPreVote(stopCh)
+
+	finish := false
+	count := 0
+	acks := make(map[NodeId]bool)
+	end := false
+	after := rf.clock.After(rf.timerState.heartbeatDuration())
+	for !end {
+		select {
+		case <-after:
+			rf.logger.Trace("操作超时退出")
 			end = true
 		case msg := <-preVoteFinishCh:
 			if msg.msgType == Degrade {
@@ -452,10 +1157,10 @@ func (rf *raft) election(stopCh chan struct{}) <-chan finishMsg {
 			}
 			if msg.msgType == Success {
 				rf.logger.Trace("接收到成功响应")
-				successCnt += 1
+				acks[msg.id] = true
 			}
-			if successCnt >= rf.peerState.majority() {
-				rf.logger.Trace("投票请求已成功发送给多数节点")
+			if rf.peerState.isQuorum(acks) {
+				rf.logger.Trace("preVote 已获得多数节点同意")
 				end = true
 				finish = true
 			}
@@ -467,53 +1172,51 @@ func (rf *raft) election(stopCh chan struct{}) <-chan finishMsg {
 		}
 	}
 
-	if !finish {
-		rf.logger.Trace("preVote 失败，退出选举")
-		go func() { preVoteFinishCh <- finishMsg{msgType: Error} }()
-		return preVoteFinishCh
-	}
-
-	// 增加 Term 数
-	err := rf.hardState.termAddAndVote(1, rf.peerState.myId())
-	if err != nil {
-		rf.logger.Error(fmt.Errorf("增加term，设置votedFor失败%w", err).Error())
-	}
-	rf.logger.Trace(fmt.Sprintf("增加 Term 数，开始发送 RequestVote 请求。Term=%d", rf.hardState.currentTerm()))
-
-	return rf.sendRequestVote(stopCh, false)
+	return finish
 }
 
-func (rf *raft) sendRequestVote(stopCh <-chan struct{}, isPreVote bool) chan finishMsg {
+func (rf *raft) sendRequestVote(stopCh <-chan struct{}, transfer bool) chan finishMsg {
 	// 发送 RV 请求
 	finishCh := make(chan finishMsg)
 
 	args := RequestVote{
-		IsPreVote:   isPreVote,
-		Term:        rf.hardState.currentTerm(),
-		CandidateId: rf.peerState.myId(),
+		Term:         rf.hardState.currentTerm(),
+		CandidateId:  rf.peerState.myId(),
+		LastLogIndex: rf.lastEntryIndex(),
+		LastLogTerm:  rf.lastEntryTerm(),
+		Transfer:     transfer,
 	}
 	for id, addr := range rf.peerState.peers() {
 		if rf.peerState.isMe(id) {
 			rf.logger.Trace(fmt.Sprintf("自身节点，不发送投票请求。Id=%s", id))
-			go func() { finishCh <- finishMsg{msgType: Success} }()
+			rf.goFunc(sendFinishMsg(finishCh, stopCh, finishMsg{msgType: Success, id: id}))
 			continue
 		}
 
-		go func(id NodeId, addr NodeAddr) {
+		id, addr := id, addr
+		rf.goFunc(func() {
 
+			span := rf.tracer.StartSpan("raft.sendRequestVote", nil)
+			span.SetAttribute("raft.peer", string(id))
+			span.SetAttribute("raft.term", args.Term)
 			var msg finishMsg
 			defer func() {
+				span.SetAttribute("raft.vote_granted", msg.msgType == Success)
+				span.End(nil)
 				select {
+				case finishCh <- msg:
 				case <-stopCh:
 					rf.logger.Trace("接收到 stopCh 消息")
-				default:
-					finishCh <- msg
 				}
 			}()
 
+			args := args
+			args.TraceContext = span.Context()
 			res := &RequestVoteReply{}
 			rf.logger.Trace(fmt.Sprintf("发送投票请求：%+v", args))
-			rpcErr := rf.transport.RequestVote(addr, args, res)
+			ctx, cancel := rf.stopContext(stopCh)
+			rpcErr := rf.transport.RequestVoteContext(ctx, addr, args, res)
+			cancel()
 
 			if rpcErr != nil {
 				rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w", addr, rpcErr).Error())
@@ -524,7 +1227,72 @@ func (rf *raft) sendRequestVote(stopCh <-chan struct{}, isPreVote bool) chan fin
 			if res.VoteGranted {
 				// 成功获得选票
 				rf.logger.Trace(fmt.Sprintf("成功获得来自 Id=%s 的选票", id))
-				msg = finishMsg{msgType: Success}
+				msg = finishMsg{msgType: Success, id: id}
+				return
+			}
+
+			term := rf.hardState.currentTerm()
+			if res.Term > term {
+				// 当前任期数落后，降级为 Follower
+				rf.logger.Trace(fmt.Sprintf("当前任期数落后，降级为 Follower, Term=%d, resTerm=%d", term, res.Term))
+				msg = finishMsg{msgType: Degrade, term: res.Term}
+			}
+		})
+	}
+
+	return finishCh
+}
+
+// 发送 PreVote 请求，请求中的任期是当前节点赢得选举后的任期，当前节点自身的任期不变
+func (rf *raft) sendPreVote(stopCh <-chan struct{}) chan finishMsg {
+	finishCh := make(chan finishMsg)
+
+	args := PreVote{
+		Term:         rf.hardState.currentTerm() + 1,
+		CandidateId:  rf.peerState.myId(),
+		LastLogIndex: rf.lastEntryIndex(),
+		LastLogTerm:  rf.lastEntryTerm(),
+	}
+	for id, addr := range rf.peerState.peers() {
+		if rf.peerState.isMe(id) {
+			rf.logger.Trace(fmt.Sprintf("自身节点，不发送 PreVote 请求。Id=%s", id))
+			rf.goFunc(sendFinishMsg(finishCh, stopCh, finishMsg{msgType: Success, id: id}))
+			continue
+		}
+
+		id, addr := id, addr
+		rf.goFunc(func() {
+
+			var msg finishMsg
+			defer func() {
+				select {
+				case finishCh <- msg:
+				case <-stopCh:
+					rf.logger.Trace("接收到 stopCh 消息")
+				}
+			}()
+
+			res := &PreVoteReply{}
+			rf.logger.Trace(fmt.Sprintf("发送 PreVote 请求：%+v", args))
+			ctx, cancel := rf.stopContext(stopCh)
+			rpcErr := preVoteContext(rf.transport, ctx, addr, args, res)
+			cancel()
+
+			if errors.Is(rpcErr, ErrPreVoteUnsupported) {
+				// 发往此节点的请求不支持预投票，视为同意，与不进行预投票时的行为一致
+				rf.logger.Trace(fmt.Sprintf("Transport 不支持向 Id=%s 的节点发送 PreVote 请求，视为同意", id))
+				msg = finishMsg{msgType: Success, id: id}
+				return
+			}
+			if rpcErr != nil {
+				rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w", addr, rpcErr).Error())
+				msg = finishMsg{msgType: RpcFailed}
+				return
+			}
+
+			if res.VoteGranted {
+				rf.logger.Trace(fmt.Sprintf("Id=%s 的节点同意发起选举", id))
+				msg = finishMsg{msgType: Success, id: id}
 				return
 			}
 
@@ -534,7 +1302,7 @@ func (rf *raft) sendRequestVote(stopCh <-chan struct{}, isPreVote bool) chan fin
 				rf.logger.Trace(fmt.Sprintf("当前任期数落后，降级为 Follower, Term=%d, resTerm=%d", term, res.Term))
 				msg = finishMsg{msgType: Degrade, term: res.Term}
 			}
-		}(id, addr)
+		})
 	}
 
 	return finishCh
@@ -549,21 +1317,72 @@ func (rf *raft) runReplication() {
 			replication = rf.newReplication(id, addr, Follower)
 			rf.leaderState.replications[id] = replication
 			rf.logger.Trace(fmt.Sprintf("开启复制循环：id=%s", id))
-			go rf.addReplication(replication)
+			rf.goReplication(func() { rf.addReplication(replication) })
+			rf.goReplication(func() { rf.heartbeatLoop(replication) })
+		}
+	}
+	// 非投票节点以 Learner 角色复制日志，不会被升级
+	for id, addr := range rf.peerState.nonVoters() {
+		rf.addLearnerReplication(id, addr)
+	}
+}
+
+// 每次心跳触发一轮 Learner 的日志追赶，用于统计连续追赶完成的轮数
+func (rf *raft) triggerLearners() {
+	for id, replication := range rf.leaderState.getReplications() {
+		if _, ok := rf.peerState.nonVoters()[id]; ok {
+			continue
+		}
+		if rf.leaderState.getFollowerRole(id) != Learner || rf.leaderState.isRpcBusy(id) {
+			continue
 		}
+		select {
+		case replication.triggerCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 通知非投票节点的复制循环发送新日志，不等待结果
+func (rf *raft) triggerNonVoters() {
+	for id := range rf.peerState.nonVoters() {
+		rf.triggerCatchUp(id)
+	}
+}
+
+// 通知节点的复制循环开始日志追赶，节点正在追赶时不重复触发
+// 追赶时缺失的日志已被压缩则发送快照，否则逐条发送日志
+func (rf *raft) triggerCatchUp(id NodeId) {
+	if replication, ok := rf.leaderState.replications[id]; ok {
+		rf.catchUp(replication)
+	}
+}
+
+// 通知复制循环开始日志追赶，不读取 replications，可在发送协程中调用
+func (rf *raft) catchUp(r *Replication) {
+	if r.isRpcBusy() {
+		return
+	}
+	select {
+	case r.triggerCh <- struct{}{}:
+	default:
 	}
 }
 
 func (rf *raft) newReplication(id NodeId, addr NodeAddr, role RoleStage) *Replication {
+	ctx, cancel := context.WithCancel(rf.rpcCtx)
 	return &Replication{
-		id:         id,
-		addr:       addr,
-		role:       role,
-		nextIndex:  rf.lastEntryIndex() + 1,
-		matchIndex: 0,
-		stepDownCh: rf.leaderState.stepDownCh,
-		stopCh:     make(chan struct{}),
-		triggerCh:  make(chan struct{}),
+		id:          id,
+		addr:        addr,
+		role:        role,
+		nextIndex:   rf.lastEntryIndex() + 1,
+		matchIndex:  0,
+		stepDownCh:  rf.leaderState.stepDownCh,
+		stopCh:      make(chan struct{}),
+		triggerCh:   make(chan struct{}),
+		heartbeatCh: make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
@@ -572,20 +1391,25 @@ func (rf *raft) addReplication(r *Replication) {
 		select {
 		case <-r.stopCh:
 			rf.logger.Trace(fmt.Sprintf("退出复制循环：id=%s", r.id))
-			delete(rf.leaderState.replications, r.id)
 			return
 		case <-r.triggerCh:
 			func() {
 				rf.logger.Trace(fmt.Sprintf("Id=%s 开始日志追赶", r.id))
 				// 设置状态
-				rf.leaderState.setRpcBusy(r.id, true)
-				defer rf.leaderState.setRpcBusy(r.id, false)
+				r.setRpcBusy(true)
+				defer r.setRpcBusy(false)
 				// 复制日志
 				replicate := rf.replicate(r)
 				rf.logger.Trace(fmt.Sprintf("日志追赶结束，返回值=%t", replicate))
+				if r.getRole() == Learner {
+					rf.leaderState.recordCatchUp(r, rf.lastEntryIndex(), replicate)
+				}
 				if replicate {
-					rf.updateLeaderCommit()
-					rf.logger.Trace(fmt.Sprintf("commitIndex 更新为 %d", rf.softState.getCommitIndex()))
+					// 由主循环更新 commitIndex
+					select {
+					case rf.leaderState.done <- r.id:
+					case <-r.stopCh:
+					}
 				}
 			}()
 		}
@@ -600,9 +1424,13 @@ func (rf *raft) handleCommand(rpcMsg rpc) {
 	rf.logger.Trace("重置选举计时器成功")
 
 	args := rpcMsg.req.(AppendEntry)
-	replyRes := AppendEntryReply{}
+	span := rf.tracer.StartSpan("raft.handleCommand", args.TraceContext)
+	span.SetAttribute("raft.entry_type", EntryTypeToString(args.EntryType))
+	span.SetAttribute("raft.entries", len(args.Entries))
+	replyRes := AppendEntryReply{Compression: true, Witness: rf.witness}
 	var replyErr error
 	defer func() {
+		span.End(replyErr)
 		rpcMsg.res <- rpcReply{
 			res: replyRes,
 			err: replyErr,
@@ -635,6 +1463,8 @@ func (rf *raft) handleCommand(rpcMsg rpc) {
 		rf.logger.Error(replyErr.Error())
 		return
 	}
+	rf.timerState.touchLeader()
+	rf.setLeader(args.LeaderId)
 
 	// 日志一致性检查
 	rf.logger.Trace("开始日志一致性检查")
@@ -643,31 +1473,15 @@ func (rf *raft) handleCommand(rpcMsg rpc) {
 		rf.logger.Trace("当前节点不包含 prevLog ")
 		func() {
 			defer func() {
-				rf.logger.Trace(fmt.Sprintf("返回最后一个日志条目的 Term=%d 及此 Term 的首个条目的索引 index=%d",
-					replyRes.ConflictTerm, replyRes.ConflictStartIndex))
+				rf.logger.Trace(fmt.Sprintf("返回最后一个日志条目的下一个索引 index=%d", replyRes.ConflictStartIndex))
 				replyRes.Term = rfTerm
 				replyRes.Success = false
 			}()
 			// 当前节点不包含索引为 prevIndex 的日志
 			rf.logger.Trace(fmt.Sprintf("当前节点不包含索引为 prevIndex=%d 的日志", prevIndex))
-			// 返回最后一个日志条目的 Term 及此 Term 的首个条目的索引
-			replyRes.ConflictTerm = rf.lastEntryTerm()
-			replyRes.ConflictStartIndex = rf.lastEntryIndex()
-			for i := rf.lastEntryIndex() - 1; i >= 0; i-- {
-				if !rf.entryExist(i) {
-					break
-				}
-				if iEntry, iEntryErr := rf.logEntry(i); iEntryErr != nil {
-					rf.logger.Error(iEntryErr.Error())
-					replyRes.ConflictStartIndex = 0
-					break
-				} else if iEntry.Term == replyRes.ConflictTerm {
-					replyRes.ConflictStartIndex = iEntry.Index
-				} else {
-					rf.logger.Trace(fmt.Sprintf("第 %d 日志term %d != conflictTerm", i, iEntry.Term))
-					break
-				}
-			}
+			// 没有冲突的任期，Leader 直接从最后一个日志条目的下一个索引开始发送
+			replyRes.ConflictTerm = 0
+			replyRes.ConflictStartIndex = rf.lastEntryIndex() + 1
 		}()
 		return
 	}
@@ -690,157 +1504,157 @@ func (rf *raft) handleCommand(rpcMsg rpc) {
 				prevIndex, args.PrevLogTerm, prevTerm))
 			// 返回 prevIndex 所在 Term 及此 Term 的首个条目的索引
 			replyRes.ConflictTerm = prevTerm
-			replyRes.ConflictStartIndex = prevIndex
-			for i := prevIndex - 1; i >= 0; i-- {
-				if !rf.entryExist(i) {
-					break
-				}
-				if iEntry, iEntryErr := rf.logEntry(i); iEntryErr != nil {
-					rf.logger.Error(iEntryErr.Error())
-					replyRes.ConflictStartIndex = 0
-					break
-				} else if iEntry.Term == replyRes.ConflictTerm {
-					replyRes.ConflictStartIndex = iEntry.Index
-				} else {
-					rf.logger.Trace(fmt.Sprintf("第 %d 日志term %d != conflictTerm", i, iEntry.Term))
-					break
-				}
-			}
+			replyRes.ConflictStartIndex = rf.conflictStartIndex(prevTerm, prevIndex)
 		}()
 		return
 	}
 	rf.logger.Trace("日志一致性检查通过")
 
-	newEntryIndex := prevIndex + 1
-	replyRes.Term = rfTerm
-	replyRes.Success = true
-	if args.EntryType == EntryReplicate {
-		// ========== 接收日志条目 ==========
-		rf.logger.Trace("接收到日志条目")
-		// 如果当前节点已经有此条目
-		if rf.lastEntryIndex() >= newEntryIndex {
-			rf.logger.Trace(fmt.Sprintf("当前节点已经含有 index=%d 的日志", newEntryIndex))
-			if entry, entryErr := rf.logEntry(newEntryIndex); entryErr != nil {
-				replyErr = fmt.Errorf("获取 index=%d 的日志失败！%w", newEntryIndex, entryErr)
-				rf.logger.Error(replyErr.Error())
-				return
-			} else if entry.Term != args.Term {
-				rf.logger.Trace(fmt.Sprintf("当前节点 index=%d 的日志与新条目冲突。term=%d, args.term=%d，截断之后的日志",
-					newEntryIndex, entry.Term, args.Term))
-				truncateErr := rf.truncateAfter(newEntryIndex)
-				if truncateErr != nil {
-					replyErr = fmt.Errorf("截断日志失败！%w", truncateErr)
-					rf.logger.Error(replyErr.Error())
-					return
-				}
-				rf.logger.Trace("日志截断成功！")
-				// 将新条目添加到日志中
-				err := rf.addEntry(args.Entries[0])
-				if err != nil {
-					replyErr = fmt.Errorf("日志添加新条目失败！%w", err)
-					rf.logger.Error(replyErr.Error())
-					return
-				}
-				rf.logger.Trace("成功将新条目添加到日志中")
-			} else {
-				rf.logger.Trace("当前节点已包含新日志")
-			}
-		} else {
-			// 将新条目添加到日志中
-			err := rf.addEntry(args.Entries[0])
-			if err != nil {
-				replyErr = fmt.Errorf("日志添加新条目失败！%w", err)
-				rf.logger.Error(replyErr.Error())
-				return
-			}
-			rf.logger.Trace("成功将新条目添加到日志中")
+	// 先解压日志，再检查大小
+	if args.Compressed {
+		if decompressErr := decompressEntries(args.Entries, rf.maxEntrySize); decompressErr != nil {
+			replyErr = fmt.Errorf("AppendEntries 请求被驳回：%w", decompressErr)
+			rf.logger.Error(replyErr.Error())
+			return
 		}
+	}
 
-		// 更新提交索引
-		leaderCommit := args.LeaderCommit
-		if leaderCommit > rf.softState.getCommitIndex() {
-			lastEntryIndex := rf.lastEntryIndex()
-			if leaderCommit >= rf.lastEntryIndex() {
-				rf.softState.setCommitIndex(lastEntryIndex)
-			} else {
-				rf.softState.setCommitIndex(leaderCommit)
-			}
-			rf.logger.Trace(fmt.Sprintf("成功更新提交索引，commitIndex=%d", rf.softState.getCommitIndex()))
-			applyErr := rf.applyFsm()
-			if applyErr != nil {
-				rf.logger.Error(fmt.Errorf("日志应用到状态机失败！%w", applyErr).Error())
-			} else {
-				rf.logger.Trace("日志成功应用到状态机")
-			}
+	// 包含过大或传输中损坏的日志的请求整体驳回，不添加其中任何日志
+	for _, entry := range args.Entries {
+		if !entryIntact(entry) {
+			replyErr = fmt.Errorf("index=%d 的日志被驳回：%w", entry.Index, ErrCorrupt)
+			rf.logger.Error(replyErr.Error())
+			return
+		}
+		if sizeErr := rf.checkEntrySize(entry); sizeErr != nil {
+			replyErr = fmt.Errorf("index=%d 的日志被驳回：%w", entry.Index, sizeErr)
+			rf.logger.Error(replyErr.Error())
+			return
 		}
+	}
 
-		// 当日志量超过阈值时，生成快照
-		rf.logger.Trace("检查是否需要生成快照")
-		rf.updateSnapshot()
+	// 见证节点只保存日志元数据
+	if rf.witness {
+		args.Entries = witnessEntries(args.Entries)
+	}
 
-		return
+	replyRes.Term = rfTerm
+	replyRes.Success = true
+	// 与 Leader 一致的最后一条日志的索引，只有此索引及之前的日志可以按 LeaderCommit 提交
+	lastNewIndex := prevIndex
+	if args.EntryType == EntryReplicate || args.EntryType == EntryChangeConf {
+		// ========== 接收日志条目 ==========
+		// 配置日志和普通日志一样添加到日志中，添加时立即生效，提交后成为最终配置
+		rf.logger.Trace(fmt.Sprintf("接收到 %d 个日志条目", len(args.Entries)))
+		if appendErr := rf.appendEntries(prevIndex, args.Entries); appendErr != nil {
+			replyErr = appendErr
+			replyRes.Success = false
+			rf.logger.Error(replyErr.Error())
+			return
+		}
+		lastNewIndex += len(args.Entries)
 	}
 
-	if args.EntryType == EntryHeartbeat {
-		// ========== 接收心跳 ==========
-		rf.logger.Trace("接收到心跳")
-		rf.peerState.setLeader(args.LeaderId)
-		replyRes.Term = rf.hardState.currentTerm()
+	// 各类请求都携带 Leader 的 commitIndex，一致性检查通过后统一更新提交索引，
+	// 连续的日志复制请求中已提交的日志不必等到下一次心跳才应用
+	rf.followLeaderCommit(args.LeaderCommit, lastNewIndex, span.Context())
 
-		// 更新提交索引
-		if prevIndex > rf.softState.getCommitIndex() {
-			rf.softState.setCommitIndex(prevIndex)
-			rf.logger.Trace(fmt.Sprintf("成功更新提交索引，commitIndex=%d", rf.softState.getCommitIndex()))
-			applyErr := rf.applyFsm()
-			if applyErr != nil {
-				rf.logger.Error(fmt.Errorf("日志应用到状态机失败！%w", applyErr).Error())
-			} else {
-				rf.logger.Trace("日志成功应用到状态机")
-			}
+	switch args.EntryType {
+	case EntryReplicate, EntryHeartbeat:
+		if args.EntryType == EntryHeartbeat {
+			rf.logger.Trace("接收到心跳")
+			replyRes.Term = rf.hardState.currentTerm()
 		}
-
 		// 当日志量超过阈值时，生成快照
 		rf.logger.Trace("检查是否需要生成快照")
 		rf.updateSnapshot()
-		return
-	}
-
-	if args.EntryType == EntryChangeConf {
+	case EntryChangeConf:
 		rf.logger.Trace("接收到成员变更请求")
-		configData := args.Entries[0].Data
-		peerErr := rf.peerState.replacePeersWithBytes(configData)
-		if peerErr != nil {
-			replyErr = peerErr
-			replyRes.Success = false
-			rf.logger.Trace("新配置应用失败")
-		}
-		rf.logger.Trace(fmt.Sprintf("新配置应用成功，Peers=%+v", rf.peerState.peers()))
-		if _, ok := rf.peerState.peers()[rf.peerState.myId()]; !ok {
-			rf.logger.Trace("新配置中不包含当前节点，退出程序")
-			go func() { rf.exitCh <- struct{}{} }()
-			return
-		}
-		replyRes.Success = true
-		return
-	}
-
-	if args.EntryType == EntryTimeoutNow {
+	case EntryTimeoutNow:
 		rf.logger.Trace("接收到 timeoutNow 请求")
+		rf.roleState.setTransfer(true)
 		replyRes.Success = rf.becomeCandidate()
 		if replyRes.Success {
 			rf.logger.Trace("角色成功变为 Candidate")
 		} else {
 			rf.logger.Trace("角色变为候选者失败")
 		}
+	case EntryPromote:
+		// 已接收到全部日志，从 Learner 角色升级为 Follower
+		if rf.roleState.getRoleStage() == Learner {
+			rf.logger.Trace(fmt.Sprintf("Learner 接收到升级请求，Term=%d", args.Term))
+			replyRes.Success = rf.becomeFollower(args.Term)
+			rf.logger.Trace("成功升级到Follower")
+		}
+	}
+}
+
+// Follower 按 Leader 的 commitIndex 推进提交索引并应用日志
+// 不超过 lastNewIndex，当前节点在此之后的日志尚未与 Leader 核对，可能在之后被截断
+func (rf *raft) followLeaderCommit(leaderCommit, lastNewIndex int, traceCtx TraceContext) {
+	commitIndex := leaderCommit
+	if commitIndex > lastNewIndex {
+		commitIndex = lastNewIndex
+	}
+	if commitIndex <= rf.softState.getCommitIndex() {
 		return
 	}
+	rf.softState.setCommitIndex(commitIndex)
+	rf.logger.Trace(fmt.Sprintf("成功更新提交索引，commitIndex=%d", commitIndex))
+	rf.applyCommitted(traceCtx)
+}
+
+// 将 Leader 发来的日志条目添加到 prevIndex 之后，截断与新条目冲突的日志
+// 配置日志添加到日志时立即生效
+func (rf *raft) appendEntries(prevIndex int, entries []Entry) error {
+	for i, newEntry := range entries {
+		newEntryIndex := prevIndex + 1 + i
+		// 如果当前节点已经有此条目
+		if rf.lastEntryIndex() >= newEntryIndex {
+			rf.logger.Trace(fmt.Sprintf("当前节点已经含有 index=%d 的日志", newEntryIndex))
+			entry, entryErr := rf.logEntry(newEntryIndex)
+			if entryErr != nil {
+				return fmt.Errorf("获取 index=%d 的日志失败！%w", newEntryIndex, entryErr)
+			}
+			if entry.Term == newEntry.Term {
+				rf.logger.Trace("当前节点已包含新日志")
+				continue
+			}
+			rf.logger.Trace(fmt.Sprintf("当前节点 index=%d 的日志与新条目冲突。term=%d, newEntry.term=%d，截断之后的日志",
+				newEntryIndex, entry.Term, newEntry.Term))
+			if truncateErr := rf.truncateAfter(newEntryIndex); truncateErr != nil {
+				return fmt.Errorf("截断日志失败！%w", truncateErr)
+			}
+			rf.logger.Trace("日志截断成功！")
+		}
+		// 将新条目添加到日志中
+		if err := rf.addEntry(newEntry); err != nil {
+			return fmt.Errorf("日志添加新条目失败！%w", err)
+		}
+		rf.logger.Trace(fmt.Sprintf("成功将新条目 index=%d 添加到日志中", newEntryIndex))
+		if newEntry.Type == EntryChangeConf {
+			if peerErr := rf.peerState.setConfigWithBytes(newEntryIndex, newEntry.Data); peerErr != nil {
+				return fmt.Errorf("新配置应用失败！%w", peerErr)
+			}
+			rf.onMembershipChange()
+			rf.logger.Trace(fmt.Sprintf("新配置应用成功，Peers=%+v", rf.peerState.peers()))
+			rf.promoteByConfig()
+		}
+	}
+	return nil
+}
 
-	// 已接收到全部日志，从 Learner 角色升级为 Follower
-	if rf.roleState.getRoleStage() == Learner && args.EntryType == EntryPromote {
-		rf.logger.Trace(fmt.Sprintf("Learner 接收到升级请求，Term=%d", args.Term))
-		replyRes.Success = rf.becomeFollower(args.Term)
-		rf.logger.Trace("成功升级到Follower")
+// Learner 接收到将自身作为投票节点的配置日志时，升级为 Follower，按新配置参与投票
+// 非投票节点不在 peers 中，始终保持 Learner 角色
+func (rf *raft) promoteByConfig() {
+	if rf.roleState.getRoleStage() != Learner {
+		return
+	}
+	if _, ok := rf.peerState.peers()[rf.peerState.myId()]; !ok {
+		return
 	}
+	rf.logger.Trace("新配置中当前节点是投票节点，从 Learner 升级为 Follower")
+	rf.becomeFollower(rf.hardState.currentTerm())
 }
 
 // Follower 和 Candidate 接收到来自 Candidate 的 RequestVote 调用
@@ -865,6 +1679,14 @@ func (rf *raft) handleVoteReq(rpcMsg rpc) {
 		replyRes.VoteGranted = false
 	}
 
+	if !args.Transfer && rf.hasLiveLeader() {
+		// 当前有正常的 Leader，拒绝投票且不更新任期，避免重新加入集群的节点打断稳定的 Leader
+		rf.logger.Trace(fmt.Sprintf("最小选举超时时间内收到过 Leader 的请求，不投票。CandidateId=%s", args.CandidateId))
+		replyRes.Term = rfTerm
+		replyRes.VoteGranted = false
+		return
+	}
+
 	argsTerm := args.Term
 	if argsTerm < rfTerm {
 		// 拉票的候选者任期落后，不投票
@@ -895,7 +1717,7 @@ func (rf *raft) handleVoteReq(rpcMsg rpc) {
 	replyRes.Term = argsTerm
 	replyRes.VoteGranted = false
 	votedFor := rf.hardState.voted()
-	if args.IsPreVote || votedFor == "" || votedFor == args.CandidateId {
+	if votedFor == "" || votedFor == args.CandidateId {
 		// 当前节点是追随者且没有投过票
 		rf.logger.Trace("当前节点是追随者且没有投过票，开始比较日志的新旧程度")
 		lastIndex := rf.lastEntryIndex()
@@ -926,6 +1748,45 @@ func (rf *raft) handleVoteReq(rpcMsg rpc) {
 	}
 }
 
+// 处理 PreVote 请求，只判断是否会给发送方投票，不修改当前节点的任期和 votedFor
+func (rf *raft) handlePreVote(rpcMsg rpc) {
+	args := rpcMsg.req.(PreVote)
+	rfTerm := rf.hardState.currentTerm()
+	replyRes := PreVoteReply{Term: rfTerm}
+	defer func() {
+		rpcMsg.res <- rpcReply{res: replyRes}
+	}()
+
+	rf.logger.Trace(fmt.Sprintf("接收到的参数：%+v", args))
+	if rf.roleState.getRoleStage() == Learner {
+		rf.logger.Trace("当前节点是 Learner，不投票")
+		return
+	}
+	if rf.hasLiveLeader() {
+		rf.logger.Trace("最小选举超时时间内收到过 Leader 的请求，不投票")
+		return
+	}
+	if args.Term <= rfTerm {
+		// 发送方赢得选举后的任期不大于当前任期，不投票
+		rf.logger.Trace(fmt.Sprintf("PreVote 请求任期落后，不投票。Term=%d, args.Term=%d", rfTerm, args.Term))
+		return
+	}
+	lastIndex := rf.lastEntryIndex()
+	lastTerm := rf.lastEntryTerm()
+	if args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= lastIndex) {
+		rf.logger.Trace("发送方日志较新，同意发起选举")
+		replyRes.VoteGranted = true
+	} else {
+		rf.logger.Trace(fmt.Sprintf("发送方日志不够新，不投票，args.lastTerm=%d, lastTerm=%d, args.lastIndex=%d, lastIndex=%d",
+			args.LastLogTerm, lastTerm, args.LastLogIndex, lastIndex))
+	}
+}
+
+// 当前节点是 Leader，或最小选举超时时间内收到过 Leader 的请求
+func (rf *raft) hasLiveLeader() bool {
+	return rf.roleState.getRoleStage() == Leader || rf.timerState.leaderAlive()
+}
+
 // 慢 Follower 接收来自 Leader 的 InstallSnapshot 调用
 // 目的是加快日志追赶速度
 func (rf *raft) handleSnapshot(rpcMsg rpc) {
@@ -935,9 +1796,12 @@ func (rf *raft) handleSnapshot(rpcMsg rpc) {
 	rf.logger.Trace("重置选举计时器成功")
 
 	args := rpcMsg.req.(InstallSnapshot)
+	span := rf.tracer.StartSpan("raft.handleSnapshot", args.TraceContext)
+	span.SetAttribute("raft.offset", args.Offset)
 	replyRes := InstallSnapshotReply{}
 	var replyErr error
 	defer func() {
+		span.End(replyErr)
 		rpcMsg.res <- rpcReply{
 			res: replyRes,
 			err: replyErr,
@@ -962,76 +1826,117 @@ func (rf *raft) handleSnapshot(rpcMsg rpc) {
 			return
 		}
 	}
+	rf.timerState.touchLeader()
+	rf.setLeader(args.LeaderId)
 
-	// 安装快照
-	if installErr := rf.fsm.Install(args.Data); installErr != nil {
-		replyErr = fmt.Errorf("安装快照失败：%w", installErr)
+	// 按偏移量拼接快照分块
+	replyRes.Term = rfTerm
+	// 状态机版本不同的快照无法用于恢复状态机，拒绝安装
+	if args.FsmVersion != "" && rf.fsmVersion != "" && args.FsmVersion != rf.fsmVersion {
+		rf.snapshotState.cancelReceiving()
+		replyErr = SnapshotVersionError{Version: args.FsmVersion, Expected: rf.fsmVersion}
+		rf.logger.Error(replyErr.Error())
 		return
 	}
-	rf.softState.setLastApplied(args.LastIncludedIndex)
-	rf.logger.Trace("安装快照成功！")
-	// 持久化快照
-	replyRes.Term = rfTerm
 	argsIndex := args.LastIncludedIndex
-	snapshot := Snapshot{
-		LastIndex: argsIndex,
-		LastTerm:  args.LastIncludedTerm,
-		Data:      args.Data,
+	nextOffset, ok, receiveErr := rf.snapshotState.receiveChunk(args)
+	replyRes.Offset = nextOffset
+	if receiveErr != nil {
+		replyErr = fmt.Errorf("接收快照失败：%w", receiveErr)
+		rf.logger.Error(replyErr.Error())
+		return
 	}
-	if saveErr := rf.snapshotState.save(snapshot); saveErr != nil {
-		replyErr = fmt.Errorf("持久化快照失败：%w", saveErr)
+	if !ok {
+		rf.logger.Trace(fmt.Sprintf("快照分块偏移量 %d 与期望的 %d 不符，等待 Leader 重新发送", args.Offset, nextOffset))
 		return
 	}
-	rf.logger.Trace("持久化快照成功！")
-
 	if !args.Done {
 		// 若传送没有完成，则继续接收数据
+		rf.logger.Trace(fmt.Sprintf("接收快照分块成功，等待下一分块，Offset=%d", nextOffset))
 		return
 	}
 
-	// 保存快照成功，删除多余日志
-	lastIndex := rf.lastEntryIndex()
-	if argsIndex < lastIndex {
-		if !rf.entryExist(argsIndex) {
-			replyErr = fmt.Errorf("收到的快照索引 %d 小于节点快照索引 %d", argsIndex, lastIndex)
-		}
-		entry, entryErr := rf.logEntry(argsIndex)
-		if entryErr != nil {
-			replyErr = fmt.Errorf("获取 index=%d 的日志失败！%w", argsIndex, entryErr)
-			rf.logger.Error(replyErr.Error())
-			return
-		}
-		if entry.Term == args.LastIncludedTerm {
-			rf.logger.Trace("删除快照之前的旧日志")
-			if truncateErr := rf.truncateBefore(argsIndex + 1); truncateErr != nil {
-				replyErr = fmt.Errorf("删除日志失败！%w", truncateErr)
-				rf.logger.Error(replyErr.Error())
-			} else {
-				rf.logger.Trace("删除日志成功！")
-			}
-		}
+	rf.logger.Trace("持久化快照成功！")
+
+	// 使用快照恢复状态机
+	if restoreErr := rf.restoreSnapshot(argsIndex); restoreErr != nil {
+		replyErr = fmt.Errorf("使用快照恢复状态机失败：%w", restoreErr)
+		rf.logger.Error(replyErr.Error())
+		return
+	}
+	rf.notifyObservers(Event{Type: EventSnapshotInstalled, Snapshot: rf.snapshotMeta(*rf.snapshotState.getSnapshot())})
+
+	// 保存快照成功，删除快照包含的日志
+	// 若节点包含与快照最后一条日志相同的条目，则保留其后的日志，否则清空日志
+	rf.logger.Trace("删除快照之前的旧日志")
+	head := Entry{Index: argsIndex, Term: args.LastIncludedTerm, Type: rf.lastEntryType()}
+	if compactErr := rf.hardState.compact(head); compactErr != nil {
+		replyErr = fmt.Errorf("删除日志失败！%w", compactErr)
+		rf.logger.Error(replyErr.Error())
 		return
 	}
+	rf.logger.Trace("删除日志成功！")
 
-	lastEntryType := rf.lastEntryType()
-	rf.logger.Trace("清空日志")
-	rf.hardState.clearEntries()
-	newEntry := Entry{
-		Index: snapshot.LastIndex,
-		Term:  snapshot.LastTerm,
-		Type:  lastEntryType,
+	// 快照包含的配置日志已被删除，使用快照中的配置更新集群成员
+	if configIndex, config, ok := rf.snapshotState.config(argsIndex); ok {
+		rf.installConfig(configIndex, config)
 	}
-	if appendEntryErr := rf.hardState.appendEntry(newEntry); appendEntryErr != nil {
-		replyErr = fmt.Errorf("添加新日志失败！")
-		rf.logger.Error(replyErr.Error())
+}
+
+// 索引为 lastIndex 的快照中的集群配置
+// 快照中没有配置时（如重启前生成的流式快照），已提交的配置在快照范围内则使用已提交的配置，否则不发送配置，由之后的日志更新
+func (rf *raft) snapshotConfig(lastIndex int) (int, []byte) {
+	if index, config, ok := rf.snapshotState.config(lastIndex); ok {
+		return index, config
+	}
+	index, config := rf.hardState.committedConfig()
+	if index > lastIndex {
+		return 0, nil
+	}
+	return index, config
+}
+
+// 使用快照附带的已提交配置更新集群成员，配置不在快照范围内时由之后的日志更新
+func (rf *raft) installConfig(index int, data []byte) {
+	if len(data) <= 0 || index > rf.snapshotState.lastIndex() || index <= rf.peerState.getCommittedIndex() {
+		return
 	}
+	if index > rf.peerState.getConfigIndex() {
+		if err := rf.peerState.setConfigWithBytes(index, data); err != nil {
+			rf.logger.Error(fmt.Errorf("使用快照中的配置更新集群成员失败：%w", err).Error())
+			return
+		}
+		rf.onMembershipChange()
+		rf.logger.Trace(fmt.Sprintf("使用快照中的配置更新集群成员，Peers=%+v", rf.peerState.peers()))
+	}
+	rf.commitConfig(index, data)
+	rf.promoteByConfig()
 }
 
 // 处理领导权转移请求
 func (rf *raft) handleTransfer(rpcMsg rpc) {
+	// 领导权转移期间不再处理客户端请求，先处理完已接收的请求
+	rf.flushClientCmds()
+
 	// 先发送一次心跳，刷新计时器，以及
 	args := rpcMsg.req.(TransferLeadership)
-	timer := time.After(rf.timerState.minElectionTimeout())
+	if args.Transferee.Id == None {
+		// 未指定目标节点时，选择日志最新的投票节点
+		transferee, ok := rf.pickTransferee()
+		if !ok {
+			rf.logger.Trace(ErrNoTransferee.Error())
+			rpcMsg.res <- rpcReply{res: TransferLeadershipReply{}, err: ErrNoTransferee}
+			return
+		}
+		rf.logger.Trace(fmt.Sprintf("未指定目标节点，选择日志最新的节点 Id=%s", transferee.Id))
+		args.Transferee = transferee
+	} else if checkErr := rf.checkTransferee(args.Transferee.Id); checkErr != nil {
+		// 不能接收领导权的节点直接驳回，不进入转移状态
+		rf.logger.Trace(checkErr.Error())
+		rpcMsg.res <- rpcReply{res: TransferLeadershipReply{}, err: checkErr}
+		return
+	}
+	timer := rf.clock.After(rf.leaderState.transferTimeout(rf.timerState.minElectionTimeout()))
 	// 设置定时器和rpc应答通道
 	rf.leaderState.setTransferBusy(args.Transferee.Id)
 	rf.leaderState.setTransferState(timer, rpcMsg.res)
@@ -1042,71 +1947,368 @@ func (rf *raft) handleTransfer(rpcMsg rpc) {
 	rf.checkTransfer(args.Transferee.Id)
 }
 
+// 检查指定的目标节点能否接收领导权：需是投票节点、不是见证节点，且未被判定为不可达
+func (rf *raft) checkTransferee(id NodeId) error {
+	if rf.peerState.isMe(id) {
+		return TransfereeError{Id: id, Reason: "是当前 Leader"}
+	}
+	if _, ok := rf.peerState.peers()[id]; !ok {
+		if _, ok := rf.peerState.nonVoters()[id]; ok {
+			return TransfereeError{Id: id, Reason: "不是投票节点"}
+		}
+		if _, ok := rf.leaderState.replications[id]; ok {
+			return TransfereeError{Id: id, Reason: "是 Learner 节点"}
+		}
+		return TransfereeError{Id: id, Reason: "不是集群成员"}
+	}
+	if _, ok := rf.leaderState.replications[id]; !ok {
+		return TransfereeError{Id: id, Reason: "没有复制进度"}
+	}
+	progress := rf.leaderState.peerProgress(id, rf.lastEntryIndex())
+	if progress.Witness {
+		return TransfereeError{Id: id, Reason: "是见证节点"}
+	}
+	if progress.Unreachable {
+		return TransfereeError{Id: id, Reason: "不可达"}
+	}
+	return nil
+}
+
+// 取消正在进行的领导权转移，恢复处理客户端请求
+func (rf *raft) handleTransferAbort(msg rpc) {
+	if transfereeId, busy := rf.leaderState.isTransferBusy(); busy {
+		rf.logger.Trace(fmt.Sprintf("取消向 Id=%s 的领导权转移", transfereeId))
+		rf.endTransfer(TransferLeadershipReply{Status: OK}, ErrTransferAborted)
+	}
+	msg.res <- rpcReply{}
+}
+
+// 选择 matchIndex 最大的投票节点作为领导权转移的目标，Learner、非投票节点和见证节点不参与选举
+func (rf *raft) pickTransferee() (Server, bool) {
+	var target Server
+	maxIndex := -1
+	for id, addr := range rf.peerState.peers() {
+		if rf.peerState.isMe(id) {
+			continue
+		}
+		if _, ok := rf.leaderState.replications[id]; !ok {
+			continue
+		}
+		progress := rf.leaderState.peerProgress(id, rf.lastEntryIndex())
+		if progress.Role == Learner || progress.Witness {
+			continue
+		}
+		if progress.MatchIndex > maxIndex {
+			maxIndex = progress.MatchIndex
+			target = Server{Id: id, Addr: addr}
+		}
+	}
+	return target, target.Id != None
+}
+
 // 处理客户端请求
+// 请求先加入批处理队列，等待时间或数据量达到阈值后再统一处理
 func (rf *raft) handleClientCmd(rpcMsg rpc) {
+	// 客户端已放弃等待，不再添加到日志
+	if ctxErr := rpcMsg.ctxErr(); ctxErr != nil {
+		rf.logger.Trace(fmt.Sprintf("客户端请求已取消：%s", ctxErr))
+		rpcMsg.res <- rpcReply{res: ApplyCommandReply{}, err: ctxErr}
+		return
+	}
 
-	// 重置心跳计时器
-	if rf.isLeader() {
-		rf.timerState.setHeartbeatTimer()
-		rf.logger.Trace("重置心跳计时器成功")
+	// 节点正在下线，不再接收新的请求
+	if !rf.shutdownState.accept() {
+		replyErr := DrainingError{Leader: rf.peerState.getLeader()}
+		rf.logger.Trace(replyErr.Error())
+		rpcMsg.res <- rpcReply{res: ApplyCommandReply{}, err: replyErr}
+		return
 	}
 
+	// 加入批处理队列的请求在答复时结束，其余请求在此结束
+	queued := false
+	defer func() {
+		if !queued {
+			rf.shutdownState.finish()
+		}
+	}()
+
 	args := rpcMsg.req.(ApplyCommand)
-	var replyRes ApplyCommandReply
-	var replyErr error
+
+	// 过大的命令在进入批处理队列之前驳回
+	if sizeErr := rf.checkEntrySize(Entry{Type: EntryReplicate, Data: args.Data}); sizeErr != nil {
+		rf.logger.Trace(sizeErr.Error())
+		rpcMsg.res <- rpcReply{res: ApplyCommandReply{Status: Rejected}, err: sizeErr}
+		return
+	}
+
+	// 只读模式下不再写入日志
+	if rf.readOnly() {
+		rf.logger.Trace(ErrReadOnly.Error())
+		rpcMsg.res <- rpcReply{res: ApplyCommandReply{Status: Rejected}, err: ErrReadOnly}
+		return
+	}
+
+	// 租约过期的 Leader 可能已被隔离，日志无法提交，立即驳回，客户端可重试其它节点
+	if rf.leaseCheck && !rf.leaseValid() {
+		rf.logger.Trace(ErrLeadershipLost.Error())
+		rpcMsg.res <- rpcReply{res: ApplyCommandReply{Status: NotLeader}, err: ErrLeadershipLost}
+		return
+	}
+
+	// 添加到日志之前进行授权检查
+	if rf.authorizer != nil {
+		authErr := rf.authorizer.Authorize(ProposalInfo{
+			ClientId: args.ClientId,
+			Metadata: args.Metadata,
+			Size:     len(args.Data),
+			Data:     args.Data,
+		})
+		if authErr != nil {
+			replyErr := fmt.Errorf("客户端请求未通过授权检查：%w", authErr)
+			rf.logger.Trace(replyErr.Error())
+			rpcMsg.res <- rpcReply{res: ApplyCommandReply{Status: Rejected}, err: replyErr}
+			return
+		}
+	}
+
+	queued = true
+	if rf.leaderState.addProposal(rpcMsg, len(args.Data)) {
+		rf.logger.Trace("批处理队列已满，开始处理客户端请求")
+		rf.flushClientCmds()
+	}
+}
+
+// 处理批处理队列中的全部客户端请求
+func (rf *raft) flushClientCmds() {
+	proposals := rf.leaderState.takeProposals()
+	if len(proposals) <= 0 {
+		return
+	}
+	rf.logger.Trace(fmt.Sprintf("批量处理 %d 个客户端请求", len(proposals)))
+	rf.handleClientCmds(proposals)
+}
+
+// 非 Leader 节点接收到客户端命令，可以转发时在单独的协程中转发给 Leader，否则答复 NotLeader
+func (rf *raft) forwardClientCmd(msg rpc) {
+	args := msg.req.(ApplyCommand)
+	// 过大的命令不转发，直接驳回
+	if sizeErr := rf.checkEntrySize(Entry{Type: EntryReplicate, Data: args.Data}); sizeErr != nil {
+		rf.logger.Trace(sizeErr.Error())
+		msg.res <- rpcReply{res: ApplyCommandReply{Status: Rejected}, err: sizeErr}
+		return
+	}
+	leader := rf.peerState.getLeader()
+	if rf.forwarder == nil || args.Forwarded || leader.Id == None || rf.peerState.isMe(leader.Id) {
+		rf.logger.Trace("当前节点不是 Leader，ApplyCommandRpc 请求驳回")
+		msg.res <- rpcReply{res: ApplyCommandReply{Status: NotLeader, Leader: leader}}
+		return
+	}
+	rf.logger.Trace(fmt.Sprintf("将客户端命令转发给 Leader Id=%s", leader.Id))
+	args.Forwarded = true
+	rf.goFunc(func() {
+		var res ApplyCommandReply
+		err := rf.forwarder.ApplyCommand(leader.Addr, args, &res)
+		if err != nil && res.Status != OK {
+			// 转发失败时答复 NotLeader，由客户端自行重定向
+			rf.logger.Error(fmt.Errorf("转发客户端命令到 %s 失败：%w", leader.Addr, err).Error())
+			msg.res <- rpcReply{res: ApplyCommandReply{Status: NotLeader, Leader: leader}}
+			return
+		}
+		msg.res <- rpcReply{res: res, err: err}
+	})
+}
+
+// 驳回批处理队列中的全部客户端请求，在节点退出 Leader 状态时调用
+func (rf *raft) rejectClientCmds() {
+	for _, msg := range rf.leaderState.takeProposals() {
+		msg.res <- rpcReply{res: ApplyCommandReply{
+			Status: NotLeader,
+			Leader: rf.peerState.getLeader(),
+		}}
+		rf.shutdownState.finish()
+	}
+}
+
+// 批量处理客户端请求，各请求的日志一起添加并复制到各节点
+func (rf *raft) handleClientCmds(proposals []rpc) {
+
+	// 批处理等待期间 ctx 已结束的请求不再添加到日志
+	alive := proposals[:0]
+	for _, msg := range proposals {
+		if ctxErr := msg.ctxErr(); ctxErr != nil {
+			rf.logger.Trace(fmt.Sprintf("客户端请求已取消：%s", ctxErr))
+			msg.res <- rpcReply{res: ApplyCommandReply{}, err: ctxErr}
+			rf.shutdownState.finish()
+			continue
+		}
+		alive = append(alive, msg)
+	}
+	proposals = alive
+	if len(proposals) <= 0 {
+		return
+	}
+
+	// 一个批次一个 span，以第一个请求的追踪上下文为上游
+	span := rf.tracer.StartSpan("raft.handleClientCmds", proposals[0].req.(ApplyCommand).TraceContext)
+	span.SetAttribute("raft.batch_size", len(proposals))
+	replyRes := make([]ApplyCommandReply, len(proposals))
+	replyErr := make([]error, len(proposals))
+	reply := func() {
+		var spanErr error
+		for _, err := range replyErr {
+			if err != nil {
+				spanErr = err
+				break
+			}
+		}
+		span.End(spanErr)
+		for i, msg := range proposals {
+			msg.res <- rpcReply{
+				res: replyRes[i],
+				err: replyErr[i],
+			}
+			rf.shutdownState.finish()
+		}
+	}
+	// 日志提交后由等待应用结果的协程答复
+	waiting := false
 	defer func() {
-		rpcMsg.res <- rpcReply{
-			res: replyRes,
-			err: replyErr,
+		if !waiting {
+			reply()
 		}
 	}()
 
-	// Leader 先将日志添加到内存
+	// 重置心跳计时器
+	if rf.isLeader() {
+		rf.timerState.setHeartbeatTimer()
+		rf.logger.Trace("重置心跳计时器成功")
+	}
+
+	// Leader 先将日志添加到内存，在给各节点发送日志的同时写入本地磁盘
 	rf.logger.Trace("将日志添加到内存")
-	addEntryErr := rf.addEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryReplicate, Data: args.Data})
-	if addEntryErr != nil {
-		replyErr = fmt.Errorf("给 Leader 添加客户端日志失败：%w", addEntryErr)
-		rf.logger.Trace(replyErr.Error())
+	entryIndexes := make([]int, 0, len(proposals))
+	appendedAt := rf.clock.Now()
+	for i, msg := range proposals {
+		args := msg.req.(ApplyCommand)
+		addEntryErr := rf.addLeaderEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryReplicate, Data: args.Data})
+		if addEntryErr != nil {
+			err := fmt.Errorf("给 Leader 添加客户端日志失败：%w", addEntryErr)
+			rf.logger.Trace(err.Error())
+			for j := i; j < len(proposals); j++ {
+				replyErr[j] = err
+			}
+			break
+		}
+		entryIndexes = append(entryIndexes, rf.lastEntryIndex())
+	}
+	if len(entryIndexes) <= 0 {
 		return
 	}
+	// 批处理出错时，已添加到日志的请求都返回此错误
+	failAll := func(err error) {
+		for i := range entryIndexes {
+			replyErr[i] = err
+		}
+	}
+
+	// 给各节点发送日志条目，成功发送到多数节点后提交
+	if replicateErr := rf.replicateEntries(span.Context()); replicateErr != nil {
+		rf.logger.Error(replicateErr.Error())
+		failAll(replicateErr)
+		return
+	}
+	committedAt := rf.clock.Now()
+	for range entryIndexes {
+		rf.metrics.Observe(MetricCommitLatency, committedAt.Sub(appendedAt).Seconds())
+	}
+
+	// 日志已提交，交给应用协程应用到状态机
+	// 主循环是唯一提交日志的协程，此时这些日志尚未交给应用协程，在此之前注册不会错过结果
+	resultChs := make([]<-chan applyResult, len(entryIndexes))
+	for i, entryIndex := range entryIndexes {
+		resultChs[i] = rf.applyState.wait(entryIndex)
+	}
+	rf.applyCommitted(span.Context())
+
+	// 当日志量超过阈值时，生成快照
+	rf.logger.Trace("检查是否需要生成快照")
+	rf.updateSnapshot()
+
+	// 在单独的协程中等待各条日志在状态机中的执行结果，返回给对应的客户端，不阻塞主循环
+	waiting = true
+	rf.goFunc(func() {
+		for i, resultCh := range resultChs {
+			result := rf.waitApplied(resultCh)
+			rf.metrics.Observe(MetricApplyLatency, rf.clock.Now().Sub(committedAt).Seconds())
+			replyRes[i].Result = result.data
+			replyRes[i].Status = OK
+			replyErr[i] = result.err
+		}
+		reply()
+	})
+}
+
+// 将 Leader 的新日志发送给各节点，成功发送到多数节点后更新提交索引
+func (rf *raft) replicateEntries(parent TraceContext) (err error) {
+	span := rf.tracer.StartSpan("raft.replicateEntries", parent)
+	span.SetAttribute("raft.last_index", rf.lastEntryIndex())
+	defer func() { span.End(err) }()
 
 	// 给各节点发送日志条目
 	finishCh := make(chan finishMsg)
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 	rf.logger.Trace("给各节点发送日志条目")
+	rf.triggerNonVoters()
 	for id, addr := range rf.peerState.peers() {
 		// 不用给自己发，正在复制日志的不发
+		// 自身节点与其它节点并行地将日志写入磁盘，写入完成后才计入法定人数
 		if rf.peerState.isMe(id) {
-			rf.logger.Trace(fmt.Sprintf("自身节点，不发送心跳。Id=%s", id))
-			rf.softState.setCommitIndex(rf.softState.getCommitIndex() + 1)
-			go func() { finishCh <- finishMsg{msgType: Success, id: id} }()
+			rf.logger.Trace(fmt.Sprintf("自身节点，将日志写入磁盘。Id=%s", id))
+			id := id
+			rf.goFunc(func() {
+				msg := finishMsg{msgType: Success, id: id}
+				if persistErr := rf.hardState.persistEntries(); persistErr != nil {
+					rf.logger.Error(persistErr.Error())
+					msg = finishMsg{msgType: Error}
+				}
+				sendFinishMsg(finishCh, stopCh, msg)()
+			})
+			continue
+		}
+		if rf.leaderState.inBackoff(id, rf.clock.Now()) {
+			rf.logger.Trace(fmt.Sprintf("节点不可达，等待下一次探测。Id=%s", id))
+			rf.goFunc(sendFinishMsg(finishCh, stopCh, finishMsg{msgType: Error}))
 			continue
 		}
 		if rf.leaderState.isRpcBusy(id) {
 			rf.logger.Trace(fmt.Sprintf("忙节点，不发送心跳。Id=%s", id))
-			go func() { finishCh <- finishMsg{msgType: Error} }()
+			rf.goFunc(sendFinishMsg(finishCh, stopCh, finishMsg{msgType: Error}))
+		}
+		if rf.leaderState.isLagging(id, rf.lastEntryIndex(), rf.hardState.entriesSize) {
+			rf.logger.Trace(fmt.Sprintf("节点落后太多，暂停批量复制，转为后台追赶。Id=%s", id))
+			rf.triggerCatchUp(id)
+			rf.goFunc(sendFinishMsg(finishCh, stopCh, finishMsg{msgType: Error}))
+			continue
 		}
 		// 发送日志
-		go rf.replicationTo(id, addr, finishCh, stopCh, EntryReplicate)
+		replication, addr := rf.leaderState.replications[id], addr
+		rf.goFunc(func() { rf.replicationTo(span.Context(), replication, addr, finishCh, stopCh, EntryReplicate) })
 	}
 
 	// 新日志成功发送到过半 Follower 节点，提交本地的日志
+	var sendErr error
 	majorityFinishCh := make(chan bool)
 	go func() {
 		count := 0
-		successCnt := 0
-		sent := false
-		after := time.After(rf.timerState.heartbeatDuration())
+		acks := make(map[NodeId]bool)
+		after := rf.clock.After(rf.timerState.heartbeatDuration())
 		for {
 			select {
 			case <-after:
-				replyErr = fmt.Errorf("等待响应结果超时")
-				rf.logger.Error(replyErr.Error())
-				if !sent {
-					majorityFinishCh <- false
-					sent = true
-				}
+				sendErr = fmt.Errorf("等待响应结果超时")
+				rf.logger.Error(sendErr.Error())
+				majorityFinishCh <- false
 				return
 			case msg := <-finishCh:
 				if msg.msgType == Degrade {
@@ -1114,33 +2316,24 @@ func (rf *raft) handleClientCmd(rpcMsg rpc) {
 					if rf.becomeFollower(msg.term) {
 						rf.logger.Trace("降级成功")
 					}
-					replyErr = fmt.Errorf("节点降级")
-					if !sent {
-						majorityFinishCh <- false
-						sent = true
-					}
+					sendErr = fmt.Errorf("节点降级")
+					majorityFinishCh <- false
 					return
 				}
 				if msg.msgType == Success {
 					rf.logger.Trace(fmt.Sprintf("接收到 id=%s 的成功响应", msg.id))
-					successCnt += 1
+					acks[msg.id] = true
 				}
-				if successCnt >= rf.peerState.majority() {
+				if rf.peerState.isQuorum(acks) {
 					rf.logger.Trace("请求已成功发送给多数节点")
-					if !sent {
-						majorityFinishCh <- true
-						sent = true
-					}
+					majorityFinishCh <- true
 					return
 				}
 				count += 1
 				if count >= rf.peerState.peersCnt() {
 					rf.logger.Trace("rpc 完成，所有节点都已返回响应")
-					if !sent {
-						replyErr = fmt.Errorf("日志未送达多数节点")
-						majorityFinishCh <- false
-						sent = true
-					}
+					sendErr = fmt.Errorf("日志未送达多数节点")
+					majorityFinishCh <- false
 					return
 				}
 			}
@@ -1149,9 +2342,7 @@ func (rf *raft) handleClientCmd(rpcMsg rpc) {
 
 	success := <-majorityFinishCh
 	if !success {
-		replyErr = fmt.Errorf("日志发送未成功！")
-		rf.logger.Error(replyErr.Error())
-		return
+		return fmt.Errorf("日志发送未成功！%w", sendErr)
 	}
 
 	// 将 commitIndex 设置为新条目的索引
@@ -1159,19 +2350,112 @@ func (rf *raft) handleClientCmd(rpcMsg rpc) {
 	rf.logger.Trace("Leader 更新 commitIndex")
 	rf.updateLeaderCommit()
 	rf.logger.Trace(fmt.Sprintf("commitIndex 日志更新为 %d", rf.softState.getCommitIndex()))
+	return nil
+}
 
-	// 应用状态机
-	applyErr := rf.applyFsm()
-	if applyErr != nil {
-		replyErr = applyErr
-		rf.logger.Error(replyErr.Error())
+// 新 Leader 添加并复制一条空日志
+// Leader 只能通过提交当前任期的日志来间接提交之前任期的日志，空日志提交后之前遗留的日志也随之提交
+func (rf *raft) appendNoop() {
+	if err := rf.addLeaderEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryNoop}); err != nil {
+		rf.logger.Error(fmt.Errorf("Leader 添加空日志失败：%w", err).Error())
+		return
+	}
+	rf.timerState.setHeartbeatTimer()
+	if err := rf.replicateEntries(nil); err != nil {
+		rf.logger.Error(err.Error())
+		return
 	}
+	rf.applyCommitted(nil)
+	rf.updateSnapshot()
+}
 
-	// 当日志量超过阈值时，生成快照
-	rf.logger.Trace("检查是否需要生成快照")
+// 处理客户端的屏障请求
+// 添加并复制一条空日志，提交后将之前的日志全部应用到状态机再返回
+func (rf *raft) handleBarrier(msg rpc) {
+	// 节点正在下线，不再接收新的请求
+	if !rf.shutdownState.accept() {
+		replyErr := DrainingError{Leader: rf.peerState.getLeader()}
+		rf.logger.Trace(replyErr.Error())
+		msg.res <- rpcReply{err: replyErr}
+		return
+	}
+	var replyErr error
+	// 日志提交后由等待应用结果的协程答复
+	waiting := false
+	defer func() {
+		if !waiting {
+			msg.res <- rpcReply{err: replyErr}
+			rf.shutdownState.finish()
+		}
+	}()
+
+	// 先处理批处理队列中的请求，保证屏障之前接收的请求先于屏障日志
+	rf.flushClientCmds()
+	if !rf.isLeader() {
+		replyErr = NotLeaderError{Leader: rf.peerState.getLeader()}
+		return
+	}
+
+	if err := rf.addLeaderEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryNoop}); err != nil {
+		replyErr = fmt.Errorf("Leader 添加屏障日志失败：%w", err)
+		rf.logger.Error(replyErr.Error())
+		return
+	}
+	barrierIndex := rf.lastEntryIndex()
+	rf.timerState.setHeartbeatTimer()
+	if err := rf.replicateEntries(nil); err != nil {
+		replyErr = err
+		rf.logger.Error(replyErr.Error())
+		return
+	}
+	// 屏障日志应用到状态机时，之前的日志都已应用
+	resultCh := rf.applyState.wait(barrierIndex)
+	rf.applyCommitted(nil)
 	rf.updateSnapshot()
 
-	replyRes.Status = OK
+	waiting = true
+	rf.goFunc(func() {
+		msg.res <- rpcReply{err: rf.waitApplied(resultCh).err}
+		rf.shutdownState.finish()
+	})
+}
+
+// 查询各 Learner 节点的日志追赶进度，包括非投票节点
+func (rf *raft) handleCatchUpProgress(msg rpc) {
+	learners := make(map[NodeId]LearnerProgress)
+	for id := range rf.leaderState.getReplications() {
+		if rf.leaderState.getFollowerRole(id) == Learner {
+			learners[id] = rf.leaderState.learnerProgress(id, rf.lastEntryIndex())
+		}
+	}
+	msg.res <- rpcReply{res: CatchUpProgressReply{Status: OK, Learners: learners}}
+}
+
+// 处理节点状态查询请求
+func (rf *raft) handleStatus(msg rpc) {
+	lastEntry := rf.lastEntry()
+	status := NodeStatus{
+		Id:            rf.peerState.myId(),
+		Role:          rf.roleState.getRoleStage(),
+		Term:          rf.hardState.currentTerm(),
+		Leader:        rf.peerState.getLeader(),
+		CommitIndex:   rf.softState.getCommitIndex(),
+		LastApplied:   rf.softState.getLastApplied(),
+		LastLogIndex:  lastEntry.Index,
+		LastLogTerm:   lastEntry.Term,
+		SnapshotIndex: rf.snapshotState.lastIndex(),
+		SnapshotTerm:  rf.snapshotState.lastTerm(),
+		Membership:    rf.peerState.membership(),
+		ReadOnly:      rf.readOnly(),
+	}
+	if status.Role == Leader {
+		status.Progress = make(map[NodeId]PeerProgress)
+		for id := range rf.leaderState.getReplications() {
+			status.Progress[id] = rf.leaderState.peerProgress(id, lastEntry.Index)
+		}
+		status.QuorumContact = rf.leaderState.quorumContact(rf.peerState.peers(), rf.peerState.myId(), rf.peerState.isQuorum, rf.clock.Now())
+	}
+	msg.res <- rpcReply{res: status}
 }
 
 // 处理添加 Learner 节点请求
@@ -1188,15 +2472,309 @@ func (rf *raft) handleLearnerAdd(msg rpc) {
 
 	// 将新节点添加到 replication 集合
 	for id, addr := range learners {
-		if _, ok := rf.leaderState.replications[id]; !ok {
-			// 开启复制循环
-			rf.logger.Trace(fmt.Sprintf("开启复制循环。id=%s", id))
-			replication := rf.newReplication(id, addr, Learner)
-			rf.leaderState.replications[id] = replication
-			go rf.addReplication(replication)
-			go func() { replication.triggerCh <- struct{}{} }()
+		rf.addLearnerReplication(id, addr)
+	}
+}
+
+// 开启 Learner 节点的复制循环，开始日志追赶
+func (rf *raft) addLearnerReplication(id NodeId, addr NodeAddr) {
+	if _, ok := rf.leaderState.replications[id]; ok {
+		return
+	}
+	rf.logger.Trace(fmt.Sprintf("开启复制循环。id=%s", id))
+	replication := rf.newReplication(id, addr, Learner)
+	rf.leaderState.replications[id] = replication
+	rf.goReplication(func() { rf.addReplication(replication) })
+	rf.goReplication(func() { rf.heartbeatLoop(replication) })
+	rf.goReplication(func() {
+		select {
+		case replication.triggerCh <- struct{}{}:
+		case <-replication.stopCh:
+		}
+	})
+}
+
+// 处理添加投票节点请求，单节点成员变更
+// 新节点先作为 Learner 追赶日志，追赶完成后升级为 Follower，再通过一条配置日志加入集群
+func (rf *raft) handleVoterAdd(msg rpc) {
+	args := msg.req.(AddVoter)
+	replyRes := AddVoterReply{}
+	var replyErr error
+	defer func() {
+		msg.res <- rpcReply{
+			res: replyRes,
+			err: replyErr,
+		}
+	}()
+
+	if _, ok := rf.peerState.peers()[args.Id]; ok {
+		rf.logger.Trace(fmt.Sprintf("节点 Id=%s 已是投票节点", args.Id))
+		replyRes.Status = OK
+		return
+	}
+	if rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		replyErr = ErrConfigChangePending
+		rf.logger.Trace(replyErr.Error())
+		return
+	}
+
+	// 日志追赶未完成时，不阻塞主循环，由客户端稍后重试
+	replication, ok := rf.leaderState.replications[args.Id]
+	if !ok {
+		rf.addLearnerReplication(args.Id, args.Addr)
+		replyErr = ErrLearnerCatchingUp
+		return
+	}
+	if rf.leaderState.getFollowerRole(args.Id) == Learner && !rf.leaderState.learnerProgress(args.Id, rf.lastEntryIndex()).Ready {
+		rf.logger.Trace(fmt.Sprintf("节点 Id=%s 未满足升级条件，继续日志追赶", args.Id))
+		rf.goFunc(func() {
+			select {
+			case replication.triggerCh <- struct{}{}:
+			case <-replication.stopCh:
+			}
+		})
+		replyErr = ErrLearnerCatchingUp
+		return
+	}
+
+	// Learner 升级为 Follower
+	if rf.leaderState.getFollowerRole(args.Id) == Learner {
+		finishCh := make(chan finishMsg)
+		stopCh := make(chan struct{})
+		rf.goFunc(func() { rf.replicationTo(nil, replication, args.Addr, finishCh, stopCh, EntryPromote) })
+		// 不能无限等待，Learner 不可达时主循环会停止处理心跳、投票和客户端请求
+		// 超时后关闭 stopCh，复制协程不再等待发送结果
+		var finish finishMsg
+		select {
+		case finish = <-finishCh:
+		case <-rf.clock.After(rf.timerState.heartbeatDuration()):
+			close(stopCh)
+			replyErr = fmt.Errorf("等待节点 Id=%s 升级为 Follower 超时：%w", args.Id, ErrTimeout)
+			rf.logger.Trace(replyErr.Error())
+			return
+		}
+		close(stopCh)
+		if finish.msgType != Success {
+			replyErr = fmt.Errorf("节点 Id=%s 升级为 Follower 失败", args.Id)
+			rf.logger.Trace(replyErr.Error())
+			return
+		}
+		rf.leaderState.setReplicationRole(args.Id, Follower)
+	}
+
+	peers := rf.peerState.peers()
+	newPeers := make(map[NodeId]NodeAddr, len(peers)+1)
+	for id, addr := range peers {
+		newPeers[id] = addr
+	}
+	newPeers[args.Id] = args.Addr
+	// 非投票节点可以显式添加为投票节点
+	nonVoters := make(map[NodeId]NodeAddr)
+	for id, addr := range rf.peerState.nonVoters() {
+		if id != args.Id {
+			nonVoters[id] = addr
+		}
+	}
+	if configErr := rf.appendConfig(newPeers, nonVoters); configErr != nil {
+		replyErr = configErr
+		rf.logger.Error(configErr.Error())
+		return
+	}
+	replyRes.Status = OK
+}
+
+// 处理添加非投票节点请求
+// 非投票节点以 Learner 角色复制日志，配置日志添加后立即生效，不需要等待日志追赶
+func (rf *raft) handleNonVoterAdd(msg rpc) {
+	args := msg.req.(AddNonVoter)
+	replyRes := AddNonVoterReply{}
+	var replyErr error
+	defer func() {
+		msg.res <- rpcReply{
+			res: replyRes,
+			err: replyErr,
+		}
+	}()
+
+	peers := rf.peerState.peers()
+	if _, ok := peers[args.Id]; ok {
+		replyErr = fmt.Errorf("节点 Id=%s 已经是投票节点", args.Id)
+		return
+	}
+	if rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		replyErr = ErrConfigChangePending
+		rf.logger.Trace(replyErr.Error())
+		return
+	}
+
+	rf.addLearnerReplication(args.Id, args.Addr)
+	nonVoters := rf.peerState.nonVoters()
+	newNonVoters := make(map[NodeId]NodeAddr, len(nonVoters)+1)
+	for id, addr := range nonVoters {
+		newNonVoters[id] = addr
+	}
+	newNonVoters[args.Id] = args.Addr
+	if configErr := rf.appendConfig(peers, newNonVoters); configErr != nil {
+		replyErr = configErr
+		rf.logger.Error(configErr.Error())
+		return
+	}
+	replyRes.Status = OK
+}
+
+// 处理移除节点请求，单节点成员变更
+func (rf *raft) handleServerRemove(msg rpc) {
+	args := msg.req.(RemoveServer)
+	replyRes := RemoveServerReply{}
+	var replyErr error
+	defer func() {
+		msg.res <- rpcReply{
+			res: replyRes,
+			err: replyErr,
+		}
+	}()
+
+	peers := rf.peerState.peers()
+	nonVoters := rf.peerState.nonVoters()
+	_, isVoter := peers[args.Id]
+	_, isNonVoter := nonVoters[args.Id]
+	if !isVoter && !isNonVoter {
+		// 不是集群成员，只需停止 Learner 的复制循环
+		rf.logger.Trace(fmt.Sprintf("节点 Id=%s 不是集群成员，停止复制循环", args.Id))
+		rf.removeReplication(args.Id)
+		replyRes.Status = OK
+		return
+	}
+	if isVoter && len(peers) <= 1 {
+		replyErr = fmt.Errorf("不能移除集群中的最后一个节点")
+		return
+	}
+	if rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		replyErr = ErrConfigChangePending
+		rf.logger.Trace(replyErr.Error())
+		return
+	}
+
+	newPeers := make(map[NodeId]NodeAddr, len(peers))
+	for id, addr := range peers {
+		if id != args.Id {
+			newPeers[id] = addr
+		}
+	}
+	newNonVoters := make(map[NodeId]NodeAddr, len(nonVoters))
+	for id, addr := range nonVoters {
+		if id != args.Id {
+			newNonVoters[id] = addr
 		}
 	}
+	if configErr := rf.appendConfig(newPeers, newNonVoters); configErr != nil {
+		replyErr = configErr
+		rf.logger.Error(configErr.Error())
+		return
+	}
+	replyRes.Status = OK
+
+	if rf.peerState.isMe(args.Id) {
+		// Leader 被移除，配置提交后退出
+		rf.logger.Trace("新配置中不包含当前节点，程序退出")
+		rf.exit()
+		return
+	}
+	rf.removeReplication(args.Id)
+}
+
+// 更新节点地址，节点身份不变
+// 集群成员的新地址通过一条成员不变的配置日志同步，各节点添加此日志时即使用新地址，
+// 成员不变，不需要联合共识；不是集群成员的 Learner 只更新复制循环使用的地址
+func (rf *raft) handlePeerAddressUpdate(msg rpc) {
+	args := msg.req.(UpdatePeerAddress)
+	replyRes := UpdatePeerAddressReply{}
+	var replyErr error
+	defer func() {
+		msg.res <- rpcReply{
+			res: replyRes,
+			err: replyErr,
+		}
+	}()
+
+	if args.Addr == "" {
+		replyErr = fmt.Errorf("节点 %s 的新地址不能为空", args.Id)
+		return
+	}
+	peers := rf.peerState.peers()
+	nonVoters := rf.peerState.nonVoters()
+	oldAddr, isVoter := peers[args.Id]
+	if !isVoter {
+		oldAddr = nonVoters[args.Id]
+	}
+	if _, isNonVoter := nonVoters[args.Id]; !isVoter && !isNonVoter {
+		if !rf.leaderState.setPeerAddr(args.Id, args.Addr) {
+			replyErr = fmt.Errorf("节点 %s 不是集群成员，也不是正在追赶日志的 Learner", args.Id)
+			return
+		}
+		rf.logger.Trace(fmt.Sprintf("Learner Id=%s 的地址更新为 %s", args.Id, args.Addr))
+		replyRes.Status = OK
+		return
+	}
+	if oldAddr == args.Addr {
+		replyRes.Status = OK
+		return
+	}
+	if rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		replyErr = ErrConfigChangePending
+		rf.logger.Trace(replyErr.Error())
+		return
+	}
+
+	newPeers := make(map[NodeId]NodeAddr, len(peers))
+	for id, addr := range peers {
+		newPeers[id] = addr
+	}
+	newNonVoters := make(map[NodeId]NodeAddr, len(nonVoters))
+	for id, addr := range nonVoters {
+		newNonVoters[id] = addr
+	}
+	if isVoter {
+		newPeers[args.Id] = args.Addr
+	} else {
+		newNonVoters[args.Id] = args.Addr
+	}
+	// 先更新复制循环的地址，配置日志直接发往新地址
+	rf.leaderState.setPeerAddr(args.Id, args.Addr)
+	if configErr := rf.appendConfig(newPeers, newNonVoters); configErr != nil {
+		rf.leaderState.setPeerAddr(args.Id, oldAddr)
+		replyErr = configErr
+		rf.logger.Error(configErr.Error())
+		return
+	}
+	rf.logger.Info(fmt.Sprintf("节点 Id=%s 的地址由 %s 更新为 %s", args.Id, oldAddr, args.Addr))
+	replyRes.Status = OK
+}
+
+// 单节点成员变更，添加一条新配置日志
+// 新配置在添加到日志时立即生效，复制到新配置的多数节点后提交，提交前不能开始下一次变更
+func (rf *raft) appendConfig(peers, nonVoters map[NodeId]NodeAddr) error {
+	data, encodeErr := encodeMembership(rf.codec, peers, nonVoters)
+	if encodeErr != nil {
+		return fmt.Errorf("新配置序列化失败！%w", encodeErr)
+	}
+	entry := Entry{Term: rf.hardState.currentTerm(), Type: EntryChangeConf, Data: data}
+	if addEntryErr := rf.addEntry(entry); addEntryErr != nil {
+		return fmt.Errorf("将配置添加到日志失败！%w", addEntryErr)
+	}
+	rf.peerState.setConfig(rf.lastEntryIndex(), peers, nonVoters)
+	rf.leaderState.setConfigIndex(rf.lastEntryIndex())
+	rf.onMembershipChange()
+	rf.logger.Trace(fmt.Sprintf("新配置已生效，Peers=%+v, NonVoters=%+v", peers, nonVoters))
+	return rf.replicateEntries(nil)
+}
+
+// 停止节点的复制循环
+func (rf *raft) removeReplication(id NodeId) {
+	if replication, ok := rf.leaderState.replications[id]; ok {
+		replication.stop()
+		delete(rf.leaderState.replications, id)
+	}
 }
 
 // 处理成员变更请求
@@ -1211,6 +2789,31 @@ func (rf *raft) handleConfigChange(msg rpc) {
 		}
 	}()
 
+	// 上一次成员变更的 C(new) 提交前，拒绝新的成员变更
+	if rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		replyErr = ErrConfigChangePending
+		rf.logger.Trace(replyErr.Error())
+		return
+	}
+	// 变更中途失败时，已添加的配置日志提交前仍由 configIndex 拒绝新的变更
+	rf.leaderState.setConfigInProgress(true)
+	defer rf.leaderState.setConfigInProgress(false)
+
+	// Learner 节点需满足升级条件
+	for id := range newConfig.Peers {
+		if rf.peerState.isMe(id) {
+			continue
+		}
+		if _, ok := rf.leaderState.replications[id]; !ok || rf.leaderState.getFollowerRole(id) != Learner {
+			continue
+		}
+		if !rf.leaderState.learnerProgress(id, rf.lastEntryIndex()).Ready {
+			replyErr = ErrLearnerCatchingUp
+			rf.logger.Trace(fmt.Sprintf("节点 Id=%s 未满足升级条件", id))
+			return
+		}
+	}
+
 	// 先将所有 Learner 节点升级为 Follower
 	promoteCh := make(chan finishMsg)
 	promoteCnt := 0
@@ -1219,7 +2822,8 @@ func (rf *raft) handleConfigChange(msg rpc) {
 			continue
 		}
 		promoteCnt += 1
-		go func(id NodeId, addr NodeAddr) {
+		replication, addr := rf.leaderState.replications[id], addr
+		rf.goFunc(func() {
 			finishCh := make(chan finishMsg)
 			stopCh := make(chan struct{})
 			defer func() {
@@ -1227,20 +2831,20 @@ func (rf *raft) handleConfigChange(msg rpc) {
 				close(finishCh)
 			}()
 			rf.logger.Trace("目标节点是 Learner 角色，发送 EntryPromote 请求")
-			go rf.replicationTo(id, addr, finishCh, stopCh, EntryPromote)
+			rf.goFunc(func() { rf.replicationTo(nil, replication, addr, finishCh, stopCh, EntryPromote) })
 			finish := <-finishCh
 			if finish.msgType == Success {
-				rf.leaderState.setReplicationRole(id, Follower)
+				replication.setRole(Follower)
 				rf.logger.Trace("目标节点升级为 Follower 成功")
 				promoteCh <- finishMsg{msgType: Success}
 			} else {
 				promoteCh <- finishMsg{msgType: Error}
 			}
-		}(id, addr)
+		})
 	}
 
 	for promoteCnt > 0 {
-		timer := time.After(rf.timerState.heartbeatDuration())
+		timer := rf.clock.After(rf.timerState.heartbeatDuration())
 		select {
 		case <-timer:
 			rf.logger.Trace("等待 Learner 升级超时")
@@ -1252,6 +2856,15 @@ func (rf *raft) handleConfigChange(msg rpc) {
 		}
 	}
 
+	// 非投票节点随配置日志一起分发，不参与升级和多数派计算
+	nonVoters := rf.peerState.nonVoters()
+	if newConfig.NonVoters != nil {
+		nonVoters = newConfig.NonVoters
+		for id, addr := range nonVoters {
+			rf.addLearnerReplication(id, addr)
+		}
+	}
+
 	// C(new) 配置
 	newPeers := newConfig.Peers
 	rf.leaderState.setNewConfig(newPeers)
@@ -1275,7 +2888,7 @@ func (rf *raft) handleConfigChange(msg rpc) {
 
 	// 分发 C(old,new) 配置
 	rf.logger.Trace("分发 C(old,new) 配置")
-	if oldNewConfigErr := rf.sendOldNewConfig(oldNewPeers); oldNewConfigErr != nil {
+	if oldNewConfigErr := rf.sendOldNewConfig(oldNewPeers, nonVoters); oldNewConfigErr != nil {
 		replyErr = oldNewConfigErr
 		rf.logger.Trace("C(old,new) 配置分发失败")
 		return
@@ -1283,7 +2896,7 @@ func (rf *raft) handleConfigChange(msg rpc) {
 
 	// 分发 C(new) 配置
 	rf.logger.Trace("分发 C(new) 配置")
-	if newConfigErr := rf.sendNewConfig(newPeers); newConfigErr != nil {
+	if newConfigErr := rf.sendNewConfig(newPeers, nonVoters); newConfigErr != nil {
 		replyErr = newConfigErr
 		rf.logger.Trace("C(new) 配置分发失败")
 		return
@@ -1294,64 +2907,244 @@ func (rf *raft) handleConfigChange(msg rpc) {
 	// 如果当前节点被移除，退出程序
 	if _, ok := peers[rf.peerState.myId()]; !ok {
 		rf.logger.Trace("新配置中不包含当前节点，程序退出")
-		go func() { rf.exitCh <- struct{}{} }()
+		rf.exit()
 		return
 	}
 	// 查看follower有没有被移除的
 	rf.logger.Trace("删除新配置中不包含的 replication")
 	followers := rf.leaderState.getReplications()
 	for id, f := range followers {
-		if _, ok := peers[id]; !ok {
-			f.stopCh <- struct{}{}
+		if !rf.peerState.isMember(id) {
+			f.stop()
 			delete(followers, id)
 		}
 	}
 	replyRes.Status = OK
 }
 
+// 结束领导权转移，答复客户端的领导权转移请求
+func (rf *raft) endTransfer(res TransferLeadershipReply, err error) {
+	if reply := rf.leaderState.finishTransfer(); reply != nil {
+		reply <- rpcReply{res: res, err: err}
+	}
+	rf.releaseTransferQueue(res.Transferee)
+}
+
+// 处理领导权转移期间暂存的客户端请求
+// 转移失败或被取消时，当前节点仍是 Leader，照常处理；否则答复 NotLeader，将客户端重定向到新的 Leader
+func (rf *raft) releaseTransferQueue(transferee Server) {
+	queue := rf.leaderState.takeTransferQueue()
+	if len(queue) <= 0 {
+		return
+	}
+	stopped := false
+	select {
+	case <-rf.shutdownState.stopCh:
+		stopped = true
+	default:
+	}
+	if rf.roleState.getRoleStage() == Leader && !stopped {
+		rf.logger.Trace(fmt.Sprintf("领导权转移结束，处理暂存的 %d 个客户端请求", len(queue)))
+		for _, msg := range queue {
+			rf.handleClientCmd(msg)
+		}
+		return
+	}
+	leader := transferee
+	if leader.Id == None {
+		leader = rf.peerState.getLeader()
+	}
+	rf.logger.Trace(fmt.Sprintf("领导权转移结束，将暂存的 %d 个客户端请求重定向到 Id=%s", len(queue), leader.Id))
+	for _, msg := range queue {
+		msg.res <- rpcReply{res: ApplyCommandReply{
+			Status: NotLeader,
+			Leader: leader,
+		}}
+	}
+}
+
 func (rf *raft) updateSnapshot() {
-	go func() {
+	rf.goFunc(func() {
 		if rf.needGenSnapshot() {
 			rf.logger.Trace("达成生成快照的条件")
-			// 从状态机生成快照
-			data, serializeErr := rf.fsm.Serialize()
-			if serializeErr != nil {
-				rf.logger.Error(fmt.Errorf("状态机生成快照失败！%w", serializeErr).Error())
-			}
-			rf.logger.Trace("状态机生成快照成功")
-			// 持久化快照
-			newSnapshot := Snapshot{
-				LastIndex: rf.softState.getLastApplied(),
-				LastTerm:  rf.hardState.currentTerm(),
-				Data:      data,
-			}
-			saveErr := rf.snapshotState.save(newSnapshot)
-			if saveErr != nil {
-				rf.logger.Error(fmt.Errorf("保存快照失败！%w", serializeErr).Error())
-			}
-			rf.logger.Trace("持久化快照成功")
-			// 清空日志
-			lastEntryType := rf.lastEntryType()
-			rf.logger.Trace("清空日志")
-			rf.hardState.clearEntries()
-			newEntry := Entry{
-				Index: newSnapshot.LastIndex,
-				Term:  newSnapshot.LastTerm,
-				Type:  lastEntryType,
-			}
-			if appendEntryErr := rf.hardState.appendEntry(newEntry); appendEntryErr != nil {
-				appendEntryErr = fmt.Errorf("添加新日志失败！")
-				rf.logger.Error(appendEntryErr.Error())
+			if _, err := rf.genSnapshot(); err != nil {
+				rf.logger.Error(err.Error())
 			}
 		}
+	})
+}
+
+// 处理客户端生成快照请求，不受压缩策略限制
+func (rf *raft) handleSnapshotCreate(msg rpc) {
+	replyRes := SnapshotMeta{}
+	var replyErr error
+	defer func() {
+		msg.res <- rpcReply{
+			res: replyRes,
+			err: replyErr,
+		}
 	}()
+
+	if rf.softState.getLastApplied() <= rf.snapshotState.lastIndex() {
+		rf.logger.Trace("没有新应用的日志，返回当前快照")
+	} else if _, err := rf.genSnapshot(); err != nil {
+		replyErr = err
+		rf.logger.Error(err.Error())
+		return
+	}
+	snapshot := rf.snapshotState.getSnapshot()
+	replyRes = SnapshotMeta{
+		LastIndex: snapshot.LastIndex,
+		LastTerm:  snapshot.LastTerm,
+		Size:      rf.snapshotState.dataSize(),
+		Version:   snapshot.Version,
+	}
+}
+
+// 从状态机生成快照，持久化后删除快照包含的日志
+// 暂停应用日志期间记录快照包含的最后一条日志，状态机实现 FsmSnapshotProvider 时只在此期间获取快照句柄，
+// 快照数据在恢复应用日志后写入；否则写入快照数据期间一直暂停应用日志
+func (rf *raft) genSnapshot() (Snapshot, error) {
+	// 同一时间只生成一个快照
+	rf.snapshotState.creating.Lock()
+	defer rf.snapshotState.creating.Unlock()
+	start := rf.clock.Now()
+	rf.applyState.pause()
+	lastIndex := rf.softState.getLastApplied()
+	if lastIndex <= rf.snapshotState.lastIndex() {
+		rf.applyState.resume()
+		rf.logger.Trace("没有新应用的日志，不生成快照")
+		return *rf.snapshotState.getSnapshot(), nil
+	}
+	// 快照的任期是其包含的最后一条日志的任期，而不是当前任期，否则压缩后的日志匹配检查会出错
+	head, headErr := rf.logEntry(lastIndex)
+	if headErr != nil {
+		rf.applyState.resume()
+		return Snapshot{}, fmt.Errorf("获取快照包含的最后一条日志 index=%d 失败！%w", lastIndex, headErr)
+	}
+	// 配置日志在应用时提交，暂停应用期间已提交的配置就是快照索引处生效的配置
+	header := Snapshot{LastIndex: lastIndex, LastTerm: head.Term, Version: rf.fsmVersion}
+	header.ConfigIndex, header.Config = rf.hardState.committedConfig()
+	var newSnapshot Snapshot
+	var saved bool
+	if provider, ok := rf.fsm.(FsmSnapshotProvider); ok {
+		handle, snapshotErr := provider.Snapshot()
+		rf.applyState.resume()
+		if snapshotErr != nil {
+			return Snapshot{}, fmt.Errorf("状态机生成快照失败！%w", snapshotErr)
+		}
+		defer handle.Release()
+		rf.logger.Trace(fmt.Sprintf("获取状态机快照句柄成功，index=%d，后台写入快照数据", lastIndex))
+		snapshot, ok, writeErr := rf.writeSnapshot(header, handle.Persist)
+		if writeErr != nil {
+			return Snapshot{}, writeErr
+		}
+		newSnapshot, saved = snapshot, ok
+	} else {
+		snapshot, ok, writeErr := rf.writeSnapshot(header, rf.serializeFsm)
+		rf.applyState.resume()
+		if writeErr != nil {
+			return Snapshot{}, writeErr
+		}
+		newSnapshot, saved = snapshot, ok
+	}
+	if !saved {
+		// 写入快照数据期间已安装了更新的快照
+		rf.logger.Trace(fmt.Sprintf("已有 index=%d 之后的快照，丢弃生成的快照", lastIndex))
+		return *rf.snapshotState.getSnapshot(), nil
+	}
+	rf.logger.Trace("持久化快照成功")
+	// 删除快照包含的日志，快照包含的最后一条日志作为日志头保留
+	rf.logger.Trace("删除快照包含的日志")
+	meta := rf.snapshotMeta(newSnapshot)
+	rf.metrics.Observe(MetricSnapshotDuration, rf.clock.Now().Sub(start).Seconds())
+	rf.metrics.SetGauge(MetricSnapshotSize, float64(meta.Size))
+	rf.notifyObservers(Event{Type: EventSnapshotCreated, Snapshot: meta})
+	if compactErr := rf.hardState.compact(head); compactErr != nil {
+		return newSnapshot, fmt.Errorf("删除日志失败！%w", compactErr)
+	}
+	return newSnapshot, nil
+}
+
+// 将状态机快照数据写入持久化器，header 是快照的元数据，返回的 bool 表示快照是否被保存，已有更新的快照时不保存
+func (rf *raft) writeSnapshot(header Snapshot, serializeTo func(io.Writer) error) (Snapshot, bool, error) {
+	if rf.snapshotState.isStream() {
+		// 状态机将快照数据直接写入存储
+		header.Version = ""
+		snapshot, saved, createErr := rf.snapshotState.create(header, serializeTo)
+		if createErr != nil {
+			return Snapshot{}, false, fmt.Errorf("状态机生成快照失败！%w", createErr)
+		}
+		return snapshot, saved, nil
+	}
+	buf := &bytes.Buffer{}
+	if serializeErr := serializeTo(buf); serializeErr != nil {
+		return Snapshot{}, false, fmt.Errorf("状态机生成快照失败！%w", serializeErr)
+	}
+	rf.logger.Trace("状态机生成快照成功")
+	// 持久化快照
+	snapshot := header
+	snapshot.Data = buf.Bytes()
+	saved, saveErr := rf.snapshotState.saveNewer(snapshot)
+	if saveErr != nil {
+		return Snapshot{}, false, fmt.Errorf("保存快照失败！%w", saveErr)
+	}
+	return snapshot, saved, nil
+}
+
+// 将状态机当前的数据写入 sink，状态机未实现 FsmSnapshotProvider 时使用，调用期间需暂停应用日志
+func (rf *raft) serializeFsm(sink io.Writer) error {
+	if rf.snapshotState.isStream() {
+		return rf.fsm.(FsmSnapshotter).SerializeTo(sink)
+	}
+	data, err := rf.fsm.Serialize()
+	if err != nil {
+		return err
+	}
+	_, err = sink.Write(data)
+	return err
+}
+
+// 使用接收到的快照恢复状态机
+// 先暂停应用协程，等待正在应用的日志批次完成，恢复状态机并将 lastApplied、commitIndex 推进到快照索引后再恢复应用协程，
+// 应用协程中尚未应用的快照包含的日志被跳过，快照之后的日志在恢复后的状态机上继续应用
+// 状态机已应用到快照索引及之后时不恢复，避免状态机回退后漏掉已应用过的日志
+func (rf *raft) restoreSnapshot(index int) error {
+	rf.applyState.pause()
+	defer rf.applyState.resume()
+	if lastApplied := rf.softState.getLastApplied(); index <= lastApplied {
+		rf.logger.Trace(fmt.Sprintf("状态机已应用到 index=%d，不使用 index=%d 的快照恢复", lastApplied, index))
+		return nil
+	}
+	if err := rf.restoreFsm(); err != nil {
+		return err
+	}
+	rf.softState.setLastApplied(index)
+	if rf.softState.getCommitIndex() < index {
+		rf.softState.setCommitIndex(index)
+	}
+	rf.applyState.skipTo(index, fmt.Errorf("index=%d 及之前的日志已包含在快照中：%w", index, ErrLogCompacted))
+	rf.logger.Trace(fmt.Sprintf("使用快照恢复状态机成功！lastApplied=%d", index))
+	return nil
+}
+
+func (rf *raft) restoreFsm() error {
+	if !rf.snapshotState.isStream() {
+		return rf.fsm.Restore(rf.snapshotState.getSnapshot().Data)
+	}
+	_, source, openErr := rf.snapshotState.open()
+	if openErr != nil {
+		return openErr
+	}
+	defer source.Close()
+	return rf.fsm.(FsmSnapshotter).RestoreFrom(source)
 }
 
 func (rf *raft) checkTransfer(id NodeId) {
 	select {
-	case <-rf.leaderState.transfer.timer:
+	case <-rf.leaderState.transferTimer():
 		rf.logger.Trace("领导权转移超时")
-		rf.leaderState.setTransferBusy(None)
+		rf.endTransfer(TransferLeadershipReply{}, fmt.Errorf("领导权转移未完成：%w", ErrTimeout))
 	default:
 		if rf.leaderState.isRpcBusy(id) {
 			// 若目标节点正在复制日志，则继续等待
@@ -1364,10 +3157,7 @@ func (rf *raft) checkTransfer(id NodeId) {
 				var replyRes TransferLeadershipReply
 				var replyErr error
 				defer func() {
-					rf.leaderState.transfer.reply <- rpcReply{
-						res: replyRes,
-						err: replyErr,
-					}
+					rf.endTransfer(replyRes, replyErr)
 				}()
 				rf.logger.Trace(fmt.Sprintf("目标节点 Id=%s 日志已是最新，发送 timeoutNow 消息", id))
 				finishCh := make(chan finishMsg)
@@ -1376,12 +3166,13 @@ func (rf *raft) checkTransfer(id NodeId) {
 					close(finishCh)
 					close(stopCh)
 				}()
-				go rf.replicationTo(id, rf.peerState.peers()[id], finishCh, stopCh, EntryTimeoutNow)
+				replication, addr := rf.leaderState.replications[id], rf.peerState.peers()[id]
+				rf.goFunc(func() { rf.replicationTo(nil, replication, addr, finishCh, stopCh, EntryTimeoutNow) })
 				msg := <-finishCh
 				if msg.msgType == Success {
 					rf.becomeFollower(rf.hardState.currentTerm())
-					rf.leaderState.setTransferBusy(None)
 					replyRes.Status = OK
+					replyRes.Transferee = Server{Id: id, Addr: addr}
 				} else {
 					replyErr = fmt.Errorf("所有权转移失败：%d", msg.msgType)
 				}
@@ -1389,24 +3180,26 @@ func (rf *raft) checkTransfer(id NodeId) {
 		} else {
 			// 目标节点不是最新，开始日志复制
 			rf.logger.Trace("目标节点不是最新，开始日志复制")
-			rf.leaderState.replications[id].triggerCh <- struct{}{}
+			rf.triggerCatchUp(id)
 		}
 	}
 }
 
-func (rf *raft) sendOldNewConfig(peers map[NodeId]NodeAddr) error {
+func (rf *raft) sendOldNewConfig(peers, nonVoters map[NodeId]NodeAddr) error {
 
-	oldNewPeersData, enOldNewErr := encodePeersMap(peers)
+	oldNewPeersData, enOldNewErr := encodeMembership(rf.codec, peers, nonVoters)
 	if enOldNewErr != nil {
 		return fmt.Errorf("序列化peers字典失败！%w", enOldNewErr)
 	}
 
-	// C(old,new)配置添加到状态
-	addEntryErr := rf.addEntry(Entry{Type: EntryChangeConf, Data: oldNewPeersData})
+	// C(old,new)配置添加到日志，添加后立即生效
+	addEntryErr := rf.addEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryChangeConf, Data: oldNewPeersData})
 	if addEntryErr != nil {
 		return fmt.Errorf("将配置添加到日志失败！%w", addEntryErr)
 	}
-	rf.peerState.replacePeers(peers)
+	rf.peerState.setConfig(rf.lastEntryIndex(), peers, nonVoters)
+	rf.leaderState.setConfigIndex(rf.lastEntryIndex())
+	rf.onMembershipChange()
 
 	// C(old,new)发送到各个节点
 	// 先给旧节点发，再给新节点发
@@ -1425,22 +3218,24 @@ func (rf *raft) sendOldNewConfig(peers map[NodeId]NodeAddr) error {
 	}
 }
 
-func (rf *raft) sendNewConfig(peers map[NodeId]NodeAddr) error {
+func (rf *raft) sendNewConfig(peers, nonVoters map[NodeId]NodeAddr) error {
 
 	// C(old,new)配置
 	oldNewPeers := rf.peerState.peers()
 
-	newPeersData, enOldNewErr := encodePeersMap(peers)
+	newPeersData, enOldNewErr := encodeMembership(rf.codec, peers, nonVoters)
 	if enOldNewErr != nil {
 		return fmt.Errorf("新配置序列化失败！%w", enOldNewErr)
 	}
 
-	// C(new)配置添加到状态
-	addEntryErr := rf.addEntry(Entry{Type: EntryChangeConf, Data: newPeersData})
+	// C(new)配置添加到日志，添加后立即生效
+	addEntryErr := rf.addEntry(Entry{Term: rf.hardState.currentTerm(), Type: EntryChangeConf, Data: newPeersData})
 	if addEntryErr != nil {
 		return fmt.Errorf("将配置添加到日志失败！%w", addEntryErr)
 	}
-	rf.peerState.replacePeers(peers)
+	rf.peerState.setConfig(rf.lastEntryIndex(), peers, nonVoters)
+	rf.leaderState.setConfigIndex(rf.lastEntryIndex())
+	rf.onMembershipChange()
 	rf.logger.Trace("替换掉当前节点的 Peers 配置")
 
 	// C(new)配置发送到各个节点
@@ -1455,13 +3250,14 @@ func (rf *raft) sendNewConfig(peers map[NodeId]NodeAddr) error {
 		}
 		// 发送日志
 		rf.logger.Trace(fmt.Sprintf("给 Id=%s 的节点发送配置", id))
-		go rf.replicationTo(id, addr, finishCh, stopCh, EntryChangeConf)
+		replication, addr := rf.leaderState.replications[id], addr
+		rf.goFunc(func() { rf.replicationTo(nil, replication, addr, finishCh, stopCh, EntryChangeConf) })
 	}
 
 	count := 1
-	successCnt := 1
+	acks := map[NodeId]bool{rf.peerState.myId(): true}
 	end := false
-	after := time.After(rf.timerState.heartbeatDuration())
+	after := rf.clock.After(rf.timerState.heartbeatDuration())
 	for !end {
 		select {
 		case <-after:
@@ -1475,10 +3271,10 @@ func (rf *raft) sendNewConfig(peers map[NodeId]NodeAddr) error {
 				}
 			}
 			if msg.msgType == Success {
-				successCnt += 1
+				acks[msg.id] = true
 			}
 			count += 1
-			if successCnt >= rf.peerState.majority() {
+			if rf.peerState.isQuorum(acks) {
 				rf.logger.Trace("已发送到大多数节点")
 				end = true
 				break
@@ -1507,13 +3303,14 @@ func (rf *raft) waitForConfig(peers map[NodeId]NodeAddr) bool {
 		}
 		// 发送日志
 		rf.logger.Trace(fmt.Sprintf("给节点 Id=%s 发送最新条目", id))
-		go rf.replicationTo(id, addr, finishCh, stopCh, EntryChangeConf)
+		replication, addr := rf.leaderState.replications[id], addr
+		rf.goFunc(func() { rf.replicationTo(nil, replication, addr, finishCh, stopCh, EntryChangeConf) })
 	}
 
 	count := 1
-	successCnt := 1
+	acks := map[NodeId]bool{rf.peerState.myId(): true}
 	end := false
-	after := time.After(rf.timerState.heartbeatDuration())
+	after := rf.clock.After(rf.timerState.heartbeatDuration())
 	for !end {
 		select {
 		case <-after:
@@ -1530,10 +3327,10 @@ func (rf *raft) waitForConfig(peers map[NodeId]NodeAddr) bool {
 			}
 			if result.msgType == Success {
 				rf.logger.Trace("接收到一个成功响应")
-				successCnt += 1
+				acks[result.id] = true
 			}
 			count += 1
-			if successCnt >= rf.peerState.majority() {
+			if rf.peerState.isQuorum(acks) {
 				rf.logger.Trace("多数节点已成功响应")
 				end = true
 				break
@@ -1552,31 +3349,30 @@ func (rf *raft) waitForConfig(peers map[NodeId]NodeAddr) bool {
 	return true
 }
 
-func encodePeersMap(peers map[NodeId]NodeAddr) ([]byte, error) {
-	var data bytes.Buffer
-	encoder := gob.NewEncoder(&data)
-	enErr := encoder.Encode(peers)
-	if enErr != nil {
-		return nil, enErr
-	}
-	return data.Bytes(), nil
-}
-
 // Leader 给某个节点发送心跳/日志
-func (rf *raft) replicationTo(id NodeId, addr NodeAddr, finishCh chan finishMsg, stopCh chan struct{}, entryType EntryType) {
+// r 由调用方在主循环中从 replications 取出，发送协程不读取 replications
+func (rf *raft) replicationTo(parent TraceContext, r *Replication, addr NodeAddr, finishCh chan finishMsg, stopCh chan struct{}, entryType EntryType) {
+	id := r.id
+	span := rf.tracer.StartSpan("raft.replicationTo", parent)
+	span.SetAttribute("raft.peer", string(id))
+	span.SetAttribute("raft.entry_type", EntryTypeToString(entryType))
 	var msg finishMsg
 	defer func() {
+		if msg.msgType != Success {
+			span.End(fmt.Errorf("给节点 %s 发送 %s 失败", id, EntryTypeToString(entryType)))
+		} else {
+			span.End(nil)
+		}
+		msg.id = id
 		select {
+		case finishCh <- msg:
 		case <-stopCh:
-		default:
-			msg.id = id
-			finishCh <- msg
 		}
 	}()
 
 	// 检查是否需要发送快照
 	rf.logger.Trace("检查是否需要发送快照")
-	if !rf.checkSnapshot(rf.leaderState.replications[id]) {
+	if !rf.checkSnapshot(r) {
 		rf.logger.Error("发送快照失败！")
 		msg = finishMsg{msgType: RpcFailed}
 		return
@@ -1585,10 +3381,22 @@ func (rf *raft) replicationTo(id NodeId, addr NodeAddr, finishCh chan finishMsg,
 	rf.logger.Trace(fmt.Sprintf("给节点 %s 发送 %s 类型的 entry", id, EntryTypeToString(entryType)))
 
 	// 发起 RPC 调用
-	prevIndex := rf.leaderState.nextIndex(id) - 1
+	prevIndex := r.getNextIndex() - 1
 	// 获取最新的日志
 	var entries []Entry
-	if entryType != EntryHeartbeat && entryType != EntryPromote && entryType != EntryTimeoutNow {
+	if entryType == EntryReplicate || entryType == EntryChangeConf {
+		// 发送 nextIndex 之后的全部日志，配置日志是最后一条
+		lastEntryIndex := rf.lastEntryIndex()
+		for index := prevIndex + 1; index <= lastEntryIndex; index++ {
+			entry, err := rf.logEntry(index)
+			if err != nil {
+				msg = finishMsg{msgType: Error}
+				rf.logger.Error(fmt.Errorf("获取 index=%d 日志失败 %w", index, err).Error())
+				return
+			}
+			entries = append(entries, entry)
+		}
+	} else if entryType != EntryHeartbeat && entryType != EntryPromote && entryType != EntryTimeoutNow {
 		lastEntryIndex := rf.lastEntryIndex()
 		entry, err := rf.logEntry(lastEntryIndex)
 		if err != nil {
@@ -1616,17 +3424,25 @@ func (rf *raft) replicationTo(id NodeId, addr NodeAddr, finishCh chan finishMsg,
 		PrevLogTerm:  prevTerm,
 		Entries:      entries,
 		LeaderCommit: rf.softState.getCommitIndex(),
+		TraceContext: span.Context(),
 	}
+	rf.witnessArgs(r, &args)
+	rf.compressArgs(r, &args)
 	res := &AppendEntryReply{}
 	rf.logger.Trace(fmt.Sprintf("发送的内容：%+v", args))
-	rpcErr := rf.transport.AppendEntries(addr, args, res)
+	ctx, cancel := rf.replicationContext(r)
+	rpcErr := rf.transport.AppendEntriesContext(ctx, addr, args, res)
+	cancel()
 
 	// 处理 RPC 调用结果
 	if rpcErr != nil {
-		rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w\n", addr, rpcErr).Error())
+		rf.peerFailed(r, addr, rpcErr)
 		msg = finishMsg{msgType: RpcFailed}
 		return
 	}
+	rf.peerReachable(r)
+	r.setCompression(res.Compression)
+	r.setWitness(res.Witness)
 
 	if res.Term > rf.hardState.currentTerm() {
 		// 当前任期数落后，降级为 Follower
@@ -1638,17 +3454,18 @@ func (rf *raft) replicationTo(id NodeId, addr NodeAddr, finishCh chan finishMsg,
 	if res.Success {
 		msg = finishMsg{msgType: Success, id: id}
 		if entryType == EntryReplicate {
-			rf.leaderState.matchAndNextIndexAdd(id)
+			matchIndex := prevIndex + len(entries)
+			rf.leaderState.setMatchAndNextIndex(r, matchIndex, matchIndex+1)
 		}
 		return
 	}
 
 	checkEntryType := entryType == EntryReplicate || entryType == EntryHeartbeat
-	checkProgress := rf.softState.getCommitIndex() > rf.leaderState.matchIndex(id)
-	if checkEntryType && checkProgress && !rf.leaderState.isRpcBusy(id) {
+	checkProgress := rf.softState.getCommitIndex() > r.getMatchIndex()
+	if checkEntryType && checkProgress {
+		// 不等待复制循环接收，复制循环已停止或正在追赶时不会阻塞
 		rf.logger.Trace(fmt.Sprintf("节点 id=%s 日志落后，开始 FindNextIndex 追赶", id))
-		rf.leaderState.replications[id].triggerCh <- struct{}{}
-		rf.logger.Trace("已触发 FindNextIndex 追赶")
+		rf.catchUp(r)
 	}
 }
 
@@ -1678,10 +3495,17 @@ func (rf *raft) replicate(s *Replication) bool {
 func (rf *raft) checkSnapshot(s *Replication) bool {
 	snapshot := rf.snapshotState.getSnapshot()
 	finishCh := make(chan finishMsg)
-	if rf.leaderState.nextIndex(s.id) <= snapshot.LastIndex {
+	if s.getNextIndex() <= snapshot.LastIndex {
 		rf.logger.Trace(fmt.Sprintf("节点 Id=%s 缺失的日志太多，直接发送快照", s.id))
-		go rf.snapshotTo(s.addr, finishCh, make(chan struct{}))
-		msg := <-finishCh
+		addr := s.peerAddr()
+		rf.goFunc(func() { rf.snapshotTo(s, addr, finishCh, s.stopCh) })
+		var msg finishMsg
+		select {
+		case msg = <-finishCh:
+		case <-s.stopCh:
+			rf.logger.Trace(fmt.Sprintf("节点 Id=%s 的复制循环已停止，不再等待快照发送结果", s.id))
+			return false
+		}
 		if msg.msgType != Success {
 			if msg.msgType == RpcFailed {
 				rf.logger.Error(fmt.Sprintf("对 id=%s 节点的 rpc 调用失败", s.id))
@@ -1696,7 +3520,7 @@ func (rf *raft) checkSnapshot(s *Replication) bool {
 			}
 		}
 		rf.logger.Trace("快照发送成功！")
-		rf.leaderState.setMatchAndNextIndex(s.id, snapshot.LastIndex, snapshot.LastIndex+1)
+		rf.leaderState.setMatchAndNextIndex(s, snapshot.LastIndex, snapshot.LastIndex+1)
 		if snapshot.LastIndex == rf.lastEntryIndex() {
 			rf.logger.Trace("快照后面没有新日志，日志追赶结束")
 			return true
@@ -1706,15 +3530,13 @@ func (rf *raft) checkSnapshot(s *Replication) bool {
 }
 
 func (rf *raft) findCorrectNextIndex(s *Replication) bool {
-	rl := rf.leaderState
-
-	for rl.nextIndex(s.id) > 0 {
+	for s.getNextIndex() > 0 {
 		select {
 		case <-s.stopCh:
 			return false
 		default:
 		}
-		nextIndex := rl.nextIndex(s.id)
+		nextIndex := s.getNextIndex()
 		prevIndex := nextIndex - 1
 		prevEntry, prevEntryErr := rf.logEntry(prevIndex)
 		if prevEntryErr != nil {
@@ -1732,12 +3554,18 @@ func (rf *raft) findCorrectNextIndex(s *Replication) bool {
 		}
 		res := &AppendEntryReply{}
 		rf.logger.Trace(fmt.Sprintf("给节点 Id=%s 发送日志：%+v", s.id, args))
-		err := rf.transport.AppendEntries(s.addr, args, res)
+		addr := s.peerAddr()
+		ctx, cancel := rf.replicationContext(s)
+		err := rf.transport.AppendEntriesContext(ctx, addr, args, res)
+		cancel()
 
 		if err != nil {
-			rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w\n", s.addr, err).Error())
+			rf.peerFailed(s, addr, err)
 			return false
 		}
+		rf.peerReachable(s)
+		s.setCompression(res.Compression)
+		s.setWitness(res.Witness)
 		rf.logger.Trace(fmt.Sprintf("接收到节点 id=%s 的应答 %+v", s.id, res))
 		// 如果任期数小，降级为 Follower
 		if res.Term > rf.hardState.currentTerm() {
@@ -1752,38 +3580,57 @@ func (rf *raft) findCorrectNextIndex(s *Replication) bool {
 			return true
 		}
 
-		conflictStartIndex := res.ConflictStartIndex
-		// Follower 日志是空的，则 nextIndex 置为 1
-		if conflictStartIndex <= 0 {
-			conflictStartIndex = 1
-		}
-		// conflictStartIndex 处的日志是一致的，则 nextIndex 置为下一个
-		if entry, entryErr := rf.logEntry(conflictStartIndex); entryErr != nil {
-			rf.logger.Error(fmt.Errorf("获取 index=%d 日志失败 %w", conflictStartIndex, entryErr).Error())
-			return false
-		} else if entry.Term == res.ConflictTerm {
-			conflictStartIndex += 1
+		conflictNextIndex := rf.conflictNextIndex(*res, nextIndex)
+		// Follower 缺失的日志已被压缩，改为发送快照，之后从快照的下一条日志继续查找
+		if conflictNextIndex <= rf.snapshotState.lastIndex() {
+			rf.logger.Trace(fmt.Sprintf("节点 Id=%s 缺失的日志已被压缩，发送快照", s.id))
+			s.setNextIndex(conflictNextIndex)
+			if !rf.checkSnapshot(s) {
+				return false
+			}
+			continue
 		}
 
 		// 向前继续查找 Follower 缺少的第一条日志的索引
-		rf.logger.Trace(fmt.Sprintf("设置节点 Id=%s 的 nextIndex 为 %d", s.id, conflictStartIndex))
-		rl.setNextIndex(s.id, conflictStartIndex)
+		rf.logger.Trace(fmt.Sprintf("设置节点 Id=%s 的 nextIndex 为 %d", s.id, conflictNextIndex))
+		s.setNextIndex(conflictNextIndex)
 	}
 	return true
 }
 
+// 根据 Follower 返回的冲突信息计算新的 nextIndex，一次跳过 Follower 一整个任期的冲突日志
+// Leader 有 ConflictTerm 的日志时，两者在此任期最后一条日志之前可能一致，跳到此任期最后一条日志之后；
+// 否则 Follower 此任期的日志全部冲突，跳到 ConflictStartIndex；ConflictTerm 为 0 表示 Follower 的日志较短
+// 返回值总是小于 nextIndex，冲突信息不准确时（如来自旧版本的节点）也能逐步回退
+func (rf *raft) conflictNextIndex(res AppendEntryReply, nextIndex int) int {
+	conflictNextIndex := res.ConflictStartIndex
+	if res.ConflictTerm > 0 {
+		if r, ok := rf.hardState.termRange(res.ConflictTerm); ok && r.last+1 < nextIndex {
+			conflictNextIndex = r.last + 1
+		}
+	}
+	if conflictNextIndex >= nextIndex {
+		conflictNextIndex = nextIndex - 1
+	}
+	// Follower 日志是空的，则 nextIndex 置为 1
+	if conflictNextIndex <= 0 {
+		conflictNextIndex = 1
+	}
+	return conflictNextIndex
+}
+
 func (rf *raft) findCorrectMatchIndex(s *Replication) bool {
 
 	rl := rf.leaderState
 	// 发送单个日志
-	for rl.nextIndex(s.id)-1 < rf.lastEntryIndex() {
+	for s.getNextIndex()-1 < rf.lastEntryIndex() {
 		select {
 		case <-s.stopCh:
 			return false
 		default:
 		}
 
-		nextIndex := rl.nextIndex(s.id)
+		nextIndex := s.getNextIndex()
 		prevIndex := nextIndex - 1
 		prevEntry, prevErr := rf.logEntry(prevIndex)
 		if prevErr != nil {
@@ -1806,65 +3653,302 @@ func (rf *raft) findCorrectMatchIndex(s *Replication) bool {
 			LeaderCommit: rf.softState.getCommitIndex(),
 			Entries:      entries,
 		}
+		rf.witnessArgs(s, &args)
+		rf.compressArgs(s, &args)
 		res := &AppendEntryReply{}
 		rf.logger.Trace(fmt.Sprintf("给 Id=%s 发送日志 %+v", s.id, args))
-		rpcErr := rf.transport.AppendEntries(s.addr, args, res)
+		addr := s.peerAddr()
+		ctx, cancel := rf.replicationContext(s)
+		rpcErr := rf.transport.AppendEntriesContext(ctx, addr, args, res)
+		cancel()
 
 		if rpcErr != nil {
-			rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w\n", s.addr, rpcErr).Error())
+			rf.peerFailed(s, addr, rpcErr)
+			return false
+		}
+		rf.peerReachable(s)
+		s.setCompression(res.Compression)
+		s.setWitness(res.Witness)
+		if res.Term > rf.hardState.currentTerm() {
+			rf.logger.Trace("任期数小，开始降级")
+			if rf.becomeFollower(res.Term) {
+				rf.logger.Trace("降级为 Follower 成功！")
+			}
 			return false
 		}
+
+		// 向后补充
+		matchIndex := s.getNextIndex()
+		rf.logger.Trace(fmt.Sprintf("设置节点 Id=%s 的状态：matchIndex=%d, nextIndex=%d", s.id, matchIndex, matchIndex+1))
+		rl.setMatchAndNextIndex(s, matchIndex, matchIndex+1)
+	}
+	return true
+}
+
+// 给某个节点发送快照
+// 快照按 SnapshotChunkSize 分块发送，rpc 调用失败时记录发送进度，下次从断点处继续发送
+func (rf *raft) snapshotTo(r *Replication, addr NodeAddr, finishCh chan finishMsg, stopCh chan struct{}) {
+	id := r.id
+	span := rf.tracer.StartSpan("raft.snapshotTo", nil)
+	span.SetAttribute("raft.peer", string(id))
+	var msg finishMsg
+	defer func() {
+		if msg.msgType != Success {
+			span.End(fmt.Errorf("给节点 %s 发送快照失败", id))
+		} else {
+			span.End(nil)
+		}
+		select {
+		case finishCh <- msg:
+		case <-stopCh:
+		}
+	}()
+	meta, source, openErr := rf.snapshotState.open()
+	if openErr != nil {
+		rf.logger.Error(openErr.Error())
+		msg = finishMsg{msgType: Error}
+		return
+	}
+	defer func() { source.Close() }()
+	dataLen := meta.Size
+	// 见证节点不运行状态机，只发送快照的元数据
+	if r.isWitness() {
+		dataLen = 0
+	}
+	chunkSize := int64(rf.snapshotState.chunkSize)
+	if chunkSize <= 0 {
+		chunkSize = dataLen
+	}
+	version := meta.Version
+	if version == "" {
+		version = rf.fsmVersion
+	}
+	// 从快照数据中读取 [offset, end) 范围的分块，偏移量回退时重新打开快照
+	// 读取的数据同时计算校验和，包括断点续传时跳过的数据，随最后一个分块发送
+	var readPos int64
+	sum := newSnapshotHash(meta.LastIndex, meta.LastTerm)
+	readChunk := func(offset, end int64) ([]byte, error) {
+		if offset < readPos {
+			reopened, err := rf.reopenSnapshot(meta, source)
+			if err != nil {
+				return nil, err
+			}
+			source, readPos = reopened, 0
+			sum = newSnapshotHash(meta.LastIndex, meta.LastTerm)
+		}
+		if _, err := io.CopyN(sum, source, offset-readPos); err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, end-offset)
+		if _, err := io.ReadFull(source, chunk); err != nil {
+			return nil, err
+		}
+		_, _ = sum.Write(chunk)
+		readPos = end
+		return chunk, nil
+	}
+
+	// 同一个快照上次未发送完成，从断点处继续发送
+	var offset int64
+	if index, sentOffset := r.snapshotProgress(); index == meta.LastIndex && sentOffset <= dataLen {
+		offset = sentOffset
+	}
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		end := offset + chunkSize
+		if end > dataLen {
+			end = dataLen
+		}
+		chunk, readErr := readChunk(offset, end)
+		if readErr != nil {
+			rf.logger.Error(fmt.Errorf("读取快照数据失败：%w", readErr).Error())
+			r.setSnapshotProgress(0, 0)
+			msg = finishMsg{msgType: Error}
+			return
+		}
+		// 限制发送速率，避免快照占满带宽影响心跳
+		if !rf.snapshotState.limiter.wait(len(chunk), stopCh) {
+			r.setSnapshotProgress(meta.LastIndex, offset)
+			return
+		}
+		args := InstallSnapshot{
+			Term:              rf.hardState.currentTerm(),
+			LeaderId:          rf.peerState.myId(),
+			LastIncludedIndex: meta.LastIndex,
+			LastIncludedTerm:  meta.LastTerm,
+			Offset:            offset,
+			Data:              chunk,
+			Done:              end >= dataLen,
+			TraceContext:      span.Context(),
+			FsmVersion:        version,
+		}
+		if args.Done {
+			// 快照可能包含配置日志，随最后一个分块发送快照中的配置，接收方据此更新集群成员
+			args.ConfigIndex, args.Config = rf.snapshotConfig(meta.LastIndex)
+			args.Checksum = nonZero(sum.Sum32())
+		}
+		var res InstallSnapshotReply
+		rf.logger.Trace(fmt.Sprintf("向节点 %s 发送快照分块：LastIncludedIndex=%d, Offset=%d, Size=%d, Done=%t",
+			addr, args.LastIncludedIndex, args.Offset, len(args.Data), args.Done))
+		ctx, cancel := rf.replicationContext(r)
+		err := rf.transport.InstallSnapshotContext(ctx, addr, args, &res)
+		cancel()
+		if err != nil {
+			rf.peerFailed(r, addr, err)
+			r.setSnapshotProgress(meta.LastIndex, offset)
+			msg = finishMsg{msgType: RpcFailed}
+			return
+		}
+		rf.peerReachable(r)
 		if res.Term > rf.hardState.currentTerm() {
-			rf.logger.Trace("任期数小，开始降级")
-			if rf.becomeFollower(res.Term) {
-				rf.logger.Trace("降级为 Follower 成功！")
-			}
-			return false
+			// 如果任期数小，降级为 Follower
+			rf.logger.Trace("任期数小，发送降级通知")
+			msg = finishMsg{msgType: Degrade, term: res.Term}
+			return
+		}
+		if args.Done && res.Offset >= dataLen {
+			break
 		}
+		// 以接收方期望的偏移量为准，继续发送
+		offset = res.Offset
+		if offset > dataLen {
+			offset = 0
+		}
+	}
+	r.setSnapshotProgress(0, 0)
+	rf.logger.Trace(fmt.Sprintf("快照在节点 %s 安装完毕", addr))
+	msg = finishMsg{msgType: Success, id: id}
+}
 
-		// 向后补充
-		matchIndex := rl.nextIndex(s.id)
-		rf.logger.Trace(fmt.Sprintf("设置节点 Id=%s 的状态：matchIndex=%d, nextIndex=%d", s.id, matchIndex, matchIndex+1))
-		rf.leaderState.setMatchAndNextIndex(s.id, matchIndex, matchIndex+1)
+// 两阶段关闭节点
+// 第一阶段停止接收新的客户端请求，等待已接收的请求提交并应用到状态机
+// 第二阶段通知主循环退出，等待其结束
+func (rf *raft) shutdown(ctx context.Context) error {
+	rf.logger.Trace("节点进入下线状态，不再接收新的客户端请求")
+	rf.shutdownState.startDraining()
+
+	// 等待已接收的请求处理完成
+	pendingCh := make(chan struct{})
+	go func() {
+		rf.shutdownState.pending.Wait()
+		close(pendingCh)
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("等待客户端请求处理完成超时：%w", ctx.Err())
+	case <-pendingCh:
 	}
-	return true
+	rf.logger.Trace("已接收的客户端请求全部处理完成")
+
+	// 等待已提交的日志全部应用到状态机
+	ticker := rf.clock.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for rf.softState.getLastApplied() < rf.softState.getCommitIndex() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待日志应用到状态机超时：%w", ctx.Err())
+		case <-ticker.C():
+		}
+	}
+	rf.logger.Trace("已提交的日志全部应用到状态机")
+
+	// 通知主循环退出，中止正在发送的 rpc 请求
+	rf.shutdownState.stop()
+	rf.rpcCancel()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("等待 raft 循环退出超时：%w", ctx.Err())
+	case <-rf.shutdownState.doneCh:
+	}
+	rf.logger.Trace("raft 循环已退出")
+
+	// 等待日志复制、rpc 调用、快照生成等后台协程退出
+	workersCh := make(chan struct{})
+	go func() {
+		rf.shutdownState.workers.Wait()
+		close(workersCh)
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("等待后台协程退出超时：%w", ctx.Err())
+	case <-workersCh:
+	}
+	rf.logger.Trace("后台协程已全部退出")
+
+	// 丢弃未接收完成的快照，将 raft 状态完整持久化一次
+	rf.snapshotState.cancelReceiving()
+	rf.persistApplyIndex(true)
+	if err := rf.hardState.flush(); err != nil {
+		return fmt.Errorf("节点关闭前持久化 raft 状态失败：%w", err)
+	}
+	rf.logger.Trace("节点已关闭")
+	return nil
 }
 
-func (rf *raft) snapshotTo(addr NodeAddr, finishCh chan finishMsg, stopCh chan struct{}) {
-	var msg finishMsg
-	defer func() {
+// Leader 的租约是否有效：最近一个最小选举超时时间内收到过法定人数节点的应答
+// 赢得选举即收到了法定人数节点的选票，刚当选时从当选的时刻开始计算
+// 租约过期时其它节点可能已选出新的 Leader
+func (rf *raft) leaseValid() bool {
+	now := rf.clock.Now()
+	contact := rf.leaderState.quorumContact(rf.peerState.peers(), rf.peerState.myId(), rf.peerState.isQuorum, now)
+	if contact.Before(rf.leaderState.electedAt) {
+		contact = rf.leaderState.electedAt
+	}
+	return now.Sub(contact) < rf.timerState.minElectionTimeout()
+}
+
+// 开启后台协程，节点关闭时等待其退出
+func (rf *raft) goFunc(f func()) {
+	rf.shutdownState.workers.Add(1)
+	go func() {
+		defer rf.shutdownState.workers.Done()
+		f()
+	}()
+}
+
+// 开启复制循环的协程，Leader 退位时等待其退出
+func (rf *raft) goReplication(f func()) {
+	rf.leaderState.workers.Add(1)
+	rf.goFunc(func() {
+		defer rf.leaderState.workers.Done()
+		f()
+	})
+}
+
+// 返回发送 rpc 结果的函数，stopCh 关闭后不再发送，避免协程阻塞
+func sendFinishMsg(finishCh chan finishMsg, stopCh <-chan struct{}, msg finishMsg) func() {
+	return func() {
 		select {
+		case finishCh <- msg:
 		case <-stopCh:
-		default:
-			finishCh <- msg
 		}
-	}()
-	snapshot := rf.snapshotState.getSnapshot()
-	args := InstallSnapshot{
-		Term:              rf.hardState.currentTerm(),
-		LeaderId:          rf.peerState.myId(),
-		LastIncludedIndex: snapshot.LastIndex,
-		LastIncludedTerm:  snapshot.LastTerm,
-		Offset:            0,
-		Data:              snapshot.Data,
-		Done:              true,
-	}
-	var res InstallSnapshotReply
-	rf.logger.Trace(fmt.Sprintf("向节点 %s 发送快照：%+v", addr, args))
-	err := rf.transport.InstallSnapshot(addr, args, &res)
+	}
+}
+
+// 通知程序退出，不阻塞主循环
+func (rf *raft) exit() {
+	select {
+	case rf.exitCh <- struct{}{}:
+	default:
+	}
+}
+
+// 关闭快照读取器并重新打开，快照已被替换时返回错误
+func (rf *raft) reopenSnapshot(meta SnapshotMeta, source io.ReadCloser) (io.ReadCloser, error) {
+	_ = source.Close()
+	newMeta, newSource, err := rf.snapshotState.open()
 	if err != nil {
-		rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w\n", addr, err).Error())
-		msg = finishMsg{msgType: RpcFailed}
-		return
+		return nil, err
 	}
-	if res.Term > rf.hardState.currentTerm() {
-		// 如果任期数小，降级为 Follower
-		rf.logger.Trace("任期数小，发送降级通知")
-		msg = finishMsg{msgType: Degrade, term: res.Term}
-		return
+	if newMeta.LastIndex != meta.LastIndex || newMeta.LastTerm != meta.LastTerm {
+		_ = newSource.Close()
+		return nil, fmt.Errorf("快照已被替换，LastIndex=%d", newMeta.LastIndex)
 	}
-	rf.logger.Trace(fmt.Sprintf("快照在节点 %s 安装完毕", addr))
-	msg = finishMsg{msgType: Success}
+	return newSource, nil
 }
 
 // 当前节点是不是 Leader
@@ -1876,10 +3960,19 @@ func (rf *raft) isLeader() bool {
 
 func (rf *raft) becomeLeader() bool {
 	rf.setRoleStage(Leader)
-	rf.peerState.setLeader(rf.peerState.myId())
+	rf.audit.checkSelfVote(rf.peerState.myId(), rf.hardState.voted(), rf.hardState.currentTerm())
 
-	// 给各个节点发送心跳，建立权柄
-	finishCh := make(chan finishMsg)
+	// 上一任期的复制进度已过期，重新初始化各节点的 nextIndex 和 matchIndex
+	rf.leaderState.resetReplications()
+	rf.leaderState.electedAt = rf.clock.Now()
+	rf.logger.Trace("重置各节点的日志复制进度")
+
+	// 开启日志复制循环，心跳使用各节点的 Replication 发送
+	rf.runReplication()
+	rf.logger.Trace("已开启全部节点日志复制循环")
+
+	// 给各个节点发送心跳，建立权柄，不读取结果，使用带缓冲的通道避免协程阻塞
+	finishCh := make(chan finishMsg, rf.peerState.peersCnt())
 	stopCh := make(chan struct{})
 	rf.logger.Trace("给各个节点发送心跳，建立权柄")
 	for id, addr := range rf.peerState.peers() {
@@ -1887,15 +3980,26 @@ func (rf *raft) becomeLeader() bool {
 			continue
 		}
 		rf.logger.Trace(fmt.Sprintf("给 Id=%s 发送心跳", id))
-		go rf.replicationTo(id, addr, finishCh, stopCh, EntryHeartbeat)
+		replication, addr := rf.leaderState.replications[id], addr
+		rf.goFunc(func() { rf.replicationTo(nil, replication, addr, finishCh, stopCh, EntryHeartbeat) })
 	}
 	rf.onRoleChange(Leader)
 	return true
 }
 
 func (rf *raft) becomeCandidate() bool {
+	if rf.witness {
+		rf.logger.Trace("见证节点不发起选举")
+		return false
+	}
+	if rf.readOnly() {
+		rf.logger.Trace("节点处于只读模式，不发起选举")
+		return false
+	}
 	// 角色置为候选者
 	rf.setRoleStage(Candidate)
+	// 开始选举后不再有已知的 Leader
+	rf.setLeader(None)
 	rf.onRoleChange(Candidate)
 	return true
 }
@@ -1917,115 +4021,297 @@ func (rf *raft) setRoleStage(stage RoleStage) {
 	rf.roleState.setRoleStage(stage)
 	rf.logger.Trace(fmt.Sprintf("角色设置为 %s", RoleToString(stage)))
 	if stage == Leader {
-		rf.peerState.setLeader(rf.peerState.myId())
+		rf.setLeader(rf.peerState.myId())
+	}
+}
+
+// 设置当前 Leader，Leader 变化时通知观察者
+func (rf *raft) setLeader(id NodeId) {
+	if rf.peerState.leaderId() == id {
+		return
 	}
+	rf.peerState.setLeader(id)
+	leader := rf.peerState.getLeader()
+	info := LeaderInfo{
+		Id:   leader.Id,
+		Addr: leader.Addr,
+		Term: rf.hardState.currentTerm(),
+		IsMe: id != None && rf.peerState.isMe(id),
+	}
+	rf.onLeaderChange(info)
+	rf.notifyObservers(Event{Type: EventLeaderChange, Term: info.Term, Leader: info})
 }
 
 // 添加新日志
 func (rf *raft) addEntry(entry Entry) error {
+	if err := rf.checkEntrySize(entry); err != nil {
+		return err
+	}
 	entry.Index = rf.lastEntryIndex() + 1
+	entry.Checksum = entryChecksum(entry)
 	rf.logger.Trace(fmt.Sprintf("日志条目索引 index=%d", entry.Index))
 	return rf.hardState.appendEntry(entry)
 }
 
-// 把日志应用到状态机
-func (rf *raft) applyFsm() (err error) {
+// Leader 添加新日志，只添加到内存，由 replicateEntries 在给各节点发送日志的同时写入磁盘
+func (rf *raft) addLeaderEntry(entry Entry) error {
+	if err := rf.checkEntrySize(entry); err != nil {
+		return err
+	}
+	entry.Index = rf.lastEntryIndex() + 1
+	entry.Checksum = entryChecksum(entry)
+	rf.logger.Trace(fmt.Sprintf("日志条目索引 index=%d", entry.Index))
+	rf.hardState.appendUnpersisted(entry)
+	return nil
+}
+
+// 将新提交的日志交给应用协程，不等待应用完成
+// 应用协程处理不及时、缓冲已满时等待，parent 为触发本次提交的请求的追踪上下文
+func (rf *raft) applyCommitted(parent TraceContext) {
+	rd := rf.collectReady(parent)
+	if len(rd.committed.entries) <= 0 {
+		return
+	}
+	if rf.runReady(rd) {
+		rf.advance(rd)
+	}
 	commitIndex := rf.softState.getCommitIndex()
-	lastApplied := rf.softState.getLastApplied()
+	rf.metrics.SetGauge(MetricCommitIndex, float64(commitIndex))
+	rf.metrics.SetGauge(MetricApplyLag, float64(commitIndex-rf.softState.getLastApplied()))
+}
+
+// 应用协程，按顺序将已提交的日志应用到状态机，状态机执行缓慢时不影响主循环的心跳和选举
+func (rf *raft) runApplier() {
+	for {
+		select {
+		case <-rf.shutdownState.stopCh:
+			return
+		case batch := <-rf.applyState.applyCh:
+			rf.applyFsm(batch)
+		}
+	}
+}
+
+// 把日志应用到状态机，并将各条日志的执行结果发送给等待的请求
+func (rf *raft) applyFsm(batch applyBatch) {
+	var err error
+	span := rf.tracer.StartSpan("raft.applyFsm", batch.trace)
+	span.SetAttribute("raft.entries", len(batch.entries))
+	defer func() { span.End(err) }()
+
+	results := make(map[int]applyResult, len(batch.entries))
+	record := func(index int, data []byte, applyErr error) {
+		results[index] = applyResult{data: data, err: applyErr}
+		if applyErr != nil && err == nil {
+			err = fmt.Errorf("应用状态机失败，%w", applyErr)
+		}
+	}
 
-	for commitIndex > lastApplied {
-		if entry, entryErr := rf.logEntry(lastApplied + 1); entryErr != nil {
-			err = fmt.Errorf("获取 index=%d 日志失败 %w", lastApplied+1, entryErr)
-			rf.logger.Error(err.Error())
+	// 状态机实现了 BatchingFsm 时，连续的普通日志一次应用
+	batching, isBatching := rf.fsm.(BatchingFsm)
+	pending := make([]Entry, 0)
+	flush := func() {
+		if len(pending) <= 0 {
 			return
+		}
+		errs := batching.ApplyBatch(pending)
+		for i, entry := range pending {
+			if len(errs) != len(pending) {
+				record(entry.Index, nil, fmt.Errorf("ApplyBatch 返回 %d 个结果，应为 %d 个", len(errs), len(pending)))
+			} else {
+				record(entry.Index, nil, errs[i])
+			}
+		}
+		rf.softState.setLastApplied(pending[len(pending)-1].Index)
+		pending = pending[:0]
+	}
+
+	rf.applyState.fsmMu.Lock()
+	lastApplied := rf.softState.getLastApplied()
+	for _, entry := range batch.entries {
+		if entry.Index <= lastApplied {
+			// 已使用快照恢复状态机，快照包含的日志不再应用
+			results[entry.Index] = applyResult{err: fmt.Errorf("index=%d 的日志已包含在快照中：%w", entry.Index, ErrLogCompacted)}
+			continue
+		}
+		lastApplied = entry.Index
+		if entry.Type == EntryReplicate && isBatching {
+			pending = append(pending, entry)
+			continue
+		}
+		// 配置日志和空日志之前的普通日志先应用
+		flush()
+		if entry.Type == EntryChangeConf {
+			// 配置日志提交后成为最终配置
+			rf.commitConfig(entry.Index, entry.Data)
+		}
+		data, applyErr := rf.fsm.Apply(AppliedEntry{Index: entry.Index, Term: entry.Term, Type: entry.Type, Data: entry.Data})
+		if entry.Type == EntryReplicate {
+			record(entry.Index, data, applyErr)
 		} else {
-			applyErr := rf.fsm.Apply(entry.Data)
+			// 配置日志和空日志只通知状态机，结果不返回给客户端
+			results[entry.Index] = applyResult{}
 			if applyErr != nil {
-				if err == nil {
-					err = fmt.Errorf("应用状态机失败，%w", applyErr)
-				} else {
-					err = fmt.Errorf("%w", err)
-				}
+				rf.logger.Error(fmt.Errorf("状态机应用 index=%d 的%s日志失败：%w", entry.Index, EntryTypeToString(entry.Type), applyErr).Error())
 			}
-			lastApplied = rf.softState.lastAppliedAdd()
 		}
+		rf.softState.setLastApplied(entry.Index)
 	}
+	flush()
+	rf.applyState.fsmMu.Unlock()
 
-	return
+	rf.applyState.notify(results)
+	rf.metrics.SetGauge(MetricLastApplied, float64(lastApplied))
+	rf.metrics.SetGauge(MetricApplyLag, float64(rf.softState.getCommitIndex()-lastApplied))
+	rf.persistApplyIndex(false)
+	if err != nil {
+		rf.logger.Error(fmt.Errorf("日志应用到状态机失败！%w", err).Error())
+	}
+}
+
+// 持久化提交索引和已应用索引，未达到持久化间隔时不保存，force 为 true 时总是保存
+func (rf *raft) persistApplyIndex(force bool) {
+	interval := rf.applyState.persistInterval
+	if interval <= 0 {
+		return
+	}
+	now := rf.clock.Now()
+	if !force && now.Sub(rf.applyState.persistedAt) < interval {
+		return
+	}
+	if err := rf.hardState.saveApplyIndex(rf.softState.getCommitIndex(), rf.softState.getLastApplied()); err != nil {
+		rf.logger.Error(err.Error())
+		return
+	}
+	rf.applyState.persistedAt = now
+}
+
+// 等待日志的应用结果，节点关闭时返回 ErrShutdown
+func (rf *raft) waitApplied(resultCh <-chan applyResult) applyResult {
+	select {
+	case result := <-resultCh:
+		return result
+	case <-rf.shutdownState.stopCh:
+		return applyResult{err: ErrShutdown}
+	}
+}
+
+// 配置日志已提交，当前节点从集群中被移除时退出程序
+// Leader 被移除时由成员变更的处理方法退出
+func (rf *raft) commitConfig(index int, data []byte) {
+	me := rf.peerState.myId()
+	wasMember := rf.peerState.isCommittedMember(me)
+	if err := rf.peerState.commitConfig(index, data); err != nil {
+		rf.logger.Error(fmt.Errorf("解析 index=%d 的配置日志失败 %w", index, err).Error())
+		return
+	}
+	rf.logger.Trace(fmt.Sprintf("index=%d 的配置已提交", index))
+	if err := rf.hardState.saveConfig(index, data); err != nil {
+		rf.logger.Error(err.Error())
+	}
+	if wasMember && !rf.peerState.isCommittedMember(me) && !rf.isLeader() {
+		rf.logger.Trace("已提交的配置中不包含当前节点，退出程序")
+		rf.exit()
+	}
 }
 
 // 更新 Leader 的提交索引
+// 只提交当前任期的日志，之前任期的日志在当前任期的日志提交时随之提交
+// 直接提交之前任期的日志，即使已复制到多数节点，也可能被之后的 Leader 覆盖
 func (rf *raft) updateLeaderCommit() {
-	commitIndexes := make([]int, 0)
+	matchIndexes := make(map[NodeId]int)
+	lastEntryIndex := rf.lastEntryIndex()
 	for id := range rf.peerState.peers() {
 		if rf.peerState.isMe(id) {
-			commitIndexes = append(commitIndexes, rf.softState.getCommitIndex())
+			// Leader 自己只有已写入磁盘的日志计入法定人数
+			matchIndexes[id] = lastEntryIndex
+			if unpersisted := rf.hardState.firstUnpersisted(); unpersisted > 0 {
+				matchIndexes[id] = unpersisted - 1
+			}
 		} else {
-			commitIndexes = append(commitIndexes, rf.leaderState.matchIndex(id))
+			matchIndex := rf.leaderState.matchIndex(id)
+			matchIndexes[id] = matchIndex
+			peer := Label{Name: "peer", Value: string(id)}
+			rf.metrics.SetGauge(MetricPeerMatchLag, float64(lastEntryIndex-matchIndex), peer)
+			rf.metrics.SetGauge(MetricPeerNextIndex, float64(rf.leaderState.nextIndex(id)), peer)
 		}
 	}
-	sort.Ints(commitIndexes)
-	rf.softState.setCommitIndex(commitIndexes[rf.peerState.majority()-1])
+	// 法定人数节点都已复制的最大索引
+	majorityIndex, ok := quorumIndex(matchIndexes, rf.peerState.isQuorum)
+	if !ok || majorityIndex <= rf.softState.getCommitIndex() {
+		return
+	}
+	entry, err := rf.logEntry(majorityIndex)
+	if err != nil {
+		rf.logger.Error(fmt.Errorf("获取 index=%d 日志失败 %w", majorityIndex, err).Error())
+		return
+	}
+	if entry.Term != rf.hardState.currentTerm() {
+		rf.logger.Trace(fmt.Sprintf("index=%d 的日志不是当前任期的日志，暂不提交。term=%d", majorityIndex, entry.Term))
+		return
+	}
+	rf.softState.setCommitIndex(majorityIndex)
 }
 
 func (rf *raft) needGenSnapshot() bool {
-	archiveThreshold := rf.softState.getCommitIndex()-rf.snapshotState.lastIndex() >= rf.snapshotState.logThreshold()
-	return archiveThreshold && rf.lastEntryType() != EntryChangeConf
+	if rf.lastEntryType() == EntryChangeConf {
+		return false
+	}
+	snapshotIndex := rf.snapshotState.lastIndex()
+	commitIndex := rf.softState.getCommitIndex()
+	stats := CompactionStats{
+		LogLength:         commitIndex - snapshotIndex,
+		LogBytes:          rf.hardState.entriesSize(snapshotIndex, commitIndex),
+		SinceLastSnapshot: rf.clock.Now().Sub(rf.snapshotState.lastCreated()),
+	}
+	return rf.snapshotState.shouldCompact(stats)
 }
 
 func (rf *raft) lastEntry() Entry {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
-	}
 	entry, _ := rf.hardState.logEntry(rf.hardState.logLength() - 1)
 	return entry
 }
 
 func (rf *raft) lastEntryIndex() int {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
-	}
 	entry, _ := rf.hardState.logEntry(rf.hardState.logLength() - 1)
 	return entry.Index
 }
 
 func (rf *raft) lastEntryTerm() int {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
-	}
 	entry, _ := rf.hardState.logEntry(rf.hardState.logLength() - 1)
 	return entry.Term
 }
 
 func (rf *raft) lastEntryType() (entryType EntryType) {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
-	}
 	entry, _ := rf.hardState.logEntry(rf.hardState.logLength() - 1)
 	return entry.Type
 }
 
-func (rf *raft) entryExist(index int) bool {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
+// 冲突任期在 until 及之前的第一条未压缩到快照中的日志的索引，Leader 据此回退 nextIndex
+// until 处日志的任期为 term，任期在日志中单调不减，term 的日志从返回值一直连续到 until
+func (rf *raft) conflictStartIndex(term, until int) int {
+	r, _ := rf.hardState.termRange(term)
+	start := r.first
+	if snapshotIndex := rf.snapshotState.lastIndex(); start <= snapshotIndex {
+		start = snapshotIndex + 1
 	}
-	return index > snapshot.LastIndex
+	if start > until {
+		start = until
+	}
+	return start
+}
+
+func (rf *raft) entryExist(index int) bool {
+	return index > rf.snapshotState.lastIndex()
 }
 
 func (rf *raft) logEntry(index int) (entry Entry, err error) {
-	snapshot := rf.snapshotState.getSnapshot()
-	if snapshot == nil {
-		log.Fatalln("快照不存在！")
-	}
-	if index < snapshot.LastIndex {
-		err = errors.New(fmt.Sprintf("索引 %d 小于等于快照索引 %d，不合法操作", index, snapshot.LastIndex))
+	// 以内存中第一条日志的索引换算，日志压缩未完成时也不会读错位置
+	firstIndex := rf.hardState.firstIndex()
+	if index < firstIndex {
+		err = fmt.Errorf("索引 %d 小于日志起始索引 %d：%w", index, firstIndex, ErrLogCompacted)
 	} else {
-		if iEntry, iEntryErr := rf.hardState.logEntry(index - snapshot.LastIndex); iEntryErr != nil {
+		if iEntry, iEntryErr := rf.hardState.logEntry(index - firstIndex); iEntryErr != nil {
 			err = fmt.Errorf(iEntryErr.Error())
 		} else {
 			entry = iEntry
@@ -2034,33 +4320,126 @@ func (rf *raft) logEntry(index int) (entry Entry, err error) {
 	return
 }
 
+// 与节点通信失败，只在首次失败和判定为不可达时打印错误日志，不可达的节点按指数退避探测
+func (rf *raft) peerFailed(r *Replication, addr NodeAddr, err error) {
+	// 复制循环停止或节点关闭时主动取消的调用，不是节点故障
+	if errors.Is(err, context.Canceled) {
+		rf.logger.Trace(fmt.Sprintf("rpc 调用已取消：%s", addr))
+		return
+	}
+	id := r.id
+	rf.notifyObservers(Event{Type: EventPeerFailure, Peer: id, Err: err})
+	failures := r.recordFailure(rf.clock.Now(), rf.timerState.heartbeatDuration())
+	switch {
+	case failures <= 1:
+		rf.logger.Error(fmt.Errorf("调用rpc服务失败：%s%w", addr, err).Error())
+	case failures == peerUnreachableFailures:
+		rf.logger.Error(fmt.Errorf("节点 Id=%s 连续 %d 次通信失败，判定为不可达：%w", id, failures, err).Error())
+		rf.notifyObservers(Event{Type: EventPeerUnreachable, Peer: id, Err: err})
+	default:
+		rf.logger.Trace(fmt.Sprintf("调用rpc服务失败：%s，连续失败 %d 次", addr, failures))
+	}
+}
+
+// 与节点通信成功，不可达的节点恢复时发送通知
+func (rf *raft) peerReachable(r *Replication) {
+	if r.recordSuccess(rf.clock.Now()) {
+		rf.logger.Info(fmt.Sprintf("节点 Id=%s 恢复通信", r.id))
+		rf.notifyObservers(Event{Type: EventPeerRecovered, Peer: r.id})
+	}
+}
+
+// 节点支持压缩且日志数据达到 CompressThreshold 时压缩请求中的日志，压缩失败时发送原始数据
+func (rf *raft) compressArgs(r *Replication, args *AppendEntry) {
+	if rf.compressAt <= 0 || len(args.Entries) <= 0 || !r.getCompression() {
+		return
+	}
+	if entriesDataSize(args.Entries) < rf.compressAt {
+		return
+	}
+	entries, err := compressEntries(args.Entries)
+	if err != nil {
+		rf.logger.Error(err.Error())
+		return
+	}
+	args.Entries = entries
+	args.Compressed = true
+}
+
+// 客户端命令的数据不能超过 MaxEntrySize，配置日志和空日志由 raft 生成，不做限制
+func (rf *raft) checkEntrySize(entry Entry) error {
+	if rf.maxEntrySize > 0 && entry.Type == EntryReplicate && len(entry.Data) > rf.maxEntrySize {
+		return EntryTooLargeError{Size: len(entry.Data), Limit: rf.maxEntrySize}
+	}
+	return nil
+}
+
+// 发送 rpc 请求使用的 ctx，超过 RpcTimeout 或节点关闭时取消
+func (rf *raft) rpcContext() (context.Context, context.CancelFunc) {
+	if rf.rpcTimeout > 0 {
+		return context.WithTimeout(rf.rpcCtx, rf.rpcTimeout)
+	}
+	return context.WithCancel(rf.rpcCtx)
+}
+
+// 复制循环中 rpc 调用的上下文，复制循环停止（Leader 退位、节点被移除或节点关闭）时随之取消
+func (rf *raft) replicationContext(r *Replication) (context.Context, context.CancelFunc) {
+	if r == nil {
+		return rf.rpcContext()
+	}
+	if rf.rpcTimeout > 0 {
+		return context.WithTimeout(r.ctx, rf.rpcTimeout)
+	}
+	return context.WithCancel(r.ctx)
+}
+
+// stopCh 关闭时随之取消的 rpc 上下文，Candidate 退出选举时结束未完成的投票请求
+func (rf *raft) stopContext(stopCh <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := rf.rpcContext()
+	rf.goFunc(func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+	return ctx, cancel
+}
+
 // 将当前索引及之后的日志删除
 func (rf *raft) truncateAfter(index int) (err error) {
 	if snapshot := rf.snapshotState.getSnapshot(); snapshot != nil {
 		if index <= snapshot.LastIndex {
-			err = errors.New(fmt.Sprintf("索引 %d 小于快照索引 %d，不合法操作", index, snapshot.LastIndex))
+			err = fmt.Errorf("索引 %d 小于快照索引 %d：%w", index, snapshot.LastIndex, ErrLogCompacted)
 		} else {
-			rf.hardState.truncateAfter(index - snapshot.LastIndex)
+			err = rf.hardState.truncateAfter(index - rf.hardState.firstIndex())
 		}
 	} else {
-		rf.hardState.truncateAfter(index)
+		err = rf.hardState.truncateAfter(index)
+	}
+	if err == nil && rf.peerState.getConfigIndex() >= index {
+		err = rf.rollbackConfig()
+		rf.onMembershipChange()
 	}
 	return
 }
 
-// 将当前索引之前的日志删除
-// 实际上保留了最后一个日志，此日志的 Index 和快照的 LastIndex 相同
-func (rf *raft) truncateBefore(index int) (err error) {
-	if snapshot := rf.snapshotState.getSnapshot(); snapshot != nil {
-		if index <= snapshot.LastIndex {
-			err = errors.New(fmt.Sprintf("索引 %d 小于快照索引 %d，不合法操作", index, snapshot.LastIndex))
-		} else {
-			rf.hardState.truncateBefore(index - snapshot.LastIndex)
+// 生效中的配置日志被截断，回退到剩余日志中最后一个配置，没有则回退到最后一个已提交的配置
+func (rf *raft) rollbackConfig() error {
+	committedIndex := rf.peerState.getCommittedIndex()
+	for i := rf.lastEntryIndex(); i > committedIndex && rf.entryExist(i); i-- {
+		entry, err := rf.logEntry(i)
+		if err != nil {
+			return fmt.Errorf("获取 index=%d 日志失败 %w", i, err)
+		}
+		if entry.Type == EntryChangeConf {
+			rf.logger.Trace(fmt.Sprintf("配置日志被截断，回退到 index=%d 的配置", i))
+			return rf.peerState.setConfigWithBytes(i, entry.Data)
 		}
-	} else {
-		rf.hardState.truncateBefore(index)
 	}
-	return
+	rf.logger.Trace(fmt.Sprintf("配置日志被截断，回退到已提交的配置 index=%d", committedIndex))
+	rf.peerState.rollbackConfig()
+	return nil
 }
 
 func (rf *raft) addRoleObserver(ob chan RoleStage) {
@@ -2069,13 +4448,128 @@ func (rf *raft) addRoleObserver(ob chan RoleStage) {
 	rf.roleObserver = append(rf.roleObserver, ob)
 }
 
+func (rf *raft) addLeaderObserver(ob chan LeaderInfo) {
+	rf.obMu.Lock()
+	defer rf.obMu.Unlock()
+	rf.leaderObserver = append(rf.leaderObserver, ob)
+}
+
+// 通知不阻塞，观察者来不及接收时丢弃旧的通知，只保留最新的 Leader 信息
+func (rf *raft) onLeaderChange(info LeaderInfo) {
+	rf.obMu.Lock()
+	defer rf.obMu.Unlock()
+	for _, ob := range rf.leaderObserver {
+		select {
+		case ob <- info:
+		default:
+			select {
+			case <-ob:
+			default:
+			}
+			select {
+			case ob <- info:
+			default:
+			}
+		}
+	}
+}
+
+func (rf *raft) addObserver(ob *Observer) {
+	rf.obMu.Lock()
+	defer rf.obMu.Unlock()
+	rf.observers = append(rf.observers, ob)
+}
+
+func (rf *raft) removeObserver(ob *Observer) {
+	rf.obMu.Lock()
+	defer rf.obMu.Unlock()
+	for i, o := range rf.observers {
+		if o == ob {
+			rf.observers = append(rf.observers[:i], rf.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// 给所有观察者发送事件，不阻塞
+// 未设置任期的事件使用当前任期，持有 HardState 锁时需自行设置任期
+func (rf *raft) notifyObservers(event Event) {
+	if event.Term == 0 && event.Type != EventTermChange {
+		event.Term = rf.hardState.currentTerm()
+	}
+	rf.obMu.Lock()
+	defer rf.obMu.Unlock()
+	for _, ob := range rf.observers {
+		ob.notify(event)
+	}
+}
+
+// 记录违反的不变量并通知观察者，term 为 0 时使用当前任期
+func (rf *raft) reportViolation(err InvariantError, term int) {
+	rf.logger.Error(err.Error())
+	rf.notifyObservers(Event{Type: EventInvariantViolated, Term: term, Err: err})
+}
+
+func (rf *raft) onMembershipChange() {
+	rf.notifyObservers(Event{Type: EventMembershipChange, Membership: rf.peerState.membership()})
+}
+
+// 运行时修改计时和日志压缩、批处理参数，其余配置项忽略
+// 超时时间在计时器下一次重置时生效，未设置自定义压缩策略时按新的阈值重新生成策略
+// 节点因磁盘故障或运维设置处于只读模式，不发起选举，驳回客户端命令
+func (rf *raft) readOnly() bool {
+	return rf.maintenance.isReadOnly() || rf.diskFault.readOnly()
+}
+
+// 运维设置只读模式，Leader 仍保持领导权
+func (rf *raft) setReadOnly(readOnly bool) {
+	if rf.maintenance.setReadOnly(readOnly) {
+		if readOnly {
+			rf.logger.Info("节点进入只读模式，不再接收客户端命令及发起选举")
+		} else {
+			rf.logger.Info("节点退出只读模式")
+		}
+	}
+}
+
+func (rf *raft) reloadConfig(config Config) error {
+	if err := validateTimeouts(config.ElectionMinTimeout, config.ElectionMaxTimeout, config.HeartbeatTimeout); err != nil {
+		return err
+	}
+	if config.MaxLogLength < 0 || config.MaxLogBytes < 0 || config.SnapshotInterval < 0 || config.MaxBatchWait < 0 || config.MaxBatchBytes < 0 {
+		return fmt.Errorf("MaxLogLength、MaxLogBytes、SnapshotInterval、MaxBatchWait、MaxBatchBytes 不能为负数")
+	}
+	rf.timerState.setTimeouts(config.ElectionMinTimeout, config.ElectionMaxTimeout, config.HeartbeatTimeout)
+	rf.snapshotState.setPolicy(newCompactionPolicy(config))
+	rf.leaderState.setBatchLimits(time.Millisecond*time.Duration(config.MaxBatchWait), config.MaxBatchBytes)
+	rf.logger.Info(fmt.Sprintf("配置已更新：electionTimeout=[%d, %d)ms，heartbeatTimeout=%dms，maxLogLength=%d，maxBatchWait=%dms，maxBatchBytes=%d",
+		config.ElectionMinTimeout, config.ElectionMaxTimeout, config.HeartbeatTimeout, config.MaxLogLength, config.MaxBatchWait, config.MaxBatchBytes))
+	return nil
+}
+
+// 快照的元数据
+func (rf *raft) snapshotMeta(snapshot Snapshot) SnapshotMeta {
+	return SnapshotMeta{
+		LastIndex: snapshot.LastIndex,
+		LastTerm:  snapshot.LastTerm,
+		Size:      rf.snapshotState.dataSize(),
+		Version:   snapshot.Version,
+	}
+}
+
 func (rf *raft) onRoleChange(role RoleStage) {
+	rf.metrics.SetGauge(MetricRole, float64(role))
+	rf.notifyObservers(Event{Type: EventRoleChange, Role: role})
 	if len(rf.roleObserver) <= 0 {
 		return
 	}
 	for _, ob := range rf.roleObserver {
-		go func(ob chan RoleStage) {
-			ob <- role
-		}(ob)
+		ob := ob
+		rf.goFunc(func() {
+			select {
+			case ob <- role:
+			case <-rf.shutdownState.stopCh:
+			}
+		})
 	}
 }
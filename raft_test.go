@@ -0,0 +1,756 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 使用内存持久化器创建 raft，state 为持久化器中已保存的状态
+func newTestRaft(t *testing.T, state RaftState) *raft {
+	t.Helper()
+	return newTestRaftWithSnapshot(t, state, Snapshot{})
+}
+
+// 持久化器中还保存了快照 snapshot，LastIndex 为 0 时不保存
+func newTestRaftWithSnapshot(t *testing.T, state RaftState, snapshot Snapshot) *raft {
+	t.Helper()
+	persister := newImMemRaftStatePersister()
+	if err := persister.SaveRaftState(state); err != nil {
+		t.Fatal(err)
+	}
+	snapshotPersister := newInMemSnapshotPersister()
+	if snapshot.LastIndex > 0 {
+		if err := snapshotPersister.SaveSnapshot(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf, err := newRaft(Config{
+		Fsm:                newKvFsm(),
+		RaftStatePersister: persister,
+		SnapshotPersister:  snapshotPersister,
+		Transport:          newInMemTransport(),
+		Logger:             nopLogger{},
+		Peers: map[NodeId]NodeAddr{
+			"node1": "127.0.0.1:9001",
+			"node2": "127.0.0.1:9002",
+			"node3": "127.0.0.1:9003",
+		},
+		Me:                 "node1",
+		Role:               Follower,
+		ElectionMinTimeout: 300,
+		ElectionMaxTimeout: 600,
+		HeartbeatTimeout:   30,
+		MaxLogLength:       64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rf
+}
+
+func TestHandleVoteReqLogRecency(t *testing.T) {
+	// 投票方日志：(index=1, term=1), (index=2, term=2)
+	state := RaftState{
+		Term: 2,
+		Entries: []Entry{
+			{},
+			{Index: 1, Term: 1},
+			{Index: 2, Term: 2},
+		},
+	}
+	tests := []struct {
+		name         string
+		lastLogIndex int
+		lastLogTerm  int
+		want         bool
+	}{
+		{name: "empty log", lastLogIndex: 0, lastLogTerm: 0, want: false},
+		{name: "older last term with longer log", lastLogIndex: 5, lastLogTerm: 1, want: false},
+		{name: "same last term with shorter log", lastLogIndex: 1, lastLogTerm: 2, want: false},
+		{name: "same last term and length", lastLogIndex: 2, lastLogTerm: 2, want: true},
+		{name: "same last term with longer log", lastLogIndex: 3, lastLogTerm: 2, want: true},
+		{name: "newer last term with shorter log", lastLogIndex: 1, lastLogTerm: 3, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newTestRaft(t, state)
+			resCh := make(chan rpcReply, 1)
+			rf.handleVoteReq(rpc{
+				rpcType: RequestVoteRpc,
+				req: RequestVote{
+					Term:         3,
+					CandidateId:  "node2",
+					LastLogIndex: tt.lastLogIndex,
+					LastLogTerm:  tt.lastLogTerm,
+				},
+				res: resCh,
+			})
+			reply := <-resCh
+			if reply.err != nil {
+				t.Fatal(reply.err)
+			}
+			if got := reply.res.(RequestVoteReply).VoteGranted; got != tt.want {
+				t.Fatalf("VoteGranted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReelectionAfterWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	cluster := newTestCluster(t, 3)
+	oldLeader := cluster.waitLeader(t, time.Second*5)
+	for i := 0; i < 5; i++ {
+		if _, ok := cluster.apply("put x 1"); !ok {
+			t.Fatal("apply failed")
+		}
+	}
+
+	// 隔离旧 Leader，剩余节点的日志都不为空，投票请求需携带最后一条日志的信息才能选出新 Leader
+	for id, chaos := range cluster.chaos {
+		if id == oldLeader {
+			for peer := range cluster.nodes {
+				chaos.Partition(cluster.addr(peer))
+			}
+		} else {
+			chaos.Partition(cluster.addr(oldLeader))
+		}
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		for id, node := range cluster.nodes {
+			if id != oldLeader && node.IsLeader() {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("no new leader elected after the old leader was partitioned")
+}
+
+func TestAddVoterUnreachableLearner(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	cluster := newTestCluster(t, 3)
+	leaderId := cluster.waitLeader(t, time.Second*5)
+	leader := cluster.nodes[leaderId]
+
+	cluster.addNode(t, "node4", Learner)
+	learnerAddr := cluster.addr("node4")
+	var learnerRes AddLearnerReply
+	if err := leader.AddLearner(AddLearner{Learners: map[NodeId]NodeAddr{"node4": learnerAddr}}, &learnerRes); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		var progressRes CatchUpProgressReply
+		if err := leader.CatchUpProgress(CatchUpProgress{}, &progressRes); err != nil {
+			t.Fatal(err)
+		}
+		if progressRes.Learners["node4"].Ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("learner did not catch up")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Learner 在升级时失去响应，未设置 RpcTimeout，请求会一直挂起
+	cluster.chaos[leaderId].AddRule(ChaosRule{Rpc: "AppendEntries", To: learnerAddr, Delay: time.Minute})
+	start := time.Now()
+	var voterRes AddVoterReply
+	err := leader.AddVoter(AddVoter{Id: "node4", Addr: learnerAddr}, &voterRes)
+	if err == nil && voterRes.Status == OK {
+		t.Fatal("AddVoter succeeded for an unreachable learner")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("AddVoter blocked for %s", elapsed)
+	}
+	if _, ok := cluster.apply("put x 1"); !ok {
+		t.Fatal("leader stopped serving clients after the promotion failed")
+	}
+}
+
+// 按 (任期, 条数) 依次生成日志，第一条是索引为 0 的空日志
+func termLog(runs ...[2]int) []Entry {
+	entries := []Entry{{}}
+	for _, run := range runs {
+		for i := 0; i < run[1]; i++ {
+			entries = append(entries, Entry{Index: len(entries), Term: run[0]})
+		}
+	}
+	return entries
+}
+
+func TestReadyAdvance(t *testing.T) {
+	rf := newTestRaft(t, RaftState{Term: 1, Entries: termLog([2]int{1, 3})})
+	indexes := func(rd ready) []int {
+		var indexes []int
+		for _, entry := range rd.committed.entries {
+			indexes = append(indexes, entry.Index)
+		}
+		return indexes
+	}
+	rf.softState.setCommitIndex(2)
+	rd := rf.collectReady(nil)
+	if got := fmt.Sprint(indexes(rd)); got != "[1 2]" {
+		t.Fatalf("committed = %s, want [1 2]", got)
+	}
+	// 未确认的 ready 再次计算时仍会返回
+	if got := fmt.Sprint(indexes(rf.collectReady(nil))); got != "[1 2]" {
+		t.Fatalf("committed before advance = %s, want [1 2]", got)
+	}
+	rf.advance(rd)
+	if got := indexes(rf.collectReady(nil)); len(got) != 0 {
+		t.Fatalf("committed after advance = %v, want none", got)
+	}
+	rf.softState.setCommitIndex(3)
+	if got := fmt.Sprint(indexes(rf.collectReady(nil))); got != "[3]" {
+		t.Fatalf("committed = %s, want [3]", got)
+	}
+}
+
+func TestTermRange(t *testing.T) {
+	rf := newTestRaft(t, RaftState{Term: 5, Entries: termLog([2]int{1, 3}, [2]int{3, 4}, [2]int{5, 2})})
+	tests := []struct {
+		term int
+		want termRange
+		ok   bool
+	}{
+		{term: 1, want: termRange{first: 1, last: 3}, ok: true},
+		{term: 2, ok: false},
+		{term: 3, want: termRange{first: 4, last: 7}, ok: true},
+		{term: 5, want: termRange{first: 8, last: 9}, ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := rf.hardState.termRange(tt.term)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Fatalf("termRange(%d) = %+v, %v, want %+v, %v", tt.term, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConflictNextIndexConverges(t *testing.T) {
+	tests := []struct {
+		name      string
+		leader    []Entry
+		follower  []Entry
+		wantMatch int // Leader 和 Follower 一致的最后一条日志的索引
+		maxRounds int // 找到一致的日志最多需要的请求次数
+	}{
+		{
+			// Follower 在 Leader 没有的任期中写入了大量日志
+			name:      "follower terms unknown to leader",
+			leader:    termLog([2]int{1, 10}, [2]int{3, 50}, [2]int{5, 50}, [2]int{7, 50}),
+			follower:  termLog([2]int{1, 10}, [2]int{2, 60}, [2]int{4, 60}, [2]int{6, 60}),
+			wantMatch: 10,
+			maxRounds: 4,
+		},
+		{
+			// Follower 的日志较短，第一次应答即跳到其最后一条日志之后
+			name:      "follower log shorter",
+			leader:    termLog([2]int{1, 10}, [2]int{3, 100}, [2]int{4, 100}),
+			follower:  termLog([2]int{1, 10}, [2]int{2, 5}),
+			wantMatch: 10,
+			maxRounds: 3,
+		},
+		{
+			// 两者都有任期 2 的日志，Follower 的更长，之后各自写入不同任期的日志
+			name:      "shared term with different lengths",
+			leader:    termLog([2]int{1, 10}, [2]int{2, 30}, [2]int{4, 40}, [2]int{6, 40}),
+			follower:  termLog([2]int{1, 10}, [2]int{2, 50}, [2]int{3, 50}, [2]int{5, 50}),
+			wantMatch: 40,
+			maxRounds: 4,
+		},
+		{
+			name:      "follower log is a prefix",
+			leader:    termLog([2]int{1, 10}, [2]int{2, 200}),
+			follower:  termLog([2]int{1, 10}, [2]int{2, 20}),
+			wantMatch: 30,
+			maxRounds: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaderTerm := tt.leader[len(tt.leader)-1].Term
+			leader := newTestRaft(t, RaftState{Term: leaderTerm, Entries: tt.leader})
+			follower := newTestRaft(t, RaftState{Term: tt.follower[len(tt.follower)-1].Term, Entries: tt.follower})
+
+			nextIndex := len(tt.leader)
+			for rounds := 1; ; rounds++ {
+				if rounds > tt.maxRounds {
+					t.Fatalf("nextIndex did not converge within %d rounds, nextIndex=%d", tt.maxRounds, nextIndex)
+				}
+				prevIndex := nextIndex - 1
+				resCh := make(chan rpcReply, 1)
+				follower.handleCommand(rpc{
+					rpcType: AppendEntryRpc,
+					req: AppendEntry{
+						EntryType:    EntryHeartbeat,
+						Term:         leaderTerm,
+						LeaderId:     "node1",
+						PrevLogIndex: prevIndex,
+						PrevLogTerm:  tt.leader[prevIndex].Term,
+					},
+					res: resCh,
+				})
+				reply := <-resCh
+				if reply.err != nil {
+					t.Fatal(reply.err)
+				}
+				res := reply.res.(AppendEntryReply)
+				if res.Success {
+					if prevIndex != tt.wantMatch {
+						t.Fatalf("matched at index %d, want %d", prevIndex, tt.wantMatch)
+					}
+					return
+				}
+				next := leader.conflictNextIndex(res, nextIndex)
+				if next >= nextIndex {
+					t.Fatalf("nextIndex did not move back: %d -> %d", nextIndex, next)
+				}
+				nextIndex = next
+			}
+		})
+	}
+}
+
+// rpc 调用阻塞到 ctx 被取消，用于检查协程能否随复制循环停止而退出
+type blockingTransport struct {
+	basicTransport
+	calls chan struct{}
+}
+
+func (tp blockingTransport) block(ctx context.Context) error {
+	select {
+	case tp.calls <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (tp blockingTransport) AppendEntriesContext(ctx context.Context, _ NodeAddr, _ AppendEntry, _ *AppendEntryReply) error {
+	return tp.block(ctx)
+}
+
+func (tp blockingTransport) RequestVoteContext(ctx context.Context, _ NodeAddr, _ RequestVote, _ *RequestVoteReply) error {
+	return tp.block(ctx)
+}
+
+func (tp blockingTransport) InstallSnapshotContext(ctx context.Context, _ NodeAddr, _ InstallSnapshot, _ *InstallSnapshotReply) error {
+	return tp.block(ctx)
+}
+
+// 等待 wg 归零，超时说明有协程泄漏
+func waitWorkers(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("goroutines did not exit")
+	}
+}
+
+func TestStopContext(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(stopCh chan struct{}, cancel context.CancelFunc)
+	}{
+		{name: "stopCh closed", stop: func(stopCh chan struct{}, _ context.CancelFunc) { close(stopCh) }},
+		{name: "ctx canceled", stop: func(_ chan struct{}, cancel context.CancelFunc) { cancel() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newTestRaft(t, RaftState{Entries: []Entry{{}}})
+			stopCh := make(chan struct{})
+			ctx, cancel := rf.stopContext(stopCh)
+			defer cancel()
+			tt.stop(stopCh, cancel)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("ctx not canceled")
+			}
+			waitWorkers(t, &rf.shutdownState.workers, time.Second)
+		})
+	}
+}
+
+func TestReplicationStopNoLeak(t *testing.T) {
+	rf := newTestRaft(t, RaftState{Term: 1, Entries: []Entry{{}, {Index: 1, Term: 1}}})
+	calls := make(chan struct{}, 16)
+	rf.transport = blockingTransport{calls: calls}
+	if err := rf.hardState.setTerm(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 当选后的心跳阻塞在 rpc 调用中
+	rf.becomeLeader()
+	replications := rf.leaderState.getReplications()
+	if len(replications) != 2 {
+		t.Fatalf("got %d replications, want 2", len(replications))
+	}
+	// 复制循环的日志追赶也阻塞在 rpc 调用中，此时心跳循环跳过忙节点
+	for _, r := range replications {
+		r.triggerCh <- struct{}{}
+		r.heartbeatCh <- struct{}{}
+	}
+	for i := 0; i < 2*len(replications); i++ {
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d rpc calls in flight", i)
+		}
+	}
+
+	// Leader 退位时停止复制循环，正在进行的 rpc 调用随之取消
+	for _, r := range replications {
+		r.stop()
+	}
+	waitWorkers(t, &rf.leaderState.workers, time.Second)
+	waitWorkers(t, &rf.shutdownState.workers, time.Second)
+}
+
+// 驳回全部请求的授权检查
+type denyAuthorizer struct{}
+
+func (denyAuthorizer) Authorize(ProposalInfo) error {
+	return errors.New("denied")
+}
+
+func TestHandleClientCmdRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(rf *raft)
+		wantStatus Status
+	}{
+		{name: "entry too large", setup: func(rf *raft) { rf.maxEntrySize = 4 }, wantStatus: Rejected},
+		{name: "read only", setup: func(rf *raft) { rf.setReadOnly(true) }, wantStatus: Rejected},
+		{name: "unauthorized", setup: func(rf *raft) { rf.authorizer = denyAuthorizer{} }, wantStatus: Rejected},
+		// 租约过期的 Leader 可能已被隔离，客户端应重试其它节点
+		{name: "lease expired", setup: func(rf *raft) { rf.leaseCheck = true }, wantStatus: NotLeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newTestRaft(t, RaftState{Entries: []Entry{{}}})
+			tt.setup(rf)
+			resCh := make(chan rpcReply, 1)
+			rf.handleClientCmd(rpc{rpcType: ApplyCommandRpc, req: ApplyCommand{Data: []byte("put k value")}, res: resCh})
+			reply := <-resCh
+			if reply.err == nil {
+				t.Fatal("expected an error")
+			}
+			if status := reply.res.(ApplyCommandReply).Status; status != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", status, tt.wantStatus)
+			}
+			// 驳回的请求已结束，不会阻塞节点下线
+			waitWorkers(t, &rf.shutdownState.pending, time.Second)
+			if len(rf.leaderState.takeProposals()) != 0 {
+				t.Fatal("rejected command was queued")
+			}
+		})
+	}
+}
+
+func TestProposalLeaseCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	// 复制协程记录节点应答的同时，主循环检查租约
+	cluster := newTestCluster(t, 3, func(config *Config) {
+		config.ProposalLeaseCheck = true
+	})
+	leaderId := cluster.waitLeader(t, time.Second*5)
+	leader := cluster.nodes[leaderId]
+	for i := 0; i < 20; i++ {
+		var res ApplyCommandReply
+		if err := leader.ApplyCommand(ApplyCommand{Data: []byte(fmt.Sprintf("put k%d v", i))}, &res); err != nil {
+			t.Fatalf("apply with a valid lease: %v", err)
+		}
+	}
+
+	// 隔离 Leader，租约过期后立即驳回客户端命令
+	for id, chaos := range cluster.chaos {
+		if id == leaderId {
+			for peer, addr := range cluster.peers {
+				if peer != leaderId {
+					chaos.Partition(addr)
+				}
+			}
+		} else {
+			chaos.Partition(cluster.addr(leaderId))
+		}
+	}
+	time.Sleep(time.Millisecond * 400)
+	start := time.Now()
+	var res ApplyCommandReply
+	err := leader.ApplyCommand(ApplyCommand{Data: []byte("put k v")}, &res)
+	if !errors.Is(err, ErrLeadershipLost) {
+		t.Fatalf("err = %v, want %v", err, ErrLeadershipLost)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*100 {
+		t.Fatalf("rejection took %s", elapsed)
+	}
+}
+
+func TestInstallSnapshotDuringHeartbeats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	// 快照分成很多小块发送，各分块之间穿插着心跳
+	cluster := newTestCluster(t, 3, func(config *Config) {
+		config.PreVote = true
+		config.MaxLogLength = 16
+		config.SnapshotChunkSize = 16
+	})
+	leaderId := cluster.waitLeader(t, time.Second*5)
+	leader := cluster.nodes[leaderId]
+	var followerId NodeId
+	for id := range cluster.nodes {
+		if id != leaderId {
+			followerId = id
+			break
+		}
+	}
+	follower := cluster.nodes[followerId].raft
+
+	// 隔离 Follower，期间的成员变更和新日志只能通过快照获取
+	followerAddr := cluster.addr(followerId)
+	for id, chaos := range cluster.chaos {
+		if id == followerId {
+			for peer, addr := range cluster.peers {
+				if peer != followerId {
+					chaos.Partition(addr)
+				}
+			}
+		} else {
+			chaos.Partition(followerAddr)
+		}
+	}
+	cluster.addNode(t, "node4", Learner)
+	var nonVoterRes AddNonVoterReply
+	if err := leader.AddNonVoter(AddNonVoter{Id: "node4", Addr: cluster.addr("node4")}, &nonVoterRes); err != nil || nonVoterRes.Status != OK {
+		t.Fatalf("AddNonVoter failed: %v", err)
+	}
+	configIndex := leader.raft.lastEntryIndex()
+	for i := 0; leader.raft.snapshotState.lastIndex() <= configIndex; i++ {
+		if i >= 200 {
+			t.Fatal("leader did not compact the config entry")
+		}
+		if _, ok := cluster.apply(fmt.Sprintf("put k%d %d", i%8, i)); !ok {
+			t.Fatal("apply failed")
+		}
+	}
+	// 恢复通信，Follower 安装快照期间检查 commitIndex 和 lastApplied 不回退
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		var commitIndex, lastApplied int
+		for {
+			select {
+			case <-stopCh:
+				errCh <- nil
+				return
+			default:
+			}
+			newCommitIndex, newLastApplied := follower.softState.getCommitIndex(), follower.softState.getLastApplied()
+			if newCommitIndex < commitIndex || newLastApplied < lastApplied {
+				errCh <- fmt.Errorf("commitIndex %d -> %d, lastApplied %d -> %d",
+					commitIndex, newCommitIndex, lastApplied, newLastApplied)
+				return
+			}
+			commitIndex, lastApplied = newCommitIndex, newLastApplied
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for _, chaos := range cluster.chaos {
+		chaos.Clear()
+	}
+	snapshotIndex := leader.raft.snapshotState.lastIndex()
+	deadline := time.Now().Add(time.Second * 10)
+	for follower.snapshotState.lastIndex() < snapshotIndex {
+		if time.Now().After(deadline) {
+			t.Fatal("follower did not install the snapshot")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// 快照之后的日志随下一次写入发送
+	if _, ok := cluster.apply("put last 1"); !ok {
+		t.Fatal("apply failed")
+	}
+	lastIndex := leader.raft.lastEntryIndex()
+	for follower.softState.getLastApplied() < lastIndex {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower lastApplied=%d, want %d", follower.softState.getLastApplied(), lastIndex)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// 快照中的成员配置已生效，状态机与 Leader 一致
+	if _, ok := follower.peerState.nonVoters()["node4"]; !ok {
+		t.Fatalf("follower nonVoters = %v, want node4", follower.peerState.nonVoters())
+	}
+	leaderData, err := leader.raft.fsm.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	followerData, err := follower.fsm.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(leaderData) != string(followerData) {
+		t.Fatalf("follower state %s, want %s", followerData, leaderData)
+	}
+}
+
+// 向 Follower 发送 AppendEntries 请求，返回应答
+func appendEntriesTo(t *testing.T, rf *raft, args AppendEntry) AppendEntryReply {
+	t.Helper()
+	resCh := make(chan rpcReply, 1)
+	rf.handleCommand(rpc{rpcType: AppendEntryRpc, req: args, res: resCh})
+	reply := <-resCh
+	if reply.err != nil {
+		t.Fatal(reply.err)
+	}
+	return reply.res.(AppendEntryReply)
+}
+
+func TestHeartbeatAdvancesCommit(t *testing.T) {
+	rf := newTestRaft(t, RaftState{Term: 1, Entries: termLog([2]int{1, 3})})
+	rf.goFunc(rf.runApplier)
+	defer func() {
+		rf.shutdownState.stop()
+		waitWorkers(t, &rf.shutdownState.workers, time.Second)
+	}()
+
+	// 日志已全部复制，之后只收到携带新 LeaderCommit 的心跳
+	res := appendEntriesTo(t, rf, AppendEntry{
+		EntryType:    EntryHeartbeat,
+		Term:         1,
+		LeaderId:     "node2",
+		PrevLogIndex: 3,
+		PrevLogTerm:  1,
+		LeaderCommit: 3,
+	})
+	if !res.Success {
+		t.Fatalf("heartbeat rejected: %+v", res)
+	}
+	if commitIndex := rf.softState.getCommitIndex(); commitIndex != 3 {
+		t.Fatalf("commitIndex = %d, want 3", commitIndex)
+	}
+	deadline := time.Now().Add(rf.timerState.heartbeatDuration())
+	for rf.softState.getLastApplied() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("lastApplied = %d after one heartbeat, want 3", rf.softState.getLastApplied())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLeaderCommitBeyondLastNewEntry(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       AppendEntry
+		wantCommit int
+	}{
+		{
+			// Follower 在 prevIndex 之后的日志未经这次请求确认，可能与 Leader 不一致
+			name:       "heartbeat",
+			args:       AppendEntry{EntryType: EntryHeartbeat, PrevLogIndex: 2, PrevLogTerm: 1, LeaderCommit: 10},
+			wantCommit: 2,
+		},
+		{
+			name: "replicate matching entry",
+			args: AppendEntry{EntryType: EntryReplicate, PrevLogIndex: 1, PrevLogTerm: 1, LeaderCommit: 10,
+				Entries: []Entry{{Index: 2, Term: 1}}},
+			wantCommit: 2,
+		},
+		{
+			// 新条目与 Follower 的日志冲突，截断之后只能提交到新条目
+			name: "replicate conflicting entry",
+			args: AppendEntry{EntryType: EntryReplicate, PrevLogIndex: 2, PrevLogTerm: 1, LeaderCommit: 10,
+				Entries: []Entry{{Index: 3, Term: 2}}},
+			wantCommit: 3,
+		},
+		{
+			name:       "leader commit behind",
+			args:       AppendEntry{EntryType: EntryHeartbeat, PrevLogIndex: 5, PrevLogTerm: 1, LeaderCommit: 4},
+			wantCommit: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := newTestRaft(t, RaftState{Term: 1, Entries: termLog([2]int{1, 5})})
+			tt.args.Term = 2
+			tt.args.LeaderId = "node2"
+			if res := appendEntriesTo(t, rf, tt.args); !res.Success {
+				t.Fatalf("request rejected: %+v", res)
+			}
+			if commitIndex := rf.softState.getCommitIndex(); commitIndex != tt.wantCommit {
+				t.Fatalf("commitIndex = %d, want %d", commitIndex, tt.wantCommit)
+			}
+		})
+	}
+}
+
+func TestRestoreFsmFromSnapshotOnStart(t *testing.T) {
+	// 重启前日志已压缩到 index=5，之后还有一条已提交的日志
+	state := RaftState{
+		Term: 1,
+		Entries: []Entry{
+			{Index: 5, Term: 1},
+			{Index: 6, Term: 1, Type: EntryReplicate, Data: []byte("put y 2")},
+		},
+	}
+	rf := newTestRaftWithSnapshot(t, state, Snapshot{LastIndex: 5, LastTerm: 1, Data: []byte(`{"x":"1"}`)})
+	if lastApplied := rf.softState.getLastApplied(); lastApplied != 5 {
+		t.Fatalf("lastApplied = %d, want 5", lastApplied)
+	}
+	if commitIndex := rf.softState.getCommitIndex(); commitIndex != 5 {
+		t.Fatalf("commitIndex = %d, want 5", commitIndex)
+	}
+	if queued := rf.applyState.getQueued(); queued != 5 {
+		t.Fatalf("queued = %d, want 5", queued)
+	}
+
+	// 快照之后的日志照常应用
+	rf.goFunc(rf.runApplier)
+	defer func() {
+		rf.shutdownState.stop()
+		waitWorkers(t, &rf.shutdownState.workers, time.Second)
+	}()
+	rf.softState.setCommitIndex(6)
+	rf.applyCommitted(nil)
+	deadline := time.Now().Add(time.Second)
+	for rf.softState.getLastApplied() < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("lastApplied = %d, want 6", rf.softState.getLastApplied())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	data, err := rf.fsm.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"x":"1","y":"2"}` {
+		t.Fatalf("fsm state = %s", data)
+	}
+}
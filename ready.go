@@ -0,0 +1,54 @@
+package raft
+
+import "fmt"
+
+// Ready/Advance 驱动方式
+// 核心逻辑只计算需要执行的 I/O，汇总为 ready，由驱动方执行后调用 advance 确认，
+// 未确认的 ready 在下一次计算时仍会返回，核心逻辑因此不依赖 I/O 的执行时机，可以单独测试
+// 目前 ready 只包含已提交待应用的日志，日志持久化和 rpc 发送仍由各角色循环直接执行
+
+// 核心逻辑产生的一批待执行的 I/O
+type ready struct {
+	committed applyBatch // 已提交、尚未交给应用协程的日志
+}
+
+// 计算当前需要执行的 I/O，不修改任何状态，parent 为触发本次提交的请求的追踪上下文
+func (rf *raft) collectReady(parent TraceContext) ready {
+	commitIndex := rf.softState.getCommitIndex()
+	queued := rf.applyState.getQueued()
+	if commitIndex <= queued {
+		return ready{}
+	}
+	entries := make([]Entry, 0, commitIndex-queued)
+	for index := queued + 1; index <= commitIndex; index++ {
+		entry, entryErr := rf.logEntry(index)
+		if entryErr != nil {
+			rf.logger.Error(fmt.Errorf("获取 index=%d 日志失败 %w", index, entryErr).Error())
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return ready{committed: applyBatch{entries: entries, trace: parent}}
+}
+
+// 执行 ready 中的 I/O，节点关闭时返回 false，此时不能确认
+// 应用协程处理不及时、缓冲已满时等待
+func (rf *raft) runReady(rd ready) bool {
+	if len(rd.committed.entries) <= 0 {
+		return true
+	}
+	select {
+	case rf.applyState.applyCh <- rd.committed:
+		return true
+	case <-rf.shutdownState.stopCh:
+		return false
+	}
+}
+
+// 确认 ready 已执行，之后计算的 ready 不再包含其中的内容
+func (rf *raft) advance(rd ready) {
+	if n := len(rd.committed.entries); n > 0 {
+		rf.applyState.setQueued(rd.committed.entries[n-1].Index)
+		rf.logger.Trace(fmt.Sprintf("已提交的日志交给应用协程，index=%d~%d", rd.committed.entries[0].Index, rd.committed.entries[n-1].Index))
+	}
+}
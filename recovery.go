@@ -0,0 +1,97 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 多数节点永久丢失时，在各个存活节点上手动恢复集群，节点需处于停止状态
+// peersJSON 是新的集群配置，格式与配置文件中的 peers 相同，如 {"id1": "addr1", "id2": "addr2"}
+// 新配置作为一条新任期的配置日志追加到本地日志，并作为已提交配置持久化，节点重启后直接使用新配置
+// 所有存活节点需要使用相同的配置进行恢复，恢复后重启节点即可重新选举出 Leader
+func RecoverCluster(config Config, persister RaftStatePersister, peersJSON []byte) error {
+	var peers map[NodeId]NodeAddr
+	if err := json.Unmarshal(peersJSON, &peers); err != nil {
+		return fmt.Errorf("解析集群配置失败：%w", err)
+	}
+	if len(peers) == 0 {
+		return fmt.Errorf("集群配置中没有节点")
+	}
+	if _, ok := peers[config.Me]; !ok {
+		return fmt.Errorf("集群配置中不包含当前节点 %s", config.Me)
+	}
+
+	raftState, loadErr := persister.LoadRaftState()
+	if loadErr != nil {
+		return fmt.Errorf("持久化器加载 RaftState 失败：%w", loadErr)
+	}
+	if len(raftState.Entries) == 0 {
+		return fmt.Errorf("节点没有可恢复的日志")
+	}
+
+	data, encodeErr := encodeMembership(codecOrDefault(config.Codec), peers, config.NonVoters)
+	if encodeErr != nil {
+		return fmt.Errorf("集群配置序列化失败！%w", encodeErr)
+	}
+	// 使用新的任期，避免与其它存活节点相同索引处的日志混淆
+	term := raftState.Term + 1
+	lastEntry := raftState.Entries[len(raftState.Entries)-1]
+	entry := Entry{Index: lastEntry.Index + 1, Term: term, Type: EntryChangeConf, Data: data}
+	entry.Checksum = entryChecksum(entry)
+
+	raftState.Term = term
+	raftState.VotedFor = None
+	raftState.Entries = append(raftState.Entries, entry)
+	raftState.Config = data
+	raftState.ConfigIndex = entry.Index
+	if saveErr := persister.SaveRaftState(raftState); saveErr != nil {
+		return fmt.Errorf("保存恢复后的 RaftState 失败：%w", saveErr)
+	}
+	return nil
+}
+
+// 离线校验节点持久化的日志，日志损坏时返回 CorruptError
+func VerifyRaftState(persister RaftStatePersister) error {
+	raftState, loadErr := persister.LoadRaftState()
+	if loadErr != nil {
+		return fmt.Errorf("持久化器加载 RaftState 失败：%w", loadErr)
+	}
+	return verifyEntries(raftState.Entries)
+}
+
+// 离线校验节点持久化的快照，快照损坏时返回 CorruptError
+// 快照损坏时需要清空快照和日志，作为新节点重新加入集群
+func VerifySnapshot(persister SnapshotPersister) error {
+	snapshot, loadErr := persister.LoadSnapshot()
+	if loadErr != nil {
+		return fmt.Errorf("持久化器加载快照失败：%w", loadErr)
+	}
+	return verifySnapshot(snapshot)
+}
+
+// 日志损坏时离线截断日志，从第一条损坏的日志开始全部丢弃，返回被丢弃的日志条数，节点需处于停止状态
+// 节点重启后从 Leader 重新复制被丢弃的日志。被丢弃的日志可能已提交，
+// 只有集群中其余节点构成多数且日志完好时才能安全使用，否则会丢失已提交的数据
+func TruncateCorruptLog(persister RaftStatePersister) (int, error) {
+	raftState, loadErr := persister.LoadRaftState()
+	if loadErr != nil {
+		return 0, fmt.Errorf("持久化器加载 RaftState 失败：%w", loadErr)
+	}
+	for i, entry := range raftState.Entries {
+		if entryIntact(entry) {
+			continue
+		}
+		if i == 0 {
+			// 第一条日志是日志压缩的标记，无法从 Leader 重新复制
+			return 0, fmt.Errorf("日志压缩标记已损坏，需要清空数据后重新加入集群：%w",
+				CorruptError{Target: "日志", FirstIndex: entry.Index, LastIndex: entry.Index})
+		}
+		dropped := len(raftState.Entries) - i
+		raftState.Entries = raftState.Entries[:i]
+		if saveErr := persister.SaveRaftState(raftState); saveErr != nil {
+			return 0, fmt.Errorf("保存截断后的 RaftState 失败：%w", saveErr)
+		}
+		return dropped, nil
+	}
+	return 0, nil
+}
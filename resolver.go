@@ -0,0 +1,165 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// 节点地址解析
+// Kubernetes 等环境中节点重启后 IP 可能改变，此时可使用稳定的 DNS 名称（如 StatefulSet 中 pod 的域名）作为节点地址
+// 设置 Config.PeerResolver 后，发送 rpc 前先将配置中的节点地址解析为实际地址并缓存，
+// rpc 失败时丢弃缓存的结果，下一次请求重新解析，节点 IP 改变后不需要修改集群成员
+// 集群成员、Leader 信息中保存的仍是配置中的地址
+
+// 节点地址解析器
+type PeerResolver interface {
+	// 将配置中的节点地址解析为 Transport 实际使用的地址
+	Resolve(ctx context.Context, addr NodeAddr) (NodeAddr, error)
+}
+
+// 使用 DNS 解析地址中的主机名，返回第一个 IP 和原端口，保留地址的协议前缀，tcp4、tcp6 地址只解析对应版本的 IP
+// 主机名已是 IP、unix 地址或无法解析的地址原样返回
+type DNSResolver struct {
+	Resolver *net.Resolver // 为 nil 时使用 net.DefaultResolver
+}
+
+// 地址的网络类型对应的 IP 类型
+var ipNetworks = map[string]string{
+	"tcp":  "ip",
+	"tcp4": "ip4",
+	"tcp6": "ip6",
+}
+
+func (r DNSResolver) Resolve(ctx context.Context, addr NodeAddr) (NodeAddr, error) {
+	network, address, parseErr := ParseNodeAddr(addr)
+	if parseErr != nil || network == "unix" {
+		return addr, nil
+	}
+	host, port, _ := net.SplitHostPort(address)
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIP(ctx, ipNetworks[network], host)
+	if err != nil {
+		return "", fmt.Errorf("解析节点地址 %s 失败：%w", addr, err)
+	}
+	if len(ips) <= 0 {
+		return "", fmt.Errorf("解析节点地址 %s 失败：没有可用的 IP", addr)
+	}
+	prefix := strings.TrimSuffix(string(addr), address)
+	return NodeAddr(prefix + net.JoinHostPort(ips[0].String(), port)), nil
+}
+
+// 根据名称获取内置的地址解析器，名称为空时返回 nil，不解析
+func PeerResolverByName(name string) (PeerResolver, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "dns":
+		return DNSResolver{}, nil
+	}
+	return nil, fmt.Errorf("不支持的节点地址解析器：%s", name)
+}
+
+// 缓存解析结果的解析器
+type peerResolver struct {
+	resolver PeerResolver
+	cache    map[NodeAddr]NodeAddr
+	mu       sync.Mutex
+}
+
+// 未设置 PeerResolver 时返回 nil，不解析
+func newPeerResolver(config Config) *peerResolver {
+	if config.PeerResolver == nil {
+		return nil
+	}
+	return &peerResolver{resolver: config.PeerResolver, cache: make(map[NodeAddr]NodeAddr)}
+}
+
+func (r *peerResolver) resolve(ctx context.Context, addr NodeAddr) (NodeAddr, error) {
+	r.mu.Lock()
+	resolved, ok := r.cache[addr]
+	r.mu.Unlock()
+	if ok {
+		return resolved, nil
+	}
+	resolved, err := r.resolver.Resolve(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.cache[addr] = resolved
+	r.mu.Unlock()
+	return resolved, nil
+}
+
+// rpc 失败时丢弃缓存的解析结果，下一次请求重新解析，返回 rpc 的错误
+// 请求被主动取消时不是地址的问题，保留缓存
+func (r *peerResolver) done(addr NodeAddr, err error) error {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		r.mu.Lock()
+		delete(r.cache, addr)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// 发送请求前解析节点地址
+type resolvingTransport struct {
+	transport ContextTransport
+	resolver  *peerResolver
+}
+
+func (tp *resolvingTransport) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	resolved, err := tp.resolver.resolve(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return tp.resolver.done(addr, tp.transport.AppendEntriesContext(ctx, resolved, args, res))
+}
+
+func (tp *resolvingTransport) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	resolved, err := tp.resolver.resolve(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return tp.resolver.done(addr, tp.transport.RequestVoteContext(ctx, resolved, args, res))
+}
+
+func (tp *resolvingTransport) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	resolved, err := tp.resolver.resolve(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return tp.resolver.done(addr, preVoteContext(tp.transport, ctx, resolved, args, res))
+}
+
+func (tp *resolvingTransport) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	resolved, err := tp.resolver.resolve(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return tp.resolver.done(addr, tp.transport.InstallSnapshotContext(ctx, resolved, args, res))
+}
+
+// 转发客户端命令前解析 Leader 地址
+type resolvingForwarder struct {
+	forwarder CommandForwarder
+	resolver  *peerResolver
+}
+
+func (f *resolvingForwarder) ApplyCommand(addr NodeAddr, args ApplyCommand, res *ApplyCommandReply) error {
+	resolved, err := f.resolver.resolve(context.Background(), addr)
+	if err != nil {
+		return err
+	}
+	return f.resolver.done(addr, f.forwarder.ApplyCommand(resolved, args, res))
+}
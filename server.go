@@ -0,0 +1,152 @@
+package raft
+
+import "time"
+
+// rpc 服务端，接收 Transport 实现解码后的请求，包装为 rpc 消息交给节点主循环处理，并返回应答
+// 与直接调用 Node 的同名方法相比，每个请求都有超时时间，主循环繁忙或请求长时间未完成时返回 ErrTimeout，
+// 服务端协程不会被无限期阻塞。客户端命令超时返回后仍可能被提交，客户端需自行去重
+type RpcServer struct {
+	node    *Node
+	timeout time.Duration
+}
+
+// 创建 rpc 服务端，timeout 为 0 时不限制请求的处理时间
+func NewRpcServer(node *Node, timeout time.Duration) *RpcServer {
+	return &RpcServer{
+		node:    node,
+		timeout: timeout,
+	}
+}
+
+// 其它节点发来的 rpc 请求
+
+func (s *RpcServer) AppendEntries(args AppendEntry, res *AppendEntryReply) error {
+	if err := s.node.raft.authenticate(args.LeaderId, args.AuthToken); err != nil {
+		return err
+	}
+	if msg := s.node.sendRpcTimeout(AppendEntryRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AppendEntryReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) RequestVote(args RequestVote, res *RequestVoteReply) error {
+	if err := s.node.raft.authenticate(args.CandidateId, args.AuthToken); err != nil {
+		return err
+	}
+	if msg := s.node.sendRpcTimeout(RequestVoteRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(RequestVoteReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) PreVote(args PreVote, res *PreVoteReply) error {
+	if err := s.node.raft.authenticate(args.CandidateId, args.AuthToken); err != nil {
+		return err
+	}
+	if msg := s.node.sendRpcTimeout(PreVoteRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(PreVoteReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) InstallSnapshot(args InstallSnapshot, res *InstallSnapshotReply) error {
+	if err := s.node.raft.authenticate(args.LeaderId, args.AuthToken); err != nil {
+		return err
+	}
+	if msg := s.node.sendRpcTimeout(InstallSnapshotRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(InstallSnapshotReply)
+		return nil
+	}
+}
+
+// 客户端发来的 rpc 请求
+
+func (s *RpcServer) ApplyCommand(args ApplyCommand, res *ApplyCommandReply) error {
+	if msg := s.node.sendRpcTimeout(ApplyCommandRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(ApplyCommandReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) ChangeConfig(args ChangeConfig, res *ChangeConfigReply) error {
+	if msg := s.node.sendRpcTimeout(ChangeConfigRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(ChangeConfigReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) TransferLeadership(args TransferLeadership, res *TransferLeadershipReply) error {
+	if msg := s.node.sendRpcTimeout(TransferLeadershipRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(TransferLeadershipReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) AddLearner(args AddLearner, res *AddLearnerReply) error {
+	if msg := s.node.sendRpcTimeout(AddLearnerRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AddLearnerReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) AddVoter(args AddVoter, res *AddVoterReply) error {
+	if msg := s.node.sendRpcTimeout(AddVoterRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AddVoterReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) AddNonVoter(args AddNonVoter, res *AddNonVoterReply) error {
+	if msg := s.node.sendRpcTimeout(AddNonVoterRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(AddNonVoterReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) RemoveServer(args RemoveServer, res *RemoveServerReply) error {
+	if msg := s.node.sendRpcTimeout(RemoveServerRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(RemoveServerReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) UpdatePeerAddress(args UpdatePeerAddress, res *UpdatePeerAddressReply) error {
+	if msg := s.node.sendRpcTimeout(UpdatePeerAddressRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(UpdatePeerAddressReply)
+		return nil
+	}
+}
+
+func (s *RpcServer) CatchUpProgress(args CatchUpProgress, res *CatchUpProgressReply) error {
+	if msg := s.node.sendRpcTimeout(CatchUpProgressRpc, args, s.timeout); msg.err != nil {
+		return msg.err
+	} else {
+		*res = msg.res.(CatchUpProgressReply)
+		return nil
+	}
+}
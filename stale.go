@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"fmt"
+	"time"
+)
+
+// 失联节点清理
+// 长期运行的集群中，宕机后不再恢复的投票节点仍计入法定人数，再有节点故障时集群就可能无法提交日志
+// 设置 Config.StalePeerPolicy 和 Config.StalePeerTimeout 后，Leader 在心跳计时器到期时检查各投票节点，
+// 判定为不可达且持续 StalePeerTimeout 以上未应答的节点，按策略移除或降级为非投票节点，并发出 EventPeerPruned 事件
+// 清理通过一条配置日志完成，与 RemoveServer 相同，上一次成员变更提交前不清理，每次只清理一个节点；
+// Leader 的租约过期时自身可能已被隔离，无法判断其它节点是否故障，也不清理
+
+// 失联节点的处理策略
+type StalePeerPolicy uint8
+
+const (
+	StalePeerKeep   StalePeerPolicy = iota // 保留失联节点，默认策略
+	StalePeerRemove                        // 将失联节点移出集群
+	StalePeerDemote                        // 将失联节点降级为非投票节点，恢复后继续复制日志，可通过 AddVoter 重新添加为投票节点
+)
+
+func StalePeerPolicyFromString(policy string) (StalePeerPolicy, error) {
+	switch policy {
+	case "", "keep":
+		return StalePeerKeep, nil
+	case "remove":
+		return StalePeerRemove, nil
+	case "demote":
+		return StalePeerDemote, nil
+	}
+	return StalePeerKeep, fmt.Errorf("不支持的失联节点处理策略：%s", policy)
+}
+
+func StalePeerPolicyToString(policy StalePeerPolicy) (name string) {
+	switch policy {
+	case StalePeerKeep:
+		name = "keep"
+	case StalePeerRemove:
+		name = "remove"
+	case StalePeerDemote:
+		name = "demote"
+	}
+	return
+}
+
+// 检查失联的投票节点，在 Leader 的心跳计时器到期时调用
+func (rf *raft) checkStalePeers() {
+	if rf.stalePolicy == StalePeerKeep || rf.staleTimeout <= 0 {
+		return
+	}
+	if _, busy := rf.leaderState.isTransferBusy(); busy {
+		return
+	}
+	if !rf.leaseValid() || rf.leaderState.configPending(rf.softState.getCommitIndex()) {
+		return
+	}
+	now := rf.clock.Now()
+	for id := range rf.peerState.peers() {
+		if rf.peerState.isMe(id) {
+			continue
+		}
+		since, unreachable := rf.leaderState.unreachableSince(id)
+		if !unreachable || now.Sub(since) < rf.staleTimeout {
+			continue
+		}
+		rf.pruneStalePeer(id, now.Sub(since))
+		return
+	}
+}
+
+// 按策略移除失联的投票节点或将其降级为非投票节点
+func (rf *raft) pruneStalePeer(id NodeId, lasting time.Duration) {
+	peers := rf.peerState.peers()
+	newPeers := make(map[NodeId]NodeAddr, len(peers))
+	for peerId, addr := range peers {
+		if peerId != id {
+			newPeers[peerId] = addr
+		}
+	}
+	nonVoters := rf.peerState.nonVoters()
+	newNonVoters := make(map[NodeId]NodeAddr, len(nonVoters)+1)
+	for peerId, addr := range nonVoters {
+		newNonVoters[peerId] = addr
+	}
+	demote := rf.stalePolicy == StalePeerDemote
+	if demote {
+		newNonVoters[id] = peers[id]
+		rf.leaderState.setReplicationRole(id, Learner)
+	}
+	if configErr := rf.appendConfig(newPeers, newNonVoters); configErr != nil {
+		if demote {
+			rf.leaderState.setReplicationRole(id, Follower)
+		}
+		rf.logger.Error(fmt.Errorf("清理失联节点 Id=%s 失败：%w", id, configErr).Error())
+		return
+	}
+	if demote {
+		rf.logger.Warn(fmt.Sprintf("节点 Id=%s 已失联 %s，降级为非投票节点", id, lasting))
+	} else {
+		rf.removeReplication(id)
+		rf.logger.Warn(fmt.Sprintf("节点 Id=%s 已失联 %s，移出集群", id, lasting))
+	}
+	rf.notifyObservers(Event{Type: EventPeerPruned, Term: rf.hardState.currentTerm(), Peer: id, Duration: lasting})
+}
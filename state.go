@@ -2,9 +2,11 @@ package raft
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"math/rand"
 	"sync"
 	"time"
@@ -56,6 +58,7 @@ func RoleToString(roleStage RoleStage) (role string) {
 
 type RoleState struct {
 	roleStage RoleStage  // 节点当前角色
+	transfer  bool       // 下一轮选举是否由领导权转移发起
 	mu        sync.Mutex // 角色并发访问锁
 }
 
@@ -77,15 +80,40 @@ func (st *RoleState) getRoleStage() RoleStage {
 	return st.roleStage
 }
 
+func (st *RoleState) setTransfer(transfer bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.transfer = transfer
+}
+
+// 获取并清除领导权转移标记
+func (st *RoleState) takeTransfer() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	transfer := st.transfer
+	st.transfer = false
+	return transfer
+}
+
 // ==================== HardState ====================
 
 // 需要持久化存储的状态
 type HardState struct {
-	term      int                // 当前时刻所处的 term
-	votedFor  NodeId             // 当前任期获得选票的 Candidate
-	entries   []Entry            // 当前节点保存的日志
-	persister RaftStatePersister // 持久化器
-	mu        sync.Mutex
+	term         int                // 当前时刻所处的 term
+	votedFor     NodeId             // 当前任期获得选票的 Candidate
+	entries      []Entry            // 当前节点保存的日志
+	compactIndex int                // 日志压缩标记，压缩后第一条日志的索引
+	config       []byte             // 最后一个已提交的集群配置
+	configIndex  int                // 最后一个已提交的配置所在的日志索引
+	commitIndex  int                // 最近一次保存的提交索引
+	lastApplied  int                // 最近一次保存的已应用索引
+	persister    RaftStatePersister // 持久化器
+	onTermChange func(term int)     // 任期变更回调，持有锁时调用，不能阻塞
+	termRanges   termRanges         // 任期 -> 内存中此任期日志的索引范围，为 nil 时在下次查询时重建
+	unpersisted  int                // 内存中第一条尚未持久化的日志的索引，为 0 表示日志全部已持久化
+	persistSeq   int                // 开始写入的次数，异步写入完成时据此判断之后是否有更新的写入
+	mu           sync.Mutex
+	writeMu      sync.Mutex // 保证各次写入按获取状态的顺序进行，持有 mu 时获取
 }
 
 func (st *HardState) lastEntryIndex() int {
@@ -123,6 +151,7 @@ func (st *HardState) setTerm(term int) error {
 	}
 	st.term = term
 	st.votedFor = ""
+	st.termChanged()
 	return nil
 }
 
@@ -136,9 +165,16 @@ func (st *HardState) termAddAndVote(delta int, voteTo NodeId) error {
 	}
 	st.term = newTerm
 	st.votedFor = voteTo
+	st.termChanged()
 	return nil
 }
 
+func (st *HardState) termChanged() {
+	if st.onTermChange != nil {
+		st.onTermChange(st.term)
+	}
+}
+
 func (st *HardState) vote(id NodeId) error {
 	st.mu.Lock()
 	defer st.mu.Unlock()
@@ -154,18 +190,81 @@ func (st *HardState) vote(id NodeId) error {
 }
 
 func (st *HardState) persist(term int, votedFor NodeId, entries []Entry) error {
-	raftState := RaftState{
-		Term:     term,
-		VotedFor: votedFor,
-		Entries:  entries,
-	}
-	err := st.persister.SaveRaftState(raftState)
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+	st.persistSeq++
+	err := st.persister.SaveRaftState(st.raftState(term, votedFor, entries))
 	if err != nil {
 		return fmt.Errorf("raft 状态持久化失败：%w", err)
 	}
+	// 每次写入完整的状态，内存中的日志随之全部持久化
+	st.unpersisted = 0
+	return nil
+}
+
+func (st *HardState) raftState(term int, votedFor NodeId, entries []Entry) RaftState {
+	return RaftState{
+		Term:         term,
+		VotedFor:     votedFor,
+		Entries:      entries,
+		CompactIndex: st.compactIndex,
+		Config:       st.config,
+		ConfigIndex:  st.configIndex,
+		CommitIndex:  st.commitIndex,
+		LastApplied:  st.lastApplied,
+	}
+}
+
+// 持久化已提交的集群配置，重启后据此恢复集群成员
+func (st *HardState) saveConfig(index int, config []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if index <= st.configIndex {
+		return nil
+	}
+	oldConfig, oldIndex := st.config, st.configIndex
+	st.config, st.configIndex = config, index
+	if err := st.persist(st.term, st.votedFor, st.entries); err != nil {
+		st.config, st.configIndex = oldConfig, oldIndex
+		return fmt.Errorf("持久化出错，保存集群配置失败。%w", err)
+	}
+	return nil
+}
+
+// 最后一个已提交的集群配置及其日志索引
+func (st *HardState) committedConfig() (int, []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.configIndex, st.config
+}
+
+// 持久化提交索引和已应用索引，重启后据此跳过已应用的日志
+func (st *HardState) saveApplyIndex(commitIndex, lastApplied int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	oldCommit, oldApplied := st.commitIndex, st.lastApplied
+	st.commitIndex, st.lastApplied = commitIndex, lastApplied
+	if err := st.persist(st.term, st.votedFor, st.entries); err != nil {
+		st.commitIndex, st.lastApplied = oldCommit, oldApplied
+		return fmt.Errorf("持久化出错，保存已应用索引失败。%w", err)
+	}
 	return nil
 }
 
+// 重启前保存的提交索引和已应用索引
+func (st *HardState) applyIndex() (commitIndex, lastApplied int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.commitIndex, st.lastApplied
+}
+
+// 将当前状态完整持久化一次，节点关闭时调用
+func (st *HardState) flush() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.persist(st.term, st.votedFor, st.entries)
+}
+
 func (st *HardState) appendEntry(entry Entry) error {
 	st.mu.Lock()
 	defer st.mu.Unlock()
@@ -174,29 +273,137 @@ func (st *HardState) appendEntry(entry Entry) error {
 		return fmt.Errorf("持久化出错，设置 Entries 属性值失败。%w", err)
 	}
 	st.entries = append(st.entries, entry)
+	if st.termRanges != nil {
+		st.termRanges.add(entry)
+	}
+	return nil
+}
+
+// 只将日志添加到内存，之后由 persistEntries 写入磁盘
+// Leader 的日志在给各节点发送的同时写入本地磁盘，写入完成前不计入法定人数
+func (st *HardState) appendUnpersisted(entry Entry) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.entries = append(st.entries, entry)
+	if st.termRanges != nil {
+		st.termRanges.add(entry)
+	}
+	if st.unpersisted == 0 {
+		st.unpersisted = entry.Index
+	}
+}
+
+// 将内存中尚未持久化的日志写入磁盘
+// 写入期间不持有 mu，不阻塞其它协程读取日志，写入之前已开始的写入先完成，较旧的状态不会覆盖较新的状态
+func (st *HardState) persistEntries() error {
+	st.mu.Lock()
+	if st.unpersisted == 0 {
+		st.mu.Unlock()
+		return nil
+	}
+	entries := make([]Entry, len(st.entries))
+	copy(entries, st.entries)
+	raftState := st.raftState(st.term, st.votedFor, entries)
+	st.writeMu.Lock()
+	st.persistSeq++
+	seq := st.persistSeq
+	st.mu.Unlock()
+	err := st.persister.SaveRaftState(raftState)
+	st.writeMu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("持久化出错，写入 Leader 日志失败。%w", err)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	// 之后开始的写入已经包含了这些日志，由其负责更新
+	if st.persistSeq != seq {
+		return nil
+	}
+	st.unpersisted = 0
+	if n := len(entries); n > 0 && len(st.entries) > 0 && st.entries[len(st.entries)-1].Index > entries[n-1].Index {
+		st.unpersisted = entries[n-1].Index + 1
+	}
 	return nil
 }
 
+// 内存中第一条尚未持久化的日志的索引，为 0 表示日志全部已持久化
+func (st *HardState) firstUnpersisted() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.unpersisted
+}
+
+// 任期在日志中的索引范围
+type termRange struct {
+	first int // 此任期第一条日志的索引
+	last  int // 此任期最后一条日志的索引
+}
+
+type termRanges map[int]termRange
+
+// 任期在日志中单调不减，按顺序添加日志即可维护各任期的范围
+func (tr termRanges) add(entry Entry) {
+	r, ok := tr[entry.Term]
+	if !ok {
+		r.first = entry.Index
+	}
+	r.last = entry.Index
+	tr[entry.Term] = r
+}
+
+// 任期 term 在内存中日志的索引范围，日志中没有此任期的日志时返回 false
+func (st *HardState) termRange(term int) (termRange, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.termRanges == nil {
+		st.termRanges = make(termRanges)
+		for _, entry := range st.entries {
+			st.termRanges.add(entry)
+		}
+	}
+	r, ok := st.termRanges[term]
+	return r, ok
+}
+
 func (st *HardState) logEntry(index int) (entry Entry, err error) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if index >= len(st.entries) {
+	if index < 0 || index >= len(st.entries) {
 		err = errors.New("索引超出范围！")
+		return
 	}
 	entry = st.entries[index]
 	return
 }
 
-func (st *HardState) voted() NodeId {
+// 内存中第一条日志的索引，此索引之前的日志已被压缩到快照中
+func (st *HardState) firstIndex() int {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	return st.votedFor
+	if len(st.entries) <= 0 {
+		return 0
+	}
+	return st.entries[0].Index
 }
 
-func (st *HardState) clearEntries() {
+// 逻辑索引在 (after, until] 范围内的日志数据总字节数
+func (st *HardState) entriesSize(after, until int) int {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	st.entries = make([]Entry, 0)
+	size := 0
+	for _, entry := range st.entries {
+		if entry.Index > after && entry.Index <= until {
+			size += len(entry.Data)
+		}
+	}
+	return size
+}
+
+func (st *HardState) voted() NodeId {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.votedFor
 }
 
 func (st *HardState) logEntries(start, end int) []Entry {
@@ -205,24 +412,82 @@ func (st *HardState) logEntries(start, end int) []Entry {
 	return st.entries[start:end]
 }
 
-func (st *HardState) truncateAfter(index int) {
+// 删除 index 位置及之后的日志，先持久化截断后的日志再修改内存，避免节点崩溃重启后被删除的日志重新出现
+func (st *HardState) truncateAfter(index int) error {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	st.entries = st.entries[:index]
+	if index < 0 || index > len(st.entries) {
+		return errors.New("索引超出范围！")
+	}
+	entries := st.entries[:index]
+	if err := st.persist(st.term, st.votedFor, entries); err != nil {
+		return fmt.Errorf("持久化出错，截断日志失败。%w", err)
+	}
+	st.entries = entries
+	st.termRanges = nil
+	return nil
+}
+
+// 压缩日志，删除 head.Index 之前的日志
+// 若日志中存在与 head 索引和任期都相同的条目，则保留此条目及之后的日志，否则用 head 替换全部日志
+// 压缩标记和截断后的日志在同一次写入中持久化，节点崩溃时两者要么都已保存，要么都未保存
+func (st *HardState) compact(head Entry) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.entries) > 0 && st.entries[0].Index >= head.Index {
+		return nil
+	}
+	return st.truncateHead(head)
 }
 
-func (st *HardState) truncateBefore(index int) {
+// 持久化的日志头早于压缩标记时，按标记完成压缩，节点启动时调用
+// lastIndex、lastTerm 是快照包含的最后一条日志的索引和任期，标记与快照不一致时返回错误
+func (st *HardState) recoverCompaction(lastIndex, lastTerm int) error {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	st.entries = st.entries[index:]
+	if len(st.entries) <= 0 || st.entries[0].Index >= st.compactIndex {
+		return nil
+	}
+	if st.compactIndex != lastIndex {
+		return fmt.Errorf("压缩标记 index=%d 与快照 lastIndex=%d 不一致", st.compactIndex, lastIndex)
+	}
+	head := Entry{Index: st.compactIndex, Term: lastTerm}
+	if pos := st.compactIndex - st.entries[0].Index; pos < len(st.entries) {
+		if st.entries[pos].Term != lastTerm {
+			return fmt.Errorf("压缩标记处日志 term=%d 与快照 lastTerm=%d 不一致", st.entries[pos].Term, lastTerm)
+		}
+		head = st.entries[pos]
+	}
+	return st.truncateHead(head)
+}
+
+// 删除 head.Index 之前的日志，与压缩标记一起持久化后再修改内存
+func (st *HardState) truncateHead(head Entry) error {
+	entries := []Entry{head}
+	if len(st.entries) > 0 {
+		pos := head.Index - st.entries[0].Index
+		if pos >= 0 && pos < len(st.entries) && st.entries[pos].Term == head.Term {
+			entries = append(make([]Entry, 0, len(st.entries)-pos), st.entries[pos:]...)
+		}
+	}
+	oldCompactIndex := st.compactIndex
+	st.compactIndex = head.Index
+	if err := st.persist(st.term, st.votedFor, entries); err != nil {
+		st.compactIndex = oldCompactIndex
+		return fmt.Errorf("持久化出错，删除日志失败。%w", err)
+	}
+	st.entries = entries
+	st.termRanges = nil
+	return nil
 }
 
 // ==================== SoftState ====================
 
 // 保存在内存中的实时状态
 type SoftState struct {
-	commitIndex int // 已经提交的最大的日志索引，由当前节点维护，初始化为0
-	lastApplied int // 应用到状态机的最后一个日志索引
+	commitIndex int      // 已经提交的最大的日志索引，由当前节点维护，初始化为0
+	lastApplied int      // 应用到状态机的最后一个日志索引
+	audit       *auditor // 不变量检查，为 nil 时不检查
 	mu          sync.Mutex
 }
 
@@ -241,8 +506,10 @@ func (st *SoftState) getCommitIndex() int {
 
 func (st *SoftState) setCommitIndex(index int) {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	old := st.commitIndex
 	st.commitIndex = index
+	st.mu.Unlock()
+	st.audit.checkCommit(old, index)
 }
 
 func (st *SoftState) setLastApplied(index int) {
@@ -251,34 +518,156 @@ func (st *SoftState) setLastApplied(index int) {
 	st.lastApplied = index
 }
 
-func (st *SoftState) lastAppliedAdd() int {
+func (st *SoftState) getLastApplied() int {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	st.lastApplied += 1
 	return st.lastApplied
 }
 
-func (st *SoftState) getLastApplied() int {
+// ==================== applyState ====================
+
+// 默认的待应用日志批次缓冲数量
+const defaultApplyBacklog = 64
+
+// 交给应用协程的一批已提交日志
+type applyBatch struct {
+	entries []Entry
+	trace   TraceContext // 触发本次提交的请求的追踪上下文
+}
+
+// 日志应用状态，已提交的日志由主循环交给应用协程，应用协程按顺序应用到状态机
+type applyState struct {
+	applyCh chan applyBatch          // 待应用的日志，缓冲已满时主循环等待，形成背压
+	queued  int                      // 已交给应用协程的最大日志索引
+	waiters map[int]chan applyResult // 等待日志应用结果的请求，键为日志索引
+	mu      sync.Mutex
+
+	persistInterval time.Duration // 持久化已应用索引的最小间隔，为 0 时不持久化
+	persistedAt     time.Time     // 最近一次持久化已应用索引的时间，只在应用协程中访问
+
+	// 应用日志、生成快照、使用快照恢复状态机时持有，保证状态机不被并发访问
+	fsmMu sync.Mutex
+}
+
+func newApplyState(config Config) *applyState {
+	backlog := config.MaxApplyBacklog
+	if backlog <= 0 {
+		backlog = defaultApplyBacklog
+	}
+	return &applyState{
+		applyCh:         make(chan applyBatch, backlog),
+		waiters:         make(map[int]chan applyResult),
+		persistInterval: time.Millisecond * time.Duration(config.ApplyIndexInterval),
+	}
+}
+
+func (st *applyState) getQueued() int {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	return st.lastApplied
+	return st.queued
+}
+
+// 只会增大，安装快照后跳过快照包含的日志
+func (st *applyState) setQueued(index int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if index > st.queued {
+		st.queued = index
+	}
+}
+
+// 暂停应用协程，等待正在应用的日志批次完成，返回后应用协程不再访问状态机，直到调用 resume
+func (st *applyState) pause() {
+	st.fsmMu.Lock()
+}
+
+// 恢复应用协程
+func (st *applyState) resume() {
+	st.fsmMu.Unlock()
+}
+
+// 状态机已使用快照恢复，index 及之前的日志不再交给应用协程，等待其结果的请求收到 err
+func (st *applyState) skipTo(index int, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if index > st.queued {
+		st.queued = index
+	}
+	for i, ch := range st.waiters {
+		if i <= index {
+			ch <- applyResult{err: err}
+			delete(st.waiters, i)
+		}
+	}
+}
+
+// 等待 index 处日志的应用结果，需在日志交给应用协程之前调用
+func (st *applyState) wait(index int) <-chan applyResult {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ch := make(chan applyResult, 1)
+	st.waiters[index] = ch
+	return ch
+}
+
+// 将日志的应用结果发送给等待的请求
+func (st *applyState) notify(results map[int]applyResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for index, result := range results {
+		if ch, ok := st.waiters[index]; ok {
+			ch <- result
+			delete(st.waiters, index)
+		}
+	}
 }
 
 // ==================== PeerState ====================
 
 // 对等节点状态和路由表
 type PeerState struct {
-	peersMap map[NodeId]NodeAddr // 所有节点
-	me       NodeId              // 当前节点在 peersMap 中的索引
-	leader   NodeId              // 当前 leader 在 peersMap 中的索引
-	mu       sync.Mutex
+	peersMap     map[NodeId]NodeAddr // 所有投票节点
+	nonVotersMap map[NodeId]NodeAddr // 非投票节点，只接收日志，不参与选举和多数派计算，不会被升级
+	me           NodeId              // 当前节点在 peersMap 中的索引
+	leader       NodeId              // 当前 leader 在 peersMap 中的索引
+	mu           sync.Mutex
+
+	// 配置日志添加到日志时立即生效，提交后才是最终配置
+	// 未提交的配置日志被截断时，回退到之前的配置
+	configIndex    int          // 当前生效配置所在的日志索引，为 0 时表示来自 Config
+	committed      membership   // 最后一个已提交的配置
+	committedIndex int          // 最后一个已提交的配置所在的日志索引
+	codec          Codec        // 配置日志的编解码器
+	quorum         QuorumPolicy // 法定人数策略
+	audit          *auditor     // 不变量检查，为 nil 时不检查
+}
+
+// 集群成员，投票节点和非投票节点分别保存
+type ClusterMembership struct {
+	Voters        map[NodeId]NodeAddr // 投票节点，参与选举和多数派计算
+	NonVoters     map[NodeId]NodeAddr // 非投票节点，只接收日志
+	VoterCount    int                 // 投票节点数
+	NonVoterCount int                 // 非投票节点数
 }
 
-func newPeerState(peers map[NodeId]NodeAddr, me NodeId) *PeerState {
+func newPeerState(peers, nonVoters map[NodeId]NodeAddr, me NodeId, codec Codec) *PeerState {
+	if nonVoters == nil {
+		nonVoters = make(map[NodeId]NodeAddr)
+	}
+	// 同时配置为非投票节点的不计入投票节点
+	voters := make(map[NodeId]NodeAddr, len(peers))
+	for id, addr := range peers {
+		if _, ok := nonVoters[id]; !ok {
+			voters[id] = addr
+		}
+	}
 	return &PeerState{
-		peersMap: peers,
-		me:       me,
-		leader:   "",
+		peersMap:     voters,
+		nonVotersMap: nonVoters,
+		me:           me,
+		leader:       "",
+		committed:    membership{Peers: voters, NonVoters: nonVoters},
+		codec:        codec,
 	}
 }
 
@@ -288,10 +677,14 @@ func (st *PeerState) leaderIsMe() bool {
 	return st.leader == st.me
 }
 
-func (st *PeerState) majority() int {
+// acks 中的节点是否构成法定人数，只统计投票节点
+func (st *PeerState) isQuorum(acks map[NodeId]bool) bool {
 	st.mu.Lock()
-	defer st.mu.Unlock()
-	return len(st.peersMap)/2 + 1
+	voters := st.peersMap
+	st.mu.Unlock()
+	granted := st.quorum.IsQuorum(voters, acks)
+	st.audit.checkQuorum(st.quorum, voters, acks, granted)
+	return granted
 }
 func (st *PeerState) peers() map[NodeId]NodeAddr {
 	st.mu.Lock()
@@ -299,35 +692,153 @@ func (st *PeerState) peers() map[NodeId]NodeAddr {
 	return st.peersMap
 }
 
-func (st *PeerState) replacePeers(peers map[NodeId]NodeAddr) {
+func (st *PeerState) nonVoters() map[NodeId]NodeAddr {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	st.peersMap = peers
+	return st.nonVotersMap
+}
+
+// 节点是否是集群成员，包括投票节点和非投票节点
+func (st *PeerState) isMember(id NodeId) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.peersMap[id]; ok {
+		return true
+	}
+	_, ok := st.nonVotersMap[id]
+	return ok
 }
 
-func (st *PeerState) replacePeersWithBytes(from []byte) error {
+// 索引为 index 的配置日志添加到日志，新配置立即生效
+func (st *PeerState) setConfig(index int, peers, nonVoters map[NodeId]NodeAddr) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
+	if nonVoters == nil {
+		nonVoters = make(map[NodeId]NodeAddr)
+	}
+	st.peersMap = peers
+	st.nonVotersMap = nonVoters
+	st.configIndex = index
+}
+
+func (st *PeerState) setConfigWithBytes(index int, from []byte) error {
 	// 	获取新节点集
-	peers, err := decodePeersMap(from)
+	config, err := decodeMembership(st.codec, from)
 	if err != nil {
 		return err
 	}
-	st.peersMap = peers
+	st.setConfig(index, config.Peers, config.NonVoters)
 	return nil
 }
 
-func decodePeersMap(from []byte) (map[NodeId]NodeAddr, error) {
-	var peers map[NodeId]NodeAddr
-	decoder := gob.NewDecoder(bytes.NewBuffer(from))
-	err := decoder.Decode(&peers)
+// 索引为 index 的配置日志已提交，成为最终配置
+func (st *PeerState) commitConfig(index int, from []byte) error {
+	config, err := decodeMembership(st.codec, from)
 	if err != nil {
-		return nil, err
-	} else {
-		return peers, nil
+		return err
 	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.committed = config
+	st.committedIndex = index
+	return nil
 }
 
+// 节点重启时恢复已持久化的集群配置
+func (st *PeerState) restoreConfig(index int, from []byte) error {
+	config, err := decodeMembership(st.codec, from)
+	if err != nil {
+		return err
+	}
+	st.setConfig(index, config.Peers, config.NonVoters)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.committed = config
+	st.committedIndex = index
+	return nil
+}
+
+// 当前生效配置所在的日志索引
+func (st *PeerState) getConfigIndex() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.configIndex
+}
+
+// 最后一个已提交配置所在的日志索引
+func (st *PeerState) getCommittedIndex() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.committedIndex
+}
+
+// 已提交配置中是否包含此节点
+func (st *PeerState) isCommittedMember(id NodeId) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.committed.Peers[id]; ok {
+		return true
+	}
+	_, ok := st.committed.NonVoters[id]
+	return ok
+}
+
+// 未提交的配置被截断，回退到最后一个已提交的配置
+func (st *PeerState) rollbackConfig() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.peersMap = st.committed.Peers
+	st.nonVotersMap = st.committed.NonVoters
+	st.configIndex = st.committedIndex
+}
+
+// 配置日志中保存的集群成员
+type membership struct {
+	Peers     map[NodeId]NodeAddr // 投票节点
+	NonVoters map[NodeId]NodeAddr // 非投票节点
+}
+
+func encodeMembership(codec Codec, peers, nonVoters map[NodeId]NodeAddr) ([]byte, error) {
+	return codec.Marshal(membership{Peers: peers, NonVoters: nonVoters})
+}
+
+// 兼容只保存了投票节点的旧版本配置日志
+func decodeMembership(codec Codec, from []byte) (membership, error) {
+	var config membership
+	if err := codec.Unmarshal(from, &config); err != nil {
+		var peers map[NodeId]NodeAddr
+		if err := codec.Unmarshal(from, &peers); err != nil {
+			return config, err
+		}
+		config.Peers = peers
+	}
+	if config.NonVoters == nil {
+		config.NonVoters = make(map[NodeId]NodeAddr)
+	}
+	return config, nil
+}
+
+// 集群成员的副本，投票节点和非投票节点分开统计
+func (st *PeerState) membership() ClusterMembership {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	voters := make(map[NodeId]NodeAddr, len(st.peersMap))
+	for id, addr := range st.peersMap {
+		voters[id] = addr
+	}
+	nonVoters := make(map[NodeId]NodeAddr, len(st.nonVotersMap))
+	for id, addr := range st.nonVotersMap {
+		nonVoters[id] = addr
+	}
+	return ClusterMembership{
+		Voters:        voters,
+		NonVoters:     nonVoters,
+		VoterCount:    len(voters),
+		NonVoterCount: len(nonVoters),
+	}
+}
+
+// 投票节点数
 func (st *PeerState) peersCnt() int {
 	st.mu.Lock()
 	defer st.mu.Unlock()
@@ -369,35 +880,118 @@ func (st *PeerState) getLeader() Server {
 
 // ==================== LeaderState ====================
 
-type Replication struct {
-	id         NodeId        // 节点标识
-	addr       NodeAddr      // 节点地址
-	role       RoleStage     // 节点角色
-	nextIndex  int           // 下一次要发送给各节点的日志索引。由 Leader 维护，初始值为 Leader 最后一个日志的索引 + 1
-	matchIndex int           // 已经复制到各节点的最大的日志索引。由 Leader 维护，初始值为0
-	rpcBusy    bool          // 是否正在通信
-	mu         sync.Mutex    // 锁
-	stepDownCh chan int      // 通知主线程降级
-	stopCh     chan struct{} // 接收主线程发来的降级通知
-	triggerCh  chan struct{} // 触发复制请求
+const (
+	ProgressProbe     ProgressState = iota // 探测中，尚未确认节点的日志位置或节点通信失败
+	ProgressReplicate                      // 复制中，已确认节点的日志位置，正常发送日志
+	ProgressSnapshot                       // 正在向节点发送快照
+)
+
+// Leader 向节点复制日志的状态
+type ProgressState uint8
+
+func ProgressStateToString(state ProgressState) (s string) {
+	switch state {
+	case ProgressProbe:
+		s = "Probe"
+	case ProgressReplicate:
+		s = "Replicate"
+	case ProgressSnapshot:
+		s = "Snapshot"
+	}
+	return
 }
 
+type Replication struct {
+	id             NodeId             // 节点标识
+	addr           NodeAddr           // 节点地址
+	role           RoleStage          // 节点角色
+	nextIndex      int                // 下一次要发送给各节点的日志索引。由 Leader 维护，初始值为 Leader 最后一个日志的索引 + 1
+	matchIndex     int                // 已经复制到各节点的最大的日志索引。由 Leader 维护，初始值为0
+	rpcBusy        bool               // 是否正在通信
+	snapshotIndex  int                // 正在发送的快照的 LastIndex
+	snapshotOffset int64              // 正在发送的快照已被接收的字节数
+	catchUpRounds  int                // Learner 连续追赶完成的复制轮数
+	failures       int                // 连续通信失败的次数
+	retryAt        time.Time          // 节点不可达时，下一次探测的时间
+	lastContact    time.Time          // 最近一次收到节点应答的时间
+	compression    bool               // 节点是否支持接收压缩的日志
+	witness        bool               // 节点是否是见证节点
+	mu             sync.Mutex         // 锁
+	stepDownCh     chan int           // 通知主线程降级
+	stopCh         chan struct{}      // 接收主线程发来的降级通知
+	triggerCh      chan struct{}      // 触发复制请求
+	heartbeatCh    chan struct{}      // 触发心跳
+	ctx            context.Context    // 复制循环中 rpc 调用的上下文，复制循环停止时取消
+	cancel         context.CancelFunc // 取消 ctx
+}
+
+// 节点地址，可能被 UpdatePeerAddress 修改
+func (r *Replication) peerAddr() NodeAddr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addr
+}
+
+// 是否正在进行日志追赶
+func (r *Replication) isRpcBusy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rpcBusy
+}
+
+// 节点不可达且未到下一次探测的时间
+func (r *Replication) inBackoff(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return now.Before(r.retryAt)
+}
+
+// 停止复制循环，并取消正在进行的 rpc 调用
+func (r *Replication) stop() {
+	close(r.stopCh)
+	r.cancel()
+}
+
+// 领导权转移期间暂存的客户端请求数默认上限
+const defaultTransferQueue = 1024
+
+// 领导权转移的目标节点默认允许落后 Leader 的日志条数
+const defaultTransferMaxLag = 100
+
 type transfer struct {
-	transferee NodeId          // 如果正在进行所有权转移，转移的目标id
-	timer      <-chan time.Time     // 领导权转移超时计时器
-	reply      chan<- rpcReply // 领导权转移 rpc 答复
+	transferee NodeId           // 如果正在进行所有权转移，转移的目标id
+	timer      <-chan time.Time // 领导权转移超时计时器
+	reply      chan<- rpcReply  // 领导权转移 rpc 答复
+	queue      []rpc            // 领导权转移期间暂存的客户端请求
+	queueLimit int              // 暂存的客户端请求数上限
 	mu         sync.Mutex
 }
 
-func newTransfer() *transfer {
+func newTransfer(queueLimit int) *transfer {
+	if queueLimit <= 0 {
+		queueLimit = defaultTransferQueue
+	}
 	return &transfer{
 		transferee: None,
+		queueLimit: queueLimit,
 	}
 }
 
 type configChange struct {
-	oldConfig map[NodeId]NodeAddr // 旧配置
-	newConfig map[NodeId]NodeAddr // 新配置
+	oldConfig   map[NodeId]NodeAddr // 旧配置
+	newConfig   map[NodeId]NodeAddr // 新配置
+	configIndex int                 // 最后一条配置日志的索引
+	inProgress  bool                // 是否有正在进行的 joint consensus 成员变更
+	mu          sync.Mutex
+}
+
+// 等待批量处理的客户端请求
+type proposalBatch struct {
+	proposals []rpc            // 客户端请求
+	bytes     int              // 请求数据总字节数
+	timer     <-chan time.Time // 批处理等待计时器
+	maxWait   time.Duration    // 批处理最长等待时间
+	maxBytes  int              // 单个批次的最大字节数
 	mu        sync.Mutex
 }
 
@@ -408,15 +1002,40 @@ type LeaderState struct {
 	replications map[NodeId]*Replication // 代表了一个复制日志的 Follower 节点
 	transfer     *transfer               // 领导权转移状态
 	configChange *configChange           // 配置变更状态
-}
-
-func newLeaderState() *LeaderState {
+	batch        *proposalBatch          // 客户端请求批处理状态
+	clock        Clock                   // 时钟
+	workers      sync.WaitGroup          // 本任期的复制循环协程
+	electedAt    time.Time               // 当选 Leader 的时间
+	audit        *auditor                // 不变量检查，为 nil 时不检查
+
+	promotionMaxLag int           // Learner 落后不超过此日志条数时，本轮复制视为追赶完成
+	promotionRounds int           // Learner 连续追赶完成的轮数达到此值后才能升级
+	transferLimit   time.Duration // 领导权转移的超时时间，为 0 时使用最小选举超时时间
+	maxLag          int           // Follower 落后超过此日志条数时暂停批量复制
+	maxLagBytes     int           // Follower 未复制的日志数据超过此字节数时暂停批量复制
+}
+
+func newLeaderState(config Config, clock Clock) *LeaderState {
+	promotionRounds := config.PromotionRounds
+	if promotionRounds <= 0 {
+		promotionRounds = 1
+	}
 	return &LeaderState{
 		stepDownCh:   make(chan int),
 		done:         make(chan NodeId),
 		replications: make(map[NodeId]*Replication),
-		transfer:     newTransfer(),
+		transfer:     newTransfer(config.MaxTransferQueue),
 		configChange: &configChange{},
+		batch: &proposalBatch{
+			maxWait:  time.Millisecond * time.Duration(config.MaxBatchWait),
+			maxBytes: config.MaxBatchBytes,
+		},
+		clock:           clock,
+		promotionMaxLag: config.PromotionMaxLag,
+		promotionRounds: promotionRounds,
+		transferLimit:   time.Millisecond * time.Duration(config.LeadershipTransferTimeout),
+		maxLag:          config.MaxReplicationLag,
+		maxLagBytes:     config.MaxReplicationLagBytes,
 	}
 }
 
@@ -424,48 +1043,204 @@ func (st *LeaderState) getReplications() map[NodeId]*Replication {
 	return st.replications
 }
 
-func (st *LeaderState) matchIndex(id NodeId) int {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	return st.replications[id].matchIndex
+// 节点落后太多，暂停批量复制，由后台追赶逐条发送日志或发送快照
+// lagBytes 返回 (matchIndex, lastIndex] 范围内的日志数据字节数，只在设置了 maxLagBytes 时调用
+func (st *LeaderState) isLagging(id NodeId, lastIndex int, lagBytes func(after, until int) int) bool {
+	if st.maxLag <= 0 && st.maxLagBytes <= 0 {
+		return false
+	}
+	matchIndex := st.matchIndex(id)
+	if st.maxLag > 0 && lastIndex-matchIndex > st.maxLag {
+		return true
+	}
+	return st.maxLagBytes > 0 && lagBytes(matchIndex, lastIndex) > st.maxLagBytes
+}
+
+// 连续通信失败达到此次数时，判定节点不可达
+const peerUnreachableFailures = 3
+
+// 节点不可达时，探测间隔最长为心跳间隔的 2^maxProbeShift 倍
+const maxProbeShift = 4
+
+// 记录一次通信失败，返回连续失败的次数
+// 节点不可达后按指数退避推迟下一次探测，base 为心跳间隔
+func (r *Replication) recordFailure(now time.Time, base time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+	if shift := r.failures - peerUnreachableFailures + 1; shift > 0 {
+		if shift > maxProbeShift {
+			shift = maxProbeShift
+		}
+		r.retryAt = now.Add(base << uint(shift))
+	}
+	return r.failures
 }
 
-func (st *LeaderState) setMatchAndNextIndex(id NodeId, matchIndex, nextIndex int) {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	st.replications[id].matchIndex = matchIndex
-	st.replications[id].nextIndex = nextIndex
+// 记录一次通信成功，返回节点是否从不可达状态恢复
+func (r *Replication) recordSuccess(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastContact = now
+	recovered := r.failures >= peerUnreachableFailures
+	r.failures = 0
+	r.retryAt = time.Time{}
+	return recovered
 }
 
-func (st *LeaderState) matchAndNextIndexAdd(id NodeId) {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	st.replications[id].matchIndex++
-	st.replications[id].nextIndex++
+// 节点被判定为不可达时，返回不可达的起始时间：最近一次应答的时间，当选后从未应答时为当选的时间
+func (st *LeaderState) unreachableSince(id NodeId) (time.Time, bool) {
+	r, ok := st.replications[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures < peerUnreachableFailures {
+		return time.Time{}, false
+	}
+	if r.lastContact.Before(st.electedAt) {
+		return st.electedAt, true
+	}
+	return r.lastContact, true
+}
+
+// 法定人数节点最近一次应答的时间，即 Leader 最近一次确认自己仍被法定人数节点认可的时间
+// 当前节点视为在 now 时刻应答，法定人数节点从未应答时返回零值
+func (st *LeaderState) quorumContact(voters map[NodeId]NodeAddr, me NodeId, isQuorum func(map[NodeId]bool) bool, now time.Time) time.Time {
+	contacts := make(map[NodeId]int, len(voters))
+	for id := range voters {
+		if id == me {
+			contacts[id] = int(now.UnixNano())
+			continue
+		}
+		r, ok := st.replications[id]
+		if !ok {
+			continue
+		}
+		r.mu.Lock()
+		if !r.lastContact.IsZero() {
+			contacts[id] = int(r.lastContact.UnixNano())
+		}
+		r.mu.Unlock()
+	}
+	contact, ok := quorumIndex(contacts, isQuorum)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(contact))
+}
+
+// 记录节点是否支持接收压缩的日志
+func (r *Replication) setCompression(compression bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compression = compression
+}
+
+func (r *Replication) getCompression() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.compression
+}
+
+func (r *Replication) setWitness(witness bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.witness = witness
+}
+
+func (r *Replication) isWitness() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.witness
+}
+
+// 节点不可达且未到下一次探测的时间
+func (st *LeaderState) inBackoff(id NodeId, now time.Time) bool {
+	r, ok := st.replications[id]
+	if !ok {
+		return false
+	}
+	return r.inBackoff(now)
+}
+
+// 清空上一任期遗留的复制进度，各节点的 Replication 由 runReplication 重新创建，
+// nextIndex 初始化为 Leader 最后一条日志的索引 + 1，matchIndex 初始化为 0
+func (st *LeaderState) resetReplications() {
+	st.replications = make(map[NodeId]*Replication)
+}
+
+func (st *LeaderState) matchIndex(id NodeId) int {
+	return st.replications[id].getMatchIndex()
+}
+
+// 复制协程中更新节点的复制进度，不读取 replications
+func (st *LeaderState) setMatchAndNextIndex(r *Replication, matchIndex, nextIndex int) {
+	r.mu.Lock()
+	old := r.matchIndex
+	r.matchIndex = matchIndex
+	r.nextIndex = nextIndex
+	r.mu.Unlock()
+	st.audit.checkMatch(r.id, old, matchIndex)
 }
 
 func (st *LeaderState) nextIndex(id NodeId) int {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	return st.replications[id].nextIndex
+	return st.replications[id].getNextIndex()
 }
 
-func (st *LeaderState) setNextIndex(id NodeId, index int) {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	st.replications[id].nextIndex = index
+func (r *Replication) getMatchIndex() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.matchIndex
 }
 
-func (st *LeaderState) setRpcBusy(id NodeId, busy bool) {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	st.replications[id].rpcBusy = busy
+func (r *Replication) getNextIndex() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.nextIndex
+}
+
+func (r *Replication) setNextIndex(index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextIndex = index
+}
+
+func (r *Replication) setRpcBusy(busy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rpcBusy = busy
+}
+
+// 修改复制循环使用的节点地址，节点没有复制循环时返回 false
+func (st *LeaderState) setPeerAddr(id NodeId, addr NodeAddr) bool {
+	r, ok := st.replications[id]
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addr = addr
+	return true
 }
 
 func (st *LeaderState) isRpcBusy(id NodeId) bool {
-	st.replications[id].mu.Lock()
-	defer st.replications[id].mu.Unlock()
-	return st.replications[id].rpcBusy
+	return st.replications[id].isRpcBusy()
+}
+
+// 快照发送进度，返回正在发送的快照索引和已被接收的字节数
+func (r *Replication) snapshotProgress() (int, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotIndex, r.snapshotOffset
+}
+
+func (r *Replication) setSnapshotProgress(index int, offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshotIndex = index
+	r.snapshotOffset = offset
 }
 
 func (st *LeaderState) setTransferBusy(id NodeId) {
@@ -487,6 +1262,55 @@ func (st *LeaderState) setTransferState(timer <-chan time.Time, reply chan<- rpc
 	st.transfer.reply = reply
 }
 
+// 领导权转移的超时时间，未配置时使用 defaultTimeout
+func (st *LeaderState) transferTimeout(defaultTimeout time.Duration) time.Duration {
+	if st.transferLimit <= 0 {
+		return defaultTimeout
+	}
+	return st.transferLimit
+}
+
+// 领导权转移超时计时器，没有进行领导权转移时返回 nil
+func (st *LeaderState) transferTimer() <-chan time.Time {
+	st.transfer.mu.Lock()
+	defer st.transfer.mu.Unlock()
+	if st.transfer.transferee == None {
+		return nil
+	}
+	return st.transfer.timer
+}
+
+// 结束领导权转移，返回尚未答复的 rpc 应答通道，已答复时返回 nil
+func (st *LeaderState) finishTransfer() chan<- rpcReply {
+	st.transfer.mu.Lock()
+	defer st.transfer.mu.Unlock()
+	reply := st.transfer.reply
+	st.transfer.transferee = None
+	st.transfer.timer = nil
+	st.transfer.reply = nil
+	return reply
+}
+
+// 暂存领导权转移期间的客户端请求，达到上限时返回 false
+func (st *LeaderState) queueDuringTransfer(msg rpc) bool {
+	st.transfer.mu.Lock()
+	defer st.transfer.mu.Unlock()
+	if len(st.transfer.queue) >= st.transfer.queueLimit {
+		return false
+	}
+	st.transfer.queue = append(st.transfer.queue, msg)
+	return true
+}
+
+// 取出领导权转移期间暂存的全部客户端请求
+func (st *LeaderState) takeTransferQueue() []rpc {
+	st.transfer.mu.Lock()
+	defer st.transfer.mu.Unlock()
+	queue := st.transfer.queue
+	st.transfer.queue = nil
+	return queue
+}
+
 func (st *LeaderState) setOldConfig(oldPeers map[NodeId]NodeAddr) {
 	st.configChange.mu.Lock()
 	defer st.configChange.mu.Unlock()
@@ -523,31 +1347,154 @@ func (st *LeaderState) newMajority() int {
 	return len(st.configChange.newConfig)/2 + 1
 }
 
+// 将客户端请求加入批处理队列，返回 true 表示需要立即处理队列中的请求
+func (st *LeaderState) addProposal(msg rpc, size int) bool {
+	st.batch.mu.Lock()
+	defer st.batch.mu.Unlock()
+	st.batch.proposals = append(st.batch.proposals, msg)
+	st.batch.bytes += size
+	if st.batch.maxWait <= 0 || (st.batch.maxBytes > 0 && st.batch.bytes >= st.batch.maxBytes) {
+		return true
+	}
+	if st.batch.timer == nil {
+		st.batch.timer = st.clock.After(st.batch.maxWait)
+	}
+	return false
+}
+
+// 修改批处理限制，正在等待的批次仍按原计时器处理
+func (st *LeaderState) setBatchLimits(maxWait time.Duration, maxBytes int) {
+	st.batch.mu.Lock()
+	defer st.batch.mu.Unlock()
+	st.batch.maxWait = maxWait
+	st.batch.maxBytes = maxBytes
+}
+
+// 取出批处理队列中的全部请求
+func (st *LeaderState) takeProposals() []rpc {
+	st.batch.mu.Lock()
+	defer st.batch.mu.Unlock()
+	proposals := st.batch.proposals
+	st.batch.proposals = nil
+	st.batch.bytes = 0
+	st.batch.timer = nil
+	return proposals
+}
+
+// 批处理等待计时器，队列为空时返回 nil
+func (st *LeaderState) batchTimer() <-chan time.Time {
+	st.batch.mu.Lock()
+	defer st.batch.mu.Unlock()
+	return st.batch.timer
+}
+
+func (st *LeaderState) setConfigIndex(index int) {
+	st.configChange.mu.Lock()
+	defer st.configChange.mu.Unlock()
+	st.configChange.configIndex = index
+}
+
+// 是否有正在进行的成员变更，或最后一条配置日志尚未提交
+func (st *LeaderState) configPending(commitIndex int) bool {
+	st.configChange.mu.Lock()
+	defer st.configChange.mu.Unlock()
+	return st.configChange.inProgress || st.configChange.configIndex > commitIndex
+}
+
+func (st *LeaderState) setConfigInProgress(inProgress bool) {
+	st.configChange.mu.Lock()
+	defer st.configChange.mu.Unlock()
+	st.configChange.inProgress = inProgress
+}
+
 func (st *LeaderState) getFollowerRole(id NodeId) RoleStage {
+	return st.replications[id].getRole()
+}
+
+func (st *LeaderState) setReplicationRole(id NodeId, role RoleStage) {
+	st.replications[id].setRole(role)
+}
+
+func (r *Replication) getRole() RoleStage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role
+}
+
+func (r *Replication) setRole(role RoleStage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.role = role
+}
+
+// 记录 Learner 一轮日志追赶的结果
+// 复制成功且落后条数不超过 promotionMaxLag 时累加连续轮数，否则清零
+func (st *LeaderState) recordCatchUp(r *Replication, lastIndex int, success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if success && lastIndex-r.matchIndex <= st.promotionMaxLag {
+		r.catchUpRounds++
+	} else {
+		r.catchUpRounds = 0
+	}
+}
+
+// Learner 的日志追赶进度
+// 节点的日志复制进度，lastIndex 为 Leader 最后一条日志的索引
+func (st *LeaderState) peerProgress(id NodeId, lastIndex int) PeerProgress {
 	st.replications[id].mu.Lock()
 	defer st.replications[id].mu.Unlock()
-	return st.replications[id].role
+	r := st.replications[id]
+	state := ProgressReplicate
+	if r.snapshotIndex > 0 {
+		state = ProgressSnapshot
+	} else if r.failures > 0 || r.nextIndex != r.matchIndex+1 {
+		state = ProgressProbe
+	}
+	return PeerProgress{
+		Role:           r.role,
+		State:          state,
+		Unreachable:    r.failures >= peerUnreachableFailures,
+		MatchIndex:     r.matchIndex,
+		NextIndex:      r.nextIndex,
+		Lag:            lastIndex - r.matchIndex,
+		RpcBusy:        r.rpcBusy,
+		SnapshotIndex:  r.snapshotIndex,
+		SnapshotOffset: r.snapshotOffset,
+		LastContact:    r.lastContact,
+		Witness:        r.witness,
+	}
 }
 
-func (st *LeaderState) setReplicationRole(id NodeId, role RoleStage) {
+func (st *LeaderState) learnerProgress(id NodeId, lastIndex int) LearnerProgress {
 	st.replications[id].mu.Lock()
 	defer st.replications[id].mu.Unlock()
-	st.replications[id].role = role
+	r := st.replications[id]
+	lag := lastIndex - r.matchIndex
+	return LearnerProgress{
+		MatchIndex: r.matchIndex,
+		Lag:        lag,
+		Rounds:     r.catchUpRounds,
+		Ready:      lag <= st.promotionMaxLag && r.catchUpRounds >= st.promotionRounds,
+	}
 }
 
 // ==================== timerState ====================
 
 type timerState struct {
-	timeoutTimer *time.Timer // 超时计时器
-	mu sync.Mutex
+	timeoutTimer  Timer     // 超时计时器
+	leaderContact time.Time // 最后一次收到合法 Leader 请求的时间
+	clock         Clock     // 时钟
+	mu            sync.Mutex
 
 	electionMinTimeout int // 最小选举超时时间
 	electionMaxTimeout int // 最大选举超时时间
 	heartbeatTimeout   int // 心跳间隔时间
 }
 
-func newTimerState(config Config) *timerState {
+func newTimerState(config Config, clock Clock) *timerState {
 	return &timerState{
+		clock:              clock,
 		electionMinTimeout: config.ElectionMinTimeout,
 		electionMaxTimeout: config.ElectionMaxTimeout,
 		heartbeatTimeout:   config.HeartbeatTimeout,
@@ -560,7 +1507,7 @@ func (st *timerState) setElectionTimer() {
 	defer st.mu.Unlock()
 	duration := st.electionDuration()
 	if st.timeoutTimer == nil {
-		st.timeoutTimer = time.NewTimer(duration)
+		st.timeoutTimer = st.clock.NewTimer(duration)
 	}
 	st.timeoutTimer.Reset(duration)
 }
@@ -568,30 +1515,78 @@ func (st *timerState) setElectionTimer() {
 func (st *timerState) setHeartbeatTimer() {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	duration := st.heartbeatDuration()
+	duration := st.heartbeatInterval()
 	if st.timeoutTimer == nil {
-		st.timeoutTimer = time.NewTimer(duration)
+		st.timeoutTimer = st.clock.NewTimer(duration)
 	}
 	st.timeoutTimer.Reset(duration)
 }
 
+// 使计时器立即到期
+func (st *timerState) expireNow() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.timeoutTimer == nil {
+		st.timeoutTimer = st.clock.NewTimer(0)
+	}
+	st.timeoutTimer.Reset(0)
+}
+
+// 调用方需持有 mu
 func (st *timerState) electionDuration() time.Duration {
 	randTimeout := rand.Intn(st.electionMaxTimeout-st.electionMinTimeout) + st.electionMinTimeout
 	return time.Millisecond * time.Duration(randTimeout)
 }
 
 func (st *timerState) minElectionTimeout() time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.electionMinDuration()
+}
+
+// 调用方需持有 mu
+func (st *timerState) electionMinDuration() time.Duration {
 	return time.Millisecond * time.Duration(st.electionMinTimeout)
 }
 
+// 修改超时时间，计时器下一次重置时生效
+func (st *timerState) setTimeouts(electionMin, electionMax, heartbeat int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.electionMinTimeout = electionMin
+	st.electionMaxTimeout = electionMax
+	st.heartbeatTimeout = heartbeat
+}
+
+// 记录收到合法 Leader 请求的时间
+func (st *timerState) touchLeader() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.leaderContact = st.clock.Now()
+}
+
+// 最小选举超时时间内是否收到过 Leader 的请求
+func (st *timerState) leaderAlive() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return !st.leaderContact.IsZero() && st.clock.Now().Sub(st.leaderContact) < st.electionMinDuration()
+}
+
 func (st *timerState) heartbeatDuration() time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.heartbeatInterval()
+}
+
+// 调用方需持有 mu
+func (st *timerState) heartbeatInterval() time.Duration {
 	return time.Millisecond * time.Duration(st.heartbeatTimeout)
 }
 
-func (st *timerState) tick() <- chan time.Time {
+func (st *timerState) tick() <-chan time.Time {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	return st.timeoutTimer.C
+	return st.timeoutTimer.C()
 }
 
 func (st *timerState) stopTimer() {
@@ -603,25 +1598,223 @@ func (st *timerState) stopTimer() {
 // ==================== snapshotState ====================
 
 type snapshotState struct {
-	snapshot     *Snapshot
-	persister    SnapshotPersister
-	maxLogLength int
-	mu           sync.Mutex
+	snapshot  *Snapshot               // 最新的快照，流式模式下 Data 为空
+	size      int64                   // 最新快照数据的字节数
+	persister SnapshotPersister       // 快照持久化器
+	stream    StreamSnapshotPersister // 流式快照持久化器，不为 nil 时使用流式模式
+	policy    CompactionPolicy        // 日志压缩策略
+	createdAt time.Time               // 最新快照的生成或安装时间
+	clock     Clock                   // 时钟
+	chunkSize int                     // 快照分块发送时每块的最大字节数
+	limiter   *rateLimiter            // 快照发送速率限制，所有节点共享
+	receiving *Snapshot               // 正在接收的快照
+	received  int64                   // 正在接收的快照已接收的字节数
+	sum       hash.Hash32             // 正在接收的快照的增量校验和
+	sink      SnapshotSink            // 流式模式下正在接收的快照写入器
+	creating  sync.Mutex              // 生成快照期间持有，同一时间只生成一个快照
+	mu        sync.Mutex
 }
 
 func (st *snapshotState) save(snapshot Snapshot) error {
 	st.mu.Lock()
 	defer st.mu.Unlock()
+	return st.saveLocked(snapshot)
+}
+
+func (st *snapshotState) saveLocked(snapshot Snapshot) error {
+	snapshot.Checksum = snapshotChecksum(snapshot)
 	err := st.persister.SaveSnapshot(snapshot)
 	if err != nil {
 		return fmt.Errorf("保存快照失败：%w", err)
 	}
 	st.snapshot = &snapshot
+	st.size = int64(len(snapshot.Data))
+	st.createdAt = st.clock.Now()
 	return nil
 }
 
-func (st *snapshotState) logThreshold() int {
-	return st.maxLogLength
+// 保存生成的快照，写入快照数据期间已安装了相同或更新的快照时不保存，返回是否保存
+func (st *snapshotState) saveNewer(snapshot Snapshot) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.snapshot != nil && st.snapshot.LastIndex >= snapshot.LastIndex {
+		return false, nil
+	}
+	if err := st.saveLocked(snapshot); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 是否使用流式快照
+func (st *snapshotState) isStream() bool {
+	return st.stream != nil
+}
+
+// 流式模式下创建快照，header 是快照的元数据，serializeTo 将快照数据写入持久化器提供的写入器
+// 已有相同或更新的快照时不创建，返回的 bool 表示快照是否被保存
+// 持久化器只保存快照的索引和任期，快照中的集群配置只保存在内存中
+func (st *snapshotState) create(header Snapshot, serializeTo func(io.Writer) error) (Snapshot, bool, error) {
+	lastIndex := header.LastIndex
+	if st.lastIndex() >= lastIndex {
+		return Snapshot{}, false, nil
+	}
+	sink, createErr := st.stream.CreateSnapshot(lastIndex, header.LastTerm)
+	if createErr != nil {
+		return Snapshot{}, false, fmt.Errorf("创建快照写入器失败：%w", createErr)
+	}
+	counter := &countWriter{w: sink}
+	if err := serializeTo(counter); err != nil {
+		_ = sink.Cancel()
+		return Snapshot{}, false, fmt.Errorf("写入快照数据失败：%w", err)
+	}
+	if err := sink.Close(); err != nil {
+		return Snapshot{}, false, fmt.Errorf("保存快照失败：%w", err)
+	}
+	snapshot := header
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.snapshot != nil && st.snapshot.LastIndex >= lastIndex {
+		// 写入期间已安装了更新的快照
+		return Snapshot{}, false, nil
+	}
+	st.snapshot = &snapshot
+	st.size = counter.n
+	st.createdAt = st.clock.Now()
+	return snapshot, true, nil
+}
+
+// 打开最新的快照，返回快照元数据和数据读取器
+func (st *snapshotState) open() (SnapshotMeta, io.ReadCloser, error) {
+	st.mu.Lock()
+	snapshot, size := st.snapshot, st.size
+	st.mu.Unlock()
+	if !st.isStream() {
+		meta := SnapshotMeta{LastIndex: snapshot.LastIndex, LastTerm: snapshot.LastTerm, Size: size, Version: snapshot.Version}
+		return meta, io.NopCloser(bytes.NewReader(snapshot.Data)), nil
+	}
+	meta, source, err := st.stream.OpenSnapshot()
+	if err != nil {
+		return meta, nil, fmt.Errorf("打开快照失败：%w", err)
+	}
+	return meta, source, nil
+}
+
+// 接收快照分块，按偏移量拼接数据，最后一个分块接收完成后持久化快照
+// 返回期望的下一个分块偏移量，以及此分块是否被接收
+func (st *snapshotState) receiveChunk(args InstallSnapshot) (int64, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if args.Offset == 0 {
+		// 开始接收新的快照
+		st.receiving = &Snapshot{
+			LastIndex: args.LastIncludedIndex,
+			LastTerm:  args.LastIncludedTerm,
+			Version:   args.FsmVersion,
+		}
+		st.received = 0
+		st.sum = newSnapshotHash(args.LastIncludedIndex, args.LastIncludedTerm)
+		if st.isStream() {
+			if st.sink != nil {
+				_ = st.sink.Cancel()
+			}
+			sink, err := st.stream.CreateSnapshot(args.LastIncludedIndex, args.LastIncludedTerm)
+			if err != nil {
+				st.receiving, st.sink = nil, nil
+				return 0, false, fmt.Errorf("创建快照写入器失败：%w", err)
+			}
+			st.sink = sink
+		}
+	}
+	rcv := st.receiving
+	if rcv == nil || rcv.LastIndex != args.LastIncludedIndex || rcv.LastTerm != args.LastIncludedTerm {
+		// 不是正在接收的快照，需要从头发送
+		return 0, false, nil
+	}
+	if args.Offset != st.received {
+		// 分块重复或缺失，等待发送方从期望的偏移量处重新发送
+		return st.received, false, nil
+	}
+	if st.isStream() {
+		if _, err := st.sink.Write(args.Data); err != nil {
+			_ = st.sink.Cancel()
+			st.receiving, st.sink = nil, nil
+			return 0, false, fmt.Errorf("写入快照数据失败：%w", err)
+		}
+	} else {
+		rcv.Data = append(rcv.Data, args.Data...)
+	}
+	_, _ = st.sum.Write(args.Data)
+	st.received += int64(len(args.Data))
+	received := st.received
+	if !args.Done {
+		return received, true, nil
+	}
+
+	// 快照接收完成，校验通过后持久化快照，传输中损坏的快照直接丢弃
+	st.receiving = nil
+	if len(args.Config) > 0 && args.ConfigIndex <= rcv.LastIndex {
+		// 只保存快照范围内的配置，之后的配置由日志更新
+		rcv.Config, rcv.ConfigIndex = args.Config, args.ConfigIndex
+	}
+	if args.Checksum != 0 && nonZero(st.sum.Sum32()) != args.Checksum {
+		if st.isStream() {
+			_ = st.sink.Cancel()
+			st.sink = nil
+		}
+		return 0, false, CorruptError{Target: "快照", FirstIndex: 1, LastIndex: rcv.LastIndex}
+	}
+	if st.isStream() {
+		sink := st.sink
+		st.sink = nil
+		if err := sink.Close(); err != nil {
+			return 0, false, fmt.Errorf("保存快照失败：%w", err)
+		}
+		st.snapshot = &Snapshot{LastIndex: rcv.LastIndex, LastTerm: rcv.LastTerm, Version: rcv.Version, Config: rcv.Config, ConfigIndex: rcv.ConfigIndex}
+		st.size = received
+		st.createdAt = st.clock.Now()
+		return received, true, nil
+	}
+	if err := st.saveLocked(*rcv); err != nil {
+		return 0, false, err
+	}
+	return received, true, nil
+}
+
+// 丢弃正在接收的快照，节点关闭时调用
+func (st *snapshotState) cancelReceiving() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.sink != nil {
+		_ = st.sink.Cancel()
+	}
+	st.receiving, st.sink, st.received = nil, nil, 0
+}
+
+// 最新快照数据的字节数
+func (st *snapshotState) dataSize() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.size
+}
+
+func (st *snapshotState) lastCreated() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.createdAt
+}
+
+func (st *snapshotState) shouldCompact(stats CompactionStats) bool {
+	st.mu.Lock()
+	policy := st.policy
+	st.mu.Unlock()
+	return policy.ShouldCompact(stats)
+}
+
+func (st *snapshotState) setPolicy(policy CompactionPolicy) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.policy = policy
 }
 
 func (st *snapshotState) lastIndex() int {
@@ -636,8 +1829,166 @@ func (st *snapshotState) lastTerm() int {
 	return st.snapshot.LastTerm
 }
 
+// 索引为 lastIndex 的快照中的集群配置，内存中的快照不是此快照或快照中没有配置时返回 false
+func (st *snapshotState) config(lastIndex int) (int, []byte, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.snapshot.LastIndex != lastIndex || len(st.snapshot.Config) <= 0 {
+		return 0, nil, false
+	}
+	return st.snapshot.ConfigIndex, st.snapshot.Config, true
+}
+
 func (st *snapshotState) getSnapshot() *Snapshot {
 	st.mu.Lock()
 	defer st.mu.Unlock()
 	return st.snapshot
 }
+
+// ==================== rateLimiter ====================
+
+// 令牌桶限速器，令牌以字节为单位，每秒生成 rate 个，最多积攒 rate 个
+// 为 nil 时不限速
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newRateLimiter(bytesPerSecond int) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// 取出 n 个令牌，令牌不足时等待，n 可以大于桶容量
+// stopCh 关闭时放弃等待，返回 false
+func (rl *rateLimiter) wait(n int, stopCh <-chan struct{}) bool {
+	if rl == nil {
+		return true
+	}
+	delay := rl.reserve(n)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-stopCh:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 预先扣除令牌，返回需要等待的时间
+func (rl *rateLimiter) reserve(n int) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+}
+
+// 统计写入字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// ==================== maintenanceState ====================
+
+// 运维设置的维护状态
+type maintenanceState struct {
+	readOnly bool // 只读模式，不接收客户端命令，不发起选举，仍参与日志复制和投票
+	mu       sync.Mutex
+}
+
+// 设置只读模式，状态改变时返回 true
+func (st *maintenanceState) setReadOnly(readOnly bool) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	changed := st.readOnly != readOnly
+	st.readOnly = readOnly
+	return changed
+}
+
+func (st *maintenanceState) isReadOnly() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.readOnly
+}
+
+// ==================== shutdownState ====================
+
+// 节点关闭状态
+type shutdownState struct {
+	draining bool           // 节点正在下线，不再接收新的客户端请求
+	pending  sync.WaitGroup // 已接收但尚未完成的客户端请求
+	workers  sync.WaitGroup // 日志复制、rpc 调用、快照生成等后台协程
+	stopCh   chan struct{}  // 通知主循环退出
+	doneCh   chan struct{}  // 主循环已退出
+	stopOnce sync.Once
+	mu       sync.Mutex
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// 进入下线状态，此后不再接收新的客户端请求
+func (st *shutdownState) startDraining() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.draining = true
+}
+
+func (st *shutdownState) isDraining() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.draining
+}
+
+// 接收一个客户端请求，节点下线时返回 false
+func (st *shutdownState) accept() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.draining {
+		return false
+	}
+	st.pending.Add(1)
+	return true
+}
+
+// 客户端请求处理完成
+func (st *shutdownState) finish() {
+	st.pending.Done()
+}
+
+// 通知主循环退出
+func (st *shutdownState) stop() {
+	st.stopOnce.Do(func() { close(st.stopCh) })
+}
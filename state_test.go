@@ -0,0 +1,188 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+)
+
+// 记录写入次数，failAfter 次写入成功后的写入返回错误，为 0 时不返回错误
+// crash 为 true 时写入成功后 panic，模拟节点在写入完成、修改内存之前崩溃
+type testPersister struct {
+	inMemRaftStatePersister
+	saves     int
+	failAfter int
+	crash     bool
+}
+
+var errTestPersist = errors.New("test persister failure")
+
+type testCrash struct{}
+
+func (ps *testPersister) SaveRaftState(state RaftState) error {
+	if ps.failAfter > 0 && ps.saves >= ps.failAfter {
+		return errTestPersist
+	}
+	ps.saves++
+	if err := ps.inMemRaftStatePersister.SaveRaftState(state); err != nil {
+		return err
+	}
+	if ps.crash {
+		panic(testCrash{})
+	}
+	return nil
+}
+
+// 从持久化器中重新加载状态，模拟节点重启
+func (ps *testPersister) restart(t *testing.T) HardState {
+	t.Helper()
+	state, err := ps.LoadRaftState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.toHardState(ps)
+}
+
+// 调用 f，吞掉 testPersister 模拟崩溃时的 panic
+func runUntilCrash(f func() error) (crashed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(testCrash); !ok {
+				panic(r)
+			}
+			crashed = true
+		}
+	}()
+	return false, f()
+}
+
+func entryIndexes(entries []Entry) []int {
+	indexes := make([]int, len(entries))
+	for i, entry := range entries {
+		indexes[i] = entry.Index
+	}
+	return indexes
+}
+
+func testEntries(terms ...int) []Entry {
+	entries := []Entry{{}}
+	for i, term := range terms {
+		entries = append(entries, Entry{Index: i + 1, Term: term})
+	}
+	return entries
+}
+
+func TestCompactSavesOnce(t *testing.T) {
+	persister := &testPersister{}
+	st := RaftState{Term: 2, Entries: testEntries(1, 1, 2, 2)}.toHardState(persister)
+	if err := st.compact(Entry{Index: 3, Term: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if persister.saves != 1 {
+		t.Fatalf("compact saved %d times, want 1", persister.saves)
+	}
+	saved, _ := persister.LoadRaftState()
+	if saved.CompactIndex != 3 || saved.Entries[0].Index != 3 || len(saved.Entries) != 2 {
+		t.Fatalf("saved state = %+v, want log head at index 3", saved)
+	}
+}
+
+func TestRecoverCompaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		marker    int
+		lastIndex int
+		lastTerm  int
+		wantHead  int
+		wantErr   bool
+	}{
+		{name: "no marker", marker: 0, lastIndex: 0, lastTerm: 0, wantHead: 0},
+		{name: "finish compaction", marker: 3, lastIndex: 3, lastTerm: 2, wantHead: 3},
+		{name: "marker beyond log", marker: 6, lastIndex: 6, lastTerm: 3, wantHead: 6},
+		{name: "term mismatch", marker: 3, lastIndex: 3, lastTerm: 1, wantErr: true},
+		{name: "index mismatch", marker: 3, lastIndex: 4, lastTerm: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			persister := &testPersister{}
+			state := RaftState{Term: 2, Entries: testEntries(1, 1, 2, 2), CompactIndex: tt.marker}
+			st := state.toHardState(persister)
+			err := st.recoverCompaction(tt.lastIndex, tt.lastTerm)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("recoverCompaction() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head := st.entries[0]; head.Index != tt.wantHead {
+				t.Fatalf("log head = %d, want %d", head.Index, tt.wantHead)
+			}
+		})
+	}
+}
+
+func TestTruncateAfterCrash(t *testing.T) {
+	tests := []struct {
+		name        string
+		failSave    bool
+		crash       bool
+		wantMemory  []int // 崩溃或失败后内存中的日志，崩溃时不检查
+		wantRestart []int // 重启后的日志
+	}{
+		{name: "success", wantMemory: []int{0, 1, 2}, wantRestart: []int{0, 1, 2}},
+		{name: "save fails", failSave: true, wantMemory: []int{0, 1, 2, 3, 4}, wantRestart: []int{0, 1, 2, 3, 4}},
+		{name: "crash after save", crash: true, wantRestart: []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			persister := &testPersister{}
+			state := RaftState{Term: 2, Entries: testEntries(1, 1, 2, 2)}
+			if err := persister.SaveRaftState(state); err != nil {
+				t.Fatal(err)
+			}
+			if tt.failSave {
+				persister.failAfter = persister.saves
+			}
+			persister.crash = tt.crash
+			st := state.toHardState(persister)
+
+			crashed, err := runUntilCrash(func() error { return st.truncateAfter(3) })
+			if crashed != tt.crash {
+				t.Fatalf("crashed = %v, want %v", crashed, tt.crash)
+			}
+			if (err != nil) != tt.failSave {
+				t.Fatalf("truncateAfter() error = %v, want error %v", err, tt.failSave)
+			}
+			if !tt.crash {
+				if got := entryIndexes(st.entries); !equalInts(got, tt.wantMemory) {
+					t.Fatalf("log in memory = %v, want %v", got, tt.wantMemory)
+				}
+			}
+
+			persister.crash = false
+			restarted := persister.restart(t)
+			if got := entryIndexes(restarted.entries); !equalInts(got, tt.wantRestart) {
+				t.Fatalf("log after restart = %v, want %v", got, tt.wantRestart)
+			}
+			if !tt.crash {
+				if got, want := entryIndexes(restarted.entries), entryIndexes(st.entries); !equalInts(got, want) {
+					t.Fatalf("log after restart = %v, log in memory before restart = %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package raft
+
+// 追踪上下文，随 rpc 消息在节点之间传递
+// 可直接作为 OpenTelemetry 的 propagation.MapCarrier 使用
+type TraceContext map[string]string
+
+// 链路追踪接口，由客户端实现，如基于 OpenTelemetry 的实现
+// raft 在日志复制、选举、快照发送、客户端请求处理和状态机应用等处创建 span
+type Tracer interface {
+	// 开始一个 span，parent 为上游的追踪上下文，为 nil 时开始新的追踪
+	StartSpan(name string, parent TraceContext) Span
+}
+
+// 一次操作的追踪记录
+type Span interface {
+	// 设置 span 的属性
+	SetAttribute(key string, value interface{})
+	// 当前 span 的追踪上下文，发送给其它节点或传给子 span
+	Context() TraceContext
+	// 结束 span，err 不为 nil 时表示操作失败
+	End(err error)
+}
+
+// 未设置 Tracer 时使用，不做任何事
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(string, TraceContext) Span {
+	return nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+func (nopSpan) Context() TraceContext            { return nil }
+func (nopSpan) End(error)                        {}
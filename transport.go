@@ -1,5 +1,10 @@
 package raft
 
+import (
+	"context"
+	"sync"
+)
+
 // 网络通信接口，由客户端实现
 type Transport interface {
 	AppendEntries(addr NodeAddr, args AppendEntry, res *AppendEntryReply) error
@@ -9,29 +14,173 @@ type Transport interface {
 	InstallSnapshot(addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error
 }
 
+// 可选实现，Transport 同时实现此接口时才会在选举前发送 PreVote 请求，未实现时 Config.PreVote 不生效
+type PreVoteTransport interface {
+	PreVote(addr NodeAddr, args PreVote, res *PreVoteReply) error
+}
+
+// 可选实现，Transport 同时实现此接口时，raft 内部通过以下方法发送请求
+// ctx 在请求超过 RpcTimeout 或节点关闭时取消，实现应据此中止网络请求并尽快返回
+type ContextTransport interface {
+	AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error
+
+	RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error
+
+	InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error
+}
+
+// 可选实现，ContextTransport 同时实现此接口时，raft 内部通过此方法发送 PreVote 请求
+type PreVoteContextTransport interface {
+	PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error
+}
+
+// 可选实现，Transport 同时实现此接口且设置了 Config.ForwardApply 时，非 Leader 节点将客户端命令转发给 Leader，
+// 并将 Leader 的应答原样返回给客户端。接收方调用 Node.ApplyCommand 处理即可
+type CommandForwarder interface {
+	ApplyCommand(addr NodeAddr, args ApplyCommand, res *ApplyCommandReply) error
+}
+
+// 未实现 ContextTransport 的 Transport 通过 transportAdapter 适配
+func withContext(transport Transport) ContextTransport {
+	if tp, ok := transport.(ContextTransport); ok {
+		return tp
+	}
+	return transportAdapter{transport: transport}
+}
+
+// Transport 实现了 PreVoteTransport 或 PreVoteContextTransport 时才能发送 PreVote 请求
+func supportsPreVote(transport Transport) bool {
+	switch transport.(type) {
+	case PreVoteTransport, PreVoteContextTransport:
+		return true
+	default:
+		return false
+	}
+}
+
+// 通过 transport 发送 PreVote 请求，transport 不支持时返回 ErrPreVoteUnsupported
+func preVoteContext(transport ContextTransport, ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	if tp, ok := transport.(PreVoteContextTransport); ok {
+		return tp.PreVoteContext(ctx, addr, args, res)
+	}
+	return ErrPreVoteUnsupported
+}
+
+// 在单独的协程中调用 Transport，ctx 取消时不等待请求返回，直接返回 ctx 的错误
+type transportAdapter struct {
+	transport Transport
+}
+
+// 调用 call，ctx 取消时直接返回，call 返回后才调用 done 写入应答
+func callContext(ctx context.Context, call func() error, done func()) error {
+	if ctx.Done() == nil {
+		err := call()
+		done()
+		return err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- call()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		done()
+		return err
+	}
+}
+
+func (tp transportAdapter) AppendEntriesContext(ctx context.Context, addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	var reply AppendEntryReply
+	return callContext(ctx, func() error {
+		return tp.transport.AppendEntries(addr, args, &reply)
+	}, func() { *res = reply })
+}
+
+func (tp transportAdapter) RequestVoteContext(ctx context.Context, addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	var reply RequestVoteReply
+	return callContext(ctx, func() error {
+		return tp.transport.RequestVote(addr, args, &reply)
+	}, func() { *res = reply })
+}
+
+func (tp transportAdapter) PreVoteContext(ctx context.Context, addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	transport, ok := tp.transport.(PreVoteTransport)
+	if !ok {
+		return ErrPreVoteUnsupported
+	}
+	var reply PreVoteReply
+	return callContext(ctx, func() error {
+		return transport.PreVote(addr, args, &reply)
+	}, func() { *res = reply })
+}
+
+func (tp transportAdapter) InstallSnapshotContext(ctx context.Context, addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	var reply InstallSnapshotReply
+	return callContext(ctx, func() error {
+		return tp.transport.InstallSnapshot(addr, args, &reply)
+	}, func() { *res = reply })
+}
+
 // Transport 接口实现，开发测试用
+// 目标节点通过 register 注册后请求直接交给该节点处理，否则返回预设的应答
 type inMemTransport struct {
+	nodes map[NodeAddr]*Node
 	aeRes map[NodeAddr]AppendEntryReply
 	rvRes map[NodeAddr]RequestVoteReply
+	pvRes map[NodeAddr]PreVoteReply
 	isRes map[NodeAddr]InstallSnapshotReply
 	err   error
+	mu    sync.RWMutex
 }
 
 func newInMemTransport() *inMemTransport {
-	return &inMemTransport{}
+	return &inMemTransport{nodes: make(map[NodeAddr]*Node)}
+}
+
+// 注册节点，之后发往 addr 的请求由 node 处理
+func (tp *inMemTransport) register(addr NodeAddr, node *Node) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.nodes[addr] = node
+}
+
+func (tp *inMemTransport) node(addr NodeAddr) (*Node, bool) {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	node, ok := tp.nodes[addr]
+	return node, ok
 }
 
 func (tp *inMemTransport) AppendEntries(addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	if node, ok := tp.node(addr); ok {
+		return node.AppendEntries(args, res)
+	}
 	*res = tp.aeRes[addr]
 	return tp.err
 }
 
 func (tp *inMemTransport) RequestVote(addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	if node, ok := tp.node(addr); ok {
+		return node.RequestVote(args, res)
+	}
 	*res = tp.rvRes[addr]
 	return tp.err
 }
 
+func (tp *inMemTransport) PreVote(addr NodeAddr, args PreVote, res *PreVoteReply) error {
+	if node, ok := tp.node(addr); ok {
+		return node.PreVote(args, res)
+	}
+	*res = tp.pvRes[addr]
+	return tp.err
+}
+
 func (tp *inMemTransport) InstallSnapshot(addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	if node, ok := tp.node(addr); ok {
+		return node.InstallSnapshot(args, res)
+	}
 	*res = tp.isRes[addr]
 	return tp.err
 }
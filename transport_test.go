@@ -0,0 +1,81 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 只实现 Transport 接口，不支持 PreVote
+type basicTransport struct {
+	transport Transport
+}
+
+func (tp basicTransport) AppendEntries(addr NodeAddr, args AppendEntry, res *AppendEntryReply) error {
+	return tp.transport.AppendEntries(addr, args, res)
+}
+
+func (tp basicTransport) RequestVote(addr NodeAddr, args RequestVote, res *RequestVoteReply) error {
+	return tp.transport.RequestVote(addr, args, res)
+}
+
+func (tp basicTransport) InstallSnapshot(addr NodeAddr, args InstallSnapshot, res *InstallSnapshotReply) error {
+	return tp.transport.InstallSnapshot(addr, args, res)
+}
+
+func TestSupportsPreVote(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport Transport
+		want      bool
+	}{
+		{name: "basic transport", transport: basicTransport{}, want: false},
+		{name: "in-memory transport", transport: newInMemTransport(), want: true},
+		{name: "chaos transport", transport: NewChaosTransport(basicTransport{}, 1), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := supportsPreVote(tt.transport); got != tt.want {
+				t.Fatalf("supportsPreVote() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreVoteUnsupported(t *testing.T) {
+	err := preVoteContext(withContext(basicTransport{}), context.Background(), "127.0.0.1:9001", PreVote{}, &PreVoteReply{})
+	if !errors.Is(err, ErrPreVoteUnsupported) {
+		t.Fatalf("preVoteContext() error = %v, want ErrPreVoteUnsupported", err)
+	}
+}
+
+func TestPreVoteFallback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	tests := []struct {
+		name string
+		wrap func(Transport) Transport
+	}{
+		{
+			// 创建节点时即可判断不支持预投票
+			name: "transport without PreVote",
+			wrap: func(tp Transport) Transport { return basicTransport{transport: tp} },
+		},
+		{
+			// 包装后的 Transport 声称支持预投票，发送时才返回 ErrPreVoteUnsupported
+			name: "wrapped transport without PreVote",
+			wrap: func(tp Transport) Transport { return NewChaosTransport(basicTransport{transport: tp}, 1) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := newTestCluster(t, 3, func(config *Config) {
+				config.PreVote = true
+				config.Transport = tt.wrap(config.Transport)
+			})
+			cluster.waitLeader(t, time.Second*5)
+		})
+	}
+}
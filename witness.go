@@ -0,0 +1,44 @@
+package raft
+
+// 见证节点
+// 见证节点参与选举投票和多数派计算，但只保存日志的索引、任期和类型，不保存客户端命令，也不运行状态机，
+// 用于两个完整副本加一个见证节点的部署，在节省存储的同时保持单节点故障的容错能力
+// 见证节点不会发起选举，也不会成为领导权转移的目标，因此不会成为 Leader
+// 见证节点在 AppendEntries 应答中声明自己的身份，Leader 之后只给它发送不含客户端命令的日志和空快照
+// 配置日志等 raft 内部日志仍完整保存，见证节点据此维护集群成员
+
+// 见证节点使用的状态机，不保存任何数据
+type witnessFsm struct{}
+
+func (witnessFsm) Apply(AppliedEntry) ([]byte, error) {
+	return nil, nil
+}
+
+func (witnessFsm) Serialize() ([]byte, error) {
+	return nil, nil
+}
+
+func (witnessFsm) Restore([]byte) error {
+	return nil
+}
+
+// 去掉客户端命令的数据，返回新的日志切片，不修改原日志
+// 日志数据改变后校验和不再匹配，一并清除
+func witnessEntries(entries []Entry) []Entry {
+	stripped := make([]Entry, len(entries))
+	for i, entry := range entries {
+		if entry.Type == EntryReplicate {
+			entry.Data = nil
+			entry.Checksum = 0
+		}
+		stripped[i] = entry
+	}
+	return stripped
+}
+
+// 发送给见证节点的日志不包含客户端命令
+func (rf *raft) witnessArgs(r *Replication, args *AppendEntry) {
+	if len(args.Entries) > 0 && r.isWitness() {
+		args.Entries = witnessEntries(args.Entries)
+	}
+}